@@ -1,15 +1,18 @@
 module github.com/germtb/mlsgit
 
-go 1.22
+go 1.24
 
 require (
 	github.com/BurntSushi/toml v1.4.0
+	github.com/klauspost/compress v1.18.0
 	github.com/sergi/go-diff v1.4.0
 	github.com/spf13/cobra v1.8.1
 	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
@@ -1,24 +1,47 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 	"github.com/spf13/cobra"
 )
 
+var (
+	addRole              string
+	addExpectFingerprint string
+	addHistory           string
+)
+
 var addCmd = &cobra.Command{
 	Use:   "add [member-id]",
 	Short: "Approve a pending join request and add the member to the group",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runAdd,
+	Long: `Approve a pending join request and add the member to the group.
+
+If the policy's add_quorum is more than one, this records your
+signed approval next to the request instead, and the member is only added
+once that many admins have approved. Every client checks the approvals
+when it syncs.
+
+--history controls what the new member can decrypt from before they
+joined: all of it (the default), none, or since:<epoch>. Under none or
+since:, the epoch key archive is cut at the add and existing members keep
+the secret that bridges the cut locally. Use 'mlsgit grant-history' to
+open more history later.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAdd,
 }
 
 func init() {
+	addCmd.Flags().StringVar(&addRole, "role", string(policy.Writer), "Role for the new member: admin, writer or reader")
+	addCmd.Flags().StringVar(&addExpectFingerprint, "expect-fingerprint", "", "Refuse unless the request's key has this fingerprint (as shown by 'mlsgit review')")
+	addCmd.Flags().StringVar(&addHistory, "history", "all", "History the new member can read: all, none or since:<epoch>")
 	rootCmd.AddCommand(addCmd)
 }
 
@@ -29,12 +52,36 @@ func runAdd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// 1. Read pending request
+	role, err := policy.ParseRole(addRole)
+	if err != nil {
+		return err
+	}
+	historyAll, historySince, err := parseHistory(addHistory)
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may add members, then read the pending request
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can add members: %w", err)
+	}
 	reqPath := paths.PendingRequest(memberID)
 	if _, err := os.Stat(reqPath); os.IsNotExist(err) {
 		return fmt.Errorf("no pending request for member '%s'", memberID)
 	}
 
+	if _, err := os.Stat(paths.RejectionTOML(memberID)); err == nil {
+		return fmt.Errorf("the request from '%s' was rejected", memberID)
+	}
+
 	info, err := storage.ReadPendingRequest(reqPath)
 	if err != nil {
 		return err
@@ -43,21 +90,37 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	pubPEM := info.PublicKey
 	kpB64 := info.Keypackage
 
-	if kpB64 == "" {
-		return fmt.Errorf("request is missing KeyPackage data")
-	}
-
-	// 2. Deserialize KeyPackage
-	kpBytes, err := crypto.B64Decode(kpB64, false)
+	// 2. Verify the request and deserialize its KeyPackage
+	keyPackage, err := verifyJoinRequest(memberID, info)
 	if err != nil {
-		return fmt.Errorf("decode keypackage: %w", err)
+		return fmt.Errorf("invalid join request from '%s': %w", memberID, err)
+	}
+	if addExpectFingerprint != "" {
+		pub, _ := crypto.LoadPublicKey(pubPEM)
+		if !crypto.FingerprintMatches(pub, addExpectFingerprint) {
+			fp, _ := crypto.SSHFingerprint(pub)
+			return fmt.Errorf("fingerprint mismatch for '%s': request key is %s, expected %s", memberID, fp, addExpectFingerprint)
+		}
 	}
-	var keyPackage mls.KeyPackageData
-	if err := json.Unmarshal(kpBytes, &keyPackage); err != nil {
-		return fmt.Errorf("unmarshal keypackage: %w", err)
+
+	// 3. Record our approval and stop until the quorum is reached
+	quorum := pol.AddQuorum()
+	if quorum > 1 {
+		approvals, err := approveRequest(paths, pol, memberID, pubPEM, keyPackage.SigPub)
+		if err != nil {
+			return err
+		}
+		if approvals < quorum {
+			fmt.Printf("Approval recorded for '%s' (%d of %d required).\n", name, approvals, quorum)
+			fmt.Println()
+			fmt.Println("Next steps:")
+			fmt.Printf("  git add .mlsgit/pending/ && git commit -m 'approve member: %s'\n", name)
+			fmt.Printf("  Then push and ask another admin to run 'mlsgit add %s'.\n", memberID)
+			return nil
+		}
 	}
 
-	// 3. Load MLS group and epoch archive
+	// 4. Load MLS group and epoch archive
 	mlsgitGroup, err := loadMLSGitGroup(paths)
 	if err != nil {
 		return err
@@ -68,8 +131,16 @@ func runAdd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// 4. Add member to MLS group (advances epoch)
-	_, welcomeBytes, err := mlsgitGroup.AddMember(keyPackage)
+	// 5. Add member to MLS group (advances epoch)
+	if historySince > oldEpoch {
+		return fmt.Errorf("--history=since:%d is after the current epoch %d", historySince, oldEpoch)
+	}
+	var welcomeBytes []byte
+	if historyAll {
+		_, welcomeBytes, err = mlsgitGroup.AddMember(keyPackage)
+	} else {
+		_, welcomeBytes, err = mlsgitGroup.AddMemberForwardOnly(keyPackage)
+	}
 	if err != nil {
 		return fmt.Errorf("add member: %w", err)
 	}
@@ -77,14 +148,13 @@ func runAdd(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("MLS epoch advanced: %d -> %d\n", oldEpoch, newEpoch)
 
-	// 5. Write Welcome message (ensure welcome dir exists)
+	// 6. Write Welcome message (ensure welcome dir exists)
 	os.MkdirAll(paths.WelcomeDir(), 0o755)
 	if err := storage.WriteWelcome(paths, memberID, welcomeBytes); err != nil {
 		return err
 	}
 
-	// 6. Move request to members/
-	myID, _, _ := storage.ReadIdentity(paths)
+	// 7. Move request and approvals to members/ and assign the role
 	if err := storage.WriteMemberTOML(paths, memberID, name, pubPEM, newEpoch, myID); err != nil {
 		return err
 	}
@@ -94,17 +164,45 @@ func runAdd(cmd *cobra.Command, args []string) error {
 
 	// Delete pending request
 	os.Remove(reqPath)
+	if quorum > 1 {
+		os.RemoveAll(paths.MemberApprovalsDir(memberID))
+		if err := os.Rename(paths.PendingApprovalsDir(memberID), paths.MemberApprovalsDir(memberID)); err != nil {
+			return fmt.Errorf("move approvals: %w", err)
+		}
+	}
+
+	if pol != nil {
+		pol.SetRole(memberID, role, newEpoch)
+		if err := savePolicy(paths, pol); err != nil {
+			return err
+		}
+	}
 
-	// 7. Persist all state
+	// 8. Grant the history the new member may read from before the cut
+	if !historyAll && historySince >= 0 {
+		devices, err := memberDevices(paths, mlsgitGroup, memberID)
+		if err != nil {
+			return err
+		}
+		if err := writeHistoryGrants(paths, mlsgitGroup, archive, devices, historySince, oldEpoch); err != nil {
+			return err
+		}
+	}
+
+	// 9. Persist all state
 	if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
 		return err
 	}
 
-	// 8. Invalidate filter cache
+	// 10. Invalidate filter cache
 	cache := storage.NewFilterCache(paths)
 	cache.InvalidateAll()
 
 	fmt.Printf("Member '%s' (%s) added to the group.\n", name, memberID)
+	if !historyAll && historySince < 0 {
+		fmt.Printf("They cannot decrypt files from before epoch %d.\n", newEpoch)
+	}
+	warnStaleKeys(paths)
 	fmt.Println()
 	fmt.Println("Next steps:")
 	fmt.Printf("  git add . && git commit -m 'add member: %s'\n", name)
@@ -112,3 +210,70 @@ func runAdd(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// parseHistory parses --history. It returns all=true for "all", and
+// otherwise the first epoch before the add to grant, or -1 for "none".
+func parseHistory(s string) (all bool, since int, err error) {
+	switch {
+	case s == "all":
+		return true, -1, nil
+	case s == "none":
+		return false, -1, nil
+	case strings.HasPrefix(s, "since:"):
+		since, err := strconv.Atoi(strings.TrimPrefix(s, "since:"))
+		if err != nil || since < 0 {
+			return false, -1, fmt.Errorf("invalid --history %q: since needs an epoch number", s)
+		}
+		return false, since, nil
+	}
+	return false, -1, fmt.Errorf("invalid --history %q: use all, none or since:<epoch>", s)
+}
+
+// approveRequest signs a vote for memberID's join request as this device and
+// returns the number of valid votes now recorded.
+func approveRequest(paths storage.MLSGitPaths, pol *policy.Policy, memberID, pubPEM string, mlsSigPub []byte) (int, error) {
+	author, key, err := loadSigningKey(paths)
+	if err != nil {
+		return 0, err
+	}
+	dir := paths.PendingApprovalsDir(memberID)
+	if err := storage.WriteApproval(dir, storage.Approval{
+		Approver:  author,
+		Signature: crypto.Sign(key, storage.ApprovalBytes(memberID, pubPEM, mlsSigPub)),
+	}); err != nil {
+		return 0, fmt.Errorf("write approval: %w", err)
+	}
+	return policy.CountApprovals(paths, pol, dir, memberID, pubPEM, mlsSigPub)
+}
+
+// verifyJoinRequest checks that a join request is for memberID, that its
+// KeyPackage is self-signed, and that the request is signed by both the
+// Ed25519 identity key and the KeyPackage's signing key. It returns the
+// decoded KeyPackage.
+func verifyJoinRequest(memberID string, req storage.PendingRequestInfo) (mls.KeyPackageData, error) {
+	if req.MemberID != memberID {
+		return mls.KeyPackageData{}, fmt.Errorf("request is for member '%s'", req.MemberID)
+	}
+	if req.Keypackage == "" {
+		return mls.KeyPackageData{}, fmt.Errorf("request is missing KeyPackage data")
+	}
+	kp, err := decodeKeyPackage([]byte(req.Keypackage))
+	if err != nil {
+		return mls.KeyPackageData{}, err
+	}
+	if err := kp.Verify(); err != nil {
+		return mls.KeyPackageData{}, err
+	}
+	pub, err := crypto.LoadPublicKey(req.PublicKey)
+	if err != nil {
+		return mls.KeyPackageData{}, fmt.Errorf("load public key: %w", err)
+	}
+	msg := storage.JoinRequestBytes(req)
+	if !crypto.Verify(pub, msg, req.Signature) {
+		return mls.KeyPackageData{}, fmt.Errorf("request is not signed by its public key")
+	}
+	if !crypto.Verify(kp.SigPub, msg, req.MLSSignature) {
+		return mls.KeyPackageData{}, fmt.Errorf("request is not signed by its KeyPackage key")
+	}
+	return kp, nil
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var allowedSignersOutput string
+
+var allowedSignersCmd = &cobra.Command{
+	Use:   "allowed-signers",
+	Short: "Trust the group's signing keys for git commit signatures",
+	Long: `Write a git allowed signers file with the signing key of every member and
+device in .mlsgit/members, and point this clone's gpg.ssh.allowedSignersFile
+at it, so 'git verify-commit' accepts SSH signatures from exactly the group.
+
+Each key is listed under its author string (member ID, or member:device).
+Run again after the membership changes.`,
+	Args: cobra.NoArgs,
+	RunE: runAllowedSigners,
+}
+
+func init() {
+	allowedSignersCmd.Flags().StringVarP(&allowedSignersOutput, "output", "o", "", "Write the file here instead of .git/mlsgit/allowed_signers")
+	rootCmd.AddCommand(allowedSignersCmd)
+}
+
+func runAllowedSigners(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	memberIDs, err := storage.ListMemberIDs(paths)
+	if err != nil {
+		return err
+	}
+	if len(memberIDs) == 0 {
+		return fmt.Errorf("no members found in .mlsgit/members")
+	}
+
+	// 1. Collect every member's and device's key
+	var b strings.Builder
+	b.WriteString("# Generated by 'mlsgit allowed-signers' from .mlsgit/members.\n")
+	n := 0
+	for _, mid := range memberIDs {
+		info, err := storage.ReadMemberTOML(paths.MemberTOML(mid))
+		if err != nil {
+			return fmt.Errorf("read member '%s': %w", mid, err)
+		}
+		deviceIDs, err := storage.ListDeviceIDs(paths, mid)
+		if err != nil {
+			return err
+		}
+		for _, did := range append([]string{""}, deviceIDs...) {
+			pub, err := storage.DevicePublicKey(paths, mid, did)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", storage.DeviceAuthor(mid, did), err)
+				continue
+			}
+			line, err := crypto.SSHAuthorizedKey(pub)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "# %s\n%s namespaces=\"git\" %s\n", info.Name, storage.DeviceAuthor(mid, did), line)
+			n++
+		}
+	}
+
+	// 2. Write the file and point git at it
+	out := allowedSignersOutput
+	if out == "" {
+		out = paths.AllowedSigners()
+	}
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	gitCmd := exec.Command("git", "config", "gpg.ssh.allowedSignersFile", out)
+	gitCmd.Dir = root
+	if output, err := gitCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git config: %w\n%s", err, output)
+	}
+
+	fmt.Printf("Wrote %d signing key(s) to %s\n", n, out)
+	fmt.Println("and set gpg.ssh.allowedSignersFile for this repository.")
+	fmt.Println()
+	fmt.Println("To sign your own commits with the SSH key you joined with:")
+	fmt.Println("  git config gpg.format ssh")
+	fmt.Println("  git config user.signingkey ~/.ssh/id_ed25519.pub")
+	fmt.Println("  git config commit.gpgsign true")
+	return nil
+}
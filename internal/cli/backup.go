@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	backupOut         string
+	backupRecoveryKey bool
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Export your identity and keys to an encrypted bundle",
+	Long: `Write your identity, signing keys, init key and MLS state to an encrypted
+bundle, so a fresh clone can take your place with 'mlsgit restore' instead
+of joining as a new member.
+
+The bundle is encrypted under a passphrase, or with --recovery-key under a
+random key that is printed once. Take a new backup after 'mlsgit update':
+a bundle holds the keys of the epoch it was taken in, and cannot follow
+key changes you make later.`,
+	Args: cobra.NoArgs,
+	RunE: runBackup,
+}
+
+func init() {
+	backupCmd.Flags().StringVar(&backupOut, "out", "", "Where to write the bundle")
+	backupCmd.Flags().BoolVar(&backupRecoveryKey, "recovery-key", false, "Encrypt under a new random recovery key instead of a passphrase")
+	backupCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(backupCmd)
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.MLSState()); os.IsNotExist(err) {
+		return fmt.Errorf("no local MLS state. Run 'mlsgit join' first")
+	}
+
+	// 1. Catch up with committed state so the bundle is current
+	group, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	memberID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	deviceID, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+	files, err := storage.ReadBackupFiles(paths)
+	if err != nil {
+		return err
+	}
+
+	// 2. Seal under a passphrase or a new recovery key
+	var passphrase []byte
+	if !backupRecoveryKey {
+		if passphrase, err = readPassphrase("Backup passphrase: "); err != nil {
+			return err
+		}
+		if len(passphrase) == 0 {
+			return fmt.Errorf("passphrase must not be empty (or use --recovery-key)")
+		}
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			again, err := readPassphrase("Repeat passphrase: ")
+			if err != nil {
+				return err
+			}
+			if string(again) != string(passphrase) {
+				return fmt.Errorf("passphrases do not match")
+			}
+		}
+	}
+	bundle, recoveryKey, err := storage.NewBackupBundle(memberID, deviceID, group.Epoch(), files, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(backupOut, []byte(bundle.ToTOML()), 0o600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup of %s at epoch %d written to %s\n", storage.DeviceAuthor(memberID, deviceID), group.Epoch(), backupOut)
+	if recoveryKey != "" {
+		fmt.Println()
+		fmt.Println("Recovery key (shown only once, store it apart from the bundle):")
+		fmt.Printf("  %s\n", recoveryKey)
+	}
+	fmt.Println()
+	fmt.Println("Keep the bundle outside this repository. To restore into a fresh clone:")
+	fmt.Printf("  mlsgit restore %s\n", backupOut)
+	return nil
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/germtb/mlsgit/internal/config"
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the group configuration",
+	Args:  cobra.NoArgs,
+	RunE:  runConfigShow,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set [key] [value]",
+	Short: "Change a setting in .mlsgit/config.toml",
+	Long: `Change a setting in .mlsgit/config.toml. Only admins may change settings.
+
+Keys: cipher_suite, compaction_threshold, rotation_interval,
+stream_threshold, ciphertext_format, compression. add_quorum is part of
+the signed policy; see 'mlsgit policy quorum'.
+
+cipher_suite takes a suite name or ID (see 'mlsgit config'). It applies to
+files written from now on; existing ciphertext records which suite they
+were encrypted under and stays readable.
+
+stream_threshold is the file size in bytes from which files are encrypted
+as streamed records, in fixed-size segments that never hold the whole file
+in memory. Streamed files are re-encrypted in full on every change.
+
+ciphertext_format is the container new files are written in: text, or the
+v2 binary or armored formats. Existing files keep their format until they
+are rewritten in full or converted with 'mlsgit migrate-format'.
+compression (none or zstd) compresses v2 records before encryption.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runConfigSet,
+}
+
+func init() {
+	configCmd.AddCommand(configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(paths)
+	if err != nil {
+		return err
+	}
+	suite, err := cfg.Suite()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("cipher_suite         = %#04x (%s)\n", cfg.CipherSuite, suite.Name)
+	fmt.Printf("compaction_threshold = %d\n", cfg.CompactionThreshold)
+	fmt.Printf("rotation_interval    = %d\n", cfg.RotationInterval)
+	fmt.Printf("stream_threshold     = %d\n", cfg.StreamThreshold)
+	fmt.Printf("ciphertext_format    = %s\n", cfg.CiphertextFormat)
+	fmt.Printf("compression          = %s\n", cfg.Compression)
+	fmt.Println()
+	fmt.Println("Available cipher suites:")
+	for _, s := range crypto.Suites() {
+		marker := ""
+		if s.PostQuantum {
+			marker = "  (post-quantum KEM)"
+		}
+		fmt.Printf("  %#04x  %s%s\n", s.ID, s.Name, marker)
+	}
+	return nil
+}
+
+func runConfigSet(cmd *cobra.Command, args []string) error {
+	key, value := args[0], args[1]
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may change settings
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can change settings: %w", err)
+	}
+
+	// 2. Apply the change
+	cfg, err := loadConfig(paths)
+	if err != nil {
+		return err
+	}
+	wasPostQuantum := cfg.PostQuantum()
+	switch key {
+	case "cipher_suite":
+		suite, err := crypto.ParseSuite(value)
+		if err != nil {
+			return err
+		}
+		cfg.CipherSuite = suite.ID
+	case "ciphertext_format":
+		cfg.CiphertextFormat = value
+	case "compression":
+		cfg.Compression = value
+	case "add_quorum":
+		return fmt.Errorf("add_quorum is part of the signed policy; use 'mlsgit policy quorum %s'", value)
+	case "compaction_threshold", "rotation_interval", "stream_threshold":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number: %w", key, err)
+		}
+		switch key {
+		case "compaction_threshold":
+			if n < 1 {
+				return fmt.Errorf("compaction_threshold must be at least 1")
+			}
+			cfg.CompactionThreshold = n
+		case "rotation_interval":
+			cfg.RotationInterval = n
+		case "stream_threshold":
+			if n < 1 {
+				return fmt.Errorf("stream_threshold must be at least 1")
+			}
+			cfg.StreamThreshold = n
+		}
+	default:
+		return fmt.Errorf("unknown setting '%s'", key)
+	}
+
+	// 3. Validate by parsing what we are about to write
+	text := cfg.ToTOML()
+	if _, err := config.ConfigFromTOML(text); err != nil {
+		return err
+	}
+	if err := os.WriteFile(paths.ConfigTOML(), []byte(text), 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Set %s = %s.\n", key, value)
+	if cfg.PostQuantum() != wasPostQuantum {
+		fmt.Println("The key exchange changed: each member should run 'mlsgit update' to")
+		fmt.Println("re-key their leaf ('mlsgit ls' shows who has not).")
+	}
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/config.toml && git commit -m 'config: %s'\n", key)
+	fmt.Println("  Then push.")
+	return nil
+}
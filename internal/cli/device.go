@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Manage your devices",
+}
+
+var deviceAddCmd = &cobra.Command{
+	Use:   "add [device-id]",
+	Short: "Approve a pending request to link one of your devices",
+	Long: `Approve a device created with 'mlsgit join --device-of <your-id>'.
+
+The new device gets its own leaf in the group under your member ID. This
+device signs the new device's public key and the signature key of its
+leaf, so other members can check that files it writes come from you and
+that you added its leaf. Only writers and admins can link devices.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDeviceAdd,
+}
+
+func init() {
+	deviceCmd.AddCommand(deviceAddCmd)
+	rootCmd.AddCommand(deviceCmd)
+}
+
+func runDeviceAdd(cmd *cobra.Command, args []string) error {
+	deviceID := args[0]
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Read own identity and the pending device request
+	myID, name, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	myDevice, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Writer); err != nil {
+		return fmt.Errorf("only writers and admins can link devices: %w", err)
+	}
+	reqPath := paths.PendingDevice(myID, deviceID)
+	if _, err := os.Stat(reqPath); os.IsNotExist(err) {
+		return fmt.Errorf("no pending request for device '%s' of member '%s'", deviceID, myID)
+	}
+	req, err := storage.ReadPendingDevice(reqPath)
+	if err != nil {
+		return err
+	}
+	if req.Keypackage == "" {
+		return fmt.Errorf("request is missing KeyPackage data")
+	}
+
+	// 2. Deserialize KeyPackage
+	kpBytes, err := crypto.B64Decode(req.Keypackage, false)
+	if err != nil {
+		return fmt.Errorf("decode keypackage: %w", err)
+	}
+	var keyPackage mls.KeyPackageData
+	if err := json.Unmarshal(kpBytes, &keyPackage); err != nil {
+		return fmt.Errorf("unmarshal keypackage: %w", err)
+	}
+	if err := keyPackage.Verify(); err != nil {
+		return fmt.Errorf("invalid device request: %w", err)
+	}
+
+	// 3. Sign the new device's public key and leaf with ours
+	pemData, err := storage.ReadSecret(paths, paths.PrivateKey())
+	if err != nil {
+		return fmt.Errorf("read private key: %w", err)
+	}
+	signingPriv, err := crypto.LoadPrivateKey(string(pemData))
+	if err != nil {
+		return err
+	}
+	signature := crypto.Sign(signingPriv, storage.DeviceApprovalBytes(myID, deviceID, req.Name, req.PublicKey))
+	leafSig := crypto.Sign(signingPriv, storage.DeviceLeafBytes(myID, deviceID, keyPackage.SigPub))
+
+	// 4. Load MLS group and epoch archive
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	oldEpoch := mlsgitGroup.Epoch()
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+
+	// 5. Add the device's leaf (advances epoch)
+	_, welcomeBytes, err := mlsgitGroup.AddMember(keyPackage)
+	if err != nil {
+		return fmt.Errorf("add device: %w", err)
+	}
+	newEpoch := mlsgitGroup.Epoch()
+
+	fmt.Printf("MLS epoch advanced: %d -> %d\n", oldEpoch, newEpoch)
+
+	// 6. Write Welcome message and device record
+	os.MkdirAll(paths.WelcomeDir(), 0o755)
+	if err := storage.WriteWelcome(paths, storage.WelcomeKey(myID, deviceID), welcomeBytes); err != nil {
+		return err
+	}
+	if err := storage.WriteDeviceInfo(paths, myID, deviceID, storage.DeviceInfo{
+		Name:        req.Name,
+		PublicKey:   req.PublicKey,
+		AddedEpoch:  newEpoch,
+		ApprovedBy:  myDevice,
+		Signature:   signature,
+		LeafSig:     leafSig,
+		KeysUpdated: time.Now().Unix(),
+	}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(paths.DeviceKeypackage(myID, deviceID), []byte(req.Keypackage), 0o644); err != nil {
+		return err
+	}
+	os.Remove(reqPath)
+
+	// 7. Persist all state
+	if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+
+	// 8. Invalidate filter cache
+	cache := storage.NewFilterCache(paths)
+	cache.InvalidateAll()
+
+	fmt.Printf("Device '%s' (%s) linked to '%s'.\n", req.Name, deviceID, name)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add . && git commit -m 'add device: %s'\n", req.Name)
+	fmt.Println("  Then push so the new device can pull and join.")
+
+	return nil
+}
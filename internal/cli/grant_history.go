@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var grantHistoryFrom int
+
+var grantHistoryCmd = &cobra.Command{
+	Use:   "grant-history [member-id]",
+	Short: "Give a member access to epochs from before they joined",
+	Long: `Encrypt the secrets of past epochs, from --from up to the epoch before the
+member joined, to each of the member's devices. They pick them up the next
+time they run mlsgit after pulling.
+
+Use this for members added with 'mlsgit add --history=none' or 'since:'.
+Epoch secrets in the archive are chained, so the secret of the first
+granted epoch also opens the epochs before it, back to the previous
+history cut. The command prints how far back that reaches.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runGrantHistory,
+}
+
+func init() {
+	grantHistoryCmd.Flags().IntVar(&grantHistoryFrom, "from", 0, "First epoch to grant")
+	grantHistoryCmd.MarkFlagRequired("from")
+	rootCmd.AddCommand(grantHistoryCmd)
+}
+
+func runGrantHistory(cmd *cobra.Command, args []string) error {
+	memberID := args[0]
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may grant history, and to whom
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can grant history: %w", err)
+	}
+	if _, err := os.Stat(paths.MemberTOML(memberID)); os.IsNotExist(err) {
+		return fmt.Errorf("member '%s' not found", memberID)
+	}
+	info, err := storage.ReadMemberTOML(paths.MemberTOML(memberID))
+	if err != nil {
+		return err
+	}
+	if grantHistoryFrom < 0 || grantHistoryFrom >= info.JoinedEpoch {
+		return fmt.Errorf("'%s' joined at epoch %d; --from must be between 0 and %d", memberID, info.JoinedEpoch, info.JoinedEpoch-1)
+	}
+
+	// 2. Encrypt the epoch secrets to each of the member's devices
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+	devices, err := memberDevices(paths, mlsgitGroup, memberID)
+	if err != nil {
+		return err
+	}
+	if err := writeHistoryGrants(paths, mlsgitGroup, archive, devices, grantHistoryFrom, info.JoinedEpoch-1); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/group/history/ && git commit -m 'grant history: %s'\n", info.Name)
+	fmt.Println("  Then push so the member can pull and read the older files.")
+	return nil
+}
+
+// writeHistoryGrants grants the secrets of epochs from..to to each device,
+// replacing any earlier grant to it, and reports how far back the grant
+// reaches through the archive.
+func writeHistoryGrants(paths storage.MLSGitPaths, group *mls.MLSGitGroup, archive *mls.EpochKeyArchive, devices []memberDevice, from, to int) error {
+	secrets := make(map[int][]byte)
+	var missing []int
+	for e := from; e <= to; e++ {
+		s, err := archive.Get(e)
+		if err != nil {
+			missing = append(missing, e)
+			continue
+		}
+		secrets[e] = s
+	}
+	if len(secrets) == 0 {
+		return fmt.Errorf("none of epochs %d-%d are in your epoch key archive", from, to)
+	}
+	for _, d := range devices {
+		grant, err := group.GrantHistory(d.leaf, secrets)
+		if err != nil {
+			return fmt.Errorf("grant history to %s: %w", d.key, err)
+		}
+		if err := storage.WriteHistoryGrant(paths, d.key, grant); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Granted epochs %d-%d to %d device(s).\n", from, to, len(devices))
+	if len(missing) > 0 {
+		fmt.Printf("Skipped %d epoch(s) missing from your archive: %v\n", len(missing), missing)
+	}
+	if start := archive.ChainStart(from); start < from {
+		fmt.Printf("Note: epoch keys are chained, so this also opens epochs %d-%d.\n", start, from-1)
+	}
+	return nil
+}
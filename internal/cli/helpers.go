@@ -1,19 +1,25 @@
 package cli
 
 import (
+	"bufio"
 	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/germtb/mlsgit/internal/config"
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
+	"golang.org/x/term"
 )
 
 func generateMemberID(name string) string {
@@ -34,7 +40,9 @@ func saveMLSState(paths storage.MLSGitPaths, group *mls.MLSGitGroup) error {
 	return storage.WriteLocalMLSState(paths, combined)
 }
 
-func loadMLSGitGroup(paths storage.MLSGitPaths) (*mls.MLSGitGroup, error) {
+// loadLocalMLSGitGroup restores the group from .git/mlsgit/ without looking
+// at committed group state.
+func loadLocalMLSGitGroup(paths storage.MLSGitPaths) (*mls.MLSGitGroup, error) {
 	data, err := storage.ReadLocalMLSState(paths)
 	if err != nil {
 		return nil, err
@@ -43,7 +51,7 @@ func loadMLSGitGroup(paths storage.MLSGitPaths) (*mls.MLSGitGroup, error) {
 	groupBytes := data[32:]
 
 	// Load X25519 init private key for DH operations during sync
-	initPriv, err := os.ReadFile(paths.LocalDir() + "/init_priv.bin")
+	initPriv, err := storage.ReadSecret(paths, paths.InitPriv())
 	if err != nil {
 		return nil, fmt.Errorf("read init_priv: %w", err)
 	}
@@ -52,49 +60,101 @@ func loadMLSGitGroup(paths storage.MLSGitPaths) (*mls.MLSGitGroup, error) {
 	if err != nil {
 		return nil, err
 	}
+	if next, ok, err := storage.FinishInitKeyRotation(paths, group.HoldsInitKey); err != nil {
+		return nil, err
+	} else if ok {
+		if group, err = mls.FromBytes(groupBytes, ed25519.NewKeyFromSeed(sigPriv), next); err != nil {
+			return nil, err
+		}
+	}
+
+	// Enforce the role policy and add quorum on transitions we sync
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig(paths)
+	if err != nil {
+		return nil, err
+	}
+	group.SetAuthorizer(policy.Authorizer(paths, pol))
+	group.SetPostQuantum(cfg.PostQuantum())
+	return group, nil
+}
+
+// generateMLSKeys generates MLS keys with an init key for the group's
+// cipher suite.
+func generateMLSKeys(cfg config.MLSGitConfig) (mls.MLSKeys, error) {
+	if cfg.PostQuantum() {
+		return mls.GenerateHybridMLSKeys()
+	}
+	return mls.GenerateMLSKeys()
+}
+
+func loadMLSGitGroup(paths storage.MLSGitPaths) (*mls.MLSGitGroup, error) {
+	group, err := loadLocalMLSGitGroup(paths)
+	if err != nil {
+		return nil, err
+	}
 
 	// Sync from committed state if it's ahead (e.g., after pulling)
 	if committedBytes, readErr := storage.ReadGroupState(paths); readErr == nil {
-		if group.SyncFromCommitted(committedBytes) {
-			newGroupBytes, _ := group.ToBytes()
-			combined := make([]byte, 32+len(newGroupBytes))
-			copy(combined[:32], sigPriv)
-			copy(combined[32:], newGroupBytes)
-			storage.WriteLocalMLSState(paths, combined)
+		updated, err := group.SyncFromCommitted(committedBytes)
+		var forkErr *mls.ForkError
+		if errors.As(err, &forkErr) {
+			return nil, fmt.Errorf("sync committed group state: %w; run 'mlsgit resolve' to rebase your membership changes", err)
+		}
+		if errors.Is(err, mls.ErrRemoved) {
+			return nil, fmt.Errorf("you were removed from the group after epoch %d; ask a member to add you again", group.Epoch())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sync committed group state: %w", err)
 		}
+		if updated {
+			saveMLSState(paths, group)
+		}
+	}
+	if acceptHistoryGrant(paths, group) {
+		saveMLSState(paths, group)
 	}
 
 	return group, nil
 }
 
-func loadEpochArchive(paths storage.MLSGitPaths, group *mls.MLSGitGroup) (*mls.EpochKeyArchive, error) {
-	epoch := group.Epoch()
-	epochSecret := group.ExportEpochSecret()
-
-	archiveData, err := storage.ReadEpochKeys(paths)
+// acceptHistoryGrant retains the past epoch secrets granted to this
+// device, if any, and reports whether any were new. A grant made to an
+// init key we have since replaced is ignored.
+func acceptHistoryGrant(paths storage.MLSGitPaths, group *mls.MLSGitGroup) bool {
+	memberID, _, err := storage.ReadIdentity(paths)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return mls.NewWithSecret(epoch, epochSecret), nil
-		}
-		return nil, err
+		return false
 	}
-	archive, err := mls.DecryptArchive(archiveData, epochSecret)
+	deviceID, _ := storage.ReadDeviceID(paths)
+	grant, err := storage.ReadHistoryGrant(paths, storage.WelcomeKey(memberID, deviceID))
 	if err != nil {
-		return nil, err
+		return false
 	}
-	if !archive.Has(epoch) {
-		archive.Add(epoch, epochSecret)
+	n, err := group.AcceptHistoryGrant(grant)
+	return err == nil && n > 0
+}
+
+func loadEpochArchive(paths storage.MLSGitPaths, group *mls.MLSGitGroup) (*mls.EpochKeyArchive, error) {
+	archiveData, err := storage.ReadEpochKeys(paths)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
-	return archive, nil
+	return group.OpenEpochArchive(archiveData)
 }
 
 func saveGroupAndArchive(paths storage.MLSGitPaths, group *mls.MLSGitGroup, archive *mls.EpochKeyArchive) error {
-	newEpochSecret := group.ExportEpochSecret()
-	archive.Add(group.Epoch(), newEpochSecret)
+	archive.Add(group.Epoch(), group.ExportEpochSecret())
+	for _, e := range group.HistoryCuts() {
+		archive.Cut(e)
+	}
 
-	archiveData, err := archive.Encrypt(newEpochSecret)
+	archiveData, err := archive.Seal()
 	if err != nil {
-		return fmt.Errorf("encrypt archive: %w", err)
+		return fmt.Errorf("seal archive: %w", err)
 	}
 	if err := storage.WriteEpochKeys(paths, archiveData); err != nil {
 		return err
@@ -110,9 +170,230 @@ func saveGroupAndArchive(paths storage.MLSGitPaths, group *mls.MLSGitGroup, arch
 	if err := storage.WriteGroupState(paths, committedBytes); err != nil {
 		return err
 	}
+	if err := refreshShares(paths, group); err != nil {
+		return fmt.Errorf("refresh backup shares: %w", err)
+	}
 	return saveMLSState(paths, group)
 }
 
+// saveGroupWithInitKey persists a group whose init key was just replaced.
+// The new key is written beside the old one and moved into place once the
+// group state that uses it is saved, so an interruption never leaves the
+// saved state with the wrong key (see storage.FinishInitKeyRotation).
+func saveGroupWithInitKey(paths storage.MLSGitPaths, group *mls.MLSGitGroup, archive *mls.EpochKeyArchive) error {
+	if err := storage.WriteSecret(paths, paths.NextInitPriv(), group.InitPriv()); err != nil {
+		return err
+	}
+	if err := saveGroupAndArchive(paths, group, archive); err != nil {
+		return err
+	}
+	return os.Rename(paths.NextInitPriv(), paths.InitPriv())
+}
+
+// readMemberKeyPackage reads and decodes a member's committed KeyPackage.
+func readMemberKeyPackage(paths storage.MLSGitPaths, memberID string) (mls.KeyPackageData, error) {
+	kpB64, err := os.ReadFile(paths.MemberKeypackage(memberID))
+	if err != nil {
+		return mls.KeyPackageData{}, fmt.Errorf("read keypackage for '%s': %w", memberID, err)
+	}
+	return decodeKeyPackage(kpB64)
+}
+
+// readDeviceKeyPackage reads and decodes the KeyPackage of one of a member's
+// additional devices.
+func readDeviceKeyPackage(paths storage.MLSGitPaths, memberID, deviceID string) (mls.KeyPackageData, error) {
+	kpB64, err := os.ReadFile(paths.DeviceKeypackage(memberID, deviceID))
+	if err != nil {
+		return mls.KeyPackageData{}, fmt.Errorf("read keypackage for device '%s': %w", deviceID, err)
+	}
+	return decodeKeyPackage(kpB64)
+}
+
+// writeDeviceKeyPackage encodes and writes a device's KeyPackage.
+func writeDeviceKeyPackage(paths storage.MLSGitPaths, memberID, deviceID string, kp mls.KeyPackageData) error {
+	kpBytes, err := json.Marshal(kp)
+	if err != nil {
+		return fmt.Errorf("marshal keypackage: %w", err)
+	}
+	return os.WriteFile(paths.DeviceKeypackage(memberID, deviceID), []byte(crypto.B64Encode(kpBytes, false)), 0o644)
+}
+
+func decodeKeyPackage(kpB64 []byte) (mls.KeyPackageData, error) {
+	kpBytes, err := crypto.B64Decode(strings.TrimSpace(string(kpB64)), false)
+	if err != nil {
+		return mls.KeyPackageData{}, fmt.Errorf("decode keypackage: %w", err)
+	}
+	var kp mls.KeyPackageData
+	if err := json.Unmarshal(kpBytes, &kp); err != nil {
+		return mls.KeyPackageData{}, fmt.Errorf("unmarshal keypackage: %w", err)
+	}
+	return kp, nil
+}
+
+// writeMemberKeyPackage encodes and writes a member's KeyPackage.
+func writeMemberKeyPackage(paths storage.MLSGitPaths, memberID string, kp mls.KeyPackageData) error {
+	kpBytes, err := json.Marshal(kp)
+	if err != nil {
+		return fmt.Errorf("marshal keypackage: %w", err)
+	}
+	return os.WriteFile(paths.MemberKeypackage(memberID), []byte(crypto.B64Encode(kpBytes, false)), 0o644)
+}
+
+// memberDevice is one device of a member and its leaf in the group.
+type memberDevice struct {
+	key  string // storage.WelcomeKey of the device
+	leaf int
+}
+
+// memberDevices returns every device of memberID that has a leaf in the
+// group, the member's first device first.
+func memberDevices(paths storage.MLSGitPaths, group *mls.MLSGitGroup, memberID string) ([]memberDevice, error) {
+	kp, err := readMemberKeyPackage(paths, memberID)
+	if err != nil {
+		return nil, err
+	}
+	var devices []memberDevice
+	if idx := group.FindLeafIndex(kp.InitPub); idx >= 0 {
+		devices = append(devices, memberDevice{key: memberID, leaf: idx})
+	}
+	deviceIDs, err := storage.ListDeviceIDs(paths, memberID)
+	if err != nil {
+		return nil, err
+	}
+	for _, did := range deviceIDs {
+		kp, err := readDeviceKeyPackage(paths, memberID, did)
+		if err != nil {
+			return nil, err
+		}
+		if idx := group.FindLeafIndex(kp.InitPub); idx >= 0 {
+			devices = append(devices, memberDevice{key: storage.WelcomeKey(memberID, did), leaf: idx})
+		}
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("member '%s' not found in MLS group state", memberID)
+	}
+	return devices, nil
+}
+
+// memberLeaves returns the group leaves of every device of memberID.
+func memberLeaves(paths storage.MLSGitPaths, group *mls.MLSGitGroup, memberID string) ([]int, error) {
+	devices, err := memberDevices(paths, group, memberID)
+	if err != nil {
+		return nil, err
+	}
+	leaves := make([]int, len(devices))
+	for i, d := range devices {
+		leaves[i] = d.leaf
+	}
+	return leaves, nil
+}
+
+// loadSigningKey returns this device's Ed25519 identity key and the author
+// string it signs as.
+func loadSigningKey(paths storage.MLSGitPaths) (string, ed25519.PrivateKey, error) {
+	memberID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return "", nil, fmt.Errorf("read identity: %w", err)
+	}
+	deviceID, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return "", nil, err
+	}
+	pemData, err := storage.ReadSecret(paths, paths.PrivateKey())
+	if err != nil {
+		return "", nil, fmt.Errorf("read private key: %w", err)
+	}
+	key, err := crypto.LoadPrivateKey(string(pemData))
+	if err != nil {
+		return "", nil, err
+	}
+	return storage.DeviceAuthor(memberID, deviceID), key, nil
+}
+
+// savePolicy signs and writes a new version of the role policy.
+func savePolicy(paths storage.MLSGitPaths, pol *policy.Policy) error {
+	author, key, err := loadSigningKey(paths)
+	if err != nil {
+		return err
+	}
+	if err := policy.Save(paths, pol, author, key); err != nil {
+		return fmt.Errorf("write policy: %w", err)
+	}
+	return nil
+}
+
+// loadConfig reads .mlsgit/config.toml, falling back to defaults if absent.
+func loadConfig(paths storage.MLSGitPaths) (config.MLSGitConfig, error) {
+	data, err := os.ReadFile(paths.ConfigTOML())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config.DefaultConfig(), nil
+		}
+		return config.MLSGitConfig{}, fmt.Errorf("read config: %w", err)
+	}
+	return config.ConfigFromTOML(string(data))
+}
+
+// keysStale reports whether keys last refreshed at updated are older than
+// the configured rotation interval.
+func keysStale(updated time.Time, cfg config.MLSGitConfig, now time.Time) bool {
+	if cfg.RotationInterval <= 0 {
+		return false
+	}
+	maxAge := time.Duration(cfg.RotationInterval) * 24 * time.Hour
+	return now.Sub(updated) > maxAge
+}
+
+// keysUpdated returns when a member's keys were last refreshed. Members
+// recorded before refreshes were tracked count from the commit that added
+// their member file, and members not committed yet from now.
+func keysUpdated(paths storage.MLSGitPaths, memberID string, info storage.MemberInfo, now time.Time) time.Time {
+	if info.KeysUpdated != 0 {
+		return time.Unix(info.KeysUpdated, 0)
+	}
+	rel, err := filepath.Rel(paths.Root, paths.MemberTOML(memberID))
+	if err != nil {
+		return now
+	}
+	out, err := gitOutput(paths.Root, "log", "-1", "--diff-filter=A", "--format=%ct", "--", rel)
+	if err != nil {
+		return now
+	}
+	added, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return now
+	}
+	return time.Unix(added, 0)
+}
+
+// warnStaleKeys prints a warning to stderr for every member whose keys are
+// older than the configured rotation interval.
+func warnStaleKeys(paths storage.MLSGitPaths) {
+	cfg, err := loadConfig(paths)
+	if err != nil || cfg.RotationInterval <= 0 {
+		return
+	}
+	myID, _, _ := storage.ReadIdentity(paths)
+	ids, _ := storage.ListMemberIDs(paths)
+	now := time.Now()
+	for _, mid := range ids {
+		if storage.IsRecovery(paths, mid) {
+			continue // offline keys are never rotated
+		}
+		info, err := storage.ReadMemberTOML(paths.MemberTOML(mid))
+		if err != nil || !keysStale(keysUpdated(paths, mid, info, now), cfg, now) {
+			continue
+		}
+		if mid == myID {
+			fmt.Fprintf(os.Stderr, "Warning: your keys are older than %d days. Run 'mlsgit update' to refresh them.\n",
+				cfg.RotationInterval)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: keys for '%s' (%s) are older than %d days.\n",
+				info.Name, mid, cfg.RotationInterval)
+		}
+	}
+}
+
 func collectFileHashes(root string) ([]crypto.FileHash, error) {
 	cmd := exec.Command("git", "ls-files", "-z")
 	cmd.Dir = root
@@ -145,15 +426,22 @@ func installFilterConfig(root string) error {
 		return err
 	}
 	configText := string(data)
-	if strings.Contains(configText, `[filter "mlsgit"]`) {
-		return nil
-	}
 
 	binary := resolveFilterBinary()
-	block := fmt.Sprintf("\n[filter \"mlsgit\"]\n\tclean = %s filter clean %%f\n\tsmudge = %s filter smudge %%f\n\trequired = true\n",
-		binary, binary)
+	var blocks string
+	if !strings.Contains(configText, `[filter "mlsgit"]`) {
+		blocks += fmt.Sprintf("\n[filter \"mlsgit\"]\n\tclean = %s filter clean %%f\n\tsmudge = %s filter smudge %%f\n\trequired = true\n",
+			binary, binary)
+	}
+	if !strings.Contains(configText, `[merge "mlsgit"]`) {
+		blocks += fmt.Sprintf("\n[merge \"mlsgit\"]\n\tname = mlsgit group state merge\n\tdriver = %s merge-driver %%O %%A %%B %%P\n",
+			binary)
+	}
+	if blocks == "" {
+		return nil
+	}
 
-	return os.WriteFile(gitConfig, []byte(configText+block), 0o644)
+	return os.WriteFile(gitConfig, []byte(configText+blocks), 0o644)
 }
 
 func resolveFilterBinary() string {
@@ -211,3 +499,152 @@ func getRootAndPaths() (string, storage.MLSGitPaths, error) {
 	}
 	return root, storage.MLSGitPaths{Root: root}, nil
 }
+
+// stdinReader is shared by passphrase prompts so consecutive reads from a
+// piped stdin don't lose buffered lines.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// readPassphrase prompts for a passphrase on the terminal without echo.
+// When stdin is not a terminal (scripts, tests) it reads one line instead.
+func readPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		pass, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return pass, err
+	}
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("read passphrase: %w", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// newSigningKey creates this device's Ed25519 identity key and stores it
+// in .git/mlsgit/. With sshKeyPath it imports an existing OpenSSH Ed25519
+// key instead of generating one; a path to the .pub file is taken to mean
+// the private key next to it. An imported key that was passphrase-protected
+// is never stored in the clear: without a keystore, one is created under
+// the same passphrase, so every local key file is sealed from the start.
+// Returns the key and its public key PEM.
+func newSigningKey(paths storage.MLSGitPaths, sshKeyPath string) (ed25519.PrivateKey, ed25519.PublicKey, string, error) {
+	var priv ed25519.PrivateKey
+	var pub ed25519.PublicKey
+	var passphrase []byte
+	var err error
+	if sshKeyPath == "" {
+		priv, pub, err = crypto.GenerateKeypair()
+	} else {
+		priv, passphrase, err = loadSSHKey(sshKeyPath)
+		if err == nil {
+			pub = priv.Public().(ed25519.PublicKey)
+		}
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if passphrase != nil {
+		if err := sealLocalKeys(paths, passphrase); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	privPEM, err := crypto.PrivateKeyToPEM(priv)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := storage.WriteSecret(paths, paths.PrivateKey(), []byte(privPEM)); err != nil {
+		return nil, nil, "", err
+	}
+	pubPEM, err := crypto.PublicKeyToPEM(pub)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return priv, pub, pubPEM, nil
+}
+
+// sealLocalKeys creates a keystore under passphrase, if there is none yet,
+// so that key files written from now on are sealed, and unlocks this
+// session. It is used when importing an encrypted SSH key.
+func sealLocalKeys(paths storage.MLSGitPaths, passphrase []byte) error {
+	ks, err := storage.ReadKeystore(paths)
+	if err != nil || ks != nil {
+		return err
+	}
+	newKs, key, err := storage.NewKeystore(passphrase)
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteKeystore(paths, newKs); err != nil {
+		return err
+	}
+	storage.UseKey(paths, key)
+	sessionErr := storage.SaveSession(paths, key, defaultUnlockFor)
+	if sessionErr != nil && !errors.Is(sessionErr, storage.ErrNoSessionDir) {
+		return sessionErr
+	}
+	fmt.Fprintln(os.Stderr, "WARNING: your SSH key is passphrase-protected, so mlsgit seals its copy of it,")
+	fmt.Fprintln(os.Stderr, "and every other key in .git/mlsgit/, under the same passphrase.")
+	if sessionErr != nil {
+		fmt.Fprintf(os.Stderr, "No session was opened: %v.\n", sessionErr)
+		fmt.Fprintln(os.Stderr, "'mlsgit passwd' changes the passphrase.")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "This session stays unlocked for %s; after that, run 'mlsgit unlock' (or set %s)\n", defaultUnlockFor, storage.PassphraseEnv)
+	fmt.Fprintln(os.Stderr, "before git can encrypt or decrypt files. 'mlsgit passwd' changes the passphrase.")
+	return nil
+}
+
+// loadSSHKey reads an OpenSSH Ed25519 private key, asking for its
+// passphrase if it is encrypted. It returns the passphrase, or nil if the
+// key was not encrypted.
+func loadSSHKey(path string) (ed25519.PrivateKey, []byte, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		path = filepath.Join(home, rest)
+	}
+	path = strings.TrimSuffix(path, ".pub")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read ssh key: %w", err)
+	}
+	var pass []byte
+	key, err := crypto.ParseSSHPrivateKey(data, nil)
+	if errors.Is(err, crypto.ErrSSHKeyEncrypted) {
+		var perr error
+		if pass, perr = readPassphrase(fmt.Sprintf("Passphrase for %s: ", path)); perr != nil {
+			return nil, nil, perr
+		}
+		if len(pass) == 0 {
+			return nil, nil, fmt.Errorf("%s is encrypted; its passphrase must not be empty", path)
+		}
+		key, err = crypto.ParseSSHPrivateKey(data, pass)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, pass, nil
+}
+
+// decryptWorkingTree re-checks out every tracked file outside .mlsgit/ so
+// the smudge filter replaces ciphertext with plaintext once we hold keys.
+func decryptWorkingTree(root string) {
+	fmt.Println("Decrypting working tree...")
+	lsCmd := exec.Command("git", "ls-files", "-z")
+	lsCmd.Dir = root
+	lsOut, _ := lsCmd.Output()
+	for _, f := range strings.Split(string(lsOut), "\x00") {
+		if f != "" && !strings.HasPrefix(f, ".mlsgit/") {
+			fp := root + "/" + f
+			os.Remove(fp)
+		}
+	}
+	checkoutCmd := exec.Command("git", "checkout", "--", ".", ":(exclude).mlsgit")
+	checkoutCmd.Dir = root
+	checkoutCmd.Run()
+	fmt.Println("Done. All files decrypted.")
+}
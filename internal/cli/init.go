@@ -10,11 +10,17 @@ import (
 	"github.com/germtb/mlsgit/internal/config"
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 	"github.com/spf13/cobra"
 )
 
-var initName string
+var (
+	initName        string
+	initPostQuantum bool
+	initSSHKey      string
+	initRecoveryKey string
+)
 
 var initCmd = &cobra.Command{
 	Use:   "init",
@@ -24,6 +30,9 @@ var initCmd = &cobra.Command{
 
 func init() {
 	initCmd.Flags().StringVar(&initName, "name", "", "Your display name for the group")
+	initCmd.Flags().BoolVar(&initPostQuantum, "post-quantum", false, "Use the X25519 + ML-KEM-768 hybrid KEM for Welcomes and re-keying")
+	initCmd.Flags().StringVar(&initSSHKey, "ssh-key", "", "Use an existing OpenSSH Ed25519 key (e.g. ~/.ssh/id_ed25519) as your signing key")
+	initCmd.Flags().StringVar(&initRecoveryKey, "recovery-key", "", "Add a recovery leaf for this offline public key (see 'mlsgit recover --keygen')")
 	rootCmd.AddCommand(initCmd)
 }
 
@@ -36,6 +45,14 @@ func runInit(cmd *cobra.Command, args []string) error {
 	if _, err := os.Stat(paths.MLSGitDir()); err == nil {
 		return fmt.Errorf(".mlsgit/ already exists. MLSGit is already initialized")
 	}
+	var recoveryKP *mls.KeyPackageData
+	if initRecoveryKey != "" {
+		kp, err := parseRecoveryKey(initRecoveryKey)
+		if err != nil {
+			return err
+		}
+		recoveryKP = &kp
+	}
 
 	if initName == "" {
 		fmt.Print("Your name: ")
@@ -47,25 +64,18 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("create dirs: %w", err)
 	}
 
-	// 2. Generate Ed25519 key pair for signing deltas
-	signingPriv, signingPub, err := crypto.GenerateKeypair()
-	if err != nil {
-		return err
-	}
-	privPEM, err := crypto.PrivateKeyToPEM(signingPriv)
-	if err != nil {
-		return err
-	}
-	if err := os.WriteFile(paths.PrivateKey(), []byte(privPEM), 0o600); err != nil {
-		return err
-	}
-	pubPEM, err := crypto.PublicKeyToPEM(signingPub)
+	// 2. Generate (or import) the Ed25519 key pair for signing deltas
+	signingPriv, signingPub, pubPEM, err := newSigningKey(paths, initSSHKey)
 	if err != nil {
 		return err
 	}
 
 	// 3. Generate MLS keys and create group
-	mlsKeys, err := mls.GenerateMLSKeys()
+	cfg := config.DefaultConfig()
+	if initPostQuantum {
+		cfg.CipherSuite = crypto.SuiteHybridAES256GCM
+	}
+	mlsKeys, err := generateMLSKeys(cfg)
 	if err != nil {
 		return err
 	}
@@ -87,14 +97,11 @@ func runInit(cmd *cobra.Command, args []string) error {
 	}
 
 	// Save init_priv for Welcome processing
-	if err := os.WriteFile(
-		paths.LocalDir()+"/init_priv.bin", mlsKeys.InitPriv, 0o600,
-	); err != nil {
+	if err := storage.WriteSecret(paths, paths.InitPriv(), mlsKeys.InitPriv); err != nil {
 		return err
 	}
 
 	// 5. Write committed files
-	cfg := config.DefaultConfig()
 	if err := os.WriteFile(paths.ConfigTOML(), []byte(cfg.ToTOML()), 0o644); err != nil {
 		return err
 	}
@@ -113,6 +120,26 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// The creator is the group's first admin, next to the recovery leaf
+	pol := policy.New(memberID)
+	if recoveryKP != nil {
+		recoveryID, err := addRecoveryLeaf(paths, mlsgitGroup, *recoveryKP, memberID)
+		if err != nil {
+			return err
+		}
+		pol.Roles[recoveryID] = policy.Recovery
+		if err := saveMLSState(paths, mlsgitGroup); err != nil {
+			return err
+		}
+		if err := storage.WriteEpochTOML(paths, mlsgitGroup.Epoch()); err != nil {
+			return err
+		}
+		fmt.Printf("Recovery leaf added (member ID: %s).\n", recoveryID)
+	}
+	if err := policy.Save(paths, pol, memberID, signingPriv); err != nil {
+		return fmt.Errorf("write policy: %w", err)
+	}
+
 	// Save group state (committed — excludes epoch_secret for security)
 	committedBytes, _ := mlsgitGroup.ToCommittedBytes()
 	if err := storage.WriteGroupState(paths, committedBytes); err != nil {
@@ -122,7 +149,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 	// Save epoch key archive
 	epochSecret := mlsgitGroup.ExportEpochSecret()
 	archive := mls.NewWithSecret(mlsgitGroup.Epoch(), epochSecret)
-	archiveData, err := archive.Encrypt(epochSecret)
+	archiveData, err := archive.Seal()
 	if err != nil {
 		return err
 	}
@@ -144,8 +171,14 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// 8. Create .mlsgit/.gitattributes to exclude from encryption
-	if err := os.WriteFile(paths.MLSGitGitattributes(), []byte("* -filter\n"), 0o644); err != nil {
+	// 8. Create .mlsgit/.gitattributes to exclude from encryption and route
+	// group state through the mlsgit merge driver
+	if err := os.WriteFile(paths.MLSGitGitattributes(), []byte(
+		"* -filter\n"+
+			"group/state.b64 merge=mlsgit\n"+
+			"epoch.toml merge=mlsgit\n"+
+			"epoch_keys.b64 merge=mlsgit\n",
+	), 0o644); err != nil {
 		return err
 	}
 
@@ -171,7 +204,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	fp, _ := crypto.PublicKeyFingerprint(signingPub)
+	fp, _ := crypto.SSHFingerprint(signingPub)
 	fmt.Printf("MLSGit initialized for '%s' (member ID: %s)\n", initName, memberID)
 	fmt.Printf("Epoch: %d\n", mlsgitGroup.Epoch())
 	fmt.Printf("Public key fingerprint: %s\n", fp)
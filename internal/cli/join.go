@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"strings"
+	"time"
 
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/mls"
@@ -14,18 +14,27 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var joinName string
+var (
+	joinName     string
+	joinDeviceOf string
+	joinSSHKey   string
+)
 
 var joinCmd = &cobra.Command{
 	Use:   "join",
 	Short: "Join an mlsgit-enabled repository",
 	Long: `Run once to create a join request (then commit and open a PR).
-Run again after your request is approved to decrypt the repo.`,
+Run again after your request is approved to decrypt the repo.
+
+With --device-of, link this clone as another device of an existing member
+instead; one of that member's devices approves it with 'mlsgit device add'.`,
 	RunE: runJoin,
 }
 
 func init() {
-	joinCmd.Flags().StringVar(&joinName, "name", "", "Your display name for the group")
+	joinCmd.Flags().StringVar(&joinName, "name", "", "Your display name for the group (the device name with --device-of)")
+	joinCmd.Flags().StringVar(&joinDeviceOf, "device-of", "", "Link this clone as a new device of an existing member ID")
+	joinCmd.Flags().StringVar(&joinSSHKey, "ssh-key", "", "Use an existing OpenSSH Ed25519 key (e.g. ~/.ssh/id_ed25519) as your signing key")
 	rootCmd.AddCommand(joinCmd)
 }
 
@@ -56,46 +65,63 @@ func runJoin(cmd *cobra.Command, args []string) error {
 	}
 
 	// Fresh join: create request
+	var memberName string
+	if joinDeviceOf != "" {
+		info, err := storage.ReadMemberTOML(paths.MemberTOML(joinDeviceOf))
+		if err != nil {
+			return fmt.Errorf("member '%s' not found: %w", joinDeviceOf, err)
+		}
+		memberName = info.Name
+	}
 	if joinName == "" {
-		fmt.Print("Your name: ")
+		if joinDeviceOf != "" {
+			fmt.Print("Device name: ")
+		} else {
+			fmt.Print("Your name: ")
+		}
 		fmt.Scanln(&joinName)
 	}
 
-	// 1. Generate Ed25519 key pair
-	signingPriv, signingPub, err := crypto.GenerateKeypair()
-	if err != nil {
-		return err
-	}
-	privPEM, err := crypto.PrivateKeyToPEM(signingPriv)
+	// 1. Generate (or import) the Ed25519 key pair
+	signingPriv, signingPub, pubPEM, err := newSigningKey(paths, joinSSHKey)
 	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(paths.PrivateKey(), []byte(privPEM), 0o600); err != nil {
-		return err
-	}
-	pubPEM, err := crypto.PublicKeyToPEM(signingPub)
+
+	// 2. Generate MLS keys for the group's cipher suite
+	cfg, err := loadConfig(paths)
 	if err != nil {
 		return err
 	}
-
-	// 2. Generate MLS keys
-	mlsKeys, err := mls.GenerateMLSKeys()
+	mlsKeys, err := generateMLSKeys(cfg)
 	if err != nil {
 		return err
 	}
-	memberID := generateMemberID(joinName)
-
 	kp := mls.BuildKeyPackage([]byte(joinName), mlsKeys)
 	kpBytes, _ := json.Marshal(kp)
 	kpB64 := crypto.B64Encode(kpBytes, false)
+	storage.WriteSecret(paths, paths.InitPriv(), mlsKeys.InitPriv)
+	storage.WriteSecret(paths, paths.SigPriv(), mlsKeys.SigPriv.Seed())
+
+	if joinDeviceOf != "" {
+		return requestDevice(paths, root, joinDeviceOf, memberName, joinName, signingPub, pubPEM, kpB64)
+	}
 
-	// Save identity and keys locally
+	// Save identity locally
+	memberID := generateMemberID(joinName)
 	storage.WriteIdentity(paths, memberID, joinName)
-	os.WriteFile(paths.LocalDir()+"/init_priv.bin", mlsKeys.InitPriv, 0o600)
-	os.WriteFile(paths.LocalDir()+"/sig_priv.bin", mlsKeys.SigPriv.Seed(), 0o600)
 
-	// 3. Write pending request
-	if err := storage.WritePendingRequest(paths, memberID, joinName, pubPEM, kpB64); err != nil {
+	// 3. Sign the request with both keys and write it
+	req := storage.PendingRequestInfo{
+		MemberID:   memberID,
+		Name:       joinName,
+		PublicKey:  pubPEM,
+		Keypackage: kpB64,
+		Timestamp:  time.Now().Unix(),
+	}
+	req.Signature = crypto.Sign(signingPriv, storage.JoinRequestBytes(req))
+	req.MLSSignature = crypto.Sign(mlsKeys.SigPriv, storage.JoinRequestBytes(req))
+	if err := storage.WritePendingRequest(paths, req); err != nil {
 		return err
 	}
 
@@ -107,7 +133,7 @@ func runJoin(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("create welcome branch: %w\n%s", err, out)
 	}
 
-	fp, _ := crypto.PublicKeyFingerprint(signingPub)
+	fp, _ := crypto.SSHFingerprint(signingPub)
 	fmt.Printf("Join request created for '%s' (member ID: %s)\n", joinName, memberID)
 	fmt.Printf("Public key fingerprint: %s\n", fp)
 	fmt.Printf("Branch: %s\n", branchName)
@@ -121,31 +147,80 @@ func runJoin(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// requestDevice writes a request to link this clone as a new device of
+// memberID and creates its welcome branch.
+func requestDevice(paths storage.MLSGitPaths, root, memberID, memberName, deviceName string, signingPub ed25519.PublicKey, pubPEM, kpB64 string) error {
+	deviceID := generateMemberID(deviceName)
+	storage.WriteDeviceIdentity(paths, memberID, memberName, deviceID)
+
+	// 3. Write pending device request
+	if err := storage.WritePendingDevice(paths, storage.PendingDeviceInfo{
+		MemberID:   memberID,
+		DeviceID:   deviceID,
+		Name:       deviceName,
+		PublicKey:  pubPEM,
+		Keypackage: kpB64,
+	}); err != nil {
+		return err
+	}
+
+	// 4. Create welcome branch
+	branchName := "welcome/" + storage.WelcomeKey(memberID, deviceID)
+	branchCmd := exec.Command("git", "checkout", "-b", branchName)
+	branchCmd.Dir = root
+	if out, err := branchCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("create welcome branch: %w\n%s", err, out)
+	}
+
+	fp, _ := crypto.SSHFingerprint(signingPub)
+	fmt.Printf("Device request created for '%s' (member %s, device ID: %s)\n", deviceName, memberID, deviceID)
+	fmt.Printf("Public key fingerprint: %s\n", fp)
+	fmt.Printf("Branch: %s\n", branchName)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/pending/ && git commit -m 'request device: %s'\n", deviceName)
+	fmt.Printf("  git push -u origin %s\n", branchName)
+	fmt.Printf("  On one of your existing devices, check out the branch and run 'mlsgit device add %s'.\n", deviceID)
+	fmt.Println()
+	fmt.Println("After the device is approved and you pull, run 'mlsgit join' again.")
+
+	return nil
+}
+
 func processWelcome(paths storage.MLSGitPaths, root string) error {
 	memberID, _, err := storage.ReadIdentity(paths)
 	if err != nil {
 		return err
 	}
+	deviceID, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+	welcomeKey := storage.WelcomeKey(memberID, deviceID)
 
-	welcomePath := paths.WelcomeFile(memberID)
+	welcomePath := paths.WelcomeFile(welcomeKey)
 	if _, err := os.Stat(welcomePath); os.IsNotExist(err) {
 		fmt.Println("Waiting for approval. No Welcome message found yet.")
-		fmt.Println("Ask an existing member to run 'mlsgit add <your-id>' and push.")
+		if deviceID != "" {
+			fmt.Printf("On one of your existing devices, run 'mlsgit device add %s' and push.\n", deviceID)
+		} else {
+			fmt.Println("Ask an existing member to run 'mlsgit add <your-id>' and push.")
+		}
 		return nil
 	}
 
 	// Load Welcome
-	welcomeBytes, err := storage.ReadWelcome(paths, memberID)
+	welcomeBytes, err := storage.ReadWelcome(paths, welcomeKey)
 	if err != nil {
 		return fmt.Errorf("read welcome: %w", err)
 	}
 
 	// Load keys saved during initial join
-	initPriv, err := os.ReadFile(paths.LocalDir() + "/init_priv.bin")
+	initPriv, err := storage.ReadSecret(paths, paths.InitPriv())
 	if err != nil {
 		return fmt.Errorf("read init_priv: %w (run 'mlsgit join' to create a request first)", err)
 	}
-	sigPrivSeed, err := os.ReadFile(paths.LocalDir() + "/sig_priv.bin")
+	sigPrivSeed, err := storage.ReadSecret(paths, paths.SigPriv())
 	if err != nil {
 		return fmt.Errorf("read sig_priv: %w", err)
 	}
@@ -165,21 +240,26 @@ func processWelcome(paths storage.MLSGitPaths, root string) error {
 		return fmt.Errorf("join from welcome: %w", err)
 	}
 
-	// Save MLS state
+	// Save MLS state, with any history granted alongside the Welcome
+	acceptHistoryGrant(paths, mlsgitGroup)
 	if err := saveMLSState(paths, mlsgitGroup); err != nil {
 		return err
 	}
 
 	// Load epoch key archive
-	epochSecret := mlsgitGroup.ExportEpochSecret()
-	archiveData, err := storage.ReadEpochKeys(paths)
-	if err == nil {
-		archive, err := mls.DecryptArchive(archiveData, epochSecret)
+	if _, err := os.Stat(paths.EpochKeys()); err == nil {
+		archive, err := loadEpochArchive(paths, mlsgitGroup)
 		if err != nil {
 			fmt.Printf("Warning: could not load epoch key archive: %v\n", err)
 			fmt.Println("You may not be able to decrypt historical files.")
 		} else {
-			fmt.Printf("Loaded epoch key archive with %d epoch(s).\n", len(archive.Epochs()))
+			readable := 0
+			for _, e := range archive.Epochs() {
+				if _, err := archive.Get(e); err == nil {
+					readable++
+				}
+			}
+			fmt.Printf("Loaded epoch key archive with %d readable epoch(s).\n", readable)
 		}
 	}
 
@@ -194,7 +274,7 @@ func processWelcome(paths storage.MLSGitPaths, root string) error {
 	// Determine the main branch for merge
 	mainBranch := getMainBranch(root)
 	currentBranch := getCurrentBranch(root)
-	welcomeBranch := "welcome/" + memberID
+	welcomeBranch := "welcome/" + welcomeKey
 
 	// If we're on the welcome branch, merge to main
 	if currentBranch == welcomeBranch {
@@ -203,7 +283,7 @@ func processWelcome(paths storage.MLSGitPaths, root string) error {
 		stageCmd.Dir = root
 		stageCmd.CombinedOutput()
 
-		commitCmd := exec.Command("git", "commit", "-m", "process welcome: "+memberID)
+		commitCmd := exec.Command("git", "commit", "-m", "process welcome: "+welcomeKey)
 		commitCmd.Dir = root
 		commitCmd.CombinedOutput()
 
@@ -213,7 +293,7 @@ func processWelcome(paths storage.MLSGitPaths, root string) error {
 		mergeCmd.CombinedOutput()
 
 		// Belt-and-suspenders: ensure welcome file is not in the index
-		rmCmd := exec.Command("git", "rm", "--cached", "--ignore-unmatch", paths.WelcomeFile(memberID))
+		rmCmd := exec.Command("git", "rm", "--cached", "--ignore-unmatch", paths.WelcomeFile(welcomeKey))
 		rmCmd.Dir = root
 		rmCmd.CombinedOutput()
 
@@ -221,7 +301,7 @@ func processWelcome(paths storage.MLSGitPaths, root string) error {
 		commitRmCmd := exec.Command("git", "diff", "--cached", "--quiet")
 		commitRmCmd.Dir = root
 		if err := commitRmCmd.Run(); err != nil {
-			amendCmd := exec.Command("git", "commit", "-m", "add member: "+memberID)
+			amendCmd := exec.Command("git", "commit", "-m", "add member: "+welcomeKey)
 			amendCmd.Dir = root
 			amendCmd.CombinedOutput()
 		}
@@ -241,21 +321,6 @@ func processWelcome(paths storage.MLSGitPaths, root string) error {
 		}
 	}
 
-	// Force re-checkout to decrypt all files
-	fmt.Println("Decrypting working tree...")
-	lsCmd := exec.Command("git", "ls-files", "-z")
-	lsCmd.Dir = root
-	lsOut, _ := lsCmd.Output()
-	for _, f := range strings.Split(string(lsOut), "\x00") {
-		if f != "" && !strings.HasPrefix(f, ".mlsgit/") {
-			fp := root + "/" + f
-			os.Remove(fp)
-		}
-	}
-	checkoutCmd := exec.Command("git", "checkout", "--", ".")
-	checkoutCmd.Dir = root
-	checkoutCmd.Run()
-	fmt.Println("Done. All files decrypted.")
-
+	decryptWorkingTree(root)
 	return nil
 }
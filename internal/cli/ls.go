@@ -3,7 +3,9 @@ package cli
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -30,12 +32,34 @@ func runLs(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	// Identify current user
-	var ownID string
+	// Identify current user and device
+	var ownID, ownDevice string
 	if _, err := os.Stat(paths.IdentityTOML()); err == nil {
 		ownID, _, _ = storage.ReadIdentity(paths)
+		ownDevice, _ = storage.ReadDeviceID(paths)
 	}
 
+	cfg, err := loadConfig(paths)
+	if err != nil {
+		return err
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	var recoveryIDs []string
+	members := memberIDs[:0]
+	for _, mid := range memberIDs {
+		if storage.IsRecovery(paths, mid) {
+			recoveryIDs = append(recoveryIDs, mid)
+		} else {
+			members = append(members, mid)
+		}
+	}
+	memberIDs = members
+
 	fmt.Printf("Members (%d):\n\n", len(memberIDs))
 	for _, mid := range memberIDs {
 		info, err := storage.ReadMemberTOML(paths.MemberTOML(mid))
@@ -45,8 +69,38 @@ func runLs(cmd *cobra.Command, args []string) error {
 		marker := ""
 		if mid == ownID {
 			marker = "  (you)"
+		} else if ownID != "" {
+			if storage.IdentityVerified(paths, ownID, mid) {
+				marker = "  [identity verified]"
+			} else {
+				marker = "  [identity unverified]"
+			}
+		}
+		if pol != nil {
+			marker += fmt.Sprintf("  [%s]", pol.Role(mid))
+		}
+		if keysStale(keysUpdated(paths, mid, info, now), cfg, now) {
+			marker += "  [keys stale]"
+		}
+		if cfg.PostQuantum() {
+			if kp, err := readMemberKeyPackage(paths, mid); err == nil && len(kp.PQInitPub) == 0 {
+				marker += "  [no post-quantum key]"
+			}
 		}
 		fmt.Printf("  %s [%s] joined at epoch %d%s\n", info.Name, mid, info.JoinedEpoch, marker)
+		printDevices(paths, mid, ownID, ownDevice, cfg.PostQuantum())
+	}
+
+	// Recovery leaves hold offline keys and never write
+	if len(recoveryIDs) > 0 {
+		fmt.Printf("\nRecovery (%d):\n\n", len(recoveryIDs))
+		for _, rid := range recoveryIDs {
+			info, err := storage.ReadRecovery(paths, rid)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("  offline key [%s] added at epoch %d by %s  [recovery]\n", rid, info.AddedEpoch, info.AddedBy)
+		}
 	}
 
 	// Show pending requests count
@@ -57,3 +111,35 @@ func runLs(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// printDevices lists a member's devices, if they have more than one. With
+// postQuantum set, devices still on X25519-only init keys are flagged.
+func printDevices(paths storage.MLSGitPaths, memberID, ownID, ownDevice string, postQuantum bool) {
+	deviceIDs, err := storage.ListDeviceIDs(paths, memberID)
+	if err != nil || len(deviceIDs) == 0 {
+		return
+	}
+	thisDevice := func(did string) string {
+		if memberID == ownID && did == ownDevice {
+			return "  (this device)"
+		}
+		return ""
+	}
+	fmt.Printf("      - primary device%s\n", thisDevice(""))
+	for _, did := range deviceIDs {
+		dev, err := storage.ReadDeviceInfo(paths, memberID, did)
+		if err != nil {
+			continue
+		}
+		marker := thisDevice(did)
+		if _, err := storage.DevicePublicKey(paths, memberID, did); err != nil {
+			marker += "  [unverified]"
+		}
+		if postQuantum {
+			if kp, err := readDeviceKeyPackage(paths, memberID, did); err == nil && len(kp.PQInitPub) == 0 {
+				marker += "  [no post-quantum key]"
+			}
+		}
+		fmt.Printf("      - %s [%s] added at epoch %d%s\n", dev.Name, did, dev.AddedEpoch, marker)
+	}
+}
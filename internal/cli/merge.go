@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// mergeDriverCmd is the hidden git merge driver for committed group state.
+var mergeDriverCmd = &cobra.Command{
+	Use:          "merge-driver [base] [ours] [theirs] [path]",
+	Short:        "Git merge driver for .mlsgit group state",
+	Hidden:       true,
+	Args:         cobra.ExactArgs(4),
+	SilenceUsage: true,
+	RunE:         runMergeDriver,
+}
+
+func init() {
+	rootCmd.AddCommand(mergeDriverCmd)
+}
+
+// runMergeDriver merges one .mlsgit file, writing the result to the ours
+// file as git expects. Linear group histories take the newer side. Forked
+// histories keep ours, leave a marker for 'mlsgit resolve' and report a
+// conflict.
+func runMergeDriver(cmd *cobra.Command, args []string) error {
+	oursFile, theirsFile, path := args[1], args[2], filepath.ToSlash(args[3])
+	ours, err := os.ReadFile(oursFile)
+	if err != nil {
+		return err
+	}
+	theirs, err := os.ReadFile(theirsFile)
+	if err != nil {
+		return err
+	}
+
+	switch path {
+	case ".mlsgit/group/state.b64":
+		_, paths, err := getRootAndPaths()
+		if err != nil {
+			return err
+		}
+		return mergeGroupState(paths, oursFile, ours, theirs)
+	case ".mlsgit/epoch.toml":
+		oursEpoch, err := storage.EpochFromTOML(ours)
+		if err != nil {
+			return err
+		}
+		theirsEpoch, err := storage.EpochFromTOML(theirs)
+		if err != nil {
+			return err
+		}
+		if theirsEpoch > oursEpoch {
+			return os.WriteFile(oursFile, theirs, 0o644)
+		}
+		return nil
+	case ".mlsgit/epoch_keys.b64":
+		// Both sides only append entries; take the union. If the group
+		// history forked, 'mlsgit resolve' rewraps the entries after it.
+		merged, err := mls.MergeArchives(ours, theirs)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(oursFile, merged, 0o644)
+	default:
+		return fmt.Errorf("no mlsgit merge strategy for %s", path)
+	}
+}
+
+func mergeGroupState(paths storage.MLSGitPaths, oursFile string, oursB64, theirsB64 []byte) error {
+	ours, err := crypto.B64Decode(strings.TrimSpace(string(oursB64)), false)
+	if err != nil {
+		return fmt.Errorf("decode our group state: %w", err)
+	}
+	theirs, err := crypto.B64Decode(strings.TrimSpace(string(theirsB64)), false)
+	if err != nil {
+		return fmt.Errorf("decode their group state: %w", err)
+	}
+
+	fork, err := mls.DivergenceEpoch(ours, theirs)
+	if err != nil {
+		return err
+	}
+	if fork >= 0 {
+		if err := os.WriteFile(paths.ForkMarker(), []byte(strconv.Itoa(fork)+"\n"), 0o600); err != nil {
+			return err
+		}
+		return fmt.Errorf("group history forked at epoch %d; run 'mlsgit resolve' after the merge", fork)
+	}
+
+	oursEpoch, err := mls.CommittedEpoch(ours)
+	if err != nil {
+		return err
+	}
+	theirsEpoch, err := mls.CommittedEpoch(theirs)
+	if err != nil {
+		return err
+	}
+	if theirsEpoch > oursEpoch {
+		return os.WriteFile(oursFile, theirsB64, 0o644)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/germtb/mlsgit/internal/delta"
+	"github.com/germtb/mlsgit/internal/filter"
+	"github.com/spf13/cobra"
+)
+
+var migrateTo string
+
+var migrateFormatCmd = &cobra.Command{
+	Use:   "migrate-format",
+	Short: "Convert encrypted files to another ciphertext format",
+	Long: `Re-encode every tracked file's ciphertext chain in another format: "text"
+(base64 JSON records, the original format), or the v2 container as
+"binary" or "armored" (base64 between armor lines, which diffs as text).
+Records are not re-encrypted and keep their signatures; each chain is
+decrypted first to check it. The result is staged for you to commit.
+
+The target defaults to the configured ciphertext_format. Streamed records
+have their own format and are left as they are; chains with compressed
+records cannot be converted to text.`,
+	Args: cobra.NoArgs,
+	RunE: runMigrateFormat,
+}
+
+func init() {
+	migrateFormatCmd.Flags().StringVar(&migrateTo, "to", "", "Target format: text, binary or armored (default: ciphertext_format)")
+	rootCmd.AddCommand(migrateFormatCmd)
+}
+
+func runMigrateFormat(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(paths)
+	if err != nil {
+		return err
+	}
+	if migrateTo == "" {
+		migrateTo = cfg.CiphertextFormat
+	}
+	format, err := delta.ParseFormat(migrateTo)
+	if err != nil {
+		return err
+	}
+
+	// 1. Find chains in another format
+	stale, err := stagedFilesMatching(root, func(blob []byte) bool {
+		f, ok := delta.DetectFormat(string(blob))
+		return ok && f != format
+	})
+	if err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		fmt.Printf("Every file is already in the %s format.\n", format)
+		return nil
+	}
+
+	// 2. Convert and stage them
+	migrated := 0
+	for _, f := range stale {
+		ct, err := filter.Migrate(f.path, f.blob, format, paths)
+		if err == nil {
+			err = stageBlob(root, f, ct)
+		}
+		if err != nil {
+			fmt.Printf("  could not convert %s: %v\n", f.path, err)
+			continue
+		}
+		migrated++
+	}
+
+	fmt.Printf("Converted %d of %d file(s) to the %s format.\n", migrated, len(stale), format)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	if string(format) != cfg.CiphertextFormat {
+		fmt.Printf("  mlsgit config set ciphertext_format %s  (so new files use it too)\n", format)
+	}
+	fmt.Printf("  git commit -m 'convert files to the %s ciphertext format'\n", format)
+	fmt.Println("  Then push.")
+	return nil
+}
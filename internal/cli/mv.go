@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/germtb/mlsgit/internal/delta"
+	"github.com/germtb/mlsgit/internal/filter"
+	"github.com/spf13/cobra"
+)
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <source>... <destination>",
+	Short: "Move or rename encrypted files",
+	Long: `Move or rename files with 'git mv' and re-encrypt them for their new
+paths.
+
+Every record is signed for the path it was written for, and a file whose
+chain was written for another path does not decrypt, so a server cannot
+pass one file off as another. A plain 'git mv' stages the old chain under
+the new path; 'mlsgit mv' re-encrypts it as a single base block under the
+current epoch and stages that instead. Files already moved with 'git mv'
+are re-encrypted too. The result is staged for you to commit.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runMv,
+}
+
+func init() {
+	rootCmd.AddCommand(mvCmd)
+}
+
+func runMv(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	state, err := filter.LoadState(paths)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no local MLS state. Run 'mlsgit join' first")
+	}
+
+	// 1. Move the files
+	mvGit := exec.Command("git", append([]string{"mv", "--"}, args...)...)
+	mvGit.Stdout = os.Stdout
+	mvGit.Stderr = os.Stderr
+	if err := mvGit.Run(); err != nil {
+		return fmt.Errorf("git mv: %w", err)
+	}
+
+	// 2. Find staged chains written for another path
+	moved, err := stagedFilesMatching(root, func(blob []byte) bool { return filter.LooksCritCiphertext(string(blob)) })
+	if err != nil {
+		return err
+	}
+
+	// 3. Re-encrypt them for their new paths and stage them
+	reencrypted := 0
+	for _, f := range moved {
+		from, err := delta.ChainPath(string(f.blob))
+		if err != nil || from == "" || from == f.path {
+			continue
+		}
+		ct, err := filter.Move(from, f.path, f.blob, paths)
+		if err != nil {
+			return fmt.Errorf("re-encrypt %s for %s: %w", from, f.path, err)
+		}
+		if err := stageBlob(root, f, ct); err != nil {
+			return fmt.Errorf("stage %s: %w", f.path, err)
+		}
+		fmt.Printf("  %s -> %s\n", from, f.path)
+		reencrypted++
+	}
+
+	fmt.Printf("Re-encrypted %d moved file(s) under epoch %d.\n", reencrypted, state.Group.Epoch())
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Println("  git commit -m 'move files'")
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var passwdRemove bool
+
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Protect local keys with a passphrase, or change it",
+	Long: `Seal the key files in .git/mlsgit/ (signing key, init key and MLS state,
+which holds the current epoch secret) under a key derived from a passphrase
+with Argon2id. Run again to change the passphrase, or with --remove to store
+the keys unsealed.
+
+While the keys are sealed, git's clean/smudge filters need them unlocked:
+run 'mlsgit unlock', or set MLSGIT_PASSPHRASE for scripts.`,
+	Args: cobra.NoArgs,
+	RunE: runPasswd,
+}
+
+func init() {
+	passwdCmd.Flags().BoolVar(&passwdRemove, "remove", false, "Remove the passphrase and store local keys unsealed")
+	rootCmd.AddCommand(passwdCmd)
+}
+
+func runPasswd(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.PrivateKey()); os.IsNotExist(err) {
+		return fmt.Errorf("no local keys. Run 'mlsgit init' or 'mlsgit join' first")
+	}
+
+	// 1. Read the key files, asking for the current passphrase if locked
+	ks, err := storage.ReadKeystore(paths)
+	if err != nil {
+		return err
+	}
+	if ks == nil && passwdRemove {
+		return fmt.Errorf("local keys are not protected by a passphrase")
+	}
+	secrets := map[string][]byte{}
+	for _, p := range storage.LocalSecrets(paths) {
+		data, err := storage.ReadSecret(paths, p)
+		if errors.Is(err, storage.ErrLocked) {
+			pass, perr := readPassphrase("Current passphrase: ")
+			if perr != nil {
+				return perr
+			}
+			key, perr := ks.Unlock(pass)
+			if perr != nil {
+				return perr
+			}
+			storage.UseKey(paths, key)
+			data, err = storage.ReadSecret(paths, p)
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		secrets[p] = data
+	}
+
+	// 2. Remove the passphrase: write the files back unsealed
+	if passwdRemove {
+		if err := storage.RemoveKeystore(paths); err != nil {
+			return err
+		}
+		for p, data := range secrets {
+			if err := os.WriteFile(p, data, 0o600); err != nil {
+				return err
+			}
+		}
+		storage.ClearSession(paths)
+		fmt.Println("Removed the passphrase. Local keys are stored unsealed.")
+		return nil
+	}
+
+	// 3. Seal under the new passphrase
+	pass, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+	if len(pass) == 0 {
+		return fmt.Errorf("passphrase must not be empty (use --remove to drop it)")
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		again, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return err
+		}
+		if string(again) != string(pass) {
+			return fmt.Errorf("passphrases do not match")
+		}
+	}
+	newKs, key, err := storage.NewKeystore(pass)
+	if err != nil {
+		return err
+	}
+	sealed := map[string][]byte{}
+	for p, data := range secrets {
+		if sealed[p], err = crypto.SealLocal(key, data); err != nil {
+			return err
+		}
+	}
+	if err := storage.WriteKeystore(paths, newKs); err != nil {
+		return err
+	}
+	for p, data := range sealed {
+		if err := os.WriteFile(p, data, 0o600); err != nil {
+			return err
+		}
+	}
+	storage.UseKey(paths, key)
+
+	// 4. Keep this session unlocked so the next git command works. The
+	// keys are sealed either way; without a runtime directory git needs
+	// the passphrase in the environment instead
+	fmt.Println("Local keys are sealed under your passphrase.")
+	if err := storage.SaveSession(paths, key, defaultUnlockFor); errors.Is(err, storage.ErrNoSessionDir) {
+		fmt.Printf("No session was opened: %v.\n", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("This session stays unlocked for %s; run 'mlsgit lock' to lock it now.\n", defaultUnlockFor)
+	return nil
+}
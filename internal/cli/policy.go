@@ -0,0 +1,279 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Show the role policy",
+	Long: `Show which members are admins, writers and readers.
+
+Admins can add and remove members and change roles. Writers can encrypt
+files. Readers can only decrypt; files they sign are rejected.
+
+A role change starts a new epoch and applies from it: files and membership
+changes from earlier epochs are checked against the role their signer held
+then, so demoting a writer keeps the files they wrote readable.`,
+	Args: cobra.NoArgs,
+	RunE: runPolicyShow,
+}
+
+var policySetCmd = &cobra.Command{
+	Use:   "set [member-id] [role]",
+	Short: "Assign a role (admin, writer or reader) to a member",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runPolicySet,
+}
+
+var policyQuorumCmd = &cobra.Command{
+	Use:   "quorum [n]",
+	Short: "Set how many admins must approve adding a member",
+	Long: `Set add_quorum, the number of admins whose signed approvals 'mlsgit add'
+needs before it admits a member. The quorum is part of the signed policy,
+and clients check each add against the quorum at the epoch it was made.
+
+Raising the quorum takes effect at once, but is refused while the group
+has a recovery leaf, which adds members without approvals. Lowering it
+needs the approval of as many admins as the current quorum: the first
+admin to run the command records a proposal in .mlsgit/pending/quorum.toml,
+each admin who runs the same command adds their approval, and the last one
+writes the new quorum into the policy.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPolicyQuorum,
+}
+
+func init() {
+	policyCmd.AddCommand(policySetCmd)
+	policyCmd.AddCommand(policyQuorumCmd)
+	rootCmd.AddCommand(policyCmd)
+}
+
+func runPolicyShow(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if pol == nil {
+		fmt.Println("No role policy; every member can add and remove members.")
+		fmt.Println("Run 'mlsgit policy set <member-id> <role>' to create one with yourself as admin.")
+		return nil
+	}
+
+	ids := make([]string, 0, len(pol.Roles))
+	for id := range pol.Roles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fmt.Printf("Policy version %d, signed by %s:\n\n", pol.Version, pol.UpdatedBy)
+	for _, id := range ids {
+		name := "?"
+		if info, err := storage.ReadMemberTOML(paths.MemberTOML(id)); err == nil {
+			name = info.Name
+		}
+		fmt.Printf("  %-7s %s [%s]\n", pol.Role(id), name, id)
+	}
+	if q := pol.AddQuorum(); q > 1 {
+		fmt.Printf("\nAdding a member needs %d admin approvals.\n", q)
+	}
+	return nil
+}
+
+func runPolicySet(cmd *cobra.Command, args []string) error {
+	memberID := args[0]
+	role, err := policy.ParseRole(args[1])
+	if err != nil {
+		return err
+	}
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may change the policy
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	if _, err := storage.ReadMemberTOML(paths.MemberTOML(memberID)); err != nil {
+		return fmt.Errorf("member '%s' not found", memberID)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if pol == nil {
+		fmt.Println("Creating a role policy with you as admin.")
+		pol = policy.New(myID)
+	} else if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can change roles: %w", err)
+	}
+
+	// 2. Keep at least one admin
+	if pol.Role(memberID) == role {
+		fmt.Printf("Member %s is already %s.\n", memberID, role)
+		return nil
+	}
+	admins := 0
+	for id, r := range pol.Roles {
+		if r == policy.Admin && id != memberID {
+			admins++
+		}
+	}
+	if admins == 0 && role != policy.Admin {
+		return fmt.Errorf("the policy must keep at least one admin")
+	}
+
+	// 3. Start a new epoch for the role to take effect from, so records
+	// and transitions from earlier epochs keep the role they were made with
+	myDevice, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	oldEpoch := mlsgitGroup.Epoch()
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+	if _, err := mlsgitGroup.SelfUpdate(); err != nil {
+		return fmt.Errorf("self update: %w", err)
+	}
+	newEpoch := mlsgitGroup.Epoch()
+	fmt.Printf("MLS epoch advanced: %d -> %d\n", oldEpoch, newEpoch)
+	if err := publishOwnInitKey(paths, mlsgitGroup, myID, myDevice, mlsgitGroup.InitPub(), mlsgitGroup.PQInitPub()); err != nil {
+		return err
+	}
+
+	// 4. Sign and write the new version and persist all state
+	pol.SetRole(memberID, role, newEpoch)
+	if err := savePolicy(paths, pol); err != nil {
+		return err
+	}
+	if err := saveGroupWithInitKey(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+
+	fmt.Printf("Member %s is %s from epoch %d (policy version %d).\n", memberID, role, newEpoch, pol.Version)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add . && git commit -m 'policy: %s is %s'\n", memberID, role)
+	fmt.Println("  Then push.")
+	return nil
+}
+
+func runPolicyQuorum(cmd *cobra.Command, args []string) error {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return fmt.Errorf("add_quorum must be a number of at least 1")
+	}
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may change the policy
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if pol == nil {
+		fmt.Println("Creating a role policy with you as admin.")
+		pol = policy.New(myID)
+	} else if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can change add_quorum: %w", err)
+	}
+	current := pol.AddQuorum()
+	if n == current {
+		fmt.Printf("add_quorum is already %d.\n", n)
+		return nil
+	}
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	epoch := mlsgitGroup.Epoch()
+
+	// 2. Raise the quorum at once, unless a recovery leaf could bypass it
+	if n > current {
+		if ids := pol.RecoveryIDs(); len(ids) > 0 {
+			return fmt.Errorf("recovery leaf %s can add members without add_quorum; remove it with 'mlsgit remove %s' before raising add_quorum", ids[0], ids[0])
+		}
+		pol.SetQuorum(epoch, n, nil)
+		if err := savePolicy(paths, pol); err != nil {
+			return err
+		}
+		fmt.Printf("add_quorum raised from %d to %d (policy version %d).\n", current, n, pol.Version)
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Printf("  git add .mlsgit/policy.toml && git commit -m 'policy: add_quorum %d'\n", n)
+		fmt.Println("  Then push.")
+		return nil
+	}
+
+	// 3. Lowering it needs approvals from the current quorum of admins
+	index := len(pol.Quorum)
+	prop, err := policy.ReadQuorumProposal(paths)
+	if err != nil {
+		return err
+	}
+	if prop == nil || prop.Index != index || prop.From != current || prop.Approvals != n {
+		prop = &policy.QuorumProposal{Index: index, From: current, Approvals: n, Epoch: epoch}
+	}
+	author, key, err := loadSigningKey(paths)
+	if err != nil {
+		return err
+	}
+	vote := storage.Approval{Approver: author, Signature: crypto.Sign(key, policy.QuorumBytes(index, current, n, prop.Epoch))}
+	votes := []storage.Approval{vote}
+	for _, a := range prop.Signatures {
+		if a.Approver != author {
+			votes = append(votes, a)
+		}
+	}
+	prop.Signatures = votes
+	approvals := policy.CountQuorumApprovals(paths, pol, index, current, n, prop.Epoch, prop.Signatures)
+	if approvals < current {
+		if err := policy.WriteQuorumProposal(paths, *prop); err != nil {
+			return fmt.Errorf("write proposal: %w", err)
+		}
+		fmt.Printf("Approval recorded for lowering add_quorum to %d (%d of %d required).\n", n, approvals, current)
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Printf("  git add .mlsgit/pending/ && git commit -m 'approve add_quorum %d'\n", n)
+		fmt.Printf("  Then push and ask another admin to run 'mlsgit policy quorum %d'.\n", n)
+		return nil
+	}
+
+	// 4. Write the approved change into the policy
+	pol.SetQuorum(prop.Epoch, n, prop.Signatures)
+	if err := savePolicy(paths, pol); err != nil {
+		return err
+	}
+	os.Remove(paths.PendingQuorum())
+	fmt.Printf("add_quorum lowered from %d to %d with %d admin approvals (policy version %d).\n", current, n, approvals, pol.Version)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/ && git commit -m 'policy: add_quorum %d'\n", n)
+	fmt.Println("  Then push.")
+	return nil
+}
@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/curve25519"
+)
+
+var (
+	recoverName   string
+	recoverKeygen bool
+)
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Regain access through the recovery leaf and become a new admin",
+	Long: `Use the offline recovery private key to take part as the recovery leaf,
+catch up on every epoch since it was added, and add a new admin identity
+for this clone. Run it in a fresh clone; the key is read from the terminal
+or stdin.
+
+With --keygen, print a new recovery key pair instead, preferably on the
+offline machine that keeps it: the public key for 'mlsgit init
+--recovery-key' or 'mlsgit add-recovery', which holds the leaf's X25519
+init key and Ed25519 signing key, and the private key to store offline.`,
+	Args: cobra.NoArgs,
+	RunE: runRecover,
+}
+
+func init() {
+	recoverCmd.Flags().StringVar(&recoverName, "name", "", "Display name of the new admin")
+	recoverCmd.Flags().BoolVar(&recoverKeygen, "keygen", false, "Generate a recovery key pair and exit")
+	rootCmd.AddCommand(recoverCmd)
+}
+
+func runRecover(cmd *cobra.Command, args []string) error {
+	if recoverKeygen {
+		return printRecoveryKeypair()
+	}
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.MLSGitDir()); os.IsNotExist(err) {
+		return fmt.Errorf(".mlsgit/ not found. This repo is not mlsgit-enabled")
+	}
+	if _, err := os.Stat(paths.MLSState()); err == nil {
+		memberID, _, _ := storage.ReadIdentity(paths)
+		return fmt.Errorf("this clone already holds keys for member '%s'; recover in a fresh clone", memberID)
+	}
+	if recoverName == "" {
+		fmt.Print("Name of the new admin: ")
+		fmt.Scanln(&recoverName)
+	}
+
+	// 1. Find the recovery leaf and open its signing key
+	secret, err := readPassphrase("Recovery private key: ")
+	if err != nil {
+		return err
+	}
+	recoveryPriv, seed, err := parseRecoveryPrivateKey(string(secret))
+	if err != nil {
+		return err
+	}
+	recoveryID, info, err := findRecoveryLeaf(paths, recoveryPriv)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.MemberTOML(recoveryID)); os.IsNotExist(err) {
+		return fmt.Errorf("recovery leaf '%s' has been removed from the group", recoveryID)
+	}
+	if seed == nil {
+		// Leaves added before the signing key was generated offline
+		// carry it sealed to the init key
+		if len(info.SigningKey) == 0 {
+			return fmt.Errorf("recovery leaf '%s' needs the full private key printed by 'mlsgit recover --keygen'", recoveryID)
+		}
+		if seed, err = crypto.DecryptWelcome(recoveryPriv, info.SigningKey); err != nil {
+			return fmt.Errorf("open recovery signing key: %w", err)
+		}
+	}
+	sigPriv := ed25519.NewKeyFromSeed(seed)
+	if kp, err := readMemberKeyPackage(paths, recoveryID); err != nil {
+		return err
+	} else if !bytes.Equal(kp.SigPub, sigPriv.Public().(ed25519.PublicKey)) {
+		return fmt.Errorf("the private key does not match recovery leaf '%s'", recoveryID)
+	}
+
+	// 2. Join as the recovery leaf and catch up to the current epoch
+	recoveryGroup, err := mls.JoinFromWelcome(info.Welcome, mls.MLSKeys{
+		SigPriv:  sigPriv,
+		SigPub:   sigPriv.Public().(ed25519.PublicKey),
+		InitPriv: recoveryPriv,
+	})
+	if err != nil {
+		return fmt.Errorf("join as recovery leaf: %w", err)
+	}
+	if err := paths.EnsureDirs(); err != nil {
+		return err
+	}
+	installFilterConfig(root)
+	sigPEM, err := crypto.PrivateKeyToPEM(sigPriv)
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteIdentity(paths, recoveryID, "recovery"); err != nil {
+		return err
+	}
+	if err := storage.WriteSecret(paths, paths.PrivateKey(), []byte(sigPEM)); err != nil {
+		return err
+	}
+	if err := storage.WriteSecret(paths, paths.InitPriv(), recoveryPriv); err != nil {
+		return err
+	}
+	if err := saveMLSState(paths, recoveryGroup); err != nil {
+		return err
+	}
+	if recoveryGroup, err = loadMLSGitGroup(paths); err != nil {
+		return fmt.Errorf("catch up from epoch %d: %w", info.AddedEpoch, err)
+	}
+	archive, err := loadEpochArchive(paths, recoveryGroup)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recovery leaf caught up from epoch %d to %d.\n", info.AddedEpoch, recoveryGroup.Epoch())
+
+	// 3. Add the new admin from the recovery leaf (advances epoch)
+	cfg, err := loadConfig(paths)
+	if err != nil {
+		return err
+	}
+	mlsKeys, err := generateMLSKeys(cfg)
+	if err != nil {
+		return err
+	}
+	kp := mls.BuildKeyPackage([]byte(recoverName), mlsKeys)
+	_, welcomeBytes, err := recoveryGroup.AddMember(kp)
+	if err != nil {
+		return fmt.Errorf("add new admin: %w", err)
+	}
+	memberID := generateMemberID(recoverName)
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if pol != nil {
+		pol.SetRole(memberID, policy.Admin, recoveryGroup.Epoch())
+		if err := savePolicy(paths, pol); err != nil {
+			return err
+		}
+	}
+	if err := saveGroupAndArchive(paths, recoveryGroup, archive); err != nil {
+		return err
+	}
+
+	// 4. Switch this clone to the new admin
+	mlsgitGroup, err := mls.JoinFromWelcome(welcomeBytes, mlsKeys)
+	if err != nil {
+		return fmt.Errorf("join as new admin: %w", err)
+	}
+	_, _, pubPEM, err := newSigningKey(paths, "")
+	if err != nil {
+		return err
+	}
+	if err := storage.WriteMemberTOML(paths, memberID, recoverName, pubPEM, mlsgitGroup.Epoch(), recoveryID); err != nil {
+		return err
+	}
+	if err := writeMemberKeyPackage(paths, memberID, kp); err != nil {
+		return err
+	}
+	if err := storage.WriteIdentity(paths, memberID, recoverName); err != nil {
+		return err
+	}
+	if err := storage.WriteSecret(paths, paths.InitPriv(), mlsKeys.InitPriv); err != nil {
+		return err
+	}
+	if err := storage.WriteSecret(paths, paths.SigPriv(), mlsKeys.SigPriv.Seed()); err != nil {
+		return err
+	}
+	if err := saveMLSState(paths, mlsgitGroup); err != nil {
+		return err
+	}
+	storage.NewFilterCache(paths).InvalidateAll()
+
+	fmt.Printf("Recovered as new admin '%s' (member ID: %s).\n", recoverName, memberID)
+	fmt.Printf("Epoch: %d\n", mlsgitGroup.Epoch())
+	decryptWorkingTree(root)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add . && git commit -m 'recover: %s'\n", recoverName)
+	fmt.Println("  Then push, and remove members who lost their keys with 'mlsgit remove <id>'.")
+	fmt.Println("  The recovery key has now been used online. Add a fresh one with")
+	fmt.Printf("  'mlsgit add-recovery <key>' and remove this one with 'mlsgit remove %s'.\n", recoveryID)
+	return nil
+}
+
+// printRecoveryKeypair generates and prints a recovery key pair: the
+// recovery leaf's self-signed key package, and its X25519 init key and
+// Ed25519 signing seed.
+func printRecoveryKeypair() error {
+	initPriv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(initPriv); err != nil {
+		return fmt.Errorf("generate recovery key: %w", err)
+	}
+	initPub, err := curve25519.X25519(initPriv, curve25519.Basepoint)
+	if err != nil {
+		return fmt.Errorf("derive recovery public key: %w", err)
+	}
+	sigPriv, sigPub, err := crypto.GenerateKeypair()
+	if err != nil {
+		return fmt.Errorf("generate recovery signing key: %w", err)
+	}
+	kp := mls.BuildKeyPackage([]byte("recovery"), mls.MLSKeys{SigPriv: sigPriv, SigPub: sigPub, InitPub: initPub})
+	kpBytes, err := json.Marshal(kp)
+	if err != nil {
+		return fmt.Errorf("marshal keypackage: %w", err)
+	}
+	fmt.Printf("Public key:  %s\n", crypto.B64Encode(kpBytes, false))
+	fmt.Printf("Private key: %s\n", crypto.B64Encode(append(initPriv, sigPriv.Seed()...), false))
+	fmt.Println()
+	fmt.Println("Store the private key offline; anyone holding it can read the repository")
+	fmt.Println("and make themselves an admin.")
+	return nil
+}
+
+// parseRecoveryPrivateKey decodes a recovery private key: the X25519 init
+// key followed by the Ed25519 signing seed, or only the init key for
+// leaves whose signing key is sealed in their recovery record.
+func parseRecoveryPrivateKey(s string) (initPriv, seed []byte, err error) {
+	raw, err := crypto.B64Decode(s, false)
+	switch {
+	case err != nil:
+	case len(raw) == curve25519.ScalarSize+ed25519.SeedSize:
+		return raw[:curve25519.ScalarSize], raw[curve25519.ScalarSize:], nil
+	case len(raw) == curve25519.ScalarSize:
+		return raw, nil, nil
+	}
+	return nil, nil, fmt.Errorf("invalid recovery private key: want the private key printed by 'mlsgit recover --keygen'")
+}
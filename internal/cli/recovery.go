@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/curve25519"
+)
+
+var addRecoveryCmd = &cobra.Command{
+	Use:   "add-recovery [public-key]",
+	Short: "Add a recovery leaf whose private key is kept offline",
+	Long: `Add a recovery leaf for the given public key, as printed by 'mlsgit recover
+--keygen' on the offline machine: the leaf's X25519 init key and Ed25519
+signing key, signed by the latter. Both private keys stay offline. The
+leaf receives every Welcome and re-keying like a member but never encrypts
+files. If every member loses their keys, the holder of the private key
+runs 'mlsgit recover' in a fresh clone to become a new admin.
+
+The recovery leaf can add and remove members without add_quorum, so it can
+only be added while add_quorum is 1, and add_quorum cannot be raised until
+it is removed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAddRecovery,
+}
+
+func init() {
+	rootCmd.AddCommand(addRecoveryCmd)
+}
+
+func runAddRecovery(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	recoveryKP, err := parseRecoveryKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may add a recovery leaf
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can add a recovery leaf: %w", err)
+	}
+	if q := pol.AddQuorum(); q > 1 {
+		return fmt.Errorf("add_quorum is %d; a recovery leaf bypasses it, so it can only be added while add_quorum is 1", q)
+	}
+
+	// 2. Add the leaf (advances epoch)
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	if mlsgitGroup.FindLeafIndex(recoveryKP.InitPub) >= 0 {
+		return fmt.Errorf("this key is already in the group")
+	}
+	oldEpoch := mlsgitGroup.Epoch()
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+	recoveryID, err := addRecoveryLeaf(paths, mlsgitGroup, recoveryKP, myID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("MLS epoch advanced: %d -> %d\n", oldEpoch, mlsgitGroup.Epoch())
+
+	// 3. Assign the recovery role and persist all state
+	if pol != nil {
+		pol.SetRole(recoveryID, policy.Recovery, mlsgitGroup.Epoch())
+		if err := savePolicy(paths, pol); err != nil {
+			return err
+		}
+	}
+	if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+	storage.NewFilterCache(paths).InvalidateAll()
+
+	fmt.Printf("Recovery leaf added (member ID: %s).\n", recoveryID)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Println("  git add . && git commit -m 'add recovery leaf'")
+	fmt.Println("  Then push. Keep the private key offline.")
+	return nil
+}
+
+// addRecoveryLeaf adds a leaf for an offline key package to the group and
+// writes its member and recovery records. Returns the recovery leaf's
+// member ID; the caller assigns its role.
+func addRecoveryLeaf(paths storage.MLSGitPaths, group *mls.MLSGitGroup, kp mls.KeyPackageData, addedBy string) (string, error) {
+	_, welcome, err := group.AddMember(kp)
+	if err != nil {
+		return "", fmt.Errorf("add recovery leaf: %w", err)
+	}
+
+	recoveryID := generateMemberID("recovery")
+	pubPEM, err := crypto.PublicKeyToPEM(ed25519.PublicKey(kp.SigPub))
+	if err != nil {
+		return "", err
+	}
+	if err := storage.WriteMemberTOML(paths, recoveryID, "recovery", pubPEM, group.Epoch(), addedBy); err != nil {
+		return "", err
+	}
+	if err := writeMemberKeyPackage(paths, recoveryID, kp); err != nil {
+		return "", err
+	}
+	if err := storage.WriteRecovery(paths, recoveryID, storage.RecoveryInfo{
+		InitPub:    kp.InitPub,
+		AddedEpoch: group.Epoch(),
+		AddedBy:    addedBy,
+		Welcome:    welcome,
+	}); err != nil {
+		return "", err
+	}
+	return recoveryID, nil
+}
+
+// parseRecoveryKey decodes the public key printed by 'mlsgit recover
+// --keygen': a key package with an X25519 init key, signed by its own
+// Ed25519 key.
+func parseRecoveryKey(s string) (mls.KeyPackageData, error) {
+	if raw, err := crypto.B64Decode(s, false); err == nil && len(raw) == curve25519.PointSize {
+		return mls.KeyPackageData{}, fmt.Errorf("this is a bare X25519 key from an older 'mlsgit recover --keygen'; generate a new key pair, which includes the leaf's signing key")
+	}
+	kp, err := decodeKeyPackage([]byte(s))
+	if err != nil || len(kp.InitPub) != curve25519.PointSize || len(kp.PQInitPub) > 0 {
+		return mls.KeyPackageData{}, fmt.Errorf("invalid recovery key: want the public key printed by 'mlsgit recover --keygen'")
+	}
+	if err := kp.Verify(); err != nil {
+		return mls.KeyPackageData{}, fmt.Errorf("invalid recovery key: %w", err)
+	}
+	return kp, nil
+}
+
+// findRecoveryLeaf returns the recovery leaf whose offline public key
+// matches recoveryPriv.
+func findRecoveryLeaf(paths storage.MLSGitPaths, recoveryPriv []byte) (string, storage.RecoveryInfo, error) {
+	pub, err := curve25519.X25519(recoveryPriv, curve25519.Basepoint)
+	if err != nil {
+		return "", storage.RecoveryInfo{}, fmt.Errorf("invalid recovery private key: %w", err)
+	}
+	ids, err := storage.ListRecoveryIDs(paths)
+	if err != nil {
+		return "", storage.RecoveryInfo{}, err
+	}
+	for _, id := range ids {
+		info, err := storage.ReadRecovery(paths, id)
+		if err == nil && bytes.Equal(info.InitPub, pub) {
+			return id, info, nil
+		}
+	}
+	return "", storage.RecoveryInfo{}, fmt.Errorf("no recovery leaf in this repository matches the key")
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var rejectReason string
+
+var rejectCmd = &cobra.Command{
+	Use:   "reject [member-id]",
+	Short: "Reject a pending join request",
+	Long: `Reject a pending join request.
+
+Records a rejection signed by this device in .mlsgit/rejected/, deletes the
+pending request and its approvals, and removes the request's welcome
+branch. Run it on the welcome branch (as you would 'mlsgit add'); the
+rejection is committed on the main branch, and nothing from the welcome
+branch is merged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReject,
+}
+
+func init() {
+	rejectCmd.Flags().StringVar(&rejectReason, "reason", "", "Why the request was rejected")
+	rootCmd.AddCommand(rejectCmd)
+}
+
+func runReject(cmd *cobra.Command, args []string) error {
+	memberID := args[0]
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Read the pending request
+	reqPath := paths.PendingRequest(memberID)
+	if _, err := os.Stat(reqPath); os.IsNotExist(err) {
+		return fmt.Errorf("no pending request for member '%s'", memberID)
+	}
+	info, err := storage.ReadPendingRequest(reqPath)
+	if err != nil {
+		return err
+	}
+
+	// 2. Leave the welcome branch: its commits come from the requester, so
+	// the rejection is recorded on the main branch and the branch is
+	// never merged
+	mainBranch := getMainBranch(root)
+	welcomeBranch := "welcome/" + memberID
+	onWelcomeBranch := getCurrentBranch(root) == welcomeBranch
+	if onWelcomeBranch {
+		checkoutCmd := exec.Command("git", "checkout", mainBranch)
+		checkoutCmd.Dir = root
+		if out, err := checkoutCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("checkout %s: %w\n%s", mainBranch, err, out)
+		}
+	}
+
+	// 3. Check that we may reject requests
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can reject join requests: %w", err)
+	}
+
+	// 4. Sign and write the rejection
+	author, key, err := loadSigningKey(paths)
+	if err != nil {
+		return err
+	}
+	rejection := storage.Rejection{
+		MemberID:   memberID,
+		Name:       info.Name,
+		PublicKey:  info.PublicKey,
+		Reason:     rejectReason,
+		RejectedBy: author,
+		Timestamp:  time.Now().Unix(),
+	}
+	rejection.Signature = crypto.Sign(key, storage.RejectionBytes(rejection))
+	if err := storage.WriteRejection(paths, rejection); err != nil {
+		return fmt.Errorf("write rejection: %w", err)
+	}
+
+	// 5. Delete the pending request and approvals, if the main branch has
+	// them
+	changed := []string{paths.RejectionTOML(memberID)}
+	for _, p := range []string{reqPath, paths.PendingApprovalsDir(memberID)} {
+		if _, err := os.Stat(p); err == nil {
+			os.RemoveAll(p)
+			changed = append(changed, p)
+		}
+	}
+
+	// 6. Commit the rejection on the main branch and delete the welcome
+	// branch
+	if onWelcomeBranch {
+		stageCmd := exec.Command("git", append([]string{"add", "-A", "--"}, changed...)...)
+		stageCmd.Dir = root
+		if out, err := stageCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("stage rejection: %w\n%s", err, out)
+		}
+		commitCmd := exec.Command("git", append([]string{"commit", "-m", "reject member: " + info.Name, "--"}, changed...)...)
+		commitCmd.Dir = root
+		if out, err := commitCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("commit rejection: %w\n%s", err, out)
+		}
+	}
+	branchCmd := exec.Command("git", "branch", "-D", welcomeBranch)
+	branchCmd.Dir = root
+	branchCmd.CombinedOutput() // the branch may only exist on the remote
+
+	fmt.Printf("Rejected the join request from '%s' (%s).\n", info.Name, memberID)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	if !onWelcomeBranch {
+		fmt.Printf("  git add .mlsgit/ && git commit -m 'reject member: %s'\n", info.Name)
+	}
+	fmt.Printf("  git push origin %s\n", mainBranch)
+	fmt.Printf("  git push origin --delete %s\n", welcomeBranch)
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/delta"
+	"github.com/germtb/mlsgit/internal/filter"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var rekeyHistory bool
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt files under the current epoch",
+	Long: `Re-encrypt every tracked file as a single base block under the current
+epoch, so the current files no longer depend on keys a removed member
+held. The result is staged for you to commit.
+
+With --history, every reachable commit is rewritten the same way through
+git fast-export and fast-import, and the mapping from old to new commit IDs
+is printed. Old blobs stay readable to whoever already has them; after a
+force-push every member must re-clone. --history refuses to run with
+uncommitted changes.`,
+	Args: cobra.NoArgs,
+	RunE: runRekey,
+}
+
+func init() {
+	rekeyCmd.Flags().BoolVar(&rekeyHistory, "history", false, "Rewrite every reachable commit, not just the current files")
+	rootCmd.AddCommand(rekeyCmd)
+}
+
+func runRekey(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	state, err := filter.LoadState(paths)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no local MLS state. Run 'mlsgit join' first")
+	}
+	epoch := state.Group.Epoch()
+
+	if rekeyHistory {
+		return rekeyAllHistory(root, paths, state)
+	}
+
+	// 1. Find current files not yet a single block at this epoch
+	stale, err := stagedFilesMatching(root, func(blob []byte) bool { return needsRekey(blob, epoch) })
+	if err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		fmt.Printf("Every file is already encrypted under epoch %d.\n", epoch)
+		return nil
+	}
+
+	// 2. Re-encrypt and stage them
+	rekeyed := 0
+	for _, f := range stale {
+		if err := rebaseStagedFile(root, paths, f); err != nil {
+			fmt.Printf("  could not re-encrypt %s: %v\n", f.path, err)
+			continue
+		}
+		rekeyed++
+	}
+
+	fmt.Printf("Re-encrypted %d of %d file(s) under epoch %d.\n", rekeyed, len(stale), epoch)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git commit -m 'rekey files under epoch %d'\n", epoch)
+	if _, err := os.Stat(paths.MerkleTOML()); err == nil {
+		fmt.Println("  mlsgit seal  (the file hashes changed)")
+	}
+	fmt.Println("  Then push.")
+	return nil
+}
+
+// rekeyAllHistory rewrites every reachable commit so its files are single
+// blocks under the current epoch.
+func rekeyAllHistory(root string, paths storage.MLSGitPaths, state *filter.FilterState) error {
+	// 1. Check that we may rewrite history
+	if err := state.Policy.Require(state.MemberID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can rewrite history: %w", err)
+	}
+	status, err := gitOutput(root, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(status)) > 0 {
+		return fmt.Errorf("working tree has uncommitted changes; commit or stash them before 'mlsgit rekey --history'")
+	}
+
+	// 2. Re-encrypt every file history references and import the result
+	// over the existing refs
+	r := &historyRekeyer{root: root, paths: paths, state: state}
+	mapping, err := rewriteHistory(root, r.rekeyBlob)
+	if err != nil {
+		return err
+	}
+
+	// 3. Check out the rewritten HEAD
+	storage.NewFilterCache(paths).InvalidateAll()
+	if _, err := gitOutput(root, "reset", "-q", "--hard"); err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-encrypted %d blob(s) under epoch %d.\n", r.rekeyed, state.Group.Epoch())
+	if len(r.failed) > 0 {
+		fmt.Printf("Left %d blob(s) as they were:\n", len(r.failed))
+		for _, f := range r.failed {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+	fmt.Printf("\nRewrote %d commit(s) (old -> new):\n", len(mapping))
+	for _, m := range mapping {
+		fmt.Printf("  %s -> %s\n", m[0], m[1])
+	}
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Println("  git push --force --all")
+	fmt.Println("  Ask every member to re-clone; old clones still hold the old blobs.")
+	return nil
+}
+
+// rewriteHistory rewrites every reachable commit through git fast-export
+// and fast-import, replacing each file's blob with the one rewriteBlob
+// returns for it, and returns the [old, new] commit hashes. The working
+// tree and index are left as they were.
+func rewriteHistory(root string, rewriteBlob func(sha, path string) (string, error)) ([][2]string, error) {
+	tmp, err := os.MkdirTemp("", "mlsgit-rewrite-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	oldMarks := filepath.Join(tmp, "old-marks")
+	newMarks := filepath.Join(tmp, "new-marks")
+
+	stream, err := gitOutput(root, "fast-export", "--all", "--no-data", "--signed-tags=strip",
+		"--export-marks="+oldMarks)
+	if err != nil {
+		return nil, err
+	}
+	r := &historyRewriter{rewriteBlob: rewriteBlob, blobs: make(map[string]string)}
+	var rewritten bytes.Buffer
+	if err := r.rewrite(bufio.NewReader(bytes.NewReader(stream)), &rewritten); err != nil {
+		return nil, err
+	}
+
+	importCmd := exec.Command("git", "fast-import", "--force", "--quiet", "--export-marks="+newMarks)
+	importCmd.Dir = root
+	importCmd.Stdin = &rewritten
+	var stderr bytes.Buffer
+	importCmd.Stderr = &stderr
+	if err := importCmd.Run(); err != nil {
+		return nil, fmt.Errorf("git fast-import: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return joinMarks(oldMarks, newMarks)
+}
+
+// historyRewriter rewrites the file references in a fast-export stream.
+type historyRewriter struct {
+	rewriteBlob func(sha, path string) (string, error)
+	blobs       map[string]string // old sha + "\x00" + path -> new sha
+}
+
+// rewrite copies a fast-export stream from in to out, rewriting every
+// filemodify command. Data payloads are copied verbatim.
+func (r *historyRewriter) rewrite(in *bufio.Reader, out io.Writer) error {
+	for {
+		line, err := in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "data "):
+			n, convErr := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "data ")))
+			if convErr != nil {
+				return fmt.Errorf("fast-export: bad data line %q", strings.TrimSpace(line))
+			}
+			io.WriteString(out, line)
+			if _, err := io.CopyN(out, in, int64(n)); err != nil {
+				return fmt.Errorf("fast-export: %w", err)
+			}
+			continue
+		case strings.HasPrefix(line, "M "):
+			rewrittenLine, rwErr := r.rewriteFileModify(line)
+			if rwErr != nil {
+				return rwErr
+			}
+			line = rewrittenLine
+		}
+		io.WriteString(out, line)
+	}
+}
+
+// rewriteFileModify rewrites "M <mode> <sha> <path>" to point at the
+// rewritten blob.
+func (r *historyRewriter) rewriteFileModify(line string) (string, error) {
+	fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 4)
+	if len(fields) != 4 || (fields[1] != "100644" && fields[1] != "100755") {
+		return line, nil
+	}
+	sha, path := fields[2], fields[3]
+	if strings.HasPrefix(path, "\"") {
+		unquoted, err := strconv.Unquote(path)
+		if err != nil {
+			return "", fmt.Errorf("fast-export: bad path %s", path)
+		}
+		path = unquoted
+	}
+
+	key := sha + "\x00" + path
+	newSHA, ok := r.blobs[key]
+	if !ok {
+		var err error
+		if newSHA, err = r.rewriteBlob(sha, path); err != nil {
+			return "", err
+		}
+		r.blobs[key] = newSHA
+	}
+	return fmt.Sprintf("M %s %s %s\n", fields[1], newSHA, fields[3]), nil
+}
+
+// historyRekeyer re-encrypts the blobs of history under the current epoch.
+type historyRekeyer struct {
+	root    string
+	paths   storage.MLSGitPaths
+	state   *filter.FilterState
+	rekeyed int
+	failed  []string
+}
+
+// rekeyBlob re-encrypts one blob and returns the new blob's hash, or the
+// old one if it is under .mlsgit/, needs no change or cannot be decrypted.
+func (r *historyRekeyer) rekeyBlob(sha, path string) (string, error) {
+	if strings.HasPrefix(path, ".mlsgit/") {
+		return sha, nil
+	}
+	blob, err := gitOutput(r.root, "cat-file", "blob", sha)
+	if err != nil {
+		return "", err
+	}
+	if !needsRekey(blob, r.state.Group.Epoch()) {
+		return sha, nil
+	}
+	ct, err := r.state.Rebase(r.paths, path, blob, io.Discard)
+	if err != nil {
+		r.failed = append(r.failed, fmt.Sprintf("%s (%s): %v", path, sha[:7], err))
+		return sha, nil
+	}
+	r.rekeyed++
+	return writeBlob(r.root, ct)
+}
+
+// needsRekey reports whether data is a ciphertext chain other than a
+// single block under epoch.
+func needsRekey(data []byte, epoch int) bool {
+	if !filter.LooksCritCiphertext(string(data)) {
+		return false
+	}
+	epochs, err := delta.ChainEpochs(string(data))
+	if err != nil {
+		return false
+	}
+	return len(epochs) != 1 || epochs[0] != epoch
+}
+
+// joinMarks pairs the commits in two fast-import mark files by mark,
+// returning [old, new] short hashes in mark order.
+func joinMarks(oldFile, newFile string) ([][2]string, error) {
+	oldMarks, err := readMarks(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newMarks, err := readMarks(newFile)
+	if err != nil {
+		return nil, err
+	}
+	marks := make([]int, 0, len(oldMarks))
+	for mark := range oldMarks {
+		marks = append(marks, mark)
+	}
+	sort.Ints(marks)
+	var mapping [][2]string
+	for _, mark := range marks {
+		newSHA, ok := newMarks[mark]
+		if !ok {
+			continue
+		}
+		mapping = append(mapping, [2]string{oldMarks[mark][:7], newSHA[:7]})
+	}
+	return mapping, nil
+}
+
+// readMarks reads a marks file of ":<mark> <sha>" lines.
+func readMarks(file string) (map[int]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read marks: %w", err)
+	}
+	marks := make(map[int]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		mark, sha, ok := strings.Cut(line, " ")
+		if !ok || !strings.HasPrefix(mark, ":") {
+			continue
+		}
+		n, err := strconv.Atoi(mark[1:])
+		if err != nil {
+			continue
+		}
+		marks[n] = sha
+	}
+	return marks, nil
+}
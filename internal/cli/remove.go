@@ -1,12 +1,10 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 
-	"github.com/germtb/mlsgit/internal/crypto"
-	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -50,23 +48,18 @@ func runRemove(cmd *cobra.Command, args []string) error {
 	if memberID == myID {
 		return fmt.Errorf("cannot remove yourself")
 	}
-
-	// 3. Find the member's leaf index via their KeyPackage InitPub
-	kpB64, err := os.ReadFile(paths.MemberKeypackage(memberID))
-	if err != nil {
-		return fmt.Errorf("read keypackage for '%s': %w", memberID, err)
-	}
-	kpBytes, err := crypto.B64Decode(string(kpB64), false)
+	pol, err := policy.Load(paths)
 	if err != nil {
-		return fmt.Errorf("decode keypackage: %w", err)
+		return err
 	}
-	var kp mls.KeyPackageData
-	if err := json.Unmarshal(kpBytes, &kp); err != nil {
-		return fmt.Errorf("unmarshal keypackage: %w", err)
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can remove members: %w", err)
 	}
-	leafIndex := mlsgitGroup.FindLeafIndex(kp.InitPub)
-	if leafIndex < 0 {
-		return fmt.Errorf("member '%s' not found in MLS group state", memberID)
+
+	// 3. Find the leaves of all the member's devices
+	leaves, err := memberLeaves(paths, mlsgitGroup, memberID)
+	if err != nil {
+		return err
 	}
 
 	oldEpoch := mlsgitGroup.Epoch()
@@ -75,8 +68,8 @@ func runRemove(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// 4. Remove member from MLS group (advances epoch)
-	_, err = mlsgitGroup.RemoveMember(leafIndex)
+	// 4. Remove every device of the member in one epoch change
+	_, err = mlsgitGroup.RemoveMembers(leaves)
 	if err != nil {
 		return fmt.Errorf("remove member: %w", err)
 	}
@@ -84,12 +77,16 @@ func runRemove(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("MLS epoch advanced: %d -> %d\n", oldEpoch, newEpoch)
 
-	// 5. Delete member files
-	os.Remove(memberPath)
-	kpPath := paths.MemberKeypackage(memberID)
-	os.Remove(kpPath)
-	welcomePath := paths.WelcomeFile(memberID)
-	os.Remove(welcomePath)
+	// 5. Keep the member's signing keys and delete their files
+	if err := retireMember(paths, pol, memberID, info, newEpoch, myID); err != nil {
+		return err
+	}
+	if pol != nil {
+		pol.SetRole(memberID, "", newEpoch)
+		if err := savePolicy(paths, pol); err != nil {
+			return err
+		}
+	}
 
 	// 6. Persist all state
 	if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
@@ -97,6 +94,10 @@ func runRemove(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Printf("Member '%s' (%s) removed from the group.\n", name, memberID)
+	if len(leaves) > 1 {
+		fmt.Printf("Revoked %d devices.\n", len(leaves))
+	}
+	warnStaleKeys(paths)
 	fmt.Println("New files will be encrypted under the new epoch key.")
 	fmt.Println()
 	fmt.Println("Next steps:")
@@ -105,3 +106,58 @@ func runRemove(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// retireMember moves a member out of the group's records: their signing
+// keys, MLS signature keys and join approvals go to former/ so records and
+// transitions they signed still verify, and their member, device, Welcome
+// and history files are deleted. The caller updates the policy.
+func retireMember(paths storage.MLSGitPaths, pol *policy.Policy, memberID string, info storage.MemberInfo, removedEpoch int, removedBy string) error {
+	former := storage.FormerMember{
+		Name:         info.Name,
+		PublicKey:    info.PublicKey,
+		JoinedEpoch:  info.JoinedEpoch,
+		RemovedEpoch: removedEpoch,
+		RemovedBy:    removedBy,
+	}
+	if pol != nil {
+		former.Role = string(pol.Role(memberID))
+	}
+	if kp, err := readMemberKeyPackage(paths, memberID); err == nil {
+		former.SigPub = kp.SigPub
+	}
+	deviceIDs, _ := storage.ListDeviceIDs(paths, memberID)
+	for _, did := range deviceIDs {
+		dev, err := storage.ReadDeviceInfo(paths, memberID, did)
+		if err != nil {
+			continue
+		}
+		if _, err := storage.DevicePublicKey(paths, memberID, did); err != nil {
+			fmt.Printf("Not keeping key of device %s: %v\n", did, err)
+			continue
+		}
+		fd := storage.FormerDevice{ID: did, Name: dev.Name, PublicKey: dev.PublicKey, AddedEpoch: dev.AddedEpoch}
+		if kp, err := readDeviceKeyPackage(paths, memberID, did); err == nil {
+			fd.SigPub = kp.SigPub
+		}
+		former.Devices = append(former.Devices, fd)
+	}
+	if err := storage.WriteFormerMember(paths, memberID, former); err != nil {
+		return err
+	}
+
+	os.Remove(paths.MemberTOML(memberID))
+	os.Remove(paths.MemberKeypackage(memberID))
+	os.Remove(paths.WelcomeFile(memberID))
+	os.Remove(paths.HistoryGrant(memberID))
+	os.Remove(paths.RecoveryTOML(memberID))
+	for _, did := range deviceIDs {
+		os.Remove(paths.WelcomeFile(storage.WelcomeKey(memberID, did)))
+		os.Remove(paths.HistoryGrant(storage.WelcomeKey(memberID, did)))
+	}
+	os.RemoveAll(paths.DevicesDir(memberID))
+	os.RemoveAll(paths.FormerApprovalsDir(memberID))
+	if err := os.Rename(paths.MemberApprovalsDir(memberID), paths.FormerApprovalsDir(memberID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("move approvals: %w", err)
+	}
+	return nil
+}
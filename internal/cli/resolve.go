@@ -0,0 +1,304 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/filter"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var resolveTheirs string
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Rebase your membership changes after a forked group history",
+	Long: `Resolve a fork in the group's epoch history.
+
+A fork happens when two members change the group concurrently (for example,
+both add someone) and the branches are merged. Run this after 'git merge' or
+'git pull' reports a conflict in .mlsgit/group/state.b64. Your membership
+changes are replayed on top of the other branch's epochs, files you
+encrypted under the abandoned epochs are re-encrypted, and the group ends up
+with a single linear history.`,
+	Args: cobra.NoArgs,
+	RunE: runResolve,
+}
+
+func init() {
+	resolveCmd.Flags().StringVar(&resolveTheirs, "theirs", "MERGE_HEAD", "revision holding the other branch's group state")
+	rootCmd.AddCommand(resolveCmd)
+}
+
+func runResolve(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Read the other branch's committed group state
+	theirsB64, err := gitOutput(root, "show", resolveTheirs+":.mlsgit/group/state.b64")
+	if err != nil {
+		return fmt.Errorf("read group state from %s: %w", resolveTheirs, err)
+	}
+	theirs, err := crypto.B64Decode(strings.TrimSpace(string(theirsB64)), false)
+	if err != nil {
+		return fmt.Errorf("decode group state from %s: %w", resolveTheirs, err)
+	}
+
+	// 2. Load our local group and epoch archive
+	mlsgitGroup, err := loadLocalMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	oldEpoch := mlsgitGroup.Epoch()
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+
+	// 3. Follow the other branch, or rebase onto it if the histories forked
+	_, err = mlsgitGroup.SyncFromCommitted(theirs)
+	var forkErr *mls.ForkError
+	if err == nil {
+		if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
+			return err
+		}
+		os.Remove(paths.ForkMarker())
+		fmt.Printf("No fork to resolve; group is at epoch %d.\n", mlsgitGroup.Epoch())
+		return nil
+	}
+	if !errors.As(err, &forkErr) {
+		return fmt.Errorf("sync group state from %s: %w", resolveTheirs, err)
+	}
+	res, err := mlsgitGroup.Rebase(theirs)
+	if err != nil {
+		return fmt.Errorf("rebase: %w", err)
+	}
+	fmt.Printf("Group history forked at epoch %d; rebased onto %s.\n", res.ForkEpoch, resolveTheirs)
+	fmt.Printf("MLS epoch: %d -> %d\n", oldEpoch, mlsgitGroup.Epoch())
+
+	// 4. Replace secrets of our abandoned epochs with the rebased ones
+	for _, e := range archive.Epochs() {
+		if e > res.ForkEpoch {
+			archive.Remove(e)
+		}
+	}
+	for e, secret := range res.Secrets {
+		archive.Add(e, secret)
+	}
+
+	// 5. Publish the outcome of each replayed membership change
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	myDevice, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+	removed := formerRemovedSince(paths, myID, res.ForkEpoch)
+	for _, op := range res.Ops {
+		if op.Skipped != "" {
+			fmt.Printf("  skipped %s: %s\n", op.Op, op.Skipped)
+			if op.Op == mls.OpRemove && len(removed) > 0 {
+				// The other branch removed them too; keep its record
+				gitOutput(root, "checkout", resolveTheirs, "--", ".mlsgit/former/"+removed[0]+".toml")
+				removed = removed[1:]
+			}
+			continue
+		}
+		switch op.Op {
+		case mls.OpAdd:
+			memberID, deviceID, err := findDeviceByInitPub(paths, op.InitPub)
+			if err != nil {
+				return err
+			}
+			os.MkdirAll(paths.WelcomeDir(), 0o755)
+			if err := storage.WriteWelcome(paths, storage.WelcomeKey(memberID, deviceID), op.Welcome); err != nil {
+				return err
+			}
+			if deviceID != "" {
+				info, err := storage.ReadDeviceInfo(paths, memberID, deviceID)
+				if err != nil {
+					return fmt.Errorf("read device record for '%s': %w", deviceID, err)
+				}
+				info.AddedEpoch = op.Epoch
+				if err := storage.WriteDeviceInfo(paths, memberID, deviceID, info); err != nil {
+					return err
+				}
+				fmt.Printf("  re-added device '%s' (%s) at epoch %d\n", info.Name, deviceID, op.Epoch)
+				continue
+			}
+			info, err := storage.ReadMemberTOML(paths.MemberTOML(memberID))
+			if err != nil {
+				return fmt.Errorf("read member record for '%s': %w", memberID, err)
+			}
+			info.JoinedEpoch = op.Epoch
+			if err := storage.WriteMemberInfo(paths, memberID, info); err != nil {
+				return err
+			}
+			fmt.Printf("  re-added '%s' (%s) at epoch %d\n", info.Name, memberID, op.Epoch)
+		case mls.OpRemove:
+			if len(removed) > 0 {
+				former, err := storage.ReadFormerMember(paths, removed[0])
+				if err != nil {
+					return err
+				}
+				former.RemovedEpoch = op.Epoch
+				if err := storage.WriteFormerMember(paths, removed[0], former); err != nil {
+					return err
+				}
+				removed = removed[1:]
+			}
+			fmt.Printf("  re-applied removal at epoch %d\n", op.Epoch)
+		case mls.OpUpdate:
+			if err := publishOwnInitKey(paths, mlsgitGroup, myID, myDevice, op.InitPub, op.PQInitPub); err != nil {
+				return err
+			}
+			fmt.Printf("  re-applied key update at epoch %d\n", op.Epoch)
+		}
+	}
+
+	// 6. Persist all state
+	if err := saveGroupWithInitKey(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+	os.Remove(paths.ForkMarker())
+
+	// 7. Re-encrypt files we changed since the fork under the rebased epoch
+	cache := storage.NewFilterCache(paths)
+	cache.InvalidateAll()
+	ourFiles, err := changedSinceMergeBase(root, resolveTheirs)
+	if err != nil {
+		return err
+	}
+	if len(ourFiles) > 0 {
+		if _, err := gitOutput(root, append([]string{"add", "--renormalize", "--"}, ourFiles...)...); err != nil {
+			return fmt.Errorf("re-encrypt files: %w", err)
+		}
+	}
+
+	// 8. Decrypt files from the other branch that were left encrypted
+	stale, err := encryptedWorkingFiles(root)
+	if err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		for _, f := range stale {
+			os.Remove(root + "/" + f)
+		}
+		if _, err := gitOutput(root, append([]string{"checkout", "--"}, stale...)...); err != nil {
+			return fmt.Errorf("decrypt files: %w", err)
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Println("  git add . && git commit --no-edit")
+	fmt.Println("  Then push.")
+
+	return nil
+}
+
+// gitOutput runs git in root and returns its stdout.
+func gitOutput(root string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// findDeviceByInitPub returns the member and device ("" for a primary
+// device) whose published KeyPackage holds initPub.
+func findDeviceByInitPub(paths storage.MLSGitPaths, initPub []byte) (string, string, error) {
+	ids, err := storage.ListMemberIDs(paths)
+	if err != nil {
+		return "", "", err
+	}
+	for _, mid := range ids {
+		kp, err := readMemberKeyPackage(paths, mid)
+		if err == nil && bytes.Equal(kp.InitPub, initPub) {
+			return mid, "", nil
+		}
+		deviceIDs, _ := storage.ListDeviceIDs(paths, mid)
+		for _, did := range deviceIDs {
+			kp, err := readDeviceKeyPackage(paths, mid, did)
+			if err == nil && bytes.Equal(kp.InitPub, initPub) {
+				return mid, did, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no member record for a re-added member")
+}
+
+// formerRemovedSince lists, in removal order, the former members we removed
+// after epoch.
+func formerRemovedSince(paths storage.MLSGitPaths, myID string, epoch int) []string {
+	ids, _ := storage.ListFormerMemberIDs(paths)
+	removedAt := make(map[string]int)
+	var out []string
+	for _, id := range ids {
+		f, err := storage.ReadFormerMember(paths, id)
+		if err == nil && f.RemovedBy == myID && f.RemovedEpoch > epoch {
+			removedAt[id] = f.RemovedEpoch
+			out = append(out, id)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return removedAt[out[i]] < removedAt[out[j]] })
+	return out
+}
+
+// changedSinceMergeBase lists encrypted files changed on HEAD since it
+// diverged from theirs.
+func changedSinceMergeBase(root, theirs string) ([]string, error) {
+	base, err := gitOutput(root, "merge-base", "HEAD", theirs)
+	if err != nil {
+		return nil, err
+	}
+	out, err := gitOutput(root, "diff", "--name-only", "--diff-filter=AM", "-z", strings.TrimSpace(string(base)), "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f == "" || strings.HasPrefix(f, ".mlsgit/") || f == ".gitattributes" || f == ".gitignore" {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// encryptedWorkingFiles lists tracked files whose working-tree contents are
+// still ciphertext.
+func encryptedWorkingFiles(root string) ([]string, error) {
+	out, err := gitOutput(root, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f == "" || strings.HasPrefix(f, ".mlsgit/") {
+			continue
+		}
+		data, err := os.ReadFile(root + "/" + f)
+		if err == nil && filter.LooksCritCiphertext(string(data)) {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [bundle]",
+	Short: "Restore your identity and keys from a backup bundle",
+	Long: `Restore a bundle written by 'mlsgit backup' into a fresh clone, then catch
+up on the epochs committed since the backup was taken.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.MLSGitDir()); os.IsNotExist(err) {
+		return fmt.Errorf(".mlsgit/ not found. This repo is not mlsgit-enabled")
+	}
+	if _, err := os.Stat(paths.MLSState()); err == nil {
+		memberID, _, _ := storage.ReadIdentity(paths)
+		return fmt.Errorf("this clone already holds keys for member '%s'; restore into a fresh clone", memberID)
+	}
+
+	// 1. Decrypt the bundle
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	bundle, err := storage.BackupBundleFromTOML(string(data))
+	if err != nil {
+		return err
+	}
+	prompt := "Backup passphrase: "
+	if bundle.KeyKind == storage.BundleRecoveryKey {
+		prompt = "Recovery key: "
+	}
+	secret, err := readPassphrase(prompt)
+	if err != nil {
+		return err
+	}
+	files, err := bundle.Open(secret)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.MemberTOML(bundle.MemberID)); os.IsNotExist(err) {
+		return fmt.Errorf("member '%s' is not in this group (removed, or a bundle from another repository)", bundle.MemberID)
+	}
+
+	// 2. Write the local files back
+	if err := paths.EnsureDirs(); err != nil {
+		return err
+	}
+	installFilterConfig(root)
+	if err := storage.RestoreBackupFiles(paths, files); err != nil {
+		return err
+	}
+
+	// 3. Catch up on the epochs committed since the backup
+	group, err := loadMLSGitGroup(paths)
+	if err != nil {
+		for name := range files {
+			os.Remove(filepath.Join(paths.LocalDir(), name))
+		}
+		return fmt.Errorf("catch up from epoch %d: %w (was the bundle taken before your last 'mlsgit update'?)", bundle.Epoch, err)
+	}
+
+	fmt.Printf("Restored %s from a backup taken at epoch %d.\n", storage.DeviceAuthor(bundle.MemberID, bundle.DeviceID), bundle.Epoch)
+	fmt.Printf("Epoch: %d\n", group.Epoch())
+	decryptWorkingTree(root)
+	fmt.Println()
+	fmt.Println("The restored keys have been outside this machine. Consider refreshing them:")
+	fmt.Println("  mlsgit update && git add . && git commit -m 'update keys' && git push")
+	return nil
+}
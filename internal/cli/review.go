@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -30,10 +31,35 @@ func runReview(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if len(requests) == 0 {
+	devices, err := storage.ListPendingDevices(paths)
+	if err != nil {
+		return err
+	}
+
+	rejections, err := storage.ListRejections(paths)
+	if err != nil {
+		return err
+	}
+	if len(rejections) > 0 {
+		reviewRejections(paths, rejections)
+	}
+
+	if len(requests) == 0 && len(devices) == 0 {
 		fmt.Println("No pending requests.")
 		return nil
 	}
+	if len(devices) > 0 {
+		reviewDevices(devices)
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	quorum := pol.AddQuorum()
 
 	fmt.Printf("Pending join requests (%d):\n\n", len(requests))
 	for _, reqPath := range requests {
@@ -50,11 +76,21 @@ func runReview(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  ID:   %s\n", info.MemberID)
 		fmt.Printf("  Name: %s\n", info.Name)
 		fmt.Printf("  Date: %s\n", tsStr)
+		keyPackage, err := verifyJoinRequest(info.MemberID, info)
+		if err != nil {
+			fmt.Printf("  Signed: INVALID (%v)\n", err)
+		} else {
+			fmt.Println("  Signed: yes")
+		}
+		if quorum > 1 {
+			n, _ := policy.CountApprovals(paths, pol, paths.PendingApprovalsDir(info.MemberID), info.MemberID, info.PublicKey, keyPackage.SigPub)
+			fmt.Printf("  Approvals: %d of %d\n", n, quorum)
+		}
 
 		if info.PublicKey != "" {
 			pub, err := crypto.LoadPublicKey(info.PublicKey)
 			if err == nil {
-				fp, _ := crypto.PublicKeyFingerprint(pub)
+				fp, _ := crypto.SSHFingerprint(pub)
 				fmt.Printf("  Key:  %s\n", fp)
 			} else {
 				fmt.Println("  Key:  (could not parse)")
@@ -63,6 +99,51 @@ func runReview(cmd *cobra.Command, args []string) error {
 		fmt.Println()
 	}
 
-	fmt.Println("Run 'mlsgit add <member-id>' to approve a request.")
+	fmt.Println("Confirm each key fingerprint with the requester, then pass it to")
+	fmt.Println("'mlsgit add <member-id> --expect-fingerprint <key>'.")
+	if quorum > 1 {
+		fmt.Printf("Run 'mlsgit add <member-id>' to approve a request (%d approvals needed).\n", quorum)
+	} else {
+		fmt.Println("Run 'mlsgit add <member-id>' to approve a request or 'mlsgit reject <member-id>' to turn it down.")
+	}
 	return nil
 }
+
+func reviewDevices(devices []string) {
+	fmt.Printf("Pending device requests (%d):\n\n", len(devices))
+	for _, reqPath := range devices {
+		req, err := storage.ReadPendingDevice(reqPath)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("  Device: %s (%s)\n", req.DeviceID, req.Name)
+		fmt.Printf("  Member: %s\n", req.MemberID)
+		if pub, err := crypto.LoadPublicKey(req.PublicKey); err == nil {
+			fp, _ := crypto.SSHFingerprint(pub)
+			fmt.Printf("  Key:    %s\n", fp)
+		}
+		fmt.Println()
+	}
+	fmt.Println("The member runs 'mlsgit device add <device-id>' on one of their devices to approve.")
+	fmt.Println()
+}
+
+func reviewRejections(paths storage.MLSGitPaths, rejections []string) {
+	fmt.Printf("Rejected requests (%d):\n\n", len(rejections))
+	for _, path := range rejections {
+		r, err := storage.ReadRejection(path)
+		if err != nil {
+			continue
+		}
+		marker := ""
+		if err := storage.VerifyRejection(paths, r); err != nil {
+			marker = "  [unverified]"
+		}
+		fmt.Printf("  %s (%s), rejected by %s on %s%s\n", r.Name, r.MemberID, r.RejectedBy,
+			time.Unix(r.Timestamp, 0).Format("2006-01-02 15:04"), marker)
+		if r.Reason != "" {
+			fmt.Printf("    Reason: %s\n", r.Reason)
+		}
+	}
+	fmt.Println()
+}
@@ -2,7 +2,6 @@
 package cli
 
 import (
-	"fmt"
 	"io"
 	"os"
 
@@ -30,25 +29,15 @@ var filterCleanCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filePath := args[0]
-		stdinData, err := io.ReadAll(os.Stdin)
-		if err != nil {
-			return fmt.Errorf("read stdin: %w", err)
-		}
-
 		root, err := config.FindGitRoot("")
 		if err != nil {
 			// Pass through if not in a git repo
-			os.Stdout.Write(stdinData)
-			return nil
+			_, err := io.Copy(os.Stdout, os.Stdin)
+			return err
 		}
 		paths := storage.MLSGitPaths{Root: root}
 
-		result, err := filter.Clean(filePath, stdinData, paths)
-		if err != nil {
-			return err
-		}
-		os.Stdout.Write(result)
-		return nil
+		return filter.CleanStream(filePath, os.Stdin, os.Stdout, paths)
 	},
 }
 
@@ -58,24 +47,14 @@ var filterSmudgeCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filePath := args[0]
-		stdinData, err := io.ReadAll(os.Stdin)
-		if err != nil {
-			return fmt.Errorf("read stdin: %w", err)
-		}
-
 		root, err := config.FindGitRoot("")
 		if err != nil {
-			os.Stdout.Write(stdinData)
-			return nil
+			_, err := io.Copy(os.Stdout, os.Stdin)
+			return err
 		}
 		paths := storage.MLSGitPaths{Root: root}
 
-		result, err := filter.Smudge(filePath, stdinData, paths)
-		if err != nil {
-			return err
-		}
-		os.Stdout.Write(result)
-		return nil
+		return filter.SmudgeStream(filePath, os.Stdin, os.Stdout, paths)
 	},
 }
 
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var safetyMarkVerified bool
+
+var safetyNumberCmd = &cobra.Command{
+	Use:   "safety-number [member-id]",
+	Short: "Show the safety number to compare with another member",
+	Long: `Show a 60-digit safety number derived from your key and another
+member's key. Both of you see the same number; compare it in person or over
+a channel you trust.
+
+If the numbers match, run again with --mark-verified to record a signed
+attestation in your member file. 'mlsgit ls' then shows the member as
+verified until their key changes.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSafetyNumber,
+}
+
+func init() {
+	safetyNumberCmd.Flags().BoolVar(&safetyMarkVerified, "mark-verified", false, "Record that you compared the safety number and it matched")
+	rootCmd.AddCommand(safetyNumberCmd)
+}
+
+func runSafetyNumber(cmd *cobra.Command, args []string) error {
+	theirID := args[0]
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Load both members' keys
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	if theirID == myID {
+		return fmt.Errorf("cannot compare safety numbers with yourself")
+	}
+	mine, err := storage.ReadMemberTOML(paths.MemberTOML(myID))
+	if err != nil {
+		return fmt.Errorf("read own member record: %w", err)
+	}
+	theirs, err := storage.ReadMemberTOML(paths.MemberTOML(theirID))
+	if err != nil {
+		return fmt.Errorf("member '%s' not found", theirID)
+	}
+	// Our half comes from our private key: the committed member file is
+	// what the comparison is meant to check, so it cannot vouch for us
+	author, key, err := loadSigningKey(paths)
+	if err != nil {
+		return err
+	}
+	myPub := key.Public().(ed25519.PublicKey)
+	if committed, err := crypto.LoadPublicKey(mine.PublicKey); err != nil || !committed.Equal(myPub) {
+		return fmt.Errorf("the key in .mlsgit/members/%s.toml is not yours; someone may have replaced it, so do not trust this repository until it is restored", myID)
+	}
+	theirPub, err := crypto.LoadPublicKey(theirs.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	// 2. Show the safety number
+	fmt.Printf("Safety number with '%s' (%s):\n\n", theirs.Name, theirID)
+	fmt.Printf("  %s\n\n", crypto.SafetyNumber(myID, myPub, theirID, theirPub))
+	if !safetyMarkVerified {
+		if storage.IdentityVerified(paths, myID, theirID) {
+			fmt.Println("You have already verified this member.")
+		} else {
+			fmt.Printf("If it matches theirs, run 'mlsgit safety-number %s --mark-verified'.\n", theirID)
+		}
+		return nil
+	}
+
+	// 3. Record a signed attestation in our member file
+	att := storage.Attestation{
+		MemberID:  theirID,
+		SignedBy:  author,
+		Signature: crypto.Sign(key, storage.AttestationBytes(myID, theirID, theirs.PublicKey)),
+	}
+	kept := mine.Verified[:0]
+	for _, a := range mine.Verified {
+		if a.MemberID != theirID {
+			kept = append(kept, a)
+		}
+	}
+	mine.Verified = append(kept, att)
+	if err := storage.WriteMemberInfo(paths, myID, mine); err != nil {
+		return err
+	}
+
+	fmt.Printf("Marked '%s' as verified.\n", theirs.Name)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/members/ && git commit -m 'verify member: %s'\n", theirs.Name)
+	fmt.Println("  Then push.")
+	return nil
+}
@@ -34,7 +34,7 @@ func runSeal(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	pemData, err := os.ReadFile(paths.PrivateKey())
+	pemData, err := storage.ReadSecret(paths, paths.PrivateKey())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,411 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sharesThreshold         int
+	sharesRelease           string
+	sharesExpectFingerprint string
+)
+
+var backupSharesCmd = &cobra.Command{
+	Use:   "backup-shares",
+	Short: "Split the epoch secrets into k-of-n shares held by the members",
+	Long: `Split the current epoch secret and epoch key archive key into Shamir
+shares, one per member, any --threshold of which restore access with
+'mlsgit recover-from-shares'. Each share is encrypted to the init key of
+every device of its member and committed under .mlsgit/shares/.
+
+The shares are split again on every epoch change, so they always cover
+the current epoch and the current members. Recovery leaves hold no share.`,
+	Args: cobra.NoArgs,
+	RunE: runBackupShares,
+}
+
+var recoverFromSharesCmd = &cobra.Command{
+	Use:   "recover-from-shares",
+	Short: "Restore access on a new identity from members' backup shares",
+	Long: `Restore access after the group can no longer manage itself, for example
+when every admin has lost their keys.
+
+The new identity first creates a join request with 'mlsgit join' and
+pushes it. Each share holder then runs
+'mlsgit recover-from-shares --release <member-id>', which re-encrypts
+their share to the request's init key, and pushes. Once enough shares are
+released, the new identity runs 'mlsgit recover-from-shares' to rebuild
+the epoch secrets and re-found the group with itself as the only member
+and admin. History stays readable through the epoch key archive; every
+other member must re-clone and join again.`,
+	Args: cobra.NoArgs,
+	RunE: runRecoverFromShares,
+}
+
+func init() {
+	backupSharesCmd.Flags().IntVar(&sharesThreshold, "threshold", 0, "Number of shares needed to recover")
+	backupSharesCmd.MarkFlagRequired("threshold")
+	recoverFromSharesCmd.Flags().StringVar(&sharesRelease, "release", "", "Release your share to the pending request of this member ID")
+	recoverFromSharesCmd.Flags().StringVar(&sharesExpectFingerprint, "expect-fingerprint", "", "Refuse to release unless the request's key has this SSH fingerprint")
+	rootCmd.AddCommand(backupSharesCmd)
+	rootCmd.AddCommand(recoverFromSharesCmd)
+}
+
+func runBackupShares(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may set up shares
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can set up backup shares: %w", err)
+	}
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	holders, err := shareHolders(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+	if sharesThreshold < 2 || sharesThreshold > len(holders) {
+		return fmt.Errorf("--threshold must be between 2 and the number of members (%d)", len(holders))
+	}
+
+	// 2. Split the secrets and encrypt one share to each member
+	if err := storage.WriteShareConfig(paths, storage.ShareConfig{Threshold: sharesThreshold}); err != nil {
+		return err
+	}
+	if err := refreshShares(paths, mlsgitGroup); err != nil {
+		return err
+	}
+
+	fmt.Printf("Split the secrets of epoch %d into %d-of-%d shares.\n", mlsgitGroup.Epoch(), sharesThreshold, len(holders))
+	fmt.Println("Shares are split again on every epoch change.")
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/shares/ && git commit -m 'backup shares: %d of %d'\n", sharesThreshold, len(holders))
+	fmt.Println("  Then push.")
+	return nil
+}
+
+func runRecoverFromShares(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	if sharesRelease != "" {
+		return releaseShare(paths, sharesRelease)
+	}
+	if _, err := os.Stat(paths.MLSState()); err == nil {
+		memberID, _, _ := storage.ReadIdentity(paths)
+		return fmt.Errorf("this clone already holds keys for member '%s'; recover from a join request in a fresh clone", memberID)
+	}
+
+	// 1. Read our join request and the shares released to it
+	myID, myName, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w (run 'mlsgit join' to create a request first)", err)
+	}
+	req, err := storage.ReadPendingRequest(paths.PendingRequest(myID))
+	if err != nil {
+		return fmt.Errorf("read join request: %w", err)
+	}
+	kp, err := verifyJoinRequest(myID, req)
+	if err != nil {
+		return fmt.Errorf("invalid join request: %w", err)
+	}
+	shareCfg, err := storage.ReadShareConfig(paths)
+	if err != nil {
+		return err
+	}
+	if shareCfg == nil {
+		return fmt.Errorf("this repository has no backup shares")
+	}
+	released, err := storage.ReadReleasedShares(paths, myID)
+	if err != nil {
+		return err
+	}
+	if len(released) < shareCfg.Threshold {
+		fmt.Printf("%d of %d shares released to you so far.\n", len(released), shareCfg.Threshold)
+		fmt.Printf("Ask share holders to run 'mlsgit recover-from-shares --release %s' and push.\n", myID)
+		return nil
+	}
+
+	// 2. Rebuild the epoch secrets and open the epoch key archive
+	initPriv, err := storage.ReadSecret(paths, paths.InitPriv())
+	if err != nil {
+		return fmt.Errorf("read init_priv: %w", err)
+	}
+	sigSeed, err := storage.ReadSecret(paths, paths.SigPriv())
+	if err != nil {
+		return fmt.Errorf("read sig_priv: %w", err)
+	}
+	holderIDs := make([]string, 0, len(released))
+	for id := range released {
+		holderIDs = append(holderIDs, id)
+	}
+	sort.Strings(holderIDs)
+	var shares [][]byte
+	for _, id := range holderIDs {
+		share, err := crypto.DecryptWelcome(initPriv, released[id])
+		if err != nil {
+			return fmt.Errorf("decrypt share released by '%s': %w", id, err)
+		}
+		shares = append(shares, share)
+	}
+	rs, err := mls.CombineSecrets(shares)
+	if err != nil {
+		return err
+	}
+	archiveData, err := storage.ReadEpochKeys(paths)
+	if err != nil {
+		return fmt.Errorf("read epoch key archive: %w", err)
+	}
+	archive, err := mls.DecryptArchive(archiveData, rs.Epoch, rs.ArchiveKey)
+	if err != nil {
+		return fmt.Errorf("open epoch key archive at epoch %d: %w (the shares may be older than the archive)", rs.Epoch, err)
+	}
+	fmt.Printf("Rebuilt the secrets of epoch %d from %d shares (%s).\n", rs.Epoch, len(shares), strings.Join(holderIDs, ", "))
+
+	// 3. Re-found the group with ourselves as the only member
+	sigPriv := ed25519.NewKeyFromSeed(sigSeed)
+	mlsgitGroup, err := mls.Refound(newGroupID(), []byte(myName), mls.MLSKeys{
+		SigPriv:   sigPriv,
+		SigPub:    sigPriv.Public().(ed25519.PublicKey),
+		InitPriv:  initPriv,
+		InitPub:   kp.InitPub,
+		PQInitPub: kp.PQInitPub,
+	}, rs)
+	if err != nil {
+		return fmt.Errorf("re-found group: %w", err)
+	}
+	newEpoch := mlsgitGroup.Epoch()
+
+	// 4. Retire every old member, then record ourselves as the admin
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	memberIDs, err := storage.ListMemberIDs(paths)
+	if err != nil {
+		return err
+	}
+	for _, id := range memberIDs {
+		info, err := storage.ReadMemberTOML(paths.MemberTOML(id))
+		if err != nil {
+			return err
+		}
+		if err := retireMember(paths, pol, id, info, newEpoch, myID); err != nil {
+			return err
+		}
+	}
+	if err := storage.WriteMemberTOML(paths, myID, myName, req.PublicKey, newEpoch, myID); err != nil {
+		return err
+	}
+	if err := os.WriteFile(paths.MemberKeypackage(myID), []byte(req.Keypackage), 0o644); err != nil {
+		return err
+	}
+	os.Remove(paths.PendingRequest(myID))
+	newPol := policy.New(myID)
+	if pol != nil {
+		// Keep the role history, so records signed before the re-founding
+		// are still checked against the roles of their epoch
+		newPol = &policy.Policy{Version: pol.Version, Roles: pol.Roles, History: pol.History}
+		for id := range pol.Roles {
+			newPol.SetRole(id, "", newEpoch)
+		}
+		newPol.SetRole(myID, policy.Admin, newEpoch)
+	}
+	if err := savePolicy(paths, newPol); err != nil {
+		return err
+	}
+
+	// 5. Drop the old shares and persist all state
+	if err := os.RemoveAll(paths.SharesDir()); err != nil {
+		return err
+	}
+	if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+	installFilterConfig(root)
+	storage.NewFilterCache(paths).InvalidateAll()
+
+	fmt.Printf("Re-founded the group at epoch %d with '%s' (member ID: %s) as its only admin.\n", newEpoch, myName, myID)
+	fmt.Printf("Retired %d former member(s).\n", len(memberIDs))
+	decryptWorkingTree(root)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add . && git commit -m 'recover from shares: %s'\n", myName)
+	fmt.Println("  Then push. Every other member must re-clone and run 'mlsgit join';")
+	fmt.Println("  after adding them, run 'mlsgit backup-shares' again.")
+	return nil
+}
+
+// newGroupID returns a random group ID in the format of the one
+// 'mlsgit init' derives.
+func newGroupID() []byte {
+	b := make([]byte, 9)
+	rand.Read(b)
+	return []byte(fmt.Sprintf("mlsgit-%x", b)[:24])
+}
+
+// releaseShare re-encrypts our backup share to the init key of forID's
+// pending join request.
+func releaseShare(paths storage.MLSGitPaths, forID string) error {
+	// 1. Open our share of the current epoch
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	deviceID, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	shareCfg, err := storage.ReadShareConfig(paths)
+	if err != nil {
+		return err
+	}
+	if shareCfg == nil {
+		return fmt.Errorf("this repository has no backup shares")
+	}
+	if shareCfg.Epoch != mlsgitGroup.Epoch() {
+		return fmt.Errorf("the shares are from epoch %d but the group is at epoch %d; they are split again on the next epoch change", shareCfg.Epoch, mlsgitGroup.Epoch())
+	}
+	sealed, err := storage.ReadShare(paths, storage.WelcomeKey(myID, deviceID))
+	if err != nil {
+		return fmt.Errorf("no backup share for this device: %w", err)
+	}
+	share, err := mlsgitGroup.OpenSealed(sealed)
+	if err != nil {
+		return fmt.Errorf("open backup share: %w", err)
+	}
+
+	// 2. Verify the request we release to
+	req, err := storage.ReadPendingRequest(paths.PendingRequest(forID))
+	if err != nil {
+		return fmt.Errorf("no pending request for member '%s'", forID)
+	}
+	kp, err := verifyJoinRequest(forID, req)
+	if err != nil {
+		return fmt.Errorf("invalid join request from '%s': %w", forID, err)
+	}
+	pub, err := crypto.LoadPublicKey(req.PublicKey)
+	if err != nil {
+		return err
+	}
+	fp, _ := crypto.SSHFingerprint(pub)
+	if sharesExpectFingerprint != "" && !crypto.FingerprintMatches(pub, sharesExpectFingerprint) {
+		return fmt.Errorf("fingerprint mismatch for '%s': request key is %s, expected %s", forID, fp, sharesExpectFingerprint)
+	}
+
+	// 3. Re-encrypt the share to the request's init key
+	var released []byte
+	if len(kp.PQInitPub) > 0 {
+		released, err = crypto.EncryptWelcomeHybrid(kp.InitPub, kp.PQInitPub, share)
+	} else {
+		released, err = crypto.EncryptWelcome(kp.InitPub, share)
+	}
+	if err != nil {
+		return fmt.Errorf("encrypt share: %w", err)
+	}
+	if err := storage.WriteReleasedShare(paths, forID, myID, released); err != nil {
+		return err
+	}
+
+	fmt.Printf("Released your share of epoch %d to '%s' (%s).\n", shareCfg.Epoch, req.Name, forID)
+	fmt.Printf("Request key fingerprint: %s\n", fp)
+	fmt.Println("Anyone holding enough released shares can take over the group; check the")
+	fmt.Println("fingerprint with the requester out of band.")
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/shares/ && git commit -m 'release share to %s'\n", forID)
+	fmt.Println("  Then push.")
+	return nil
+}
+
+// refreshShares splits the current epoch's secrets again for the current
+// members, if backup shares are set up. With fewer members than the
+// threshold, the shares are dropped until enough members join.
+func refreshShares(paths storage.MLSGitPaths, group *mls.MLSGitGroup) error {
+	shareCfg, err := storage.ReadShareConfig(paths)
+	if err != nil || shareCfg == nil {
+		return err
+	}
+	if err := storage.ClearShares(paths); err != nil {
+		return err
+	}
+	holders, err := shareHolders(paths, group)
+	if err != nil {
+		return err
+	}
+	shareCfg.Epoch = group.Epoch()
+	shareCfg.Holders = 0
+	if len(holders) < shareCfg.Threshold {
+		fmt.Fprintf(os.Stderr, "Warning: %d member(s) cannot hold %d-of-n backup shares; no shares until more members join.\n",
+			len(holders), shareCfg.Threshold)
+		return storage.WriteShareConfig(paths, *shareCfg)
+	}
+	shares, err := group.SplitSecrets(len(holders), shareCfg.Threshold)
+	if err != nil {
+		return err
+	}
+	for i, devices := range holders {
+		for _, d := range devices {
+			sealed, err := group.SealToLeaf(d.leaf, shares[i])
+			if err != nil {
+				return fmt.Errorf("encrypt share for %s: %w", d.key, err)
+			}
+			if err := storage.WriteShare(paths, d.key, sealed); err != nil {
+				return err
+			}
+		}
+	}
+	shareCfg.Holders = len(holders)
+	return storage.WriteShareConfig(paths, *shareCfg)
+}
+
+// shareHolders returns the devices of every member that holds a backup
+// share, one entry per member. Recovery leaves hold none.
+func shareHolders(paths storage.MLSGitPaths, group *mls.MLSGitGroup) ([][]memberDevice, error) {
+	memberIDs, err := storage.ListMemberIDs(paths)
+	if err != nil {
+		return nil, err
+	}
+	var holders [][]memberDevice
+	for _, id := range memberIDs {
+		if storage.IsRecovery(paths, id) {
+			continue
+		}
+		devices, err := memberDevices(paths, group, id)
+		if err != nil {
+			continue // not (or no longer) in the group
+		}
+		holders = append(holders, devices)
+	}
+	return holders, nil
+}
@@ -0,0 +1,323 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/delta"
+	"github.com/germtb/mlsgit/internal/filter"
+	"github.com/germtb/mlsgit/internal/policy"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	shredBeforeEpoch int
+	shredRebase      bool
+	shredYes         bool
+)
+
+var shredCmd = &cobra.Command{
+	Use:   "shred",
+	Short: "Destroy the keys of old epochs so their files can never be decrypted",
+	Long: `Remove the secrets of every epoch before --before-epoch from the epoch key
+archive, so files last written under those epochs become unrecoverable,
+even to current members.
+
+Current files whose delta chains still reference a shredded epoch can be
+re-encrypted under the current epoch first (--rebase, or answer the
+prompt); otherwise they become unreadable too.
+
+Older copies of .mlsgit/epoch_keys.b64 wrap each shredded epoch under the
+epoch after it, so every reachable commit is rewritten through git
+fast-export and fast-import with those entries removed, and the mapping
+from old to new commit IDs is printed. The working tree and index are
+kept. History is rewritten before this clone's own copies of the secrets
+are deleted, so a failed rewrite leaves them in place and the command can
+be run again. It needs a clean working tree, and asks for confirmation
+unless --yes is given. The command then lists every path and revision
+that can no longer be decrypted. After a force-push every member must
+re-clone; old clones still hold the shredded keys.`,
+	Args: cobra.NoArgs,
+	RunE: runShred,
+}
+
+func init() {
+	shredCmd.Flags().IntVar(&shredBeforeEpoch, "before-epoch", 0, "Shred every epoch before this one")
+	shredCmd.Flags().BoolVar(&shredRebase, "rebase", false, "Re-encrypt current files that depend on shredded epochs without asking")
+	shredCmd.Flags().BoolVar(&shredYes, "yes", false, "Rewrite history without asking for confirmation")
+	shredCmd.MarkFlagRequired("before-epoch")
+	rootCmd.AddCommand(shredCmd)
+}
+
+func runShred(cmd *cobra.Command, args []string) error {
+	root, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Check that we may shred, and what
+	myID, _, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	pol, err := policy.Load(paths)
+	if err != nil {
+		return err
+	}
+	if err := pol.Require(myID, policy.Admin); err != nil {
+		return fmt.Errorf("only admins can shred epochs: %w", err)
+	}
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+	epoch := mlsgitGroup.Epoch()
+	if shredBeforeEpoch < 1 || shredBeforeEpoch > epoch {
+		return fmt.Errorf("--before-epoch must be between 1 and the current epoch (%d)", epoch)
+	}
+	if shredBeforeEpoch <= archive.ShreddedBefore() {
+		return fmt.Errorf("epochs before %d are already shredded", archive.ShreddedBefore())
+	}
+	status, err := gitOutput(root, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(status)) > 0 {
+		return fmt.Errorf("working tree has uncommitted changes; commit or stash them before 'mlsgit shred'")
+	}
+	if !shredYes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("shred rewrites every commit on every branch; pass --yes to confirm")
+		}
+		fmt.Printf("Shredding epochs before %d rewrites every commit on every branch and cannot be undone. Continue? [y/N] ", shredBeforeEpoch)
+		answer, _ := stdinReader.ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	// 2. Offer to re-encrypt current files that depend on those epochs
+	stale, err := stagedFilesBefore(root, shredBeforeEpoch)
+	if err != nil {
+		return err
+	}
+	if len(stale) > 0 && !shredRebase && term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("%d current file(s) depend on epochs before %d. Re-encrypt them under epoch %d first? [y/N] ", len(stale), shredBeforeEpoch, epoch)
+		answer, _ := stdinReader.ReadString('\n')
+		shredRebase = strings.EqualFold(strings.TrimSpace(answer), "y")
+	}
+	rebased := 0
+	if shredRebase {
+		for _, f := range stale {
+			if err := rebaseStagedFile(root, paths, f); err != nil {
+				fmt.Printf("  could not re-encrypt %s: %v\n", f.path, err)
+				continue
+			}
+			rebased++
+		}
+		fmt.Printf("Re-encrypted %d of %d current file(s) under epoch %d.\n", rebased, len(stale), epoch)
+	}
+
+	// 3. Remove the shredded secrets from every copy of the archive in
+	// history. Only the archive in memory is shredded yet: if the rewrite
+	// fails, our own copies of the secrets are still on disk
+	dropped := archive.Shred(shredBeforeEpoch)
+	mapping, err := rewriteHistory(root, func(sha, path string) (string, error) {
+		if path != archivePath {
+			return sha, nil
+		}
+		data, err := gitOutput(root, "cat-file", "blob", sha)
+		if err != nil {
+			return "", err
+		}
+		shredded, err := archive.ShredFile(data)
+		if err != nil {
+			return "", fmt.Errorf("shred %s at %s: %w", path, sha[:7], err)
+		}
+		return writeBlob(root, shredded)
+	})
+	if err != nil {
+		return fmt.Errorf("%w; no secrets were deleted, run 'mlsgit shred' again", err)
+	}
+
+	// 4. Shred our own copies of the secrets
+	mlsgitGroup.ForgetBefore(shredBeforeEpoch)
+	if err := saveGroupAndArchive(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+	storage.NewFilterCache(paths).InvalidateAll()
+
+	// 5. Find every revision that depends on the shredded epochs
+	lost, err := revisionsBefore(root, shredBeforeEpoch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Shredded the secrets of %d epoch(s) before epoch %d.\n", len(dropped), shredBeforeEpoch)
+	fmt.Printf("\nRewrote %d commit(s) (old -> new):\n", len(mapping))
+	for _, m := range mapping {
+		fmt.Printf("  %s -> %s\n", m[0], m[1])
+	}
+	fmt.Println()
+	if len(lost) == 0 {
+		fmt.Println("No revision in this repository depends on them.")
+	} else {
+		lostPaths := make([]string, 0, len(lost))
+		for p := range lost {
+			lostPaths = append(lostPaths, p)
+		}
+		sort.Strings(lostPaths)
+		fmt.Printf("Permanently undecryptable (%d path(s)):\n", len(lostPaths))
+		for _, p := range lostPaths {
+			fmt.Printf("  %s: %s\n", p, strings.Join(lost[p], ", "))
+		}
+	}
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add .mlsgit/ && git commit -m 'shred epochs before %d'\n", shredBeforeEpoch)
+	fmt.Println("  git reflog expire --expire=now --all && git gc --prune=now")
+	fmt.Println("  git push --force --all")
+	fmt.Println("  Ask every member to re-clone; old clones still hold the shredded keys.")
+	return nil
+}
+
+// archivePath is the epoch key archive's path in the repository.
+const archivePath = ".mlsgit/epoch_keys.b64"
+
+// stagedFile is an encrypted file in the index.
+type stagedFile struct {
+	mode, path string
+	blob       []byte
+}
+
+// stagedFilesBefore lists files in the index whose chains reference an
+// epoch before epoch.
+func stagedFilesBefore(root string, epoch int) ([]stagedFile, error) {
+	return stagedFilesMatching(root, func(blob []byte) bool { return chainBefore(blob, epoch) })
+}
+
+// stagedFilesMatching lists files in the index, outside .mlsgit/, whose
+// staged contents match.
+func stagedFilesMatching(root string, match func(blob []byte) bool) ([]stagedFile, error) {
+	out, err := gitOutput(root, "ls-files", "-s", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var files []stagedFile
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> <sha> <stage>\t<path>
+		meta, path, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || strings.HasPrefix(path, ".mlsgit/") {
+			continue
+		}
+		blob, err := gitOutput(root, "cat-file", "blob", fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if match(blob) {
+			files = append(files, stagedFile{mode: fields[0], path: path, blob: blob})
+		}
+	}
+	return files, nil
+}
+
+// rebaseStagedFile re-encrypts a staged file under the current epoch and
+// stages the result.
+func rebaseStagedFile(root string, paths storage.MLSGitPaths, f stagedFile) error {
+	ct, err := filter.Rebase(f.path, f.blob, paths)
+	if err != nil {
+		return err
+	}
+	return stageBlob(root, f, ct)
+}
+
+// stageBlob stages data as the new contents of a staged file.
+func stageBlob(root string, f stagedFile, data []byte) error {
+	sha, err := writeBlob(root, data)
+	if err != nil {
+		return err
+	}
+	_, err = gitOutput(root, "update-index", "--cacheinfo", f.mode+","+sha+","+f.path)
+	return err
+}
+
+// writeBlob stores data as a blob, bypassing the clean filter, and returns
+// its hash.
+func writeBlob(root string, data []byte) (string, error) {
+	hashCmd := exec.Command("git", "hash-object", "-w", "--no-filters", "--stdin")
+	hashCmd.Dir = root
+	hashCmd.Stdin = bytes.NewReader(data)
+	sha, err := hashCmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("store blob: %w", err)
+	}
+	return strings.TrimSpace(string(sha)), nil
+}
+
+// revisionsBefore maps each path to the commits (short hashes, newest
+// first) whose version of it references an epoch before epoch.
+func revisionsBefore(root string, epoch int) (map[string][]string, error) {
+	out, err := gitOutput(root, "rev-list", "--all")
+	if err != nil {
+		return nil, err
+	}
+	checked := make(map[string]bool) // blob sha -> depends on a shredded epoch
+	lost := make(map[string][]string)
+	for _, commit := range strings.Fields(string(out)) {
+		tree, err := gitOutput(root, "ls-tree", "-r", "-z", commit)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range strings.Split(string(tree), "\x00") {
+			// <mode> <type> <sha>\t<path>
+			meta, path, ok := strings.Cut(entry, "\t")
+			fields := strings.Fields(meta)
+			if !ok || len(fields) != 3 || fields[1] != "blob" || strings.HasPrefix(path, ".mlsgit/") {
+				continue
+			}
+			sha := fields[2]
+			before, seen := checked[sha]
+			if !seen {
+				blob, err := gitOutput(root, "cat-file", "blob", sha)
+				if err != nil {
+					return nil, err
+				}
+				before = chainBefore(blob, epoch)
+				checked[sha] = before
+			}
+			if before {
+				lost[path] = append(lost[path], commit[:7])
+			}
+		}
+	}
+	return lost, nil
+}
+
+// chainBefore reports whether data is a ciphertext chain with a block
+// from before epoch.
+func chainBefore(data []byte, epoch int) bool {
+	if !filter.LooksCritCiphertext(string(data)) {
+		return false
+	}
+	epochs, err := delta.ChainEpochs(string(data))
+	if err != nil {
+		return false
+	}
+	for _, e := range epochs {
+		if e < epoch {
+			return true
+		}
+	}
+	return false
+}
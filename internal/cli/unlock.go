@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// defaultUnlockFor is how long 'mlsgit unlock' keeps local keys unlocked.
+const defaultUnlockFor = 12 * time.Hour
+
+var unlockFor time.Duration
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Unlock passphrase-protected local keys for this session",
+	Long: `Ask for the passphrase set with 'mlsgit passwd' and cache the derived key
+so git's filters and other mlsgit commands can use the local keys until
+the session expires or 'mlsgit lock' is run.
+
+The key is cached under $XDG_RUNTIME_DIR, a per-login tmpfs, and never in
+a persistent directory; expired sessions are deleted the next time mlsgit
+reads or saves one. Where $XDG_RUNTIME_DIR is not set (macOS, many ssh and
+CI sessions), unlock refuses: set MLSGIT_PASSPHRASE instead.`,
+	Args: cobra.NoArgs,
+	RunE: runUnlock,
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Forget the key cached by 'mlsgit unlock'",
+	Args:  cobra.NoArgs,
+	RunE:  runLock,
+}
+
+func init() {
+	unlockCmd.Flags().DurationVar(&unlockFor, "for", defaultUnlockFor, "How long to stay unlocked")
+	rootCmd.AddCommand(unlockCmd)
+	rootCmd.AddCommand(lockCmd)
+}
+
+func runUnlock(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	ks, err := storage.ReadKeystore(paths)
+	if err != nil {
+		return err
+	}
+	if ks == nil {
+		return fmt.Errorf("local keys are not protected by a passphrase (see 'mlsgit passwd')")
+	}
+	if unlockFor <= 0 {
+		return fmt.Errorf("--for must be positive")
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	key, err := ks.Unlock(pass)
+	if err != nil {
+		return err
+	}
+	if err := storage.SaveSession(paths, key, unlockFor); err != nil {
+		return err
+	}
+	fmt.Printf("Unlocked for %s. Run 'mlsgit lock' to lock again.\n", unlockFor)
+	return nil
+}
+
+func runLock(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+	if err := storage.ClearSession(paths); err != nil {
+		return err
+	}
+	fmt.Println("Locked.")
+	return nil
+}
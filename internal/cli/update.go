@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Refresh your own keys and advance the epoch",
+	Long: `Rotate your init key and re-key your path in the ratchet tree.
+
+Anyone holding a copy of your previous keys (for example from a lost or
+compromised machine) cannot derive epoch secrets after this update.
+
+When the group's cipher_suite selects the post-quantum hybrid KEM, the new
+keys are X25519 + ML-KEM-768. This is how X25519-only members migrate.`,
+	Args: cobra.NoArgs,
+	RunE: runUpdate,
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+}
+
+func runUpdate(cmd *cobra.Command, args []string) error {
+	_, paths, err := getRootAndPaths()
+	if err != nil {
+		return err
+	}
+
+	// 1. Read own identity
+	myID, name, err := storage.ReadIdentity(paths)
+	if err != nil {
+		return fmt.Errorf("read identity: %w", err)
+	}
+	myDevice, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return err
+	}
+
+	// 2. Load MLS group and epoch archive
+	mlsgitGroup, err := loadMLSGitGroup(paths)
+	if err != nil {
+		return err
+	}
+	oldEpoch := mlsgitGroup.Epoch()
+	archive, err := loadEpochArchive(paths, mlsgitGroup)
+	if err != nil {
+		return err
+	}
+
+	// 3. Rotate our init key (advances epoch)
+	if _, err := mlsgitGroup.SelfUpdate(); err != nil {
+		return fmt.Errorf("self update: %w", err)
+	}
+	newEpoch := mlsgitGroup.Epoch()
+
+	fmt.Printf("MLS epoch advanced: %d -> %d\n", oldEpoch, newEpoch)
+
+	// 4. Publish the new init key
+	if err := publishOwnInitKey(paths, mlsgitGroup, myID, myDevice, mlsgitGroup.InitPub(), mlsgitGroup.PQInitPub()); err != nil {
+		return err
+	}
+
+	// 5. Persist all state
+	if err := saveGroupWithInitKey(paths, mlsgitGroup, archive); err != nil {
+		return err
+	}
+
+	fmt.Println("Your keys have been refreshed.")
+	fmt.Println("New files will be encrypted under the new epoch key.")
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  git add . && git commit -m 'update keys: %s'\n", name)
+	fmt.Println("  Then push.")
+
+	return nil
+}
+
+// publishOwnInitKey records a new init key in this device's KeyPackage,
+// re-signs it with the group's leaf key and marks its keys as refreshed.
+func publishOwnInitKey(paths storage.MLSGitPaths, group *mls.MLSGitGroup, memberID, deviceID string, initPub, pqInitPub []byte) error {
+	now := time.Now().Unix()
+	if deviceID != "" {
+		kp, err := readDeviceKeyPackage(paths, memberID, deviceID)
+		if err != nil {
+			return err
+		}
+		kp.InitPub, kp.PQInitPub = initPub, pqInitPub
+		group.SignKeyPackage(&kp)
+		if err := writeDeviceKeyPackage(paths, memberID, deviceID, kp); err != nil {
+			return err
+		}
+		info, err := storage.ReadDeviceInfo(paths, memberID, deviceID)
+		if err != nil {
+			return fmt.Errorf("read device record for '%s': %w", deviceID, err)
+		}
+		info.KeysUpdated = now
+		return storage.WriteDeviceInfo(paths, memberID, deviceID, info)
+	}
+
+	kp, err := readMemberKeyPackage(paths, memberID)
+	if err != nil {
+		return err
+	}
+	kp.InitPub, kp.PQInitPub = initPub, pqInitPub
+	group.SignKeyPackage(&kp)
+	if err := writeMemberKeyPackage(paths, memberID, kp); err != nil {
+		return err
+	}
+	info, err := storage.ReadMemberTOML(paths.MemberTOML(memberID))
+	if err != nil {
+		return fmt.Errorf("read member record for '%s': %w", memberID, err)
+	}
+	info.KeysUpdated = now
+	return storage.WriteMemberInfo(paths, memberID, info)
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 const (
@@ -16,8 +17,15 @@ const (
 	// DefaultCompactionThreshold is the number of deltas before compaction.
 	DefaultCompactionThreshold = 50
 
-	// MLSCiphersuiteID is MLS_128_DHKEMX25519_AES128GCM_SHA256_Ed25519.
-	MLSCiphersuiteID = 0x0001
+	// DefaultStreamThreshold is the file size, in bytes, from which the
+	// filter encrypts in streamed segments instead of in memory.
+	DefaultStreamThreshold = 16 << 20
+
+	// MLSCiphersuiteID is crypto.SuiteAES256GCM, the default entry of the
+	// crypto.Suite registry: X25519 and Ed25519 with AES-256-GCM. It reuses
+	// the MLS registry value of MLS_128_DHKEMX25519_AES128GCM_SHA256_Ed25519
+	// but encrypts with 256-bit keys.
+	MLSCiphersuiteID = crypto.SuiteAES256GCM
 
 	// Version is the mlsgit version string.
 	Version = "0.1.0"
@@ -51,9 +59,23 @@ func FindGitRoot(start string) (string, error) {
 
 // MLSGitConfig holds runtime configuration from .mlsgit/config.toml.
 type MLSGitConfig struct {
-	Version              string `toml:"version"`
-	CipherSuite          int    `toml:"cipher_suite"`
-	CompactionThreshold  int    `toml:"compaction_threshold"`
+	Version             string `toml:"version"`
+	CipherSuite         int    `toml:"cipher_suite"`
+	CompactionThreshold int    `toml:"compaction_threshold"`
+	// RotationInterval is the number of days after which a member's keys
+	// are considered stale and should be refreshed with `mlsgit update`.
+	// Zero disables the check.
+	RotationInterval int `toml:"rotation_interval"`
+	// StreamThreshold is the file size, in bytes, from which files are
+	// encrypted as streamed records: in fixed-size segments, without
+	// holding the file in memory, and without deltas.
+	StreamThreshold int `toml:"stream_threshold"`
+	// CiphertextFormat is the container new ciphertext chains are written
+	// in (see delta.Format): "text", or the v2 "binary" and "armored".
+	CiphertextFormat string `toml:"ciphertext_format"`
+	// Compression is "zstd" to compress v2 records before encrypting
+	// them, or "none".
+	Compression string `toml:"compression"`
 }
 
 // DefaultConfig returns a config with default values.
@@ -62,26 +84,62 @@ func DefaultConfig() MLSGitConfig {
 		Version:             Version,
 		CipherSuite:         MLSCiphersuiteID,
 		CompactionThreshold: DefaultCompactionThreshold,
+		StreamThreshold:     DefaultStreamThreshold,
+		CiphertextFormat:    "text",
+		Compression:         "none",
 	}
 }
 
+// Suite returns the registry entry for the configured cipher suite.
+func (c MLSGitConfig) Suite() (crypto.Suite, error) {
+	return crypto.LookupSuite(c.CipherSuite)
+}
+
+// PostQuantum reports whether the group's init and path keys use the
+// X25519 + ML-KEM-768 hybrid KEM.
+func (c MLSGitConfig) PostQuantum() bool {
+	s, err := c.Suite()
+	return err == nil && s.PostQuantum
+}
+
 // tomlConfig is the TOML wrapper for serialization.
 type tomlConfig struct {
 	MLSGit MLSGitConfig `toml:"mlsgit"`
 }
 
 // ToTOML serializes the config to TOML format matching the Python output.
+// Optional settings are only written when set.
 func (c MLSGitConfig) ToTOML() string {
-	return fmt.Sprintf("[mlsgit]\nversion = %q\ncipher_suite = %d\ncompaction_threshold = %d\n",
+	text := fmt.Sprintf("[mlsgit]\nversion = %q\ncipher_suite = %d\ncompaction_threshold = %d\n",
 		c.Version, c.CipherSuite, c.CompactionThreshold)
+	if c.RotationInterval != 0 {
+		text += fmt.Sprintf("rotation_interval = %d\n", c.RotationInterval)
+	}
+	if c.StreamThreshold != DefaultStreamThreshold {
+		text += fmt.Sprintf("stream_threshold = %d\n", c.StreamThreshold)
+	}
+	if c.CiphertextFormat != "text" {
+		text += fmt.Sprintf("ciphertext_format = %q\n", c.CiphertextFormat)
+	}
+	if c.Compression != "none" {
+		text += fmt.Sprintf("compression = %q\n", c.Compression)
+	}
+	return text
 }
 
 // ConfigFromTOML parses a config from TOML text.
 func ConfigFromTOML(text string) (MLSGitConfig, error) {
 	var wrapper tomlConfig
-	if _, err := toml.Decode(text, &wrapper); err != nil {
+	md, err := toml.Decode(text, &wrapper)
+	if err != nil {
 		return MLSGitConfig{}, fmt.Errorf("parsing config TOML: %w", err)
 	}
+	// add_quorum moved to the signed policy, where a member cannot lower
+	// it by editing an unsigned file. Refuse to run with it rather than
+	// silently dropping to one approval.
+	if md.IsDefined("mlsgit", "add_quorum") {
+		return MLSGitConfig{}, fmt.Errorf("add_quorum is no longer read from config.toml; remove it and set it with 'mlsgit policy quorum <n>'")
+	}
 	cfg := DefaultConfig()
 	m := wrapper.MLSGit
 	if m.Version != "" {
@@ -90,8 +148,35 @@ func ConfigFromTOML(text string) (MLSGitConfig, error) {
 	if m.CipherSuite != 0 {
 		cfg.CipherSuite = m.CipherSuite
 	}
+	if _, err := crypto.LookupSuite(cfg.CipherSuite); err != nil {
+		return MLSGitConfig{}, fmt.Errorf("cipher_suite: %w", err)
+	}
 	if m.CompactionThreshold != 0 {
 		cfg.CompactionThreshold = m.CompactionThreshold
 	}
+	if m.RotationInterval < 0 {
+		return MLSGitConfig{}, fmt.Errorf("rotation_interval must not be negative")
+	}
+	cfg.RotationInterval = m.RotationInterval
+	if m.StreamThreshold < 0 {
+		return MLSGitConfig{}, fmt.Errorf("stream_threshold must not be negative")
+	}
+	if m.StreamThreshold != 0 {
+		cfg.StreamThreshold = m.StreamThreshold
+	}
+	switch m.CiphertextFormat {
+	case "":
+	case "text", "binary", "armored":
+		cfg.CiphertextFormat = m.CiphertextFormat
+	default:
+		return MLSGitConfig{}, fmt.Errorf("ciphertext_format must be text, binary or armored")
+	}
+	switch m.Compression {
+	case "":
+	case "none", "zstd":
+		cfg.Compression = m.Compression
+	default:
+		return MLSGitConfig{}, fmt.Errorf("compression must be none or zstd")
+	}
 	return cfg, nil
 }
@@ -3,7 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 func TestFindGitRoot(t *testing.T) {
@@ -89,3 +92,93 @@ compaction_threshold = 50
 		t.Errorf("CompactionThreshold = %d, want %d", cfg.CompactionThreshold, 50)
 	}
 }
+
+func TestRotationIntervalRoundtrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RotationInterval = 90
+	text := cfg.ToTOML()
+	if !strings.Contains(text, "rotation_interval = 90\n") {
+		t.Errorf("ToTOML() missing rotation_interval: %q", text)
+	}
+	parsed, err := ConfigFromTOML(text)
+	if err != nil {
+		t.Fatalf("ConfigFromTOML error: %v", err)
+	}
+	if parsed.RotationInterval != 90 {
+		t.Errorf("RotationInterval = %d, want 90", parsed.RotationInterval)
+	}
+
+	if _, err := ConfigFromTOML("[mlsgit]\nrotation_interval = -1\n"); err == nil {
+		t.Error("negative rotation_interval should be rejected")
+	}
+}
+
+func TestAddQuorumRejected(t *testing.T) {
+	if strings.Contains(DefaultConfig().ToTOML(), "add_quorum") {
+		t.Error("add_quorum should not be written to config.toml")
+	}
+	if _, err := ConfigFromTOML("[mlsgit]\nadd_quorum = 2\n"); err == nil || !strings.Contains(err.Error(), "policy") {
+		t.Errorf("add_quorum in config.toml = %v, want an error pointing to the policy", err)
+	}
+}
+
+func TestStreamThresholdRoundtrip(t *testing.T) {
+	cfg := DefaultConfig()
+	if strings.Contains(cfg.ToTOML(), "stream_threshold") {
+		t.Error("default stream_threshold should not be written")
+	}
+	cfg.StreamThreshold = 1 << 20
+	parsed, err := ConfigFromTOML(cfg.ToTOML())
+	if err != nil {
+		t.Fatalf("ConfigFromTOML error: %v", err)
+	}
+	if parsed.StreamThreshold != 1<<20 {
+		t.Errorf("StreamThreshold = %d, want %d", parsed.StreamThreshold, 1<<20)
+	}
+
+	if _, err := ConfigFromTOML("[mlsgit]\nstream_threshold = -1\n"); err == nil {
+		t.Error("negative stream_threshold should be rejected")
+	}
+}
+
+func TestCiphertextFormatRoundtrip(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.CiphertextFormat != "text" || cfg.Compression != "none" {
+		t.Errorf("defaults = %q, %q; want text, none", cfg.CiphertextFormat, cfg.Compression)
+	}
+	cfg.CiphertextFormat = "armored"
+	cfg.Compression = "zstd"
+	parsed, err := ConfigFromTOML(cfg.ToTOML())
+	if err != nil {
+		t.Fatalf("ConfigFromTOML error: %v", err)
+	}
+	if parsed.CiphertextFormat != "armored" || parsed.Compression != "zstd" {
+		t.Errorf("parsed = %q, %q; want armored, zstd", parsed.CiphertextFormat, parsed.Compression)
+	}
+
+	if _, err := ConfigFromTOML("[mlsgit]\nciphertext_format = \"xml\"\n"); err == nil {
+		t.Error("unknown ciphertext_format should be rejected")
+	}
+	if _, err := ConfigFromTOML("[mlsgit]\ncompression = \"gzip\"\n"); err == nil {
+		t.Error("unknown compression should be rejected")
+	}
+}
+
+func TestCipherSuitePostQuantum(t *testing.T) {
+	if DefaultConfig().PostQuantum() {
+		t.Error("default cipher suite should be X25519-only")
+	}
+	cfg := DefaultConfig()
+	cfg.CipherSuite = crypto.SuiteHybridAES256GCM
+	parsed, err := ConfigFromTOML(cfg.ToTOML())
+	if err != nil {
+		t.Fatalf("ConfigFromTOML error: %v", err)
+	}
+	if !parsed.PostQuantum() {
+		t.Errorf("CipherSuite = %#x should select the hybrid KEM", parsed.CipherSuite)
+	}
+
+	if _, err := ConfigFromTOML("[mlsgit]\ncipher_suite = 7\n"); err == nil {
+		t.Error("unknown cipher_suite should be rejected")
+	}
+}
@@ -65,8 +65,13 @@ func EncryptWelcome(recipientPub, plaintext []byte) ([]byte, error) {
 	return out, nil
 }
 
-// DecryptWelcome decrypts a Welcome message encrypted with EncryptWelcome.
+// DecryptWelcome decrypts a Welcome message encrypted with EncryptWelcome,
+// or with EncryptWelcomeHybrid, in which case recipientPriv is the hybrid
+// seed.
 func DecryptWelcome(recipientPriv, encrypted []byte) ([]byte, error) {
+	if IsHybridWelcome(encrypted) {
+		return decryptWelcomeHybrid(recipientPriv, encrypted)
+	}
 	if len(encrypted) < eciesOverhead {
 		return nil, fmt.Errorf("encrypted welcome too short: %d bytes (minimum %d)", len(encrypted), eciesOverhead)
 	}
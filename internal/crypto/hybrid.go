@@ -0,0 +1,192 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// HybridSeedSize is the size of a hybrid private key seed.
+	HybridSeedSize = 32
+	// MLKEMPublicKeySize is the size of an ML-KEM-768 encapsulation key.
+	MLKEMPublicKeySize = mlkem.EncapsulationKeySize768
+	// MLKEMCiphertextSize is the size of an ML-KEM-768 ciphertext.
+	MLKEMCiphertextSize = mlkem.CiphertextSize768
+)
+
+// hybridWelcomeMagic prefixes Welcomes encrypted with EncryptWelcomeHybrid
+// so DecryptWelcome can tell them apart from X25519-only Welcomes.
+var hybridWelcomeMagic = []byte("mlsgit-hybrid-v1")
+
+// HybridKeys expands a 32-byte seed into an X25519 private key and the
+// public keys of the X25519 + ML-KEM-768 hybrid KEM. Both halves are derived
+// from the seed with HKDF, so recovering the X25519 private key (e.g. with a
+// quantum computer) reveals nothing about the ML-KEM key.
+func HybridKeys(seed []byte) (x25519Priv, x25519Pub, mlkemPub []byte, err error) {
+	dk, x25519Priv, err := expandHybridSeed(seed)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	x25519Pub, err = curve25519.X25519(x25519Priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("derive x25519 public key: %w", err)
+	}
+	return x25519Priv, x25519Pub, dk.EncapsulationKey().Bytes(), nil
+}
+
+// expandHybridSeed derives the ML-KEM decapsulation key and X25519 private
+// key from a hybrid seed.
+func expandHybridSeed(seed []byte) (*mlkem.DecapsulationKey768, []byte, error) {
+	if len(seed) != HybridSeedSize {
+		return nil, nil, fmt.Errorf("hybrid seed must be %d bytes", HybridSeedSize)
+	}
+	r := hkdf.New(sha256.New, seed, nil, []byte("mlsgit-hybrid-keys"))
+	x25519Priv := make([]byte, x25519KeySize)
+	mlkemSeed := make([]byte, mlkem.SeedSize)
+	if _, err := io.ReadFull(r, x25519Priv); err != nil {
+		return nil, nil, fmt.Errorf("hkdf: %w", err)
+	}
+	if _, err := io.ReadFull(r, mlkemSeed); err != nil {
+		return nil, nil, fmt.Errorf("hkdf: %w", err)
+	}
+	dk, err := mlkem.NewDecapsulationKey768(mlkemSeed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("derive ml-kem key: %w", err)
+	}
+	return dk, x25519Priv, nil
+}
+
+// HybridEncapsulate runs the hybrid KEM against a recipient's X25519 and
+// ML-KEM-768 public keys. It returns the 32-byte shared secret, the
+// ephemeral X25519 public key and the ML-KEM ciphertext. The shared secret
+// stays safe as long as either X25519 or ML-KEM-768 is unbroken.
+func HybridEncapsulate(x25519Pub, mlkemPub []byte) (shared, ephPub, kemCT []byte, err error) {
+	if len(x25519Pub) != x25519KeySize {
+		return nil, nil, nil, fmt.Errorf("recipient public key must be %d bytes", x25519KeySize)
+	}
+	ek, err := mlkem.NewEncapsulationKey768(mlkemPub)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("parse ml-kem public key: %w", err)
+	}
+
+	ephPriv := make([]byte, x25519KeySize)
+	if _, err := rand.Read(ephPriv); err != nil {
+		return nil, nil, nil, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	ephPub, err = curve25519.X25519(ephPriv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("derive ephemeral public key: %w", err)
+	}
+	dh, err := curve25519.X25519(ephPriv, x25519Pub)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("ecdh: %w", err)
+	}
+	ssPQ, kemCT := ek.Encapsulate()
+	return combineHybrid(ssPQ, dh, ephPub, x25519Pub, kemCT), ephPub, kemCT, nil
+}
+
+// HybridDecapsulate recovers the shared secret of HybridEncapsulate with
+// the recipient's hybrid seed.
+func HybridDecapsulate(seed, ephPub, kemCT []byte) ([]byte, error) {
+	dk, x25519Priv, err := expandHybridSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	ssPQ, err := dk.Decapsulate(kemCT)
+	if err != nil {
+		return nil, fmt.Errorf("ml-kem decapsulate: %w", err)
+	}
+	dh, err := curve25519.X25519(x25519Priv, ephPub)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+	x25519Pub, err := curve25519.X25519(x25519Priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("derive x25519 public key: %w", err)
+	}
+	return combineHybrid(ssPQ, dh, ephPub, x25519Pub, kemCT), nil
+}
+
+// combineHybrid hashes both shared secrets together with the X25519
+// transcript and the ML-KEM ciphertext, as in X-Wing.
+func combineHybrid(ssPQ, dh, ephPub, x25519Pub, kemCT []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("mlsgit-hybrid-kem"))
+	h.Write(ssPQ)
+	h.Write(dh)
+	h.Write(ephPub)
+	h.Write(x25519Pub)
+	h.Write(kemCT)
+	return h.Sum(nil)
+}
+
+// EncryptWelcomeHybrid encrypts a Welcome message for a recipient with the
+// X25519 + ML-KEM-768 hybrid KEM:
+//
+//  1. (shared, ephPub, kemCT) = HybridEncapsulate(x25519Pub, mlkemPub)
+//  2. KDF:  HKDF-SHA256(shared, salt=nil, info="mlsgit-welcome") -> 32-byte AES key
+//  3. Encrypt: AES-GCM(aesKey, plaintext)
+//  4. Return: magic || ephPub(32) || kemCT(1088) || nonce(12) || ciphertext+tag
+func EncryptWelcomeHybrid(x25519Pub, mlkemPub, plaintext []byte) ([]byte, error) {
+	shared, ephPub, kemCT, err := HybridEncapsulate(x25519Pub, mlkemPub)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := deriveWelcomeKey(shared)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ct, err := AESGCMEncrypt(aesKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	out := make([]byte, 0, len(hybridWelcomeMagic)+len(ephPub)+len(kemCT)+len(nonce)+len(ct))
+	out = append(out, hybridWelcomeMagic...)
+	out = append(out, ephPub...)
+	out = append(out, kemCT...)
+	out = append(out, nonce...)
+	out = append(out, ct...)
+	return out, nil
+}
+
+// IsHybridWelcome reports whether an encrypted Welcome was made by
+// EncryptWelcomeHybrid.
+func IsHybridWelcome(encrypted []byte) bool {
+	return bytes.HasPrefix(encrypted, hybridWelcomeMagic)
+}
+
+// decryptWelcomeHybrid reverses EncryptWelcomeHybrid with the recipient's
+// hybrid seed.
+func decryptWelcomeHybrid(seed, encrypted []byte) ([]byte, error) {
+	body := encrypted[len(hybridWelcomeMagic):]
+	if len(body) < eciesOverhead+MLKEMCiphertextSize {
+		return nil, fmt.Errorf("encrypted welcome too short: %d bytes (minimum %d)",
+			len(encrypted), len(hybridWelcomeMagic)+eciesOverhead+MLKEMCiphertextSize)
+	}
+	ephPub := body[:x25519KeySize]
+	kemCT := body[x25519KeySize : x25519KeySize+MLKEMCiphertextSize]
+	nonce := body[x25519KeySize+MLKEMCiphertextSize : x25519KeySize+MLKEMCiphertextSize+IVSize]
+	ct := body[x25519KeySize+MLKEMCiphertextSize+IVSize:]
+
+	shared, err := HybridDecapsulate(seed, ephPub, kemCT)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := deriveWelcomeKey(shared)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := AESGCMDecrypt(aesKey, nonce, ct)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plaintext, nil
+}
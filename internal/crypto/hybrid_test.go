@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func generateHybridSeed(t *testing.T) []byte {
+	t.Helper()
+	seed := make([]byte, HybridSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		t.Fatal(err)
+	}
+	return seed
+}
+
+func TestHybridKeysDeterministic(t *testing.T) {
+	seed := generateHybridSeed(t)
+	priv1, pub1, pq1, err := HybridKeys(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv2, pub2, pq2, _ := HybridKeys(seed)
+	if !bytes.Equal(priv1, priv2) || !bytes.Equal(pub1, pub2) || !bytes.Equal(pq1, pq2) {
+		t.Error("same seed should give the same keys")
+	}
+	if bytes.Equal(priv1, seed) {
+		t.Error("X25519 private key must not be the seed itself")
+	}
+	if len(pq1) != MLKEMPublicKeySize {
+		t.Errorf("ml-kem public key is %d bytes, want %d", len(pq1), MLKEMPublicKeySize)
+	}
+}
+
+func TestHybridEncapsulateDecapsulate(t *testing.T) {
+	seed := generateHybridSeed(t)
+	_, pub, pqPub, _ := HybridKeys(seed)
+
+	shared, ephPub, kemCT, err := HybridEncapsulate(pub, pqPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := HybridDecapsulate(seed, ephPub, kemCT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, shared) {
+		t.Error("decapsulated secret differs")
+	}
+
+	// Both halves contribute: a different seed derives a different secret
+	other, _ := HybridDecapsulate(generateHybridSeed(t), ephPub, kemCT)
+	if bytes.Equal(other, shared) {
+		t.Error("wrong seed should not recover the shared secret")
+	}
+}
+
+func TestEncryptDecryptWelcomeHybrid(t *testing.T) {
+	seed := generateHybridSeed(t)
+	_, pub, pqPub, _ := HybridKeys(seed)
+	plaintext := []byte(`{"group_id":"test","epoch":1,"epoch_secret":"AAAA"}`)
+
+	encrypted, err := EncryptWelcomeHybrid(pub, pqPub, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsHybridWelcome(encrypted) {
+		t.Error("hybrid welcome should be recognized")
+	}
+
+	decrypted, err := DecryptWelcome(seed, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+
+	if _, err := DecryptWelcome(generateHybridSeed(t), encrypted); err == nil {
+		t.Error("decryption with the wrong seed should fail")
+	}
+	if _, err := DecryptWelcome(seed, encrypted[:len(encrypted)-MLKEMCiphertextSize]); err == nil {
+		t.Error("truncated hybrid welcome should fail")
+	}
+}
@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// sealedMagic prefixes local files sealed with SealLocal so readers can
+// tell them apart from files written before a passphrase was set.
+var sealedMagic = []byte("mlsgit-sealed-v1")
+
+// KDFParams are the Argon2id cost parameters for PassphraseKey.
+type KDFParams struct {
+	Time    int // passes over memory
+	Memory  int // KiB
+	Threads int
+}
+
+// DefaultKDFParams follows the RFC 9106 second recommended option
+// (3 passes, 64 MiB).
+var DefaultKDFParams = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// PassphraseKey derives a 32-byte key from a passphrase with Argon2id.
+func PassphraseKey(passphrase, salt []byte, params KDFParams) ([]byte, error) {
+	if len(salt) < 16 {
+		return nil, fmt.Errorf("salt must be at least 16 bytes")
+	}
+	if params.Time < 1 || params.Memory < 8*params.Threads || params.Threads < 1 || params.Threads > 255 {
+		return nil, fmt.Errorf("invalid argon2id parameters %+v", params)
+	}
+	return argon2.IDKey(passphrase, salt, uint32(params.Time), uint32(params.Memory), uint8(params.Threads), AESKeySize), nil
+}
+
+// SealLocal encrypts local key material under a passphrase-derived key:
+//
+//	magic || nonce(12) || AES-256-GCM(key, plaintext)
+func SealLocal(key, plaintext []byte) ([]byte, error) {
+	nonce, ct, err := AESGCMEncrypt(key, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("seal: %w", err)
+	}
+	out := make([]byte, 0, len(sealedMagic)+len(nonce)+len(ct))
+	out = append(out, sealedMagic...)
+	out = append(out, nonce...)
+	return append(out, ct...), nil
+}
+
+// IsSealed reports whether data was produced by SealLocal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// OpenLocal reverses SealLocal. A wrong key fails authentication.
+func OpenLocal(key, sealed []byte) ([]byte, error) {
+	if !IsSealed(sealed) {
+		return nil, fmt.Errorf("not a sealed file")
+	}
+	body := sealed[len(sealedMagic):]
+	if len(body) < IVSize+TagSize {
+		return nil, fmt.Errorf("sealed file too short")
+	}
+	plaintext, err := AESGCMDecrypt(key, body[:IVSize], body[IVSize:])
+	if err != nil {
+		return nil, fmt.Errorf("unseal: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealLocalRoundtrip(t *testing.T) {
+	salt := bytes.Repeat([]byte{0x07}, 16)
+	key, err := PassphraseKey([]byte("correct horse"), salt, DefaultKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := PassphraseKey([]byte("correct horse"), salt, DefaultKDFParams)
+	if !bytes.Equal(key, again) {
+		t.Error("same passphrase and salt should give the same key")
+	}
+
+	secret := []byte("epoch secret")
+	sealed, err := SealLocal(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || IsSealed(secret) {
+		t.Error("IsSealed should recognise only sealed data")
+	}
+	if bytes.Contains(sealed, secret) {
+		t.Error("sealed data contains the plaintext")
+	}
+	opened, err := OpenLocal(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, secret) {
+		t.Errorf("opened = %q, want %q", opened, secret)
+	}
+
+	wrong, _ := PassphraseKey([]byte("battery staple"), salt, DefaultKDFParams)
+	if _, err := OpenLocal(wrong, sealed); err == nil {
+		t.Error("wrong passphrase should fail to open")
+	}
+}
+
+func TestPassphraseKeyRejectsBadParams(t *testing.T) {
+	salt := bytes.Repeat([]byte{0x07}, 16)
+	if _, err := PassphraseKey([]byte("pw"), salt[:8], DefaultKDFParams); err == nil {
+		t.Error("short salt should be rejected")
+	}
+	if _, err := PassphraseKey([]byte("pw"), salt, KDFParams{}); err == nil {
+		t.Error("zero parameters should be rejected")
+	}
+}
@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+// recoveryEncoding spells recovery keys in upper-case base32 so they
+// survive being read aloud or written down.
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryKey generates a random 32-byte key and its printable form,
+// base32 in dash-separated groups of four.
+func NewRecoveryKey() (key []byte, printable string, err error) {
+	key = make([]byte, AESKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", fmt.Errorf("random recovery key: %w", err)
+	}
+	enc := recoveryEncoding.EncodeToString(key)
+	var groups []string
+	for i := 0; i < len(enc); i += 4 {
+		groups = append(groups, enc[i:min(i+4, len(enc))])
+	}
+	return key, strings.Join(groups, "-"), nil
+}
+
+// ParseRecoveryKey decodes the printable form of a recovery key, ignoring
+// case, dashes and spaces.
+func ParseRecoveryKey(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+	key, err := recoveryEncoding.DecodeString(s)
+	if err != nil || len(key) != AESKeySize {
+		return nil, fmt.Errorf("malformed recovery key")
+	}
+	return key, nil
+}
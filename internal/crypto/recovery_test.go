@@ -0,0 +1,29 @@
+package crypto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryKeyRoundtrip(t *testing.T) {
+	key, printable, err := NewRecoveryKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != AESKeySize {
+		t.Fatalf("key is %d bytes", len(key))
+	}
+	for _, s := range []string{printable, strings.ToLower(printable), strings.ReplaceAll(printable, "-", " ")} {
+		got, err := ParseRecoveryKey(s)
+		if err != nil {
+			t.Fatalf("ParseRecoveryKey(%q): %v", s, err)
+		}
+		if !bytes.Equal(got, key) {
+			t.Errorf("ParseRecoveryKey(%q) returned a different key", s)
+		}
+	}
+	if _, err := ParseRecoveryKey(printable[:20]); err == nil {
+		t.Error("truncated recovery key should be rejected")
+	}
+}
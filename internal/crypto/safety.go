@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// safetyIterations is the number of SHA-512 rounds per half of a safety
+// number, as in Signal's numeric fingerprints.
+const safetyIterations = 5200
+
+// SafetyNumber returns a 60-digit code, in groups of five, that two members
+// read to each other out of band to confirm they hold each other's real
+// keys. Each member contributes 30 digits derived from their ID and public
+// key; the halves are sorted, so both members compute the same code. The
+// caller's own key must come from their private key, not from a copy in
+// the repository, or a replaced copy would go unnoticed.
+func SafetyNumber(idA string, pubA ed25519.PublicKey, idB string, pubB ed25519.PublicKey) string {
+	a, b := safetyHalf(idA, pubA), safetyHalf(idB, pubB)
+	if b < a {
+		a, b = b, a
+	}
+	digits := a + b
+	groups := make([]string, 0, len(digits)/5)
+	for i := 0; i < len(digits); i += 5 {
+		groups = append(groups, digits[i:i+5])
+	}
+	return strings.Join(groups, " ")
+}
+
+// safetyHalf derives one member's 30 digits.
+func safetyHalf(id string, pub ed25519.PublicKey) string {
+	buf := append([]byte{0, 0}, pub...)
+	buf = append(buf, id...)
+	digest := sha512.Sum512(buf)
+	for i := 1; i < safetyIterations; i++ {
+		digest = sha512.Sum512(append(digest[:], pub...))
+	}
+	var b strings.Builder
+	for i := 0; i < 30; i += 5 {
+		var chunk [8]byte
+		copy(chunk[3:], digest[i:i+5])
+		fmt.Fprintf(&b, "%05d", binary.BigEndian.Uint64(chunk[:])%100000)
+	}
+	return b.String()
+}
+
+// NormalizeFingerprint lowercases a fingerprint and strips the separators
+// people add when reading it aloud or pasting it, so fingerprints from
+// different sources compare equal.
+func NormalizeFingerprint(fp string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', ':', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(fp))
+}
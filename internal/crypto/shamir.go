@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// SplitSecret splits secret into n Shamir shares over GF(256), any k of
+// which recover it with CombineShares. Each share is its x coordinate (1..n)
+// followed by one byte per secret byte.
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("invalid threshold %d of %d shares", k, n)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("empty secret")
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+	coeffs := make([]byte, k)
+	for j, s := range secret {
+		// Random polynomial of degree k-1 with the secret byte at x=0
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("random coefficients: %w", err)
+		}
+		for i := range shares {
+			x := shares[i][0]
+			var y byte
+			for c := k - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coeffs[c]
+			}
+			shares[i][1+j] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares recovers a secret from shares made by SplitSecret by
+// Lagrange interpolation at x=0. Fewer shares than the threshold yield a
+// wrong secret, not an error; callers check the result.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("need at least 2 shares, have %d", len(shares))
+	}
+	size := len(shares[0])
+	seen := make(map[byte]bool, len(shares))
+	for _, s := range shares {
+		if len(s) != size || size < 2 {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		if s[0] == 0 || seen[s[0]] {
+			return nil, fmt.Errorf("duplicate or invalid share index %d", s[0])
+		}
+		seen[s[0]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for i, si := range shares {
+		// Lagrange basis at 0: prod x_m / (x_m - x_i), and - is ^ in GF(256)
+		basis := byte(1)
+		for m, sm := range shares {
+			if m != i {
+				basis = gfMul(basis, gfDiv(sm[0], sm[0]^si[0]))
+			}
+		}
+		for j := range secret {
+			secret[j] ^= gfMul(basis, si[1+j])
+		}
+	}
+	return secret, nil
+}
+
+// gfMul multiplies in GF(256) with the AES polynomial x^8+x^4+x^3+x+1.
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides in GF(256); b must be non-zero.
+func gfDiv(a, b byte) byte {
+	// b^-1 = b^254
+	inv := byte(1)
+	for i := 0; i < 254; i++ {
+		inv = gfMul(inv, b)
+	}
+	return gfMul(a, inv)
+}
@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombineSecret(t *testing.T) {
+	secret := []byte("thirty-two bytes of epoch secret")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("got %d shares", len(shares))
+	}
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var picked [][]byte
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		got, err := CombineShares(picked)
+		if err != nil {
+			t.Fatalf("CombineShares(%v): %v", subset, err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("CombineShares(%v) = %q", subset, got)
+		}
+	}
+
+	got, err := CombineShares(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Error("two of three shares should not recover the secret")
+	}
+}
+
+func TestCombineSharesRejectsBadInput(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Error("duplicate shares should be rejected")
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[1][:3]}); err == nil {
+		t.Error("shares of different lengths should be rejected")
+	}
+	if _, err := SplitSecret([]byte("secret"), 3, 4); err == nil {
+		t.Error("threshold above share count should be rejected")
+	}
+	if _, err := SplitSecret([]byte("secret"), 3, 1); err == nil {
+		t.Error("threshold of 1 should be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	stdcrypto "crypto"
 	"crypto/ed25519"
 	"crypto/sha256"
 	"crypto/x509"
@@ -86,6 +87,18 @@ func Verify(publicKey ed25519.PublicKey, data, signature []byte) bool {
 	return ed25519.Verify(publicKey, data, signature)
 }
 
+// SignPrehashed signs a SHA-512 digest with Ed25519ph, for content too
+// large to hold in memory.
+func SignPrehashed(privateKey ed25519.PrivateKey, digest []byte) ([]byte, error) {
+	return privateKey.Sign(nil, digest, &ed25519.Options{Hash: stdcrypto.SHA512})
+}
+
+// VerifyPrehashed verifies an Ed25519ph signature over a SHA-512 digest.
+// Returns true on success.
+func VerifyPrehashed(publicKey ed25519.PublicKey, digest, signature []byte) bool {
+	return ed25519.VerifyWithOptions(publicKey, digest, signature, &ed25519.Options{Hash: stdcrypto.SHA512}) == nil
+}
+
 // PublicKeyFingerprint returns a hex SHA-256 fingerprint of the public key PEM (first 16 chars).
 func PublicKeyFingerprint(publicKey ed25519.PublicKey) (string, error) {
 	pemStr, err := PublicKeyToPEM(publicKey)
@@ -1,6 +1,8 @@
 package crypto
 
 import (
+	"crypto/sha512"
+	"strings"
 	"testing"
 )
 
@@ -100,6 +102,29 @@ func TestVerifyInvalidSignature(t *testing.T) {
 	}
 }
 
+func TestSignPrehashed(t *testing.T) {
+	priv, pub, err := GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha512.Sum512([]byte("test message"))
+
+	sig, err := SignPrehashed(priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPrehashed(pub, digest[:], sig) {
+		t.Error("valid signature rejected")
+	}
+	if Verify(pub, digest[:], sig) {
+		t.Error("prehashed signature should not verify as a plain signature")
+	}
+	other := sha512.Sum512([]byte("tampered"))
+	if VerifyPrehashed(pub, other[:], sig) {
+		t.Error("signature over another digest should be rejected")
+	}
+}
+
 func TestPublicKeyFingerprint(t *testing.T) {
 	_, pub, err := GenerateKeypair()
 	if err != nil {
@@ -124,3 +149,28 @@ func TestPublicKeyFingerprint(t *testing.T) {
 		t.Error("same key should produce same fingerprint")
 	}
 }
+
+func TestSafetyNumberSymmetric(t *testing.T) {
+	_, alicePub, _ := GenerateKeypair()
+	_, bobPub, _ := GenerateKeypair()
+
+	ab := SafetyNumber("alice", alicePub, "bob", bobPub)
+	ba := SafetyNumber("bob", bobPub, "alice", alicePub)
+	if ab != ba {
+		t.Fatalf("safety number not symmetric: %q vs %q", ab, ba)
+	}
+	if digits := strings.ReplaceAll(ab, " ", ""); len(digits) != 60 {
+		t.Errorf("safety number has %d digits, want 60: %q", len(digits), ab)
+	}
+
+	_, otherPub, _ := GenerateKeypair()
+	if SafetyNumber("alice", alicePub, "bob", otherPub) == ab {
+		t.Error("a different key should change the safety number")
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	if got := NormalizeFingerprint("AB:CD ef-01"); got != "abcdef01" {
+		t.Errorf("NormalizeFingerprint = %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrSSHKeyEncrypted is returned by ParseSSHPrivateKey when the key is
+// protected by a passphrase and none was given.
+var ErrSSHKeyEncrypted = errors.New("ssh key is encrypted")
+
+// ParseSSHPrivateKey parses an OpenSSH private key file, decrypting it
+// with passphrase if it is encrypted. Only Ed25519 keys are accepted, as
+// they are the only SSH keys mlsgit can sign with.
+func ParseSSHPrivateKey(data, passphrase []byte) (ed25519.PrivateKey, error) {
+	var raw interface{}
+	var err error
+	if passphrase == nil {
+		raw, err = ssh.ParseRawPrivateKey(data)
+	} else {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, ErrSSHKeyEncrypted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse ssh key: %w", err)
+	}
+	switch k := raw.(type) {
+	case *ed25519.PrivateKey:
+		return *k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("ssh key is %T, only Ed25519 keys are supported", raw)
+	}
+}
+
+// SSHFingerprint returns the OpenSSH fingerprint of a public key
+// ("SHA256:..."), as shown by ssh-keygen -l.
+func SSHFingerprint(publicKey ed25519.PublicKey) (string, error) {
+	sshPub, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("ssh public key: %w", err)
+	}
+	return ssh.FingerprintSHA256(sshPub), nil
+}
+
+// SSHAuthorizedKey formats a public key as an OpenSSH public key line
+// ("ssh-ed25519 AAAA..."), without a trailing newline.
+func SSHAuthorizedKey(publicKey ed25519.PublicKey) (string, error) {
+	sshPub, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("ssh public key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))), nil
+}
+
+// FingerprintMatches reports whether fp names publicKey, either as an
+// OpenSSH "SHA256:..." fingerprint or in the hex form of
+// PublicKeyFingerprint.
+func FingerprintMatches(publicKey ed25519.PublicKey, fp string) bool {
+	fp = strings.TrimSpace(fp)
+	if strings.HasPrefix(fp, "SHA256:") {
+		want, err := SSHFingerprint(publicKey)
+		return err == nil && fp == want
+	}
+	want, err := PublicKeyFingerprint(publicKey)
+	return err == nil && NormalizeFingerprint(fp) == want
+}
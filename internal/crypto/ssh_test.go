@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHPrivateKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseSSHPrivateKey(pem.EncodeToMemory(block), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(priv) {
+		t.Error("parsed key does not match")
+	}
+
+	block, _ = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("pw"))
+	encrypted := pem.EncodeToMemory(block)
+	if _, err := ParseSSHPrivateKey(encrypted, nil); !errors.Is(err, ErrSSHKeyEncrypted) {
+		t.Errorf("err = %v, want ErrSSHKeyEncrypted", err)
+	}
+	if got, err := ParseSSHPrivateKey(encrypted, []byte("pw")); err != nil || !got.Equal(priv) {
+		t.Errorf("encrypted key with passphrase: %v", err)
+	}
+}
+
+func TestFingerprintMatches(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	sshFP, err := SSHFingerprint(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sshFP, "SHA256:") {
+		t.Errorf("SSHFingerprint = %q", sshFP)
+	}
+	hexFP, _ := PublicKeyFingerprint(pub)
+	for _, fp := range []string{sshFP, hexFP, strings.ToUpper(hexFP)} {
+		if !FingerprintMatches(pub, fp) {
+			t.Errorf("%q should match", fp)
+		}
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if FingerprintMatches(other, sshFP) || FingerprintMatches(other, hexFP) {
+		t.Error("fingerprint matched the wrong key")
+	}
+	// Base64 is case-sensitive
+	if FingerprintMatches(pub, "SHA256:"+strings.ToLower(strings.TrimPrefix(sshFP, "SHA256:"))) {
+		t.Error("lowercased SSH fingerprint should not match")
+	}
+}
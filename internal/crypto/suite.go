@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Ciphersuite IDs. The first two are the MLS registry values for X25519
+// with AES-GCM and ChaCha20-Poly1305 (mlsgit uses 256-bit AES keys); the
+// rest are in the MLS private-use range.
+const (
+	SuiteAES256GCM               = 0x0001
+	SuiteChaCha20Poly1305        = 0x0003
+	SuiteHybridAES256GCM         = 0xF001
+	SuiteXChaCha20Poly1305       = 0xF002
+	SuiteHybridXChaCha20Poly1305 = 0xF003
+)
+
+// Suite is an entry in the ciphersuite registry. It names the AEAD that
+// encrypts file content and whether the group's init and path keys use
+// the X25519 + ML-KEM-768 hybrid KEM. Signing is always Ed25519 and key
+// derivation always HKDF-SHA256 with 32-byte keys.
+type Suite struct {
+	ID          int
+	Name        string
+	PostQuantum bool
+	newAEAD     func(key []byte) (cipher.AEAD, error)
+}
+
+var suites = map[int]Suite{
+	SuiteAES256GCM:               {SuiteAES256GCM, "x25519-aes256gcm", false, newAESGCM},
+	SuiteChaCha20Poly1305:        {SuiteChaCha20Poly1305, "x25519-chacha20poly1305", false, chacha20poly1305.New},
+	SuiteHybridAES256GCM:         {SuiteHybridAES256GCM, "x25519mlkem768-aes256gcm", true, newAESGCM},
+	SuiteXChaCha20Poly1305:       {SuiteXChaCha20Poly1305, "x25519-xchacha20poly1305", false, chacha20poly1305.NewX},
+	SuiteHybridXChaCha20Poly1305: {SuiteHybridXChaCha20Poly1305, "x25519mlkem768-xchacha20poly1305", true, chacha20poly1305.NewX},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// LookupSuite returns the registered suite with the given ID.
+func LookupSuite(id int) (Suite, error) {
+	s, ok := suites[id]
+	if !ok {
+		return Suite{}, fmt.Errorf("unknown cipher suite %#04x", id)
+	}
+	return s, nil
+}
+
+// ParseSuite resolves a suite from its name or its ID in decimal or hex
+// (e.g. "x25519-chacha20poly1305", "3" or "0x0003").
+func ParseSuite(s string) (Suite, error) {
+	s = strings.TrimSpace(s)
+	for _, suite := range suites {
+		if strings.EqualFold(s, suite.Name) {
+			return suite, nil
+		}
+	}
+	id, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return Suite{}, fmt.Errorf("unknown cipher suite %q", s)
+	}
+	return LookupSuite(int(id))
+}
+
+// Suites returns every registered suite, ordered by ID.
+func Suites() []Suite {
+	out := make([]Suite, 0, len(suites))
+	for _, s := range suites {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// NewAEAD returns the suite's AEAD keyed with a 32-byte key, for callers
+// that choose their own nonces (see delta.EncryptStream).
+func (s Suite) NewAEAD(key []byte) (cipher.AEAD, error) {
+	aead, err := s.newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	return aead, nil
+}
+
+// Encrypt encrypts plaintext with the suite's AEAD under a 32-byte key
+// using a random nonce, authenticating aad (which may be nil) with it.
+// Returns (nonce, ciphertext||tag).
+func (s Suite) Encrypt(key, plaintext, aad []byte) (nonce, ct []byte, err error) {
+	aead, err := s.newAEAD(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("random nonce: %w", err)
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// Decrypt decrypts ciphertext||tag produced by Encrypt with the same aad.
+func (s Suite) Decrypt(key, nonce, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := s.newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s: nonce must be %d bytes", s.Name, aead.NonceSize())
+	}
+	if len(ciphertext) < aead.Overhead() {
+		return nil, fmt.Errorf("ciphertext too short (missing %s tag)", s.Name)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%s decrypt: %w", s.Name, err)
+	}
+	return plaintext, nil
+}
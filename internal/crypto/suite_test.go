@@ -0,0 +1,67 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSuitesRoundtrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	plaintext := []byte("hello, suites")
+	for _, s := range Suites() {
+		nonce, ct, err := s.Encrypt(key, plaintext, []byte("header"))
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name, err)
+		}
+		got, err := s.Decrypt(key, nonce, ct, []byte("header"))
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("%s: decrypted = %q", s.Name, got)
+		}
+		if _, err := s.Decrypt(key, nonce, ct, []byte("other")); err == nil {
+			t.Errorf("%s: decrypting with other associated data should fail", s.Name)
+		}
+	}
+}
+
+func TestSuiteMismatchFails(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	aes, _ := LookupSuite(SuiteAES256GCM)
+	chacha, _ := LookupSuite(SuiteChaCha20Poly1305)
+	nonce, ct, _ := aes.Encrypt(key, []byte("data"), nil)
+	if _, err := chacha.Decrypt(key, nonce, ct, nil); err == nil {
+		t.Error("decrypting under another suite should fail")
+	}
+
+	// The AES-256-GCM suite is wire-compatible with AESGCMEncrypt
+	nonce, ct, _ = AESGCMEncrypt(key, []byte("data"))
+	if got, err := aes.Decrypt(key, nonce, ct, nil); err != nil || string(got) != "data" {
+		t.Errorf("AES-256-GCM suite should decrypt AESGCMEncrypt output: %v", err)
+	}
+}
+
+func TestParseSuite(t *testing.T) {
+	for in, want := range map[string]int{
+		"1":                                SuiteAES256GCM,
+		"0x0003":                           SuiteChaCha20Poly1305,
+		"61441":                            SuiteHybridAES256GCM,
+		"X25519-XChaCha20Poly1305":         SuiteXChaCha20Poly1305,
+		"x25519mlkem768-xchacha20poly1305": SuiteHybridXChaCha20Poly1305,
+	} {
+		s, err := ParseSuite(in)
+		if err != nil {
+			t.Errorf("ParseSuite(%q): %v", in, err)
+			continue
+		}
+		if s.ID != want {
+			t.Errorf("ParseSuite(%q) = %#x, want %#x", in, s.ID, want)
+		}
+	}
+	for _, in := range []string{"2", "rot13", ""} {
+		if _, err := ParseSuite(in); err == nil {
+			t.Errorf("ParseSuite(%q) should fail", in)
+		}
+	}
+}
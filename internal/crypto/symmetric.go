@@ -43,6 +43,20 @@ func DeriveFileKey(epochSecret []byte, filePath string, epoch int) []byte {
 	return key
 }
 
+// DeriveStreamKey derives the key for one streamed record from its file
+// key and the record's random salt, so streamed segments, whose nonces are
+// counters, never share a key with anything else.
+//
+// key = HKDF-SHA-256(secret=fileKey, salt=salt, info="mlsgit-stream-key")
+func DeriveStreamKey(fileKey, salt []byte) []byte {
+	hkdfReader := hkdf.New(sha256.New, fileKey, salt, []byte("mlsgit-stream-key"))
+	key := make([]byte, AESKeySize)
+	if _, err := io.ReadFull(hkdfReader, key); err != nil {
+		panic(fmt.Sprintf("hkdf: %v", err))
+	}
+	return key
+}
+
 // AESGCMEncrypt encrypts plaintext with AES-256-GCM using a random nonce.
 // Returns (nonce, ciphertext||tag).
 func AESGCMEncrypt(key, plaintext []byte) (nonce, ct []byte, err error) {
@@ -0,0 +1,135 @@
+package delta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+// binaryBlockSize is the length of the old-content blocks a binary delta
+// matches against. Shorter runs of unchanged bytes are sent literally.
+const binaryBlockSize = 16
+
+// Binary delta instructions.
+const (
+	opCopy byte = 'C' // uvarint offset, uvarint length: copy from old content
+	opAdd  byte = 'A' // uvarint length, bytes: insert literal bytes
+)
+
+// binarySniffLen is how much of the content IsBinary scans for a NUL
+// byte, as git does.
+const binarySniffLen = 8000
+
+// IsBinary reports whether data should be diffed as bytes rather than
+// text: it has a NUL byte near the start or is not valid UTF-8.
+func IsBinary(data []byte) bool {
+	if bytes.IndexByte(data[:min(len(data), binarySniffLen)], 0) >= 0 {
+		return true
+	}
+	return !utf8.Valid(data)
+}
+
+// ComputeBinaryDelta computes a byte-level delta from oldData to newData:
+// the two lengths followed by copy and add instructions, in the style of
+// VCDIFF.
+func ComputeBinaryDelta(oldData, newData []byte) []byte {
+	// Index the first occurrence of every aligned block of the old content
+	index := make(map[string]int, len(oldData)/binaryBlockSize)
+	for o := 0; o+binaryBlockSize <= len(oldData); o += binaryBlockSize {
+		if _, ok := index[string(oldData[o:o+binaryBlockSize])]; !ok {
+			index[string(oldData[o:o+binaryBlockSize])] = o
+		}
+	}
+
+	out := binary.AppendUvarint(nil, uint64(len(oldData)))
+	out = binary.AppendUvarint(out, uint64(len(newData)))
+	pending := 0 // start of the literal run not yet emitted
+	for i := 0; i+binaryBlockSize <= len(newData); {
+		o, ok := index[string(newData[i:i+binaryBlockSize])]
+		if !ok {
+			i++
+			continue
+		}
+		// Extend the match backwards into the literal run, then forwards
+		start, oldStart := i, o
+		for start > pending && oldStart > 0 && newData[start-1] == oldData[oldStart-1] {
+			start--
+			oldStart--
+		}
+		end, oldEnd := i+binaryBlockSize, o+binaryBlockSize
+		for end < len(newData) && oldEnd < len(oldData) && newData[end] == oldData[oldEnd] {
+			end++
+			oldEnd++
+		}
+		out = appendAdd(out, newData[pending:start])
+		out = append(out, opCopy)
+		out = binary.AppendUvarint(out, uint64(oldStart))
+		out = binary.AppendUvarint(out, uint64(end-start))
+		i, pending = end, end
+	}
+	return appendAdd(out, newData[pending:])
+}
+
+// appendAdd appends an add instruction for lit, if it is not empty.
+func appendAdd(out, lit []byte) []byte {
+	if len(lit) == 0 {
+		return out
+	}
+	out = append(out, opAdd)
+	out = binary.AppendUvarint(out, uint64(len(lit)))
+	return append(out, lit...)
+}
+
+// ApplyBinaryDelta applies a delta produced by ComputeBinaryDelta. Unlike
+// a text patch it never applies fuzzily: the old content must have the
+// length the delta was computed from, and every instruction must stay in
+// bounds.
+func ApplyBinaryDelta(oldData, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	oldLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("binary delta: read old length: %w", err)
+	}
+	newLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("binary delta: read new length: %w", err)
+	}
+	if oldLen != uint64(len(oldData)) {
+		return nil, fmt.Errorf("binary delta: made for %d bytes, applied to %d", oldLen, len(oldData))
+	}
+	if newLen > uint64(len(oldData))+uint64(len(delta)) {
+		return nil, fmt.Errorf("binary delta: new length %d out of range", newLen)
+	}
+
+	out := make([]byte, 0, newLen)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case opCopy:
+			off, err1 := binary.ReadUvarint(r)
+			n, err2 := binary.ReadUvarint(r)
+			if err1 != nil || err2 != nil || off > uint64(len(oldData)) || n > uint64(len(oldData))-off {
+				return nil, fmt.Errorf("binary delta: copy out of range")
+			}
+			out = append(out, oldData[off:off+n]...)
+		case opAdd:
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return nil, fmt.Errorf("binary delta: add out of range")
+			}
+			lit := make([]byte, n)
+			r.Read(lit)
+			out = append(out, lit...)
+		default:
+			return nil, fmt.Errorf("binary delta: unknown instruction %#x", op)
+		}
+		if uint64(len(out)) > newLen {
+			return nil, fmt.Errorf("binary delta: output exceeds %d bytes", newLen)
+		}
+	}
+	if uint64(len(out)) != newLen {
+		return nil, fmt.Errorf("binary delta: produced %d bytes, want %d", len(out), newLen)
+	}
+	return out, nil
+}
@@ -3,9 +3,13 @@ package delta
 import (
 	"crypto/ed25519"
 	"fmt"
+	"io"
+	"strings"
 )
 
-// Compact decrypts the full chain and re-encrypts as a single base block.
+// Compact decrypts the full chain and re-encrypts as a single base block
+// under the given crypto.Suite ID, in enc's format. A streamed record is
+// re-encrypted as a streamed record.
 // Used when the delta chain exceeds the compaction threshold or after member removal.
 func Compact(
 	ciphertext string,
@@ -13,13 +17,63 @@ func Compact(
 	newEpochSecret []byte,
 	filePath string,
 	newEpoch int,
+	suiteID int,
+	enc Encoding,
 	author string,
 	privateKey ed25519.PrivateKey,
 	getPublicKey PublicKeyFunc,
 ) (string, error) {
-	plaintext, err := DecryptChain(ciphertext, getEpochSecret, filePath, getPublicKey)
+	return Move(ciphertext, getEpochSecret, newEpochSecret, filePath, filePath, newEpoch, suiteID, enc, author, privateKey, getPublicKey)
+}
+
+// Move is Compact for a file renamed from fromPath to toPath: the chain is
+// decrypted as written for fromPath and re-encrypted for toPath, since every
+// record is bound to the path it was written for.
+func Move(
+	ciphertext string,
+	getEpochSecret EpochSecretFunc,
+	newEpochSecret []byte,
+	fromPath, toPath string,
+	newEpoch int,
+	suiteID int,
+	enc Encoding,
+	author string,
+	privateKey ed25519.PrivateKey,
+	getPublicKey PublicKeyFunc,
+) (string, error) {
+	if IsStream([]byte(ciphertext)) {
+		return compactStream(ciphertext, getEpochSecret, newEpochSecret, fromPath, toPath, newEpoch, suiteID, author, privateKey, getPublicKey)
+	}
+	plaintext, err := DecryptChain(ciphertext, getEpochSecret, fromPath, getPublicKey)
 	if err != nil {
 		return "", fmt.Errorf("compact decrypt: %w", err)
 	}
-	return EncryptBaseBlock(plaintext, newEpochSecret, filePath, newEpoch, author, privateKey)
+	return EncryptBaseBlock(plaintext, newEpochSecret, toPath, newEpoch, suiteID, enc, author, privateKey)
+}
+
+// compactStream re-encrypts a streamed record segment by segment, piping
+// DecryptStream into EncryptStream. If the old record fails to verify, the
+// new one is discarded.
+func compactStream(
+	ciphertext string,
+	getEpochSecret EpochSecretFunc,
+	newEpochSecret []byte,
+	fromPath, toPath string,
+	newEpoch int,
+	suiteID int,
+	author string,
+	privateKey ed25519.PrivateKey,
+	getPublicKey PublicKeyFunc,
+) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(DecryptStream(pw, strings.NewReader(ciphertext), getEpochSecret, fromPath, getPublicKey))
+	}()
+	var ct strings.Builder
+	err := EncryptStream(&ct, pr, newEpochSecret, toPath, newEpoch, suiteID, author, privateKey)
+	pr.CloseWithError(err)
+	if err != nil {
+		return "", fmt.Errorf("compact stream: %w", err)
+	}
+	return ct.String(), nil
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 func TestCompact(t *testing.T) {
@@ -11,12 +13,12 @@ func TestCompact(t *testing.T) {
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
 	// Build a chain with multiple deltas
-	ct, _ := EncryptBaseBlock([]byte("version 1"), secret, "test.txt", 0, "alice", priv)
+	ct, _ := EncryptBaseBlock([]byte("version 1"), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	for i := 2; i <= 5; i++ {
 		old := "version " + string(rune('0'+i-1))
 		new := "version " + string(rune('0'+i))
 		delta := ComputeDelta(old, new)
-		ct, _ = EncryptDelta(delta, secret, "test.txt", 0, i-1, "alice", priv, ct)
+		ct, _ = EncryptDelta([]byte(delta), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, i-1, "alice", priv, ct)
 	}
 
 	if CountDeltas(ct) != 4 {
@@ -24,10 +26,10 @@ func TestCompact(t *testing.T) {
 	}
 
 	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
-	getKey := func(author string) (ed25519.PublicKey, error) { return pub, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
 
 	newSecret := bytes.Repeat([]byte{0x43}, 32)
-	compacted, err := Compact(ct, getSecret, newSecret, "test.txt", 1, "alice", priv, getKey)
+	compacted, err := Compact(ct, getSecret, newSecret, "test.txt", 1, crypto.SuiteAES256GCM, Encoding{}, "alice", priv, getKey)
 	if err != nil {
 		t.Fatalf("Compact error: %v", err)
 	}
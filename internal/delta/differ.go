@@ -7,8 +7,49 @@ import (
 	dmp "github.com/sergi/go-diff/diffmatchpatch"
 )
 
+// Delta codecs, recorded in each delta record.
+const (
+	// CodecText is a diff-match-patch text patch. Delta records written
+	// before codecs were recorded have none and use it.
+	CodecText = "text"
+	// CodecBinary is a byte-level copy/add delta (ComputeBinaryDelta).
+	CodecBinary = "binary"
+)
+
+// patcher is the text codec's diff-match-patch instance. The binary codec
+// has its own exact copy/add format and never goes through it.
 var patcher = dmp.New()
 
+// ChooseCodec returns the codec for a delta from oldData to newData:
+// CodecBinary if either looks binary, CodecText otherwise.
+func ChooseCodec(oldData, newData []byte) string {
+	if IsBinary(oldData) || IsBinary(newData) {
+		return CodecBinary
+	}
+	return CodecText
+}
+
+// Compute computes a delta from oldData to newData with codec.
+func Compute(codec string, oldData, newData []byte) []byte {
+	if codec == CodecBinary {
+		return ComputeBinaryDelta(oldData, newData)
+	}
+	return []byte(ComputeDelta(string(oldData), string(newData)))
+}
+
+// Apply applies a delta computed with codec to oldData. An empty codec
+// is CodecText.
+func Apply(codec string, oldData, delta []byte) ([]byte, error) {
+	switch codec {
+	case "", CodecText:
+		newText, err := ApplyDelta(string(oldData), string(delta))
+		return []byte(newText), err
+	case CodecBinary:
+		return ApplyBinaryDelta(oldData, delta)
+	}
+	return nil, fmt.Errorf("unknown delta codec %q", codec)
+}
+
 // ComputeDelta computes a compact character-level delta from oldText to newText.
 // Returns a string representation that can be applied with ApplyDelta.
 func ComputeDelta(oldText, newText string) string {
@@ -1,6 +1,7 @@
 package delta
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -40,3 +41,56 @@ func TestApplyDeltaInvalid(t *testing.T) {
 		t.Fatal("expected error for invalid delta")
 	}
 }
+
+func TestComputeApplyBinaryDelta(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), bytes.Repeat([]byte{0xff, 0x00, 0x80, 0x7f}, 256)...)
+	edited := append([]byte{}, png...)
+	edited[100] ^= 0xff
+	edited = append(edited[:300], append([]byte("inserted\x00bytes"), edited[300:]...)...)
+
+	tests := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"edit and insert", png, edited},
+		{"truncate", png, png[:50]},
+		{"from empty", nil, png},
+		{"to empty", png, nil},
+		{"identical", png, png},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := ComputeBinaryDelta(tt.old, tt.new)
+			got, err := ApplyBinaryDelta(tt.old, delta)
+			if err != nil {
+				t.Fatalf("ApplyBinaryDelta error: %v", err)
+			}
+			if !bytes.Equal(got, tt.new) {
+				t.Errorf("ApplyBinaryDelta produced %d bytes, want %d", len(got), len(tt.new))
+			}
+		})
+	}
+
+	delta := ComputeBinaryDelta(png, edited)
+	if len(delta) > len(edited)/4 {
+		t.Errorf("delta is %d bytes for a small edit of %d", len(delta), len(edited))
+	}
+	if _, err := ApplyBinaryDelta(edited, delta); err == nil {
+		t.Error("a delta should not apply to other content")
+	}
+	if _, err := ApplyBinaryDelta(png, delta[:len(delta)-1]); err == nil {
+		t.Error("a truncated delta should be rejected")
+	}
+}
+
+func TestChooseCodec(t *testing.T) {
+	if c := ChooseCodec([]byte("plain text\n"), []byte("more text 世\n")); c != CodecText {
+		t.Errorf("text codec = %q", c)
+	}
+	if c := ChooseCodec([]byte("text"), []byte("a\x00b")); c != CodecBinary {
+		t.Errorf("NUL byte codec = %q", c)
+	}
+	if c := ChooseCodec([]byte{0xff, 0xfe}, []byte("text")); c != CodecBinary {
+		t.Errorf("invalid UTF-8 codec = %q", c)
+	}
+}
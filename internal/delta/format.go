@@ -0,0 +1,404 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/config"
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is the container a ciphertext chain is written in. A chain keeps
+// the format of its base block: deltas are appended in the same format.
+type Format string
+
+// Ciphertext formats.
+const (
+	// FormatText is the original format: base64 JSON records joined by
+	// config.DeltaSeparator. It has no version marker.
+	FormatText Format = "text"
+	// FormatBinary is the v2 container: a magic header followed by
+	// length-prefixed binary records.
+	FormatBinary Format = "binary"
+	// FormatArmored is the v2 container in base64 between armor lines, so
+	// it diffs as text and appending a delta only changes the last lines.
+	FormatArmored Format = "armored"
+)
+
+// CompressionZstd marks a record whose content was compressed with zstd
+// before encryption. Only v2 records can be compressed.
+const CompressionZstd = "zstd"
+
+const (
+	// binaryMagic opens a v2 container; the last byte is its version.
+	binaryMagic = "MLSGIT\x00\x03"
+	// legacyBinaryMagic opens a container written before records carried
+	// their version: their headers are the epoch, seq and suite only, and
+	// every record in it is version 0. It is read, never written.
+	legacyBinaryMagic = "MLSGIT\x00\x02"
+
+	armorBegin   = "-----BEGIN MLSGIT CIPHERTEXT-----\n"
+	armorEnd     = "-----END MLSGIT CIPHERTEXT-----\n"
+	armorLineLen = 64
+
+	// maxRecordSize bounds the length prefix of a v2 record.
+	maxRecordSize = 1 << 30
+)
+
+// Encoding selects how new records are written: the container for a new
+// chain, and the compression applied to v2 records.
+type Encoding struct {
+	Format      Format
+	Compression string
+}
+
+// ParseFormat validates a format name. An empty name is FormatText.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return FormatText, nil
+	case FormatText, FormatBinary, FormatArmored:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown ciphertext format %q (want text, binary or armored)", s)
+}
+
+// isV2 reports whether f is one of the v2 container formats.
+func (f Format) isV2() bool {
+	return f == FormatBinary || f == FormatArmored
+}
+
+// DetectFormat returns the format of a ciphertext chain, and false if data
+// is not one. v2 containers are recognized by their magic; text chains by
+// their first record decoding to JSON with the record fields.
+func DetectFormat(data string) (Format, bool) {
+	switch {
+	case strings.HasPrefix(data, binaryMagic), strings.HasPrefix(data, legacyBinaryMagic):
+		return FormatBinary, true
+	case strings.HasPrefix(data, armorBegin):
+		return FormatArmored, true
+	}
+	firstBlock, _, _ := strings.Cut(data, config.DeltaSeparator)
+	jsonBytes, err := crypto.B64Decode(strings.TrimSpace(firstBlock), true)
+	if err != nil {
+		return "", false
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &obj); err != nil {
+		return "", false
+	}
+	_, hasEpoch := obj["epoch"]
+	_, hasCT := obj["ct"]
+	_, hasIV := obj["iv"]
+	return FormatText, hasEpoch && hasCT && hasIV
+}
+
+// chainBlock is a parsed record and the PrevHash the record after it must
+// carry.
+type chainBlock struct {
+	record DeltaRecord
+	hash   string
+}
+
+// parseChain splits a ciphertext chain in any format into its records.
+func parseChain(ciphertext string) ([]chainBlock, Format, error) {
+	format, ok := DetectFormat(ciphertext)
+	if !ok {
+		return nil, "", fmt.Errorf("not a ciphertext chain")
+	}
+	if format == FormatText {
+		blocks := strings.Split(ciphertext, config.DeltaSeparator)
+		out := make([]chainBlock, len(blocks))
+		for i, b := range blocks {
+			record, err := DeltaRecordFromB64(strings.TrimSpace(b))
+			if err != nil {
+				return nil, format, fmt.Errorf("parse block %d: %w", i, err)
+			}
+			out[i] = chainBlock{record, hashPrefix(strings.Join(blocks[:i+1], config.DeltaSeparator))}
+		}
+		return out, format, nil
+	}
+
+	container, err := v2Container(ciphertext, format)
+	if err != nil {
+		return nil, format, err
+	}
+	var out []chainBlock
+	legacy := isLegacyContainer(container)
+	h := sha256.New()
+	h.Write(container[:len(binaryMagic)])
+	rest := container[len(binaryMagic):]
+	for len(rest) > 0 {
+		n, k := binary.Uvarint(rest)
+		if k <= 0 || n > maxRecordSize || n > uint64(len(rest)-k) {
+			return nil, format, fmt.Errorf("parse block %d: bad record length", len(out))
+		}
+		record, err := unmarshalRecord(rest[k:k+int(n)], legacy)
+		if err != nil {
+			return nil, format, fmt.Errorf("parse block %d: %w", len(out), err)
+		}
+		h.Write(rest[:k+int(n)])
+		out = append(out, chainBlock{record, hex.EncodeToString(h.Sum(nil))})
+		rest = rest[k+int(n):]
+	}
+	if len(out) == 0 {
+		return nil, format, fmt.Errorf("empty ciphertext")
+	}
+	return out, format, nil
+}
+
+// ParseChain returns the records of a ciphertext chain in any format, base
+// block first, and the chain's format. It does not verify the chain.
+func ParseChain(ciphertext string) ([]DeltaRecord, Format, error) {
+	blocks, format, err := parseChain(ciphertext)
+	if err != nil {
+		return nil, format, err
+	}
+	records := make([]DeltaRecord, len(blocks))
+	for i, b := range blocks {
+		records[i] = b.record
+	}
+	return records, format, nil
+}
+
+// Convert re-encodes a ciphertext chain in another format, recomputing the
+// hash chain of version 0 records for it; later records link to each other
+// the same way in every format. Records are not re-encrypted, so their
+// signatures still hold, but the chain is not verified either: decrypt it
+// first.
+func Convert(ciphertext string, format Format) (string, error) {
+	records, from, err := ParseChain(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if !format.isV2() {
+		for _, r := range records {
+			if r.Compression != "" {
+				return "", fmt.Errorf("compressed records cannot be written in the %s format", format)
+			}
+		}
+	}
+	if from == format {
+		return ciphertext, nil
+	}
+	out := ""
+	for i, r := range records {
+		if i == 0 {
+			out = encodeBase(r, format)
+		} else if out, err = appendRecord(out, format, r); err != nil {
+			return "", err
+		}
+	}
+	return out, nil
+}
+
+// encodeBase encodes a base block as a new chain in format.
+func encodeBase(r DeltaRecord, format Format) string {
+	if !format.isV2() {
+		return r.ToB64()
+	}
+	out, _ := appendRecord("", format, r)
+	return out
+}
+
+// appendRecord appends r to a chain in format. A version 0 record gets
+// the PrevHash of the format's hash chain; later records carry their own,
+// which they signed. An empty v2 chain is started with the magic header.
+func appendRecord(prevCiphertext string, format Format, r DeltaRecord) (string, error) {
+	if !format.isV2() {
+		if r.Version == 0 {
+			r.PrevHash = hashPrefix(prevCiphertext)
+		}
+		return prevCiphertext + config.DeltaSeparator + r.ToB64(), nil
+	}
+	container := []byte(binaryMagic)
+	if prevCiphertext != "" {
+		var err error
+		if container, err = v2Container(prevCiphertext, format); err != nil {
+			return "", err
+		}
+		if r.Version == 0 {
+			h := sha256.Sum256(container)
+			r.PrevHash = hex.EncodeToString(h[:])
+		}
+	}
+	container = appendField(container, r.marshalBinary())
+	if format == FormatArmored {
+		return armor(container), nil
+	}
+	return string(container), nil
+}
+
+// v2Container returns the binary container of a v2 chain.
+func v2Container(ciphertext string, format Format) ([]byte, error) {
+	if format == FormatArmored {
+		return dearmor(ciphertext)
+	}
+	return []byte(ciphertext), nil
+}
+
+// isLegacyContainer reports whether a v2 container has the layout of
+// legacyBinaryMagic.
+func isLegacyContainer(container []byte) bool {
+	return bytes.HasPrefix(container, []byte(legacyBinaryMagic))
+}
+
+// upgradeChain re-encodes a chain in a legacy container with binaryMagic,
+// in the same format, so a record with a version can be appended to it.
+// Its records are all version 0, whose hash chain is recomputed as Convert
+// does. Other chains are returned as they are.
+func upgradeChain(ciphertext string) (string, error) {
+	format, ok := DetectFormat(ciphertext)
+	if !ok || !format.isV2() {
+		return ciphertext, nil
+	}
+	container, err := v2Container(ciphertext, format)
+	if err != nil || !isLegacyContainer(container) {
+		return ciphertext, err
+	}
+	records, _, err := ParseChain(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	out := encodeBase(records[0], format)
+	for _, r := range records[1:] {
+		if out, err = appendRecord(out, format, r); err != nil {
+			return "", err
+		}
+	}
+	return out, nil
+}
+
+// armor wraps a v2 container in base64 lines between armor lines.
+func armor(container []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(container)
+	var b strings.Builder
+	b.WriteString(armorBegin)
+	for len(encoded) > armorLineLen {
+		b.WriteString(encoded[:armorLineLen])
+		b.WriteByte('\n')
+		encoded = encoded[armorLineLen:]
+	}
+	b.WriteString(encoded)
+	b.WriteByte('\n')
+	b.WriteString(armorEnd)
+	return b.String()
+}
+
+// dearmor returns the v2 container inside an armored chain.
+func dearmor(ciphertext string) ([]byte, error) {
+	body, ok := strings.CutPrefix(ciphertext, armorBegin)
+	if ok {
+		body, _, ok = strings.Cut(body, armorEnd)
+	}
+	if !ok {
+		return nil, fmt.Errorf("malformed armored ciphertext")
+	}
+	container, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(body, "\n", ""))
+	if err != nil || !bytes.HasPrefix(container, []byte(binaryMagic)) && !isLegacyContainer(container) {
+		return nil, fmt.Errorf("malformed armored ciphertext")
+	}
+	return container, nil
+}
+
+// marshalBinary encodes a record for the v2 container: the version,
+// epoch, seq and suite as uvarints, then the remaining fields
+// length-prefixed.
+func (r DeltaRecord) marshalBinary() []byte {
+	prevHash, _ := hex.DecodeString(r.PrevHash)
+	var b []byte
+	for _, n := range []int{r.Version, r.Epoch, r.Seq, r.Suite} {
+		b = binary.AppendUvarint(b, uint64(n))
+	}
+	for _, field := range [][]byte{
+		[]byte(r.Codec), []byte(r.Compression), r.IV, r.CT, r.Sig,
+		[]byte(r.Author), prevHash, []byte(r.FilePath),
+	} {
+		b = appendField(b, field)
+	}
+	return b
+}
+
+// unmarshalRecord decodes a record written by marshalBinary or, from a
+// legacy container, one whose header has no version.
+func unmarshalRecord(data []byte, legacy bool) (DeltaRecord, error) {
+	r := bytes.NewReader(data)
+	var ints [4]uint64
+	header := ints[:]
+	if legacy {
+		header = ints[1:]
+	}
+	for i := range header {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > maxRecordSize {
+			return DeltaRecord{}, fmt.Errorf("malformed record header")
+		}
+		header[i] = n
+	}
+	var fields [8][]byte
+	for i := range fields {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return DeltaRecord{}, fmt.Errorf("malformed record field %d", i)
+		}
+		fields[i] = make([]byte, n)
+		r.Read(fields[i])
+	}
+	if r.Len() != 0 {
+		return DeltaRecord{}, fmt.Errorf("trailing bytes in record")
+	}
+	return DeltaRecord{
+		Version:     int(ints[0]),
+		Epoch:       int(ints[1]),
+		Seq:         int(ints[2]),
+		Suite:       int(ints[3]),
+		Codec:       string(fields[0]),
+		Compression: string(fields[1]),
+		IV:          fields[2],
+		CT:          fields[3],
+		Sig:         fields[4],
+		Author:      string(fields[5]),
+		PrevHash:    hex.EncodeToString(fields[6]),
+		FilePath:    string(fields[7]),
+	}, nil
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxRecordSize))
+)
+
+// compress compresses data for a record, returning the compression used:
+// none unless it was requested and makes the content smaller.
+func compress(compression string, data []byte) ([]byte, string) {
+	if compression != CompressionZstd {
+		return data, ""
+	}
+	compressed := zstdEncoder.EncodeAll(data, nil)
+	if len(compressed) >= len(data) {
+		return data, ""
+	}
+	return compressed, CompressionZstd
+}
+
+// decompress undoes compress after decryption.
+func decompress(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case "":
+		return data, nil
+	case CompressionZstd:
+		out, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", compression)
+}
@@ -0,0 +1,200 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// buildTestChain encrypts versions as a base block and deltas in enc.
+func buildTestChain(t *testing.T, priv ed25519.PrivateKey, enc Encoding, versions []string) string {
+	t.Helper()
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	chain, err := EncryptBaseBlock([]byte(versions[0]), secret, "test.txt", 0, crypto.SuiteAES256GCM, enc, "alice", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(versions); i++ {
+		d := ComputeDelta(versions[i-1], versions[i])
+		chain, err = EncryptDelta([]byte(d), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, enc, i, "alice", priv, chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return chain
+}
+
+func decryptTestChain(chain string, pub ed25519.PublicKey) ([]byte, error) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	return DecryptChain(chain, getSecret, "test.txt", getKey)
+}
+
+func TestChainFormats(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	versions := []string{
+		strings.Repeat("the same line again\n", 200),
+		strings.Repeat("the same line again\n", 200) + "one more\n",
+		"short\n",
+	}
+	for _, format := range []Format{FormatText, FormatBinary, FormatArmored} {
+		chain := buildTestChain(t, priv, Encoding{Format: format, Compression: CompressionZstd}, versions)
+		if got, ok := DetectFormat(chain); !ok || got != format {
+			t.Errorf("%s: DetectFormat = %q, %v", format, got, ok)
+		}
+		if n := CountDeltas(chain); n != 2 {
+			t.Errorf("%s: CountDeltas = %d, want 2", format, n)
+		}
+		records, _, err := ParseChain(chain)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		// Only v2 records are compressed, and only when it helps
+		if want := map[Format]string{FormatText: "", FormatBinary: CompressionZstd, FormatArmored: CompressionZstd}[format]; records[0].Compression != want {
+			t.Errorf("%s: base block compression = %q, want %q", format, records[0].Compression, want)
+		}
+		got, err := decryptTestChain(chain, pub)
+		if err != nil {
+			t.Fatalf("%s: DecryptChain: %v", format, err)
+		}
+		if string(got) != versions[2] {
+			t.Errorf("%s: got %q, want %q", format, got, versions[2])
+		}
+	}
+
+	// The binary container is smaller than the text one
+	text := buildTestChain(t, priv, Encoding{Format: FormatText}, versions)
+	bin := buildTestChain(t, priv, Encoding{Format: FormatBinary}, versions)
+	if len(bin) >= len(text) {
+		t.Errorf("binary chain %d bytes, text chain %d bytes", len(bin), len(text))
+	}
+	if !strings.HasPrefix(buildTestChain(t, priv, Encoding{Format: FormatArmored}, versions[:1]), "-----BEGIN MLSGIT CIPHERTEXT-----\n") {
+		t.Error("armored chain should start with its armor line")
+	}
+}
+
+func TestConvertChain(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	versions := []string{"version 1", "version 2", "version 3"}
+	chain := buildTestChain(t, priv, Encoding{Format: FormatText}, versions)
+
+	for _, format := range []Format{FormatBinary, FormatArmored, FormatText} {
+		converted, err := Convert(chain, format)
+		if err != nil {
+			t.Fatalf("to %s: %v", format, err)
+		}
+		if got, _ := DetectFormat(converted); got != format {
+			t.Errorf("to %s: converted chain is %s", format, got)
+		}
+		if got, err := decryptTestChain(converted, pub); err != nil || string(got) != "version 3" {
+			t.Errorf("to %s: DecryptChain = %q, %v", format, got, err)
+		}
+		chain = converted
+	}
+
+	// Compressed records have no text encoding
+	compressed := buildTestChain(t, priv, Encoding{Format: FormatBinary, Compression: CompressionZstd}, []string{strings.Repeat("z", 1000)})
+	if _, err := Convert(compressed, FormatText); err == nil {
+		t.Error("converting compressed records to text should fail")
+	}
+}
+
+func TestBinaryChainTampering(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	chain := buildTestChain(t, priv, Encoding{Format: FormatBinary}, []string{"version 1", "version 2"})
+	records, _, err := ParseChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relabeling the base block's author breaks its signature
+	i := strings.Index(chain, records[0].Author)
+	tampered := chain[:i] + "b" + chain[i+1:]
+	if _, err := decryptTestChain(tampered, pub); err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Errorf("tampered base block: %v", err)
+	}
+
+	// Truncated records are rejected
+	if _, err := decryptTestChain(chain[:len(chain)-3], pub); err == nil {
+		t.Error("truncated chain should be rejected")
+	}
+	if _, ok := DetectFormat("MLSGIT"); ok {
+		t.Error("a partial magic is not a chain")
+	}
+}
+
+// legacyTestChain encrypts versions as a binary chain the way it was written
+// before records carried their version: version 0 records, each header the
+// epoch, seq and suite only, linked by the hash of the container before it.
+func legacyTestChain(t *testing.T, priv ed25519.PrivateKey, versions []string) []byte {
+	t.Helper()
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	suite, err := crypto.LookupSuite(crypto.SuiteAES256GCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := []byte(legacyBinaryMagic)
+	for i, v := range versions {
+		content, codec := []byte(v), ""
+		if i > 0 {
+			content, codec = []byte(ComputeDelta(versions[i-1], v)), CodecText
+		}
+		iv, ct, err := suite.Encrypt(crypto.DeriveFileKey(secret, "test.txt", 0), content, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prevHash []byte
+		if i > 0 {
+			h := sha256.Sum256(container)
+			prevHash = h[:]
+		}
+		var b []byte
+		for _, n := range []int{0, i, crypto.SuiteAES256GCM} {
+			b = binary.AppendUvarint(b, uint64(n))
+		}
+		for _, field := range [][]byte{
+			[]byte(codec), nil, iv, ct, crypto.Sign(priv, append(append([]byte{}, iv...), ct...)),
+			[]byte("alice"), prevHash, []byte("test.txt"),
+		} {
+			b = appendField(b, field)
+		}
+		container = appendField(container, b)
+	}
+	return container
+}
+
+func TestLegacyBinaryChain(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	versions := []string{"version 1", "version 2", "version 3"}
+	legacy := legacyTestChain(t, priv, versions)
+
+	for name, chain := range map[string]string{"binary": string(legacy), "armored": armor(legacy)} {
+		if _, ok := DetectFormat(chain); !ok {
+			t.Fatalf("%s: legacy chain not detected", name)
+		}
+		if got, err := decryptTestChain(chain, pub); err != nil || string(got) != "version 3" {
+			t.Fatalf("%s: DecryptChain = %q, %v", name, got, err)
+		}
+
+		// Appending a record moves the chain to the current layout
+		secret := bytes.Repeat([]byte{0x42}, 32)
+		d := ComputeDelta("version 3", "version 4")
+		appended, err := EncryptDelta([]byte(d), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 3, "alice", priv, chain)
+		if err != nil {
+			t.Fatalf("%s: EncryptDelta: %v", name, err)
+		}
+		container, err := v2Container(appended, map[string]Format{"binary": FormatBinary, "armored": FormatArmored}[name])
+		if err != nil || !bytes.HasPrefix(container, []byte(binaryMagic)) {
+			t.Errorf("%s: appended chain does not start with the current magic: %v", name, err)
+		}
+		if got, err := decryptTestChain(appended, pub); err != nil || string(got) != "version 4" {
+			t.Errorf("%s: DecryptChain after append = %q, %v", name, got, err)
+		}
+	}
+}
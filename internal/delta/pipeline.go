@@ -3,6 +3,8 @@ package delta
 import (
 	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -12,21 +14,35 @@ import (
 )
 
 // DeltaRecord is one encrypted delta (or base) block in the ciphertext chain.
+// Suite is the crypto.Suite ID the block was encrypted under; records
+// written before suites were recorded have none and use AES-256-GCM.
+// Codec is the delta format of a delta block; base blocks have none.
+// Compression is set on v2 records whose content was compressed before
+// encryption; text records are never compressed. Version is recordVersion
+// on records whose header is bound into their signature and AEAD, and 0 on
+// records written before that.
 type DeltaRecord struct {
-	Epoch    int    `json:"epoch"`
-	Seq      int    `json:"seq"`
-	IV       []byte `json:"-"`
-	CT       []byte `json:"-"`
-	Sig      []byte `json:"-"`
-	Author   string `json:"author"`
-	PrevHash string `json:"prev_hash"`
-	FilePath string `json:"file_path"`
+	Version     int    `json:"version,omitempty"`
+	Epoch       int    `json:"epoch"`
+	Seq         int    `json:"seq"`
+	Suite       int    `json:"suite,omitempty"`
+	Codec       string `json:"codec,omitempty"`
+	Compression string `json:"-"`
+	IV          []byte `json:"-"`
+	CT          []byte `json:"-"`
+	Sig         []byte `json:"-"`
+	Author      string `json:"author"`
+	PrevHash    string `json:"prev_hash"`
+	FilePath    string `json:"file_path"`
 }
 
 // deltaRecordJSON is the JSON wire format with base64 encoded byte fields.
 type deltaRecordJSON struct {
+	Version  int    `json:"version,omitempty"`
 	Epoch    int    `json:"epoch"`
 	Seq      int    `json:"seq"`
+	Suite    int    `json:"suite,omitempty"`
+	Codec    string `json:"codec,omitempty"`
 	IV       string `json:"iv"`
 	CT       string `json:"ct"`
 	Sig      string `json:"sig"`
@@ -38,8 +54,11 @@ type deltaRecordJSON struct {
 // ToB64 serializes to a base64-encoded JSON string (url-safe b64 of JSON, matching Python).
 func (r DeltaRecord) ToB64() string {
 	obj := deltaRecordJSON{
+		Version:  r.Version,
 		Epoch:    r.Epoch,
 		Seq:      r.Seq,
+		Suite:    r.Suite,
+		Codec:    r.Codec,
 		IV:       crypto.B64Encode(r.IV, true),
 		CT:       crypto.B64Encode(r.CT, true),
 		Sig:      crypto.B64Encode(r.Sig, true),
@@ -74,8 +93,11 @@ func DeltaRecordFromB64(b64Str string) (DeltaRecord, error) {
 		return DeltaRecord{}, fmt.Errorf("decode sig: %w", err)
 	}
 	return DeltaRecord{
+		Version:  obj.Version,
 		Epoch:    obj.Epoch,
 		Seq:      obj.Seq,
+		Suite:    obj.Suite,
+		Codec:    obj.Codec,
 		IV:       iv,
 		CT:       ct,
 		Sig:      sig,
@@ -85,175 +107,354 @@ func DeltaRecordFromB64(b64Str string) (DeltaRecord, error) {
 	}, nil
 }
 
+// suite returns the ciphersuite the record was encrypted under.
+func (r DeltaRecord) suite() (crypto.Suite, error) {
+	if r.Suite == 0 {
+		return crypto.LookupSuite(crypto.SuiteAES256GCM)
+	}
+	return crypto.LookupSuite(r.Suite)
+}
+
+// recordVersion is the version of records written now. Their header (see
+// DeltaRecord.header) is signed with iv || ct and passed to the AEAD as
+// additional data, and their PrevHash is the link of the record before
+// them. Version 0 records sign only iv || ct, have no additional data, and
+// chain to the hash of the ciphertext before them.
+const recordVersion = 1
+
+// header returns the canonical encoding of a record's metadata: every
+// field except the IV, ciphertext and signature, with the integers as
+// uvarints and the strings length-prefixed.
+func (r DeltaRecord) header() []byte {
+	b := []byte("mlsgit-record\n")
+	for _, n := range []int{r.Version, r.Epoch, r.Seq, r.Suite} {
+		b = binary.AppendUvarint(b, uint64(n))
+	}
+	for _, field := range []string{r.Codec, r.Compression, r.Author, r.PrevHash, r.FilePath} {
+		b = appendField(b, []byte(field))
+	}
+	return b
+}
+
+// signedData returns the bytes a record's signature covers.
+func (r DeltaRecord) signedData() []byte {
+	var b []byte
+	if r.Version != 0 {
+		b = r.header()
+	}
+	b = append(b, r.IV...)
+	return append(b, r.CT...)
+}
+
+// additionalData returns the AEAD additional data of a record.
+func (r DeltaRecord) additionalData() []byte {
+	if r.Version == 0 {
+		return nil
+	}
+	return r.header()
+}
+
+// link returns the PrevHash of a record written after r: SHA-256 over r's
+// header, IV, ciphertext and signature. r's header holds its own PrevHash,
+// so the link commits to the whole chain up to r, whatever its format.
+func (r DeltaRecord) link() string {
+	b := r.header()
+	for _, field := range [][]byte{r.IV, r.CT, r.Sig} {
+		b = appendField(b, field)
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// appendField appends a uvarint length and then data to b.
+func appendField(b, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
 func hashPrefix(data string) string {
 	h := sha256.Sum256([]byte(data))
 	return fmt.Sprintf("%x", h)
 }
 
-// EncryptBaseBlock encrypts a full plaintext as the initial base block.
-// Returns the full ciphertext string (a single base64-encoded DeltaRecord).
+// EncryptBaseBlock encrypts a full plaintext as the initial base block
+// under the given crypto.Suite ID, as a new chain in enc's format.
+// Returns the full ciphertext string (a single DeltaRecord).
 func EncryptBaseBlock(
 	plaintext []byte,
 	epochSecret []byte,
 	filePath string,
 	epoch int,
+	suiteID int,
+	enc Encoding,
 	author string,
 	privateKey ed25519.PrivateKey,
 ) (string, error) {
-	key := crypto.DeriveFileKey(epochSecret, filePath, epoch)
-	iv, ct, err := crypto.AESGCMEncrypt(key, plaintext)
+	record := DeltaRecord{Epoch: epoch, Suite: suiteID, Author: author, FilePath: filePath}
+	record, err := encryptRecord(record, plaintext, enc.Format, enc.Compression, epochSecret, privateKey)
 	if err != nil {
 		return "", fmt.Errorf("encrypt base block: %w", err)
 	}
-	sigData := append(iv, ct...)
-	sig := crypto.Sign(privateKey, sigData)
-	record := DeltaRecord{
-		Epoch:    epoch,
-		Seq:      0,
-		IV:       iv,
-		CT:       ct,
-		Sig:      sig,
-		Author:   author,
-		PrevHash: "",
-		FilePath: filePath,
-	}
-	return record.ToB64(), nil
+	return encodeBase(record, enc.Format), nil
 }
 
-// EncryptDelta encrypts a delta and appends it to the existing ciphertext chain.
-// Returns the full ciphertext string (old ciphertext + separator + new record).
+// EncryptDelta encrypts a delta computed with codec under the given
+// crypto.Suite ID and appends it to the existing ciphertext chain, which may
+// use other suites and codecs. The delta is written in the chain's format,
+// compressed as enc asks if that format allows it. seq must be above the
+// last record's.
+// Returns the full ciphertext string (old ciphertext + new record).
 func EncryptDelta(
-	deltaText string,
+	deltaData []byte,
+	codec string,
 	epochSecret []byte,
 	filePath string,
 	epoch int,
+	suiteID int,
+	enc Encoding,
 	seq int,
 	author string,
 	privateKey ed25519.PrivateKey,
 	prevCiphertext string,
 ) (string, error) {
-	key := crypto.DeriveFileKey(epochSecret, filePath, epoch)
-	iv, ct, err := crypto.AESGCMEncrypt(key, []byte(deltaText))
+	prevCiphertext, err := upgradeChain(prevCiphertext)
 	if err != nil {
-		return "", fmt.Errorf("encrypt delta: %w", err)
+		return "", fmt.Errorf("encrypt delta: previous ciphertext: %w", err)
+	}
+	blocks, format, err := parseChain(prevCiphertext)
+	if err != nil {
+		return "", fmt.Errorf("encrypt delta: previous ciphertext: %w", err)
+	}
+	last := blocks[len(blocks)-1].record
+	if seq <= last.Seq {
+		return "", fmt.Errorf("encrypt delta: seq %d does not follow %d", seq, last.Seq)
 	}
-	sigData := append(iv, ct...)
-	sig := crypto.Sign(privateKey, sigData)
-	prevHash := hashPrefix(prevCiphertext)
 	record := DeltaRecord{
 		Epoch:    epoch,
 		Seq:      seq,
-		IV:       iv,
-		CT:       ct,
-		Sig:      sig,
+		Suite:    suiteID,
+		Codec:    codec,
 		Author:   author,
-		PrevHash: prevHash,
+		PrevHash: last.link(),
 		FilePath: filePath,
 	}
-	return prevCiphertext + config.DeltaSeparator + record.ToB64(), nil
+	if record, err = encryptRecord(record, deltaData, format, enc.Compression, epochSecret, privateKey); err != nil {
+		return "", fmt.Errorf("encrypt delta: %w", err)
+	}
+	return appendRecord(prevCiphertext, format, record)
+}
+
+// encryptRecord compresses (v2 formats only), encrypts and signs content
+// as the record r, whose metadata is already filled in.
+func encryptRecord(
+	r DeltaRecord,
+	content []byte,
+	format Format,
+	compression string,
+	epochSecret []byte,
+	privateKey ed25519.PrivateKey,
+) (DeltaRecord, error) {
+	suite, err := crypto.LookupSuite(r.Suite)
+	if err != nil {
+		return DeltaRecord{}, err
+	}
+	if format.isV2() {
+		content, r.Compression = compress(compression, content)
+	}
+	r.Version = recordVersion
+	key := crypto.DeriveFileKey(epochSecret, r.FilePath, r.Epoch)
+	if r.IV, r.CT, err = suite.Encrypt(key, content, r.additionalData()); err != nil {
+		return DeltaRecord{}, err
+	}
+	r.Sig = crypto.Sign(privateKey, r.signedData())
+	return r, nil
 }
 
 // EpochSecretFunc retrieves the epoch secret for a given epoch.
 type EpochSecretFunc func(epoch int) ([]byte, error)
 
-// PublicKeyFunc retrieves the public signing key for a given author.
-type PublicKeyFunc func(author string) (ed25519.PublicKey, error)
+// PublicKeyFunc retrieves the public signing key an author held for a
+// record written at epoch.
+type PublicKeyFunc func(author string, epoch int) (ed25519.PublicKey, error)
 
-// DecryptChain decrypts a full ciphertext chain (base block + deltas).
-// Returns the final plaintext as bytes.
+// DecryptChain decrypts a full ciphertext chain (base block + deltas) in
+// any format. Returns the final plaintext as bytes. Streamed records are
+// refused; decrypt them with DecryptStream, which never holds the whole
+// file in memory.
 func DecryptChain(
 	ciphertext string,
 	getEpochSecret EpochSecretFunc,
 	filePath string,
 	getPublicKey PublicKeyFunc,
 ) ([]byte, error) {
-	blocks := strings.Split(ciphertext, config.DeltaSeparator)
-	if len(blocks) == 0 {
-		return nil, fmt.Errorf("empty ciphertext")
+	if IsStream([]byte(ciphertext)) {
+		return nil, fmt.Errorf("streamed record: decrypt it with DecryptStream")
 	}
 
-	// Decrypt base block
-	baseRecord, err := DeltaRecordFromB64(blocks[0])
+	blocks, _, err := parseChain(ciphertext)
 	if err != nil {
-		return nil, fmt.Errorf("parse base block: %w", err)
+		return nil, err
 	}
 
-	basePath := baseRecord.FilePath
-	if basePath == "" {
-		basePath = filePath
-	}
-
-	baseEpochSecret, err := getEpochSecret(baseRecord.Epoch)
-	if err != nil {
-		return nil, fmt.Errorf("get epoch secret for base: %w", err)
-	}
-	baseKey := crypto.DeriveFileKey(baseEpochSecret, basePath, baseRecord.Epoch)
-
-	pub, err := getPublicKey(baseRecord.Author)
-	if err != nil {
-		return nil, fmt.Errorf("get public key for base: %w", err)
-	}
-	sigData := append(baseRecord.IV, baseRecord.CT...)
-	if !crypto.Verify(pub, sigData, baseRecord.Sig) {
-		return nil, fmt.Errorf("signature verification failed on base block (author=%s)", baseRecord.Author)
-	}
-
-	plaintext, err := crypto.AESGCMDecrypt(baseKey, baseRecord.IV, baseRecord.CT)
-	if err != nil {
-		return nil, fmt.Errorf("decrypt base block: %w", err)
-	}
-	text := string(plaintext)
-
-	// Verify hash chain and apply deltas
-	prevContent := blocks[0]
-	for i := 1; i < len(blocks); i++ {
-		record, err := DeltaRecordFromB64(blocks[i])
+	// Decrypt the base block, then verify the hash chain and apply deltas
+	var plaintext []byte
+	for i, block := range blocks {
+		record := block.record
+		name, err := checkLink(blocks, i)
 		if err != nil {
-			return nil, fmt.Errorf("parse delta %d: %w", i, err)
+			return nil, err
 		}
 
-		expectedHash := hashPrefix(prevContent)
-		if record.PrevHash != expectedHash {
-			return nil, fmt.Errorf("hash chain broken at delta %d", i)
+		// Version 0 records may leave the path out; every later record
+		// signs the path it was written for, which must be this one
+		recordPath := record.FilePath
+		if recordPath == "" && record.Version == 0 {
+			recordPath = filePath
 		}
-
-		deltaPath := record.FilePath
-		if deltaPath == "" {
-			deltaPath = filePath
+		if recordPath != filePath {
+			return nil, fmt.Errorf("%s was written for %q, not %q", name, recordPath, filePath)
 		}
 
-		deltaEpochSecret, err := getEpochSecret(record.Epoch)
+		epochSecret, err := getEpochSecret(record.Epoch)
 		if err != nil {
-			return nil, fmt.Errorf("get epoch secret for delta %d: %w", i, err)
+			return nil, fmt.Errorf("get epoch secret for %s: %w", name, err)
 		}
-		deltaKey := crypto.DeriveFileKey(deltaEpochSecret, deltaPath, record.Epoch)
+		key := crypto.DeriveFileKey(epochSecret, recordPath, record.Epoch)
 
-		pub, err := getPublicKey(record.Author)
+		if err := verifyRecord(record, name, getPublicKey); err != nil {
+			return nil, err
+		}
+
+		suite, err := record.suite()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		content, err := suite.Decrypt(key, record.IV, record.CT, record.additionalData())
 		if err != nil {
-			return nil, fmt.Errorf("get public key for delta %d: %w", i, err)
+			return nil, fmt.Errorf("decrypt %s: %w", name, err)
 		}
-		sigData := append(record.IV, record.CT...)
-		if !crypto.Verify(pub, sigData, record.Sig) {
-			return nil, fmt.Errorf("signature verification failed on delta %d (author=%s)", i, record.Author)
+		if content, err = decompress(record.Compression, content); err != nil {
+			return nil, fmt.Errorf("decompress %s: %w", name, err)
 		}
 
-		deltaBytes, err := crypto.AESGCMDecrypt(deltaKey, record.IV, record.CT)
-		if err != nil {
-			return nil, fmt.Errorf("decrypt delta %d: %w", i, err)
+		if i == 0 {
+			plaintext = content
+		} else if plaintext, err = Apply(record.Codec, plaintext, content); err != nil {
+			return nil, fmt.Errorf("apply delta %d: %w", i, err)
 		}
+	}
 
-		text, err = ApplyDelta(text, string(deltaBytes))
+	return plaintext, nil
+}
+
+// VerifyChain checks that ciphertext is a whole ciphertext chain, in any
+// format, or streamed record, with an unbroken hash chain and a valid
+// signature on every record, without decrypting it.
+func VerifyChain(ciphertext string, getPublicKey PublicKeyFunc) error {
+	if IsStream([]byte(ciphertext)) {
+		return VerifyStream(strings.NewReader(ciphertext), getPublicKey)
+	}
+	blocks, _, err := parseChain(ciphertext)
+	if err != nil {
+		return err
+	}
+	for i, block := range blocks {
+		name, err := checkLink(blocks, i)
 		if err != nil {
-			return nil, fmt.Errorf("apply delta %d: %w", i, err)
+			return err
 		}
+		if err := verifyRecord(block.record, name, getPublicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		prevContent = prevContent + config.DeltaSeparator + blocks[i]
+// checkLink checks the version of block i of a chain and its link to the
+// block before, returning the block's name for errors.
+func checkLink(blocks []chainBlock, i int) (string, error) {
+	record := blocks[i].record
+	name := "base block"
+	if i > 0 {
+		name = fmt.Sprintf("delta %d", i)
 	}
+	if record.Version > recordVersion {
+		return name, fmt.Errorf("%s has unsupported record version %d", name, record.Version)
+	}
+	if i > 0 {
+		prev := blocks[i-1]
+		wantHash := prev.hash
+		if record.Version != 0 {
+			wantHash = prev.record.link()
+		}
+		if record.PrevHash != wantHash {
+			return name, fmt.Errorf("hash chain broken at delta %d", i)
+		}
+		if record.Seq <= prev.record.Seq {
+			return name, fmt.Errorf("delta %d out of sequence (seq %d after %d)", i, record.Seq, prev.record.Seq)
+		}
+	} else if record.Version != 0 && record.PrevHash != "" {
+		return name, fmt.Errorf("hash chain broken at base block")
+	}
+	return name, nil
+}
 
-	return []byte(text), nil
+// verifyRecord checks a record's signature against its author's key.
+func verifyRecord(record DeltaRecord, name string, getPublicKey PublicKeyFunc) error {
+	pub, err := getPublicKey(record.Author, record.Epoch)
+	if err != nil {
+		return fmt.Errorf("get public key for %s: %w", name, err)
+	}
+	if !crypto.Verify(pub, record.signedData(), record.Sig) {
+		return fmt.Errorf("signature verification failed on %s (author=%s)", name, record.Author)
+	}
+	return nil
 }
 
 // CountDeltas returns the number of delta blocks (excluding the base block).
 func CountDeltas(ciphertext string) int {
-	return strings.Count(ciphertext, config.DeltaSeparator)
+	if format, ok := DetectFormat(ciphertext); !ok || format == FormatText {
+		return strings.Count(ciphertext, config.DeltaSeparator)
+	}
+	blocks, _, err := parseChain(ciphertext)
+	if err != nil {
+		return 0
+	}
+	return len(blocks) - 1
+}
+
+// ChainEpochs returns the epoch of each block in a ciphertext chain, base
+// block first. A streamed record is a single block.
+func ChainEpochs(ciphertext string) ([]int, error) {
+	if IsStream([]byte(ciphertext)) {
+		hdr, err := streamHeaderOf(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return []int{hdr.Epoch}, nil
+	}
+	blocks, _, err := parseChain(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	epochs := make([]int, 0, len(blocks))
+	for _, b := range blocks {
+		epochs = append(epochs, b.record.Epoch)
+	}
+	return epochs, nil
+}
+
+// ChainPath returns the path the newest record of a ciphertext chain, or a
+// streamed record, was written for. It is empty for a version 0 record
+// that left its path out.
+func ChainPath(ciphertext string) (string, error) {
+	if IsStream([]byte(ciphertext)) {
+		hdr, err := streamHeaderOf(ciphertext)
+		return hdr.FilePath, err
+	}
+	blocks, _, err := parseChain(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return blocks[len(blocks)-1].record.FilePath, nil
 }
@@ -3,7 +3,11 @@ package delta
 import (
 	"bytes"
 	"crypto/ed25519"
+	"strings"
 	"testing"
+
+	"github.com/germtb/mlsgit/internal/config"
+	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 func makeTestKeys(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
@@ -19,7 +23,7 @@ func TestDeltaRecordRoundtrip(t *testing.T) {
 	priv, _ := makeTestKeys(t)
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
-	ct, err := EncryptBaseBlock([]byte("hello"), secret, "test.txt", 0, "alice", priv)
+	ct, err := EncryptBaseBlock([]byte("hello"), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -61,13 +65,13 @@ func TestEncryptDecryptBaseBlock(t *testing.T) {
 	secret := bytes.Repeat([]byte{0x42}, 32)
 	plaintext := []byte("hello, encrypted world!")
 
-	ct, err := EncryptBaseBlock(plaintext, secret, "test.txt", 0, "alice", priv)
+	ct, err := EncryptBaseBlock(plaintext, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
-	getKey := func(author string) (ed25519.PublicKey, error) { return pub, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
 
 	decrypted, err := DecryptChain(ct, getSecret, "test.txt", getKey)
 	if err != nil {
@@ -83,27 +87,27 @@ func TestEncryptDecryptDeltaChain(t *testing.T) {
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
 	// Base block
-	ct, err := EncryptBaseBlock([]byte("version 1"), secret, "test.txt", 0, "alice", priv)
+	ct, err := EncryptBaseBlock([]byte("version 1"), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Delta 1
 	delta1 := ComputeDelta("version 1", "version 2")
-	ct, err = EncryptDelta(delta1, secret, "test.txt", 0, 1, "alice", priv, ct)
+	ct, err = EncryptDelta([]byte(delta1), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 1, "alice", priv, ct)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Delta 2
 	delta2 := ComputeDelta("version 2", "version 3")
-	ct, err = EncryptDelta(delta2, secret, "test.txt", 0, 2, "alice", priv, ct)
+	ct, err = EncryptDelta([]byte(delta2), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 2, "alice", priv, ct)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
-	getKey := func(author string) (ed25519.PublicKey, error) { return pub, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
 
 	decrypted, err := DecryptChain(ct, getSecret, "test.txt", getKey)
 	if err != nil {
@@ -118,38 +122,58 @@ func TestCountDeltas(t *testing.T) {
 	priv, _ := makeTestKeys(t)
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
-	ct, _ := EncryptBaseBlock([]byte("v1"), secret, "test.txt", 0, "alice", priv)
+	ct, _ := EncryptBaseBlock([]byte("v1"), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	if CountDeltas(ct) != 0 {
 		t.Errorf("base block count = %d, want 0", CountDeltas(ct))
 	}
 
 	delta := ComputeDelta("v1", "v2")
-	ct, _ = EncryptDelta(delta, secret, "test.txt", 0, 1, "alice", priv, ct)
+	ct, _ = EncryptDelta([]byte(delta), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 1, "alice", priv, ct)
 	if CountDeltas(ct) != 1 {
 		t.Errorf("one delta count = %d, want 1", CountDeltas(ct))
 	}
 
 	delta2 := ComputeDelta("v2", "v3")
-	ct, _ = EncryptDelta(delta2, secret, "test.txt", 0, 2, "alice", priv, ct)
+	ct, _ = EncryptDelta([]byte(delta2), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 2, "alice", priv, ct)
 	if CountDeltas(ct) != 2 {
 		t.Errorf("two delta count = %d, want 2", CountDeltas(ct))
 	}
 }
 
+func TestChainEpochs(t *testing.T) {
+	priv, _ := makeTestKeys(t)
+	secret := bytes.Repeat([]byte{0x42}, 32)
+
+	ct, _ := EncryptBaseBlock([]byte("v1"), secret, "test.txt", 2, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
+	ct, _ = EncryptDelta([]byte(ComputeDelta("v1", "v2")), CodecText, secret, "test.txt", 3, crypto.SuiteAES256GCM, Encoding{}, 1, "alice", priv, ct)
+	ct, _ = EncryptDelta([]byte(ComputeDelta("v2", "v3")), CodecText, secret, "test.txt", 5, crypto.SuiteAES256GCM, Encoding{}, 2, "alice", priv, ct)
+
+	epochs, err := ChainEpochs(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(epochs) != 3 || epochs[0] != 2 || epochs[1] != 3 || epochs[2] != 5 {
+		t.Errorf("ChainEpochs = %v, want [2 3 5]", epochs)
+	}
+	if _, err := ChainEpochs("not a chain"); err == nil {
+		t.Error("expected an error for a non-ciphertext")
+	}
+}
+
 func TestDecryptChainBrokenHash(t *testing.T) {
 	priv, pub := makeTestKeys(t)
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
-	ct, _ := EncryptBaseBlock([]byte("v1"), secret, "test.txt", 0, "alice", priv)
+	ct, _ := EncryptBaseBlock([]byte("v1"), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	delta := ComputeDelta("v1", "v2")
-	ct, _ = EncryptDelta(delta, secret, "test.txt", 0, 1, "alice", priv, ct)
+	ct, _ = EncryptDelta([]byte(delta), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 1, "alice", priv, ct)
 
 	// Tamper with the base block portion to break hash chain
 	// Replace first char
 	tampered := "X" + ct[1:]
 
 	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
-	getKey := func(author string) (ed25519.PublicKey, error) { return pub, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
 
 	_, err := DecryptChain(tampered, getSecret, "test.txt", getKey)
 	if err == nil {
@@ -157,17 +181,44 @@ func TestDecryptChainBrokenHash(t *testing.T) {
 	}
 }
 
+func TestVerifyChain(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	_, otherPub := makeTestKeys(t)
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	getOther := func(author string, epoch int) (ed25519.PublicKey, error) { return otherPub, nil }
+
+	ct, _ := EncryptBaseBlock([]byte("v1"), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{Format: FormatBinary}, "alice", priv)
+	ct, _ = EncryptDelta([]byte(ComputeDelta("v1", "v2")), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{Format: FormatBinary}, 1, "alice", priv, ct)
+	stream := encryptTestStream(t, []byte("streamed"), priv, crypto.SuiteAES256GCM)
+
+	for _, c := range []string{ct, stream} {
+		if err := VerifyChain(c, getKey); err != nil {
+			t.Errorf("valid chain: %v", err)
+		}
+		if err := VerifyChain(c, getOther); err == nil {
+			t.Error("chain signed by another key should not verify")
+		}
+		if err := VerifyChain(c[:len(c)-3], getKey); err == nil {
+			t.Error("truncated chain should not verify")
+		}
+	}
+	if err := VerifyChain(binaryMagic+"plaintext", getKey); err == nil {
+		t.Error("plaintext after the binary magic should not verify")
+	}
+}
+
 func TestEncryptDecryptEmpty(t *testing.T) {
 	priv, pub := makeTestKeys(t)
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
-	ct, err := EncryptBaseBlock([]byte(""), secret, "test.txt", 0, "alice", priv)
+	ct, err := EncryptBaseBlock([]byte(""), secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
-	getKey := func(author string) (ed25519.PublicKey, error) { return pub, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
 
 	decrypted, err := DecryptChain(ct, getSecret, "test.txt", getKey)
 	if err != nil {
@@ -177,3 +228,174 @@ func TestEncryptDecryptEmpty(t *testing.T) {
 		t.Errorf("expected empty, got %d bytes", len(decrypted))
 	}
 }
+
+func TestDecryptChainMixedSuites(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	secret := bytes.Repeat([]byte{0x42}, 32)
+
+	// A legacy base block without a recorded suite, then deltas written
+	// after switching suites.
+	key := crypto.DeriveFileKey(secret, "test.txt", 0)
+	iv, ct, err := crypto.AESGCMEncrypt(key, []byte("version 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := DeltaRecord{IV: iv, CT: ct, Sig: crypto.Sign(priv, append(iv, ct...)), Author: "alice", FilePath: "test.txt"}
+	chain := legacy.ToB64()
+
+	versions := []string{"version 1", "version 2", "version 3"}
+	for i, suiteID := range []int{crypto.SuiteChaCha20Poly1305, crypto.SuiteXChaCha20Poly1305} {
+		chain, err = EncryptDelta([]byte(ComputeDelta(versions[i], versions[i+1])), CodecText, secret, "test.txt", 0, suiteID, Encoding{}, i+1, "alice", priv, chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks := strings.Split(chain, config.DeltaSeparator)
+	if r, _ := DeltaRecordFromB64(blocks[2]); r.Suite != crypto.SuiteXChaCha20Poly1305 {
+		t.Errorf("Suite = %#x, want %#x", r.Suite, crypto.SuiteXChaCha20Poly1305)
+	}
+
+	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	decrypted, err := DecryptChain(chain, getSecret, "test.txt", getKey)
+	if err != nil {
+		t.Fatalf("DecryptChain error: %v", err)
+	}
+	if string(decrypted) != "version 3" {
+		t.Errorf("decrypted = %q, want %q", decrypted, "version 3")
+	}
+
+	if _, err := EncryptBaseBlock([]byte("x"), secret, "test.txt", 0, 0x7777, Encoding{}, "alice", priv); err == nil {
+		t.Error("unknown suite should be rejected")
+	}
+}
+
+func TestDecryptChainBinaryDelta(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	secret := bytes.Repeat([]byte{0x42}, 32)
+
+	v1 := append([]byte("\x00\x01\x02header"), bytes.Repeat([]byte{0xfe, 0xed}, 200)...)
+	v2 := append(append([]byte{}, v1[:50]...), append([]byte{0xff, 0x00}, v1[50:]...)...)
+	chain, err := EncryptBaseBlock(v1, secret, "image.bin", 0, crypto.SuiteAES256GCM, Encoding{}, "alice", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err = EncryptDelta(ComputeBinaryDelta(v1, v2), CodecBinary, secret, "image.bin", 0, crypto.SuiteAES256GCM, Encoding{}, 1, "alice", priv, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := strings.Split(chain, config.DeltaSeparator)
+	if r, _ := DeltaRecordFromB64(blocks[1]); r.Codec != CodecBinary {
+		t.Errorf("Codec = %q, want %q", r.Codec, CodecBinary)
+	}
+
+	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	decrypted, err := DecryptChain(chain, getSecret, "image.bin", getKey)
+	if err != nil {
+		t.Fatalf("DecryptChain error: %v", err)
+	}
+	if !bytes.Equal(decrypted, v2) {
+		t.Error("binary chain did not round-trip")
+	}
+}
+
+func TestDecryptChainBoundMetadata(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	chain := buildTestChain(t, priv, Encoding{}, []string{"version 1", "version 2", "version 3"})
+	records, _, err := ParseChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[2].PrevHash != records[1].link() {
+		t.Error("delta should link to the record before it")
+	}
+
+	// Rebuild the text chain with the last record changed; the key lookup
+	// accepts any author, so only the binding can catch a relabel
+	withLast := func(change func(r *DeltaRecord)) string {
+		blocks := make([]string, len(records))
+		for i, r := range records {
+			if i == len(records)-1 {
+				change(&r)
+			}
+			blocks[i] = r.ToB64()
+		}
+		return strings.Join(blocks, config.DeltaSeparator)
+	}
+	if got, err := decryptTestChain(withLast(func(r *DeltaRecord) {}), pub); err != nil || string(got) != "version 3" {
+		t.Fatalf("untouched chain: %q, %v", got, err)
+	}
+	// The PrevHash a version 0 record would carry in this position
+	textPrev := hashPrefix(strings.Join(strings.Split(chain, config.DeltaSeparator)[:2], config.DeltaSeparator))
+	cases := map[string]struct {
+		change func(r *DeltaRecord)
+		want   string
+	}{
+		"relabeled author":  {func(r *DeltaRecord) { r.Author = "bob" }, "signature verification failed"},
+		"rewritten path":    {func(r *DeltaRecord) { r.FilePath = "other.txt" }, `written for "other.txt"`},
+		"renumbered seq":    {func(r *DeltaRecord) { r.Seq = 7 }, "signature verification failed"},
+		"changed codec":     {func(r *DeltaRecord) { r.Codec = CodecBinary }, "signature verification failed"},
+		"repeated seq":      {func(r *DeltaRecord) { r.Seq = 1 }, "out of sequence"},
+		"stripped version":  {func(r *DeltaRecord) { r.Version = 0 }, "hash chain broken"},
+		"unknown version":   {func(r *DeltaRecord) { r.Version = 9 }, "unsupported record version"},
+		"relinked prev":     {func(r *DeltaRecord) { r.PrevHash = records[0].link() }, "hash chain broken"},
+		"downgraded record": {func(r *DeltaRecord) { r.Version, r.PrevHash = 0, textPrev }, "signature verification failed"},
+	}
+	for name, c := range cases {
+		if _, err := decryptTestChain(withLast(c.change), pub); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", name, err, c.want)
+		}
+	}
+
+	// Swapping deltas breaks the links
+	swapped := strings.Join([]string{records[0].ToB64(), records[2].ToB64(), records[1].ToB64()}, config.DeltaSeparator)
+	if _, err := decryptTestChain(swapped, pub); err == nil {
+		t.Error("reordered deltas should be rejected")
+	}
+
+	// Deltas must be numbered after the chain they extend
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	if _, err := EncryptDelta([]byte(ComputeDelta("version 3", "version 4")), CodecText, secret, "test.txt", 0, crypto.SuiteAES256GCM, Encoding{}, 2, "alice", priv, chain); err == nil {
+		t.Error("EncryptDelta should reject a seq that does not follow the chain")
+	}
+}
+
+func TestDecryptChainMovedPath(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	chain := buildTestChain(t, priv, Encoding{Format: FormatBinary}, []string{"version 1", "version 2"})
+
+	// A chain copied to another path is refused there
+	if _, err := DecryptChain(chain, getSecret, "other.txt", getKey); err == nil || !strings.Contains(err.Error(), `written for "test.txt"`) {
+		t.Errorf("moved chain: got %v, want a path mismatch", err)
+	}
+	if from, err := ChainPath(chain); err != nil || from != "test.txt" {
+		t.Errorf("ChainPath = %q, %v; want test.txt", from, err)
+	}
+
+	// Moving it re-encrypts it for the new path only
+	moved, err := Move(chain, getSecret, secret, "test.txt", "other.txt", 1, crypto.SuiteAES256GCM, Encoding{}, "alice", priv, getKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecryptChain(moved, getSecret, "other.txt", getKey); err != nil || string(got) != "version 2" {
+		t.Errorf("moved chain at new path: %q, %v", got, err)
+	}
+	if _, err := DecryptChain(moved, getSecret, "test.txt", getKey); err == nil {
+		t.Error("moved chain should not decrypt at its old path")
+	}
+
+	// Only version 0 records may leave their path out
+	records, _, err := ParseChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records[0].FilePath = ""
+	records[0].Sig = crypto.Sign(priv, records[0].signedData())
+	if _, err := decryptTestChain(records[0].ToB64(), pub); err == nil {
+		t.Error("a version 1 record without a path should be refused")
+	}
+}
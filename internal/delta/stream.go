@@ -0,0 +1,381 @@
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Streamed records ---
+//
+// Files too large to hold in memory are written as a single streamed record
+// instead of a base block and deltas, one line at a time:
+//
+//	MLSGIT-STREAM 1
+//	<b64url JSON header: epoch, suite, author, file_path, salt, segment_size>
+//	<b64url segment 0>
+//	...
+//	sig:<b64url Ed25519ph signature>
+//
+// Segments are sealed with the STREAM construction: the nonce is zeros, a
+// 4-byte segment counter and a last-segment flag byte, under a key derived
+// from the file key and the header's random salt, with the header as
+// additional data. Reordered, dropped or truncated segments fail to
+// authenticate. The signature covers the header and every segment and is
+// checked before the last segment is written out; see DecryptStream.
+
+const (
+	// StreamMagic is the first line of a streamed record.
+	StreamMagic = "MLSGIT-STREAM 1"
+
+	// DefaultSegmentSize is the plaintext length of each streamed segment.
+	DefaultSegmentSize = 64 << 10
+
+	// maxSegmentSize bounds the segment size a reader accepts, and so the
+	// memory it needs.
+	maxSegmentSize = 1 << 20
+
+	streamSigPrefix = "sig:"
+	streamSaltSize  = 32
+)
+
+// maxStreamLine is the longest line of a streamed record: a full segment
+// of the largest size, its tag, and the newline.
+var maxStreamLine = base64.RawURLEncoding.EncodedLen(maxSegmentSize+64) + 1
+
+// streamHeader is the JSON header of a streamed record.
+type streamHeader struct {
+	Epoch       int    `json:"epoch"`
+	Suite       int    `json:"suite"`
+	Author      string `json:"author"`
+	FilePath    string `json:"file_path"`
+	Salt        string `json:"salt"`
+	SegmentSize int    `json:"segment_size"`
+}
+
+// IsStream reports whether data starts with a streamed record.
+func IsStream(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(StreamMagic+"\n"))
+}
+
+// EncryptStream encrypts everything read from r as a streamed record under
+// the given crypto.Suite ID and writes it to w, holding at most two
+// segments in memory.
+func EncryptStream(
+	w io.Writer,
+	r io.Reader,
+	epochSecret []byte,
+	filePath string,
+	epoch int,
+	suiteID int,
+	author string,
+	privateKey ed25519.PrivateKey,
+) error {
+	suite, err := crypto.LookupSuite(suiteID)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, streamSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("random salt: %w", err)
+	}
+	header, _ := json.Marshal(streamHeader{
+		Epoch:       epoch,
+		Suite:       suiteID,
+		Author:      author,
+		FilePath:    filePath,
+		Salt:        crypto.B64Encode(salt, true),
+		SegmentSize: DefaultSegmentSize,
+	})
+	aead, err := suite.NewAEAD(crypto.DeriveStreamKey(crypto.DeriveFileKey(epochSecret, filePath, epoch), salt))
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	digest := sha512.New()
+	writeHashed(digest, header)
+	fmt.Fprintf(bw, "%s\n%s\n", StreamMagic, crypto.B64Encode(header, true))
+
+	// Read one segment ahead so the last one can be flagged
+	cur, next := make([]byte, DefaultSegmentSize), make([]byte, DefaultSegmentSize)
+	n, eof, err := readSegment(r, cur)
+	if err != nil {
+		return err
+	}
+	for counter := uint32(0); ; counter++ {
+		last := eof
+		nextN, nextEOF := 0, false
+		if !last {
+			if nextN, nextEOF, err = readSegment(r, next); err != nil {
+				return err
+			}
+			last = nextN == 0
+		}
+		ct := aead.Seal(nil, segmentNonce(aead, counter, last), cur[:n], header)
+		writeHashed(digest, ct)
+		fmt.Fprintf(bw, "%s\n", crypto.B64Encode(ct, true))
+		if last {
+			break
+		}
+		if counter == math.MaxUint32 {
+			return fmt.Errorf("file too large to stream")
+		}
+		cur, next = next, cur
+		n, eof = nextN, nextEOF
+	}
+
+	sig, err := crypto.SignPrehashed(privateKey, digest.Sum(nil))
+	if err != nil {
+		return fmt.Errorf("sign stream: %w", err)
+	}
+	fmt.Fprintf(bw, "%s%s\n", streamSigPrefix, crypto.B64Encode(sig, true))
+	return bw.Flush()
+}
+
+// DecryptStream decrypts a streamed record read from r and writes the
+// plaintext to w one segment at a time. The epoch secret and the author's
+// key are looked up before anything is written, and the last segment is
+// only written once the signature over the whole stream has verified.
+// Every segment before it has been authenticated by the AEAD, but not yet
+// by the signature: if DecryptStream returns an error, the caller must
+// discard everything written to w, so w should be a temporary file rather
+// than the final destination.
+func DecryptStream(
+	w io.Writer,
+	r io.Reader,
+	getEpochSecret EpochSecretFunc,
+	filePath string,
+	getPublicKey PublicKeyFunc,
+) error {
+	br := bufio.NewReaderSize(r, maxStreamLine)
+	magic, err := readStreamLine(br)
+	if err != nil || string(magic) != StreamMagic {
+		return fmt.Errorf("not a streamed record")
+	}
+	headerLine, err := readStreamLine(br)
+	if err != nil {
+		return fmt.Errorf("read stream header: %w", err)
+	}
+	header, hdr, err := parseStreamHeader(string(headerLine))
+	if err != nil {
+		return err
+	}
+	if hdr.FilePath != filePath {
+		return fmt.Errorf("streamed record was written for %q, not %q", hdr.FilePath, filePath)
+	}
+
+	suite, err := crypto.LookupSuite(hdr.Suite)
+	if err != nil {
+		return fmt.Errorf("stream: %w", err)
+	}
+	salt, err := crypto.B64Decode(hdr.Salt, true)
+	if err != nil {
+		return fmt.Errorf("decode stream salt: %w", err)
+	}
+	epochSecret, err := getEpochSecret(hdr.Epoch)
+	if err != nil {
+		return fmt.Errorf("get epoch secret for stream: %w", err)
+	}
+	pub, err := getPublicKey(hdr.Author, hdr.Epoch)
+	if err != nil {
+		return fmt.Errorf("get public key for stream: %w", err)
+	}
+	aead, err := suite.NewAEAD(crypto.DeriveStreamKey(crypto.DeriveFileKey(epochSecret, filePath, hdr.Epoch), salt))
+	if err != nil {
+		return err
+	}
+
+	digest := sha512.New()
+	writeHashed(digest, header)
+	line, err := readStreamLine(br)
+	if err != nil || bytes.HasPrefix(line, []byte(streamSigPrefix)) {
+		return fmt.Errorf("streamed record has no segments")
+	}
+	var final []byte
+	for counter := uint32(0); ; counter++ {
+		next, err := readStreamLine(br)
+		if err != nil {
+			return fmt.Errorf("streamed record truncated after segment %d", counter)
+		}
+		last := bytes.HasPrefix(next, []byte(streamSigPrefix))
+
+		ct, err := crypto.B64Decode(string(line), true)
+		if err != nil {
+			return fmt.Errorf("decode segment %d: %w", counter, err)
+		}
+		pt, err := aead.Open(nil, segmentNonce(aead, counter, last), ct, header)
+		if err != nil {
+			return fmt.Errorf("decrypt segment %d: %w", counter, err)
+		}
+		if !last && len(pt) != hdr.SegmentSize {
+			return fmt.Errorf("segment %d is short", counter)
+		}
+		writeHashed(digest, ct)
+		if last {
+			final, line = pt, next
+			break
+		}
+		if _, err := w.Write(pt); err != nil {
+			return err
+		}
+		if counter == math.MaxUint32 {
+			return fmt.Errorf("streamed record has too many segments")
+		}
+		line = next
+	}
+
+	sig, err := crypto.B64Decode(strings.TrimPrefix(string(line), streamSigPrefix), true)
+	if err != nil {
+		return fmt.Errorf("decode stream signature: %w", err)
+	}
+	if !crypto.VerifyPrehashed(pub, digest.Sum(nil), sig) {
+		return fmt.Errorf("signature verification failed on stream (author=%s)", hdr.Author)
+	}
+	if _, err := readStreamLine(br); err != io.EOF {
+		return fmt.Errorf("trailing data after stream signature")
+	}
+	_, err = w.Write(final)
+	return err
+}
+
+// VerifyStream checks that r holds a whole streamed record signed by its
+// author, without decrypting it.
+func VerifyStream(r io.Reader, getPublicKey PublicKeyFunc) error {
+	br := bufio.NewReaderSize(r, maxStreamLine)
+	magic, err := readStreamLine(br)
+	if err != nil || string(magic) != StreamMagic {
+		return fmt.Errorf("not a streamed record")
+	}
+	headerLine, err := readStreamLine(br)
+	if err != nil {
+		return fmt.Errorf("read stream header: %w", err)
+	}
+	header, hdr, err := parseStreamHeader(string(headerLine))
+	if err != nil {
+		return err
+	}
+	pub, err := getPublicKey(hdr.Author, hdr.Epoch)
+	if err != nil {
+		return fmt.Errorf("get public key for stream: %w", err)
+	}
+
+	digest := sha512.New()
+	writeHashed(digest, header)
+	segments := 0
+	var line []byte
+	for {
+		if line, err = readStreamLine(br); err != nil {
+			return fmt.Errorf("streamed record truncated after segment %d", segments)
+		}
+		if bytes.HasPrefix(line, []byte(streamSigPrefix)) {
+			break
+		}
+		ct, err := crypto.B64Decode(string(line), true)
+		if err != nil {
+			return fmt.Errorf("decode segment %d: %w", segments, err)
+		}
+		writeHashed(digest, ct)
+		segments++
+	}
+	if segments == 0 {
+		return fmt.Errorf("streamed record has no segments")
+	}
+
+	sig, err := crypto.B64Decode(strings.TrimPrefix(string(line), streamSigPrefix), true)
+	if err != nil {
+		return fmt.Errorf("decode stream signature: %w", err)
+	}
+	if !crypto.VerifyPrehashed(pub, digest.Sum(nil), sig) {
+		return fmt.Errorf("signature verification failed on stream (author=%s)", hdr.Author)
+	}
+	if _, err := readStreamLine(br); err != io.EOF {
+		return fmt.Errorf("trailing data after stream signature")
+	}
+	return nil
+}
+
+// streamHeaderOf returns the header of a streamed record.
+func streamHeaderOf(ciphertext string) (streamHeader, error) {
+	lines := strings.SplitN(ciphertext, "\n", 3)
+	if len(lines) < 2 {
+		return streamHeader{}, fmt.Errorf("streamed record has no header")
+	}
+	_, hdr, err := parseStreamHeader(lines[1])
+	return hdr, err
+}
+
+// parseStreamHeader decodes a header line, returning its JSON (the
+// segments' additional data) and its fields.
+func parseStreamHeader(line string) ([]byte, streamHeader, error) {
+	header, err := crypto.B64Decode(strings.TrimSpace(line), true)
+	if err != nil {
+		return nil, streamHeader{}, fmt.Errorf("b64 decode stream header: %w", err)
+	}
+	var hdr streamHeader
+	if err := json.Unmarshal(header, &hdr); err != nil {
+		return nil, streamHeader{}, fmt.Errorf("json unmarshal stream header: %w", err)
+	}
+	if hdr.SegmentSize < 1 || hdr.SegmentSize > maxSegmentSize {
+		return nil, streamHeader{}, fmt.Errorf("stream segment size %d out of range", hdr.SegmentSize)
+	}
+	return header, hdr, nil
+}
+
+// segmentNonce returns the STREAM nonce for a segment: zeros, the
+// big-endian counter, then 1 for the last segment and 0 otherwise.
+func segmentNonce(aead cipher.AEAD, counter uint32, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// readSegment fills buf from r, reporting whether r ran out.
+func readSegment(r io.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, true, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("read plaintext: %w", err)
+	}
+	return n, false, nil
+}
+
+// readStreamLine returns the next line of a streamed record without its
+// newline, or io.EOF at the end.
+func readStreamLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("streamed record line too long")
+	}
+	if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+		return nil, err
+	}
+	return bytes.Clone(bytes.TrimSuffix(line, []byte("\n"))), nil
+}
+
+// writeHashed feeds a length-prefixed chunk to the signature digest.
+func writeHashed(h hash.Hash, data []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(data)))
+	h.Write(n[:])
+	h.Write(data)
+}
@@ -0,0 +1,150 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+func encryptTestStream(t *testing.T, plaintext []byte, priv ed25519.PrivateKey, suiteID int) string {
+	t.Helper()
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	var ct strings.Builder
+	if err := EncryptStream(&ct, bytes.NewReader(plaintext), secret, "big.bin", 3, suiteID, "alice", priv); err != nil {
+		t.Fatal(err)
+	}
+	return ct.String()
+}
+
+func decryptTestStream(ct string, pub ed25519.PublicKey) ([]byte, error) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	var out bytes.Buffer
+	err := DecryptStream(&out, strings.NewReader(ct), getSecret, "big.bin", getKey)
+	return out.Bytes(), err
+}
+
+func TestEncryptDecryptStream(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	for _, size := range []int{0, 1, DefaultSegmentSize, 3*DefaultSegmentSize + 17} {
+		for _, suiteID := range []int{crypto.SuiteAES256GCM, crypto.SuiteXChaCha20Poly1305} {
+			plaintext := bytes.Repeat([]byte{0x00, 0x01, 0xfe}, size/3+1)[:size]
+			ct := encryptTestStream(t, plaintext, priv, suiteID)
+			if !IsStream([]byte(ct)) {
+				t.Fatalf("size %d: not a streamed record", size)
+			}
+			got, err := decryptTestStream(ct, pub)
+			if err != nil {
+				t.Fatalf("size %d suite %#04x: %v", size, suiteID, err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("size %d suite %#04x: roundtrip mismatch", size, suiteID)
+			}
+		}
+	}
+}
+
+func TestDecryptStreamRejectsTampering(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	plaintext := bytes.Repeat([]byte("segment "), DefaultSegmentSize/2)
+	ct := encryptTestStream(t, plaintext, priv, crypto.SuiteAES256GCM)
+	lines := strings.Split(strings.TrimSuffix(ct, "\n"), "\n")
+	// magic, header, 4 segments, signature
+	if len(lines) != 7 {
+		t.Fatalf("got %d lines, want 7", len(lines))
+	}
+	join := func(l []string) string { return strings.Join(l, "\n") + "\n" }
+	with := func(i int, line string) string {
+		l := append([]string(nil), lines...)
+		l[i] = line
+		return join(l)
+	}
+
+	// A segment from another record under the same key fails to open
+	other := strings.Split(encryptTestStream(t, plaintext, priv, crypto.SuiteAES256GCM), "\n")
+	cases := map[string]string{
+		"swapped segments":  join(append(append(append([]string(nil), lines[:2]...), lines[3], lines[2]), lines[4:]...)),
+		"truncated":         join(append(append([]string(nil), lines[:4]...), lines[6])),
+		"dropped signature": join(lines[:6]),
+		"foreign segment":   with(3, other[3]),
+		"flipped bit":       with(2, strings.Map(func(r rune) rune { return r ^ 1 }, lines[2][:1])+lines[2][1:]),
+		"trailing data":     join(append(append([]string(nil), lines...), lines[2])),
+	}
+	for name, tampered := range cases {
+		if _, err := decryptTestStream(tampered, pub); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Another author's key rejects the signature, before the last
+	// segment is written
+	_, otherPub := makeTestKeys(t)
+	got, err := decryptTestStream(ct, otherPub)
+	if err == nil {
+		t.Error("wrong author key should be rejected")
+	}
+	if len(got) >= len(plaintext) {
+		t.Errorf("wrote %d of %d bytes before the signature verified", len(got), len(plaintext))
+	}
+}
+
+func TestStreamThroughChainFunctions(t *testing.T) {
+	priv, pub := makeTestKeys(t)
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	getSecret := func(epoch int) ([]byte, error) { return secret, nil }
+	getKey := func(author string, epoch int) (ed25519.PublicKey, error) { return pub, nil }
+	plaintext := bytes.Repeat([]byte("0123456789"), DefaultSegmentSize/5)
+	ct := encryptTestStream(t, plaintext, priv, crypto.SuiteAES256GCM)
+
+	if _, err := DecryptChain(ct, getSecret, "big.bin", getKey); err == nil {
+		t.Error("DecryptChain should leave streamed records to DecryptStream")
+	}
+	if epochs, err := ChainEpochs(ct); err != nil || len(epochs) != 1 || epochs[0] != 3 {
+		t.Errorf("ChainEpochs = %v, %v; want [3]", epochs, err)
+	}
+	if n := CountDeltas(ct); n != 0 {
+		t.Errorf("CountDeltas = %d, want 0", n)
+	}
+
+	// Compacting keeps the record streamed
+	compacted, err := Compact(ct, getSecret, secret, "big.bin", 4, crypto.SuiteAES256GCM, Encoding{}, "alice", priv, getKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsStream([]byte(compacted)) {
+		t.Error("compacted stream should stay streamed")
+	}
+	if epochs, _ := ChainEpochs(compacted); len(epochs) != 1 || epochs[0] != 4 {
+		t.Errorf("compacted epochs = %v, want [4]", epochs)
+	}
+	if got, err := decryptTestStream(compacted, pub); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypt compacted: %v", err)
+	}
+
+	// A stream is bound to its path; moving it re-encrypts it
+	var out bytes.Buffer
+	if err := DecryptStream(&out, strings.NewReader(ct), getSecret, "moved.bin", getKey); err == nil || out.Len() != 0 {
+		t.Errorf("moved stream: wrote %d bytes, err %v", out.Len(), err)
+	}
+	moved, err := Move(ct, getSecret, secret, "big.bin", "moved.bin", 4, crypto.SuiteAES256GCM, Encoding{}, "alice", priv, getKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from, err := ChainPath(moved); err != nil || from != "moved.bin" {
+		t.Errorf("ChainPath = %q, %v; want moved.bin", from, err)
+	}
+	out.Reset()
+	if err := DecryptStream(&out, strings.NewReader(moved), getSecret, "moved.bin", getKey); err != nil || !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("decrypt moved stream: %v", err)
+	}
+
+	// A stream that fails to verify is not re-encrypted
+	tampered := strings.Replace(ct, "sig:", "sig:AAAA", 1)
+	if _, err := Compact(tampered, getSecret, secret, "big.bin", 4, crypto.SuiteAES256GCM, Encoding{}, "alice", priv, getKey); err == nil {
+		t.Error("compacting a tampered stream should fail")
+	}
+}
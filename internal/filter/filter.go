@@ -2,26 +2,32 @@
 package filter
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/ed25519"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/germtb/mlsgit/internal/config"
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/delta"
 	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 )
 
 // FilterState holds all state needed for filter operations.
 type FilterState struct {
 	MemberID   string
+	Author     string // member ID, plus device ID on additional devices
 	Name       string
 	SigningKey ed25519.PrivateKey
 	Group     *mls.MLSGitGroup
 	Archive   *mls.EpochKeyArchive
 	Config    config.MLSGitConfig
+	Policy    *policy.Policy // nil if the repository has no role policy
 }
 
 // LoadState loads all state needed for filter operations.
@@ -36,9 +42,13 @@ func LoadState(paths storage.MLSGitPaths) (*FilterState, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read identity: %w", err)
 	}
+	deviceID, err := storage.ReadDeviceID(paths)
+	if err != nil {
+		return nil, fmt.Errorf("read identity: %w", err)
+	}
 
 	// Load Ed25519 signing key
-	pemData, err := os.ReadFile(paths.PrivateKey())
+	pemData, err := storage.ReadSecret(paths, paths.PrivateKey())
 	if err != nil {
 		return nil, fmt.Errorf("read private key: %w", err)
 	}
@@ -56,7 +66,7 @@ func LoadState(paths storage.MLSGitPaths) (*FilterState, error) {
 	groupState := mlsStateBytes[32:]
 
 	// Load X25519 init private key for DH operations during sync
-	initPriv, err := os.ReadFile(paths.LocalDir() + "/init_priv.bin")
+	initPriv, err := storage.ReadSecret(paths, paths.InitPriv())
 	if err != nil {
 		return nil, fmt.Errorf("read init_priv: %w", err)
 	}
@@ -65,95 +75,157 @@ func LoadState(paths storage.MLSGitPaths) (*FilterState, error) {
 	if err != nil {
 		return nil, fmt.Errorf("restore group: %w", err)
 	}
-
-	// Sync from committed state if it's ahead (e.g., after pulling)
-	if committedBytes, readErr := storage.ReadGroupState(paths); readErr == nil {
-		if mlsgitGroup.SyncFromCommitted(committedBytes) {
-			newGroupBytes, _ := mlsgitGroup.ToBytes()
-			combined := make([]byte, 32+len(newGroupBytes))
-			copy(combined[:32], sigPrivRaw)
-			copy(combined[32:], newGroupBytes)
-			storage.WriteLocalMLSState(paths, combined)
+	if next, ok, err := storage.FinishInitKeyRotation(paths, mlsgitGroup.HoldsInitKey); err != nil {
+		return nil, err
+	} else if ok {
+		if mlsgitGroup, err = mls.FromBytes(groupState, ed25519.NewKeyFromSeed(sigPrivRaw), next); err != nil {
+			return nil, fmt.Errorf("restore group: %w", err)
 		}
 	}
 
-	// Load epoch key archive
-	epochSecret := mlsgitGroup.ExportEpochSecret()
-	var archive *mls.EpochKeyArchive
+	// Load config
+	cfgData, err := os.ReadFile(paths.ConfigTOML())
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	cfg, err := config.ConfigFromTOML(string(cfgData))
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
 
-	archiveData, err := storage.ReadEpochKeys(paths)
+	// Enforce the role policy and add quorum on transitions we sync
+	pol, err := policy.Load(paths)
 	if err != nil {
-		if os.IsNotExist(err) {
-			archive = mls.NewWithSecret(mlsgitGroup.Epoch(), epochSecret)
-		} else {
-			return nil, fmt.Errorf("read epoch keys: %w", err)
+		return nil, err
+	}
+	mlsgitGroup.SetAuthorizer(policy.Authorizer(paths, pol))
+	mlsgitGroup.SetPostQuantum(cfg.PostQuantum())
+
+	// Sync from committed state if it's ahead (e.g., after pulling)
+	updated := false
+	if committedBytes, readErr := storage.ReadGroupState(paths); readErr == nil {
+		updated, err = mlsgitGroup.SyncFromCommitted(committedBytes)
+		if errors.Is(err, mls.ErrRemoved) {
+			// Files from the epochs we were in still decrypt
+			fmt.Fprintf(os.Stderr, "mlsgit: you are no longer a member of the group; files written after epoch %d stay encrypted\n", mlsgitGroup.Epoch())
+		} else if err != nil {
+			return nil, fmt.Errorf("sync committed group state: %w", err)
 		}
-	} else {
-		archive, err = mls.DecryptArchive(archiveData, epochSecret)
-		if err != nil {
-			return nil, fmt.Errorf("decrypt epoch archive: %w", err)
+	}
+	// Pick up past epoch secrets granted to this device
+	if grant, readErr := storage.ReadHistoryGrant(paths, storage.WelcomeKey(memberID, deviceID)); readErr == nil {
+		if n, err := mlsgitGroup.AcceptHistoryGrant(grant); err == nil && n > 0 {
+			updated = true
 		}
 	}
-
-	// Ensure current epoch is in the archive
-	epoch := mlsgitGroup.Epoch()
-	if !archive.Has(epoch) {
-		archive.Add(epoch, epochSecret)
+	if updated {
+		newGroupBytes, _ := mlsgitGroup.ToBytes()
+		combined := make([]byte, 32+len(newGroupBytes))
+		copy(combined[:32], sigPrivRaw)
+		copy(combined[32:], newGroupBytes)
+		storage.WriteLocalMLSState(paths, combined)
 	}
 
-	// Load config
-	cfgData, err := os.ReadFile(paths.ConfigTOML())
-	if err != nil {
-		return nil, fmt.Errorf("read config: %w", err)
+	// Load epoch key archive
+	archiveData, err := storage.ReadEpochKeys(paths)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read epoch keys: %w", err)
 	}
-	cfg, err := config.ConfigFromTOML(string(cfgData))
+	archive, err := mlsgitGroup.OpenEpochArchive(archiveData)
 	if err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
+		return nil, fmt.Errorf("decrypt epoch archive: %w", err)
 	}
 
 	return &FilterState{
 		MemberID:   memberID,
+		Author:     storage.DeviceAuthor(memberID, deviceID),
 		Name:       name,
 		SigningKey: signingPriv,
 		Group:     mlsgitGroup,
 		Archive:   archive,
 		Config:    cfg,
+		Policy:    pol,
 	}, nil
 }
 
-// getPublicKeyForAuthor loads the public signing key for a given author from members/.
-func getPublicKeyForAuthor(paths storage.MLSGitPaths, author string) (ed25519.PublicKey, error) {
-	memberPath := paths.MemberTOML(author)
-	info, err := storage.ReadMemberTOML(memberPath)
+// getPublicKeyForAuthor loads the public signing key for a given author from
+// members/. Authors on additional devices are only trusted if the device's
+// approval chains back to the member's primary key, and authors who were
+// readers at the record's epoch are not trusted to write at all. Removed members are looked up in former/ and only
+// trusted for records from epochs they were in the group.
+func getPublicKeyForAuthor(paths storage.MLSGitPaths, pol *policy.Policy, author string, epoch int) (ed25519.PublicKey, error) {
+	memberID, deviceID := storage.SplitAuthor(author)
+	if _, err := os.Stat(paths.MemberTOML(memberID)); os.IsNotExist(err) {
+		return getFormerPublicKey(paths, pol, memberID, deviceID, epoch)
+	}
+	if pol != nil && pol.RoleAt(memberID, epoch) == policy.Reader {
+		return nil, fmt.Errorf("author %s was a reader at epoch %d and could not write files", memberID, epoch)
+	}
+	if deviceID != "" {
+		return storage.DevicePublicKey(paths, memberID, deviceID)
+	}
+	info, err := storage.ReadMemberTOML(paths.MemberTOML(memberID))
 	if err != nil {
 		return nil, fmt.Errorf("member TOML not found for author %q: %w", author, err)
 	}
 	return crypto.LoadPublicKey(info.PublicKey)
 }
 
-// LooksCritCiphertext returns true if data appears to be an MLSGit ciphertext chain.
-func LooksCritCiphertext(data string) bool {
-	firstBlock := data
-	if idx := indexOf(data, config.DeltaSeparator); idx >= 0 {
-		firstBlock = data[:idx]
+// getFormerPublicKey loads a removed member's signing key for a record
+// written at epoch.
+func getFormerPublicKey(paths storage.MLSGitPaths, pol *policy.Policy, memberID, deviceID string, epoch int) (ed25519.PublicKey, error) {
+	former, err := storage.ReadFormerMember(paths, memberID)
+	if err != nil {
+		return nil, fmt.Errorf("author %q is neither a member nor a former member: %w", storage.DeviceAuthor(memberID, deviceID), err)
 	}
-	firstBlock = trimSpace(firstBlock)
-	if firstBlock == "" {
-		return false
+	role := pol.RoleAt(memberID, epoch)
+	if role == "" {
+		role = policy.Role(former.Role)
 	}
+	if pol != nil && role == policy.Reader {
+		return nil, fmt.Errorf("former member %s was a reader and could not write files", memberID)
+	}
+	return former.PublicKeyAt(deviceID, epoch)
+}
+
+// LooksCritCiphertext returns true if data appears to be an MLSGit ciphertext
+// chain, in any format, or streamed record.
+func LooksCritCiphertext(data string) bool {
+	if delta.IsStream([]byte(data)) {
+		return true
+	}
+	_, ok := delta.DetectFormat(data)
+	return ok
+}
 
-	jsonBytes, err := crypto.B64Decode(firstBlock, true)
+// verifyCiphertext checks that data is a whole ciphertext chain or
+// streamed record signed by members of the group. Only such data is staged
+// as it is; plaintext that merely starts like ciphertext is encrypted.
+func (s *FilterState) verifyCiphertext(paths storage.MLSGitPaths, data string) error {
+	return delta.VerifyChain(data, s.publicKeyFunc(paths))
+}
+
+// verifySpooled is verifyCiphertext for content spooled to f, reading a
+// streamed record from the file rather than into memory.
+func (s *FilterState) verifySpooled(paths storage.MLSGitPaths, f *os.File, stream bool) (bool, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if stream {
+		return delta.VerifyStream(bufio.NewReader(f), s.publicKeyFunc(paths)) == nil, nil
+	}
+	data, err := io.ReadAll(f)
 	if err != nil {
-		return false
+		return false, fmt.Errorf("read spooled input: %w", err)
 	}
-	var obj map[string]interface{}
-	if err := json.Unmarshal(jsonBytes, &obj); err != nil {
-		return false
+	return s.verifyCiphertext(paths, string(data)) == nil, nil
+}
+
+// publicKeyFunc returns the lookup of record authors' signing keys.
+func (s *FilterState) publicKeyFunc(paths storage.MLSGitPaths) delta.PublicKeyFunc {
+	return func(author string, epoch int) (ed25519.PublicKey, error) {
+		return getPublicKeyForAuthor(paths, s.Policy, author, epoch)
 	}
-	_, hasEpoch := obj["epoch"]
-	_, hasCT := obj["ct"]
-	_, hasIV := obj["iv"]
-	return hasEpoch && hasCT && hasIV
 }
 
 // Clean is the clean filter: plaintext -> ciphertext.
@@ -165,9 +237,19 @@ func Clean(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byte
 	if state == nil {
 		return stdinData, nil
 	}
+	return state.clean(filePath, stdinData, paths)
+}
 
-	epoch := state.Group.Epoch()
-	epochSecret, _ := state.Archive.Get(epoch)
+// clean encrypts stdinData in memory as a base block or a delta.
+func (s *FilterState) clean(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byte, error) {
+	// Files smudge left encrypted (history we were not granted) are staged
+	// as they are, not encrypted a second time
+	if LooksCritCiphertext(string(stdinData)) && s.verifyCiphertext(paths, string(stdinData)) == nil {
+		return stdinData, nil
+	}
+
+	epoch := s.Group.Epoch()
+	epochSecret, _ := s.Archive.Get(epoch)
 
 	cache := storage.NewFilterCache(paths)
 
@@ -178,29 +260,35 @@ func Clean(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byte
 	if cachedPlain != nil && bytesEqual(cachedPlain, stdinData) && hasCachedCT {
 		return []byte(cachedCT), nil
 	}
+	if err := s.Policy.Require(s.MemberID, policy.Writer); err != nil {
+		return nil, fmt.Errorf("cannot encrypt %s: %w", filePath, err)
+	}
 
 	var ct string
-	if cachedPlain == nil || !hasCachedCT {
-		// First add or cache miss: encrypt full plaintext as base block
-		ct, err = delta.EncryptBaseBlock(stdinData, epochSecret, filePath, epoch, state.MemberID, state.SigningKey)
+	var err error
+	if cachedPlain == nil || !hasCachedCT || delta.IsStream([]byte(cachedCT)) {
+		// First add, cache miss, or a file that was streamed: encrypt full
+		// plaintext as base block
+		ct, err = delta.EncryptBaseBlock(stdinData, epochSecret, filePath, epoch, s.Config.CipherSuite, s.Encoding(), s.Author, s.SigningKey)
 		if err != nil {
 			return nil, fmt.Errorf("encrypt base block: %w", err)
 		}
 	} else {
-		// Compute delta from old to new plaintext
-		oldText := string(cachedPlain)
-		newText := string(stdinData)
-		deltaText := delta.ComputeDelta(oldText, newText)
+		// Compute delta from old to new plaintext, as bytes if either
+		// looks binary
+		codec := delta.ChooseCodec(cachedPlain, stdinData)
+		deltaData := delta.Compute(codec, cachedPlain, stdinData)
 
 		nDeltas := delta.CountDeltas(cachedCT)
-		if nDeltas >= state.Config.CompactionThreshold {
-			ct, err = delta.EncryptBaseBlock(stdinData, epochSecret, filePath, epoch, state.MemberID, state.SigningKey)
+		// A binary delta no smaller than the file is worse than a new base
+		if nDeltas >= s.Config.CompactionThreshold || (codec == delta.CodecBinary && len(deltaData) >= len(stdinData)) {
+			ct, err = delta.EncryptBaseBlock(stdinData, epochSecret, filePath, epoch, s.Config.CipherSuite, s.Encoding(), s.Author, s.SigningKey)
 			if err != nil {
 				return nil, fmt.Errorf("encrypt compacted base: %w", err)
 			}
 		} else {
-			ct, err = delta.EncryptDelta(deltaText, epochSecret, filePath, epoch,
-				nDeltas+1, state.MemberID, state.SigningKey, cachedCT)
+			ct, err = delta.EncryptDelta(deltaData, codec, epochSecret, filePath, epoch,
+				s.Config.CipherSuite, s.Encoding(), nDeltas+1, s.Author, s.SigningKey, cachedCT)
 			if err != nil {
 				return nil, fmt.Errorf("encrypt delta: %w", err)
 			}
@@ -211,8 +299,16 @@ func Clean(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byte
 	return []byte(ct), nil
 }
 
-// Smudge is the smudge filter: ciphertext -> plaintext.
+// Smudge is the smudge filter: ciphertext -> plaintext. Streamed records
+// go through SmudgeStream.
 func Smudge(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byte, error) {
+	if delta.IsStream(stdinData) {
+		var out bytes.Buffer
+		if err := SmudgeStream(filePath, bytes.NewReader(stdinData), &out, paths); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
 	state, err := LoadState(paths)
 	if err != nil {
 		return nil, err
@@ -230,12 +326,15 @@ func Smudge(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byt
 	getEpochSecret := func(epoch int) ([]byte, error) {
 		return state.Archive.Get(epoch)
 	}
-	getPublicKey := func(author string) (ed25519.PublicKey, error) {
-		return getPublicKeyForAuthor(paths, author)
+	getPublicKey := func(author string, epoch int) (ed25519.PublicKey, error) {
+		return getPublicKeyForAuthor(paths, state.Policy, author, epoch)
 	}
 
 	plaintext, err := delta.DecryptChain(ciphertext, getEpochSecret, filePath, getPublicKey)
 	if err != nil {
+		if keepEncrypted(state, paths, filePath, err) {
+			return stdinData, nil
+		}
 		return nil, fmt.Errorf("decrypt chain: %w", err)
 	}
 
@@ -245,27 +344,343 @@ func Smudge(filePath string, stdinData []byte, paths storage.MLSGitPaths) ([]byt
 	return plaintext, nil
 }
 
-// helpers
+// keepEncrypted reports whether a file that failed to decrypt should be
+// checked out as ciphertext rather than fail the checkout, and says why.
+func keepEncrypted(state *FilterState, paths storage.MLSGitPaths, filePath string, err error) bool {
+	// During an unresolved fork, files from the other branch may be
+	// encrypted under epochs we don't hold yet. Leave them as ciphertext
+	// so the merge can finish; 'mlsgit resolve' decrypts them.
+	if _, statErr := os.Stat(paths.ForkMarker()); statErr == nil {
+		fmt.Fprintf(os.Stderr, "mlsgit: leaving %s encrypted until 'mlsgit resolve': %v\n", filePath, err)
+		return true
+	}
+	// Files last written before a history cut we were added behind
+	// stay encrypted until someone grants us that history.
+	var missing *mls.MissingEpochError
+	if errors.As(err, &missing) && behindHistoryCut(state.Group, missing.Epoch) {
+		fmt.Fprintf(os.Stderr, "mlsgit: leaving %s encrypted: epoch %d is from before you were given access\n", filePath, missing.Epoch)
+		return true
+	}
+	// Files written under shredded epochs can never be decrypted again
+	if errors.As(err, &missing) && missing.Epoch < state.Archive.ShreddedBefore() {
+		fmt.Fprintf(os.Stderr, "mlsgit: leaving %s encrypted: epoch %d was shredded\n", filePath, missing.Epoch)
+		return true
+	}
+	return false
+}
+
+// --- Streaming filters ---
+//
+// CleanStream and SmudgeStream are what the git filter commands run. Files
+// smaller than the stream threshold go through Clean and Smudge in memory;
+// larger files are encrypted as streamed records (delta.EncryptStream),
+// spooled through the filter cache on disk so memory use stays constant.
+
+// CleanStream is the clean filter reading plaintext from r and writing
+// ciphertext to w.
+func CleanStream(filePath string, r io.Reader, w io.Writer, paths storage.MLSGitPaths) error {
+	state, err := LoadState(paths)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	// Read up to the threshold; anything smaller is cleaned in memory
+	head, err := io.ReadAll(io.LimitReader(r, int64(state.Config.StreamThreshold)))
+	if err != nil {
+		return fmt.Errorf("read stdin: %w", err)
+	}
+	if len(head) < state.Config.StreamThreshold {
+		ct, err := state.clean(filePath, head, paths)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(ct)
+		return err
+	}
+	input := io.MultiReader(bytes.NewReader(head), r)
+
+	// Spool the plaintext to the cache; if it is unchanged, reuse the
+	// cached ciphertext
+	cache := storage.NewFilterCache(paths)
+	plainTemp, err := cache.CreateTemp(filePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(plainTemp.Name())
+	defer plainTemp.Close()
+	if _, err := io.Copy(plainTemp, input); err != nil {
+		return fmt.Errorf("spool plaintext: %w", err)
+	}
+
+	// Files smudge left encrypted are staged as they are, if they verify
+	if delta.IsStream(head) || looksLikeChainStart(head) {
+		if ok, err := state.verifySpooled(paths, plainTemp, delta.IsStream(head)); err != nil {
+			return err
+		} else if ok {
+			if _, err := plainTemp.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, plainTemp)
+			return err
+		}
+	}
+	if cache.PlaintextMatches(filePath, plainTemp.Name()) {
+		if cached, err := cache.OpenCiphertext(filePath); err == nil {
+			defer cached.Close()
+			_, err := io.Copy(w, cached)
+			return err
+		}
+	}
+	if err := state.Policy.Require(state.MemberID, policy.Writer); err != nil {
+		return fmt.Errorf("cannot encrypt %s: %w", filePath, err)
+	}
+
+	// Encrypt to w and the cache at once
+	if _, err := plainTemp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctTemp, err := cache.CreateTemp(filePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ctTemp.Name())
+	defer ctTemp.Close()
+	epoch := state.Group.Epoch()
+	epochSecret, _ := state.Archive.Get(epoch)
+	if err := delta.EncryptStream(io.MultiWriter(w, ctTemp), plainTemp, epochSecret, filePath, epoch,
+		state.Config.CipherSuite, state.Author, state.SigningKey); err != nil {
+		return fmt.Errorf("encrypt stream: %w", err)
+	}
+	return cache.Commit(filePath, plainTemp.Name(), ctTemp.Name())
+}
+
+// SmudgeStream is the smudge filter reading ciphertext from r and writing
+// plaintext to w. Streamed records are decrypted a segment at a time into
+// a temporary file, which is copied to w only once the stream's signature
+// has verified, so no plaintext from a truncated or spliced stream reaches
+// the working tree; anything else goes through Smudge.
+func SmudgeStream(filePath string, r io.Reader, w io.Writer, paths storage.MLSGitPaths) error {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(delta.StreamMagic) + 1); !delta.IsStream(head) {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return fmt.Errorf("read stdin: %w", err)
+		}
+		plaintext, err := Smudge(filePath, data, paths)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(plaintext)
+		return err
+	}
+
+	state, err := LoadState(paths)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		_, err := io.Copy(w, br)
+		return err
+	}
+
+	// Decrypt into the cache, and only copy the plaintext to w once the
+	// stream's signature has verified
+	cache := storage.NewFilterCache(paths)
+	ctTemp, err := cache.CreateTemp(filePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(ctTemp.Name())
+	defer ctTemp.Close()
+	plainTemp, err := cache.CreateTemp(filePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(plainTemp.Name())
+	defer plainTemp.Close()
+
+	getPublicKey := func(author string, epoch int) (ed25519.PublicKey, error) {
+		return getPublicKeyForAuthor(paths, state.Policy, author, epoch)
+	}
+	out := &countingWriter{w: plainTemp}
+	err = delta.DecryptStream(out, io.TeeReader(br, ctTemp), state.Archive.Get, filePath, getPublicKey)
+	if err != nil {
+		// Keys are checked before any plaintext is decrypted, so a file we
+		// cannot decrypt can still be passed through as ciphertext
+		if out.n == 0 && keepEncrypted(state, paths, filePath, err) {
+			if _, err := ctTemp.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, io.MultiReader(ctTemp, br)); err != nil {
+				return err
+			}
+			return nil
+		}
+		return fmt.Errorf("decrypt stream: %w", err)
+	}
+	if _, err := plainTemp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, plainTemp); err != nil {
+		return err
+	}
+	return cache.Commit(filePath, plainTemp.Name(), ctTemp.Name())
+}
+
+// Rebase re-encrypts a ciphertext chain as a single base block under the
+// current epoch, so it no longer depends on the epochs it was written in,
+// and records the result in the filter cache.
+func Rebase(filePath string, ciphertext []byte, paths storage.MLSGitPaths) ([]byte, error) {
+	return Move(filePath, filePath, ciphertext, paths)
+}
+
+// Move is Rebase for a file renamed from fromPath to toPath: the chain
+// written for fromPath is re-encrypted for toPath, where it is recorded in
+// the filter cache.
+func Move(fromPath, toPath string, ciphertext []byte, paths storage.MLSGitPaths) ([]byte, error) {
+	state, err := LoadState(paths)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no local MLS state")
+	}
+	cache := storage.NewFilterCache(paths)
+	plainTemp, err := cache.CreateTemp(toPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(plainTemp.Name())
+	defer plainTemp.Close()
+	ct, err := state.Move(paths, fromPath, toPath, ciphertext, plainTemp)
+	if err != nil {
+		return nil, err
+	}
+	ctTemp, err := cache.CreateTemp(toPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(ctTemp.Name())
+	defer ctTemp.Close()
+	if _, err := ctTemp.Write(ct); err != nil {
+		return nil, err
+	}
+	return ct, cache.Commit(toPath, plainTemp.Name(), ctTemp.Name())
+}
+
+// Migrate re-encodes a ciphertext chain in another format after checking
+// that it decrypts, and records the result in the filter cache. Records
+// are not re-encrypted.
+func Migrate(filePath string, ciphertext []byte, format delta.Format, paths storage.MLSGitPaths) ([]byte, error) {
+	state, err := LoadState(paths)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no local MLS state")
+	}
+	getPublicKey := func(author string, epoch int) (ed25519.PublicKey, error) {
+		return getPublicKeyForAuthor(paths, state.Policy, author, epoch)
+	}
+	plaintext, err := delta.DecryptChain(string(ciphertext), state.Archive.Get, filePath, getPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt chain: %w", err)
+	}
+	ct, err := delta.Convert(string(ciphertext), format)
+	if err != nil {
+		return nil, err
+	}
+	cache := storage.NewFilterCache(paths)
+	cache.Put(filePath, plaintext, ct)
+	return []byte(ct), nil
+}
 
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
+// Rebase re-encrypts a ciphertext chain as a single base block under the
+// current epoch with delta.Compact, or a streamed record as a new streamed
+// record. It returns the new chain after decrypting it to plaintext.
+func (s *FilterState) Rebase(paths storage.MLSGitPaths, filePath string, ciphertext []byte, plaintext io.Writer) ([]byte, error) {
+	return s.Move(paths, filePath, filePath, ciphertext, plaintext)
+}
+
+// Move is Rebase for a file renamed from fromPath to filePath, using
+// delta.Move.
+func (s *FilterState) Move(paths storage.MLSGitPaths, fromPath, filePath string, ciphertext []byte, plaintext io.Writer) ([]byte, error) {
+	if err := s.Policy.Require(s.MemberID, policy.Writer); err != nil {
+		return nil, fmt.Errorf("cannot encrypt %s: %w", filePath, err)
+	}
+
+	epoch := s.Group.Epoch()
+	epochSecret, _ := s.Archive.Get(epoch)
+	getPublicKey := s.publicKeyFunc(paths)
+	ct, err := delta.Move(string(ciphertext), s.Archive.Get, epochSecret, fromPath, filePath, epoch,
+		s.Config.CipherSuite, s.Encoding(), s.Author, s.SigningKey, getPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if delta.IsStream([]byte(ct)) {
+		if err := delta.DecryptStream(plaintext, bytes.NewReader([]byte(ct)), s.Archive.Get, filePath, getPublicKey); err != nil {
+			return nil, fmt.Errorf("verify rebased stream: %w", err)
 		}
+		return []byte(ct), nil
+	}
+	content, err := delta.DecryptChain(ct, s.Archive.Get, filePath, getPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("verify rebased chain: %w", err)
 	}
-	return -1
+	if _, err := plaintext.Write(content); err != nil {
+		return nil, err
+	}
+	return []byte(ct), nil
 }
 
-func trimSpace(s string) string {
-	start := 0
-	for start < len(s) && (s[start] == ' ' || s[start] == '\t' || s[start] == '\n' || s[start] == '\r') {
-		start++
+// Encoding returns how the configuration asks new records to be written.
+func (s *FilterState) Encoding() delta.Encoding {
+	return delta.Encoding{Format: delta.Format(s.Config.CiphertextFormat), Compression: s.Config.Compression}
+}
+
+// helpers
+
+// behindHistoryCut reports whether epoch is at or before a history cut.
+func behindHistoryCut(group *mls.MLSGitGroup, epoch int) bool {
+	for _, c := range group.HistoryCuts() {
+		if epoch <= c {
+			return true
+		}
 	}
-	end := len(s)
-	for end > start && (s[end-1] == ' ' || s[end-1] == '\t' || s[end-1] == '\n' || s[end-1] == '\r') {
-		end--
+	return false
+}
+
+// looksLikeChainStart reports whether data starts like a ciphertext
+// chain, so that only such content too large for LooksCritCiphertext is
+// read back to be verified: v2 chains open with their magic, and every
+// text record is base64 of JSON that opens with its epoch.
+func looksLikeChainStart(data []byte) bool {
+	if format, ok := delta.DetectFormat(string(data[:min(len(data), 64)])); ok && format != delta.FormatText {
+		return true
+	}
+	const prefix = `{"epoch":`
+	n := len(prefix) / 3 * 4 // base64 length of prefix
+	if len(data) < n {
+		return false
 	}
-	return s[start:end]
+	decoded, err := crypto.B64Decode(string(data[:n]), true)
+	return err == nil && string(decoded) == prefix
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 func bytesEqual(a, b []byte) bool {
@@ -1,15 +1,18 @@
 package filter
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/germtb/mlsgit/internal/config"
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/delta"
 	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/policy"
 	"github.com/germtb/mlsgit/internal/storage"
 )
 
@@ -55,7 +58,7 @@ func setupFilterTest(t *testing.T) (storage.MLSGitPaths, *mls.MLSGitGroup, *mls.
 	// Save epoch archive
 	epochSecret := group.ExportEpochSecret()
 	archive := mls.NewWithSecret(group.Epoch(), epochSecret)
-	archiveData, _ := archive.Encrypt(epochSecret)
+	archiveData, _ := archive.Seal()
 	storage.WriteEpochKeys(paths, archiveData)
 
 	// Write member TOML
@@ -122,6 +125,211 @@ func TestCleanDelta(t *testing.T) {
 	}
 }
 
+func TestCleanBinaryDelta(t *testing.T) {
+	paths, _, _ := setupFilterTest(t)
+
+	v1 := append([]byte("\x89PNG\r\n\x1a\n\x00"), bytes.Repeat([]byte{0x10, 0xff, 0x00}, 500)...)
+	v2 := append([]byte{}, v1...)
+	v2[700] = 0x42
+	Clean("image.png", v1, paths)
+	ct, err := Clean("image.png", v2, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := strings.Split(string(ct), config.DeltaSeparator)
+	if len(blocks) != 2 {
+		t.Fatalf("second write should have 1 delta, got %d", len(blocks)-1)
+	}
+	if r, _ := delta.DeltaRecordFromB64(blocks[1]); r.Codec != delta.CodecBinary {
+		t.Errorf("Codec = %q, want %q", r.Codec, delta.CodecBinary)
+	}
+	decrypted, err := Smudge("image.png", ct, paths)
+	if err != nil || !bytes.Equal(decrypted, v2) {
+		t.Errorf("smudge did not restore the binary file: %v", err)
+	}
+
+	// A rewrite with nothing in common starts a new base block
+	v3 := bytes.Repeat([]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a}, 100)
+	ct, _ = Clean("image.png", v3, paths)
+	if n := delta.CountDeltas(string(ct)); n != 0 {
+		t.Errorf("unrelated binary content should be a base block, got %d deltas", n)
+	}
+}
+
+func TestCleanBinaryFormat(t *testing.T) {
+	paths, _, _ := setupFilterTest(t)
+	Clean("test.txt", []byte("version 1"), paths)
+
+	// Switching formats keeps existing chains in theirs until rewritten
+	cfg := config.DefaultConfig()
+	cfg.CiphertextFormat = "binary"
+	cfg.Compression = "zstd"
+	os.WriteFile(paths.ConfigTOML(), []byte(cfg.ToTOML()), 0o644)
+	ct, _ := Clean("test.txt", []byte("version 2"), paths)
+	if f, _ := delta.DetectFormat(string(ct)); f != delta.FormatText || delta.CountDeltas(string(ct)) != 1 {
+		t.Errorf("delta should be appended to the text chain, got %s with %d deltas", f, delta.CountDeltas(string(ct)))
+	}
+
+	ct, err := Clean("new.txt", []byte("new file"), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err = Clean("new.txt", []byte("new file, edited"), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := delta.DetectFormat(string(ct)); f != delta.FormatBinary || delta.CountDeltas(string(ct)) != 1 {
+		t.Errorf("new file should be a binary chain with 1 delta, got %s with %d", f, delta.CountDeltas(string(ct)))
+	}
+	if !LooksCritCiphertext(string(ct)) {
+		t.Error("binary chain should be recognized")
+	}
+	decrypted, err := Smudge("new.txt", ct, paths)
+	if err != nil || string(decrypted) != "new file, edited" {
+		t.Errorf("smudge = %q, %v", decrypted, err)
+	}
+
+	// Migrating converts without re-encrypting
+	migrated, err := Migrate("new.txt", ct, delta.FormatArmored, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := delta.DetectFormat(string(migrated)); f != delta.FormatArmored {
+		t.Errorf("migrated chain is %s", f)
+	}
+	if again, _ := Clean("new.txt", []byte("new file, edited"), paths); !bytes.Equal(again, migrated) {
+		t.Error("clean should return the migrated chain from the cache")
+	}
+}
+
+func TestCleanSmudgeStream(t *testing.T) {
+	paths, _, _ := setupFilterTest(t)
+	cfg := config.DefaultConfig()
+	cfg.StreamThreshold = 4096
+	os.WriteFile(paths.ConfigTOML(), []byte(cfg.ToTOML()), 0o644)
+
+	clean := func(data []byte) []byte {
+		t.Helper()
+		var out bytes.Buffer
+		if err := CleanStream("data.bin", bytes.NewReader(data), &out, paths); err != nil {
+			t.Fatalf("CleanStream error: %v", err)
+		}
+		return out.Bytes()
+	}
+	smudge := func(ct []byte) []byte {
+		t.Helper()
+		var out bytes.Buffer
+		if err := SmudgeStream("data.bin", bytes.NewReader(ct), &out, paths); err != nil {
+			t.Fatalf("SmudgeStream error: %v", err)
+		}
+		return out.Bytes()
+	}
+
+	// Files below the threshold are still base blocks and deltas
+	small := []byte("small file")
+	ct := clean(small)
+	if delta.IsStream(ct) || !LooksCritCiphertext(string(ct)) {
+		t.Error("small file should be a base block")
+	}
+	if got := smudge(ct); !bytes.Equal(got, small) {
+		t.Errorf("smudge = %q, want %q", got, small)
+	}
+
+	// Large files are streamed, and unchanged content reuses the cache
+	big := bytes.Repeat([]byte{0x00, 0x01, 0x02, 0xff}, 3*delta.DefaultSegmentSize/4+100)
+	ct = clean(big)
+	if !delta.IsStream(ct) || !LooksCritCiphertext(string(ct)) {
+		t.Fatal("large file should be a streamed record")
+	}
+	if got := smudge(ct); !bytes.Equal(got, big) {
+		t.Error("smudge did not restore the streamed file")
+	}
+
+	// A stream whose signature fails writes no plaintext at all
+	forged := append(bytes.Clone(ct[:len(ct)-8]), "AAAAAAA\n"...)
+	var out bytes.Buffer
+	if err := SmudgeStream("data.bin", bytes.NewReader(forged), &out, paths); err == nil {
+		t.Error("smudge should reject a stream with a bad signature")
+	}
+	if out.Len() != 0 {
+		t.Errorf("smudge wrote %d bytes of an unverified stream", out.Len())
+	}
+	if again := clean(big); !bytes.Equal(again, ct) {
+		t.Error("same plaintext should return the cached streamed record")
+	}
+	if passed := clean(ct); !bytes.Equal(passed, ct) {
+		t.Error("a streamed record should be staged as it is")
+	}
+	if got, err := Smudge("data.bin", ct, paths); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("Smudge of a streamed record: %v", err)
+	}
+
+	// Rebasing re-encrypts it as a stream and caches the result
+	rebased, err := Rebase("data.bin", ct, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delta.IsStream(rebased) || bytes.Equal(rebased, ct) {
+		t.Error("a rebased streamed record should be a new streamed record")
+	}
+	if again := clean(big); !bytes.Equal(again, rebased) {
+		t.Error("clean should return the rebased record from the cache")
+	}
+
+	// Shrinking below the threshold starts a new base block
+	ct = clean(small)
+	if delta.IsStream(ct) || delta.CountDeltas(string(ct)) != 0 {
+		t.Error("shrunk file should be a single base block")
+	}
+	if got := smudge(ct); !bytes.Equal(got, small) {
+		t.Errorf("smudge = %q, want %q", got, small)
+	}
+}
+
+func TestCleanEncryptsLookalikePlaintext(t *testing.T) {
+	paths, _, _ := setupFilterTest(t)
+	cfg := config.DefaultConfig()
+	cfg.StreamThreshold = 4096
+	os.WriteFile(paths.ConfigTOML(), []byte(cfg.ToTOML()), 0o644)
+
+	// A chain signed by someone outside the group is not staged as it is
+	_, mallory, _ := ed25519.GenerateKey(nil)
+	forged, _ := delta.EncryptBaseBlock([]byte("forged"), make([]byte, 32), "test.txt", 0, crypto.SuiteAES256GCM, delta.Encoding{}, "mallory", mallory)
+
+	filler := strings.Repeat("secret plaintext\n", 512)
+	for _, plain := range []string{
+		"MLSGIT\x00\x02" + "secret",
+		"-----BEGIN MLSGIT CIPHERTEXT-----\nsecret\n",
+		delta.StreamMagic + "\nsecret\n",
+		"MLSGIT\x00\x02" + filler,
+		"-----BEGIN MLSGIT CIPHERTEXT-----\n" + filler,
+		delta.StreamMagic + "\n" + filler,
+		forged,
+	} {
+		var out bytes.Buffer
+		if err := CleanStream("test.txt", strings.NewReader(plain), &out, paths); err != nil {
+			t.Fatalf("CleanStream error: %v", err)
+		}
+		if out.String() == plain {
+			t.Errorf("plaintext starting %q was staged unencrypted", plain[:min(len(plain), 20)])
+			continue
+		}
+		var got bytes.Buffer
+		if err := SmudgeStream("test.txt", &out, &got, paths); err != nil || got.String() != plain {
+			t.Errorf("smudge of %q = %q, %v", plain[:min(len(plain), 20)], got.String()[:min(got.Len(), 20)], err)
+		}
+	}
+
+	// Ciphertext signed by a member is staged as it is
+	ct, err := Clean("other.txt", []byte("encrypted"), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := Clean("test.txt", ct, paths); !bytes.Equal(again, ct) {
+		t.Error("a member's ciphertext should be staged as it is")
+	}
+}
+
 func TestSmudgePassthroughNonCiphertext(t *testing.T) {
 	paths, _, _ := setupFilterTest(t)
 
@@ -155,7 +363,7 @@ func TestSmudgePassthroughNoState(t *testing.T) {
 func TestLooksCritCiphertext(t *testing.T) {
 	_, priv, _ := ed25519.GenerateKey(nil)
 	secret := make([]byte, 32)
-	ct, _ := delta.EncryptBaseBlock([]byte("test"), secret, "test.txt", 0, "alice", priv)
+	ct, _ := delta.EncryptBaseBlock([]byte("test"), secret, "test.txt", 0, crypto.SuiteAES256GCM, delta.Encoding{}, "alice", priv)
 
 	if !LooksCritCiphertext(ct) {
 		t.Error("valid ciphertext should be recognized")
@@ -169,3 +377,72 @@ func TestLooksCritCiphertext(t *testing.T) {
 		t.Error("empty string should not be recognized")
 	}
 }
+
+func TestSmudgeFormerMember(t *testing.T) {
+	paths, group, _ := setupFilterTest(t)
+
+	// A record bob signed at epoch 0, before he was removed
+	bobPriv, bobPub, _ := crypto.GenerateKeypair()
+	bobPEM, _ := crypto.PublicKeyToPEM(bobPub)
+	ct, err := delta.EncryptBaseBlock([]byte("from bob\n"), group.ExportEpochSecret(), "bob.txt", 0,
+		config.DefaultConfig().CipherSuite, delta.Encoding{}, "bob456789abc", bobPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Smudge("bob.txt", []byte(ct), paths); err == nil {
+		t.Fatal("record from an unknown author should not verify")
+	}
+
+	former := storage.FormerMember{Name: "bob", PublicKey: bobPEM, Role: "writer", JoinedEpoch: 0, RemovedEpoch: 1}
+	storage.WriteFormerMember(paths, "bob456789abc", former)
+	got, err := Smudge("bob.txt", []byte(ct), paths)
+	if err != nil {
+		t.Fatalf("record from before the removal: %v", err)
+	}
+	if string(got) != "from bob\n" {
+		t.Errorf("got %q", got)
+	}
+
+	// Moving the removal to epoch 0 puts the record after it
+	former.RemovedEpoch = 0
+	storage.WriteFormerMember(paths, "bob456789abc", former)
+	if _, err := Smudge("bob.txt", []byte(ct), paths); err == nil {
+		t.Error("record from after the removal should not verify")
+	}
+}
+
+func TestSmudgeAfterDemotion(t *testing.T) {
+	paths, group, _ := setupFilterTest(t)
+
+	// Bob writes a file at epoch 0 as a writer
+	bobPriv, bobPub, _ := crypto.GenerateKeypair()
+	bobPEM, _ := crypto.PublicKeyToPEM(bobPub)
+	storage.WriteMemberTOML(paths, "bob456789abc", "bob", bobPEM, 0, "test123456ab")
+	ct, err := delta.EncryptBaseBlock([]byte("from bob\n"), group.ExportEpochSecret(), "bob.txt", 0,
+		config.DefaultConfig().CipherSuite, delta.Encoding{}, "bob456789abc", bobPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and is demoted to reader from epoch 1
+	pemData, _ := os.ReadFile(paths.PrivateKey())
+	adminKey, _ := crypto.LoadPrivateKey(string(pemData))
+	pol := policy.New("test123456ab")
+	pol.SetRole("bob456789abc", policy.Writer, 0)
+	pol.SetRole("bob456789abc", policy.Reader, 1)
+	if err := policy.Save(paths, pol, "test123456ab", adminKey); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Smudge("bob.txt", []byte(ct), paths)
+	if err != nil {
+		t.Fatalf("file written before the demotion: %v", err)
+	}
+	if string(got) != "from bob\n" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := getPublicKeyForAuthor(paths, pol, "bob456789abc", 1); err == nil {
+		t.Error("a record from after the demotion should not verify")
+	}
+}
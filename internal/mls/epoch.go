@@ -1,22 +1,57 @@
 package mls
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 const archiveKeyLabel = "mlsgit-archive"
 
+// archiveHeader starts every append-only archive file. Files without it
+// are the older single-blob format.
+const archiveHeader = "mlsgit-epoch-keys v2"
+
+// wrappedSecret is one archive entry: an epoch secret encrypted under a
+// key derived from the secret of a later epoch, or a cut.
+type wrappedSecret struct {
+	under int    // epoch whose secret wraps this one, or historyCut
+	data  []byte // nonce || ciphertext || tag
+}
+
+// MissingEpochError reports an epoch whose secret the archive cannot
+// provide.
+type MissingEpochError struct {
+	Epoch int
+}
+
+func (e *MissingEpochError) Error() string {
+	return fmt.Sprintf("epoch %d not in archive", e.Epoch)
+}
+
+// historyCut marks an epoch whose secret is deliberately not in the
+// archive, so members added after it cannot reach further back.
+const historyCut = -1
+
 // EpochKeyArchive manages a collection of epoch secrets keyed by epoch number.
+//
+// On disk the archive is append-only: each epoch's secret is wrapped under
+// the next known epoch's secret, one line per epoch. Secrets are unwrapped
+// lazily, walking backward from the newest epoch only as far as needed.
 type EpochKeyArchive struct {
-	keys map[int][]byte
+	keys        map[int][]byte
+	wrapped     map[int]wrappedSecret
+	shredBefore int // epochs below this were shredded; 0 if none
 }
 
 // NewEpochKeyArchive creates an empty archive.
 func NewEpochKeyArchive() *EpochKeyArchive {
-	return &EpochKeyArchive{keys: make(map[int][]byte)}
+	return &EpochKeyArchive{keys: make(map[int][]byte), wrapped: make(map[int]wrappedSecret)}
 }
 
 // NewWithSecret creates a new archive with a single epoch secret.
@@ -26,111 +61,402 @@ func NewWithSecret(epoch int, secret []byte) *EpochKeyArchive {
 	return a
 }
 
-// Add records the secret for an epoch.
+// Add records the secret for an epoch. Secrets of shredded epochs are
+// ignored.
 func (a *EpochKeyArchive) Add(epoch int, secret []byte) {
+	if epoch < a.shredBefore {
+		return
+	}
 	a.keys[epoch] = secret
 }
 
-// Get retrieves the secret for an epoch.
+// Remove drops the secret for an epoch, wrapped or not. The entry wrapped
+// under it is unwrapped first where possible, so Seal rewraps it under the
+// next epoch instead of losing the history behind it.
+func (a *EpochKeyArchive) Remove(epoch int) {
+	for e, w := range a.wrapped {
+		if w.under == epoch {
+			a.Get(e)
+			delete(a.wrapped, e)
+		}
+	}
+	delete(a.keys, epoch)
+	delete(a.wrapped, epoch)
+}
+
+// Shred drops the secrets of every epoch before epoch and records that they
+// were shredded, so merging with an older copy of the archive does not bring
+// them back. It returns the epochs whose secrets it dropped.
+func (a *EpochKeyArchive) Shred(epoch int) []int {
+	var dropped []int
+	for _, e := range a.Epochs() {
+		if e < epoch {
+			dropped = append(dropped, e)
+		}
+	}
+	for e := range a.keys {
+		if e < epoch {
+			delete(a.keys, e)
+		}
+	}
+	for e := range a.wrapped {
+		if e < epoch {
+			delete(a.wrapped, e)
+		}
+	}
+	if epoch > a.shredBefore {
+		a.shredBefore = epoch
+	}
+	return dropped
+}
+
+// ShreddedBefore returns the epoch below which secrets were shredded, or 0.
+func (a *EpochKeyArchive) ShreddedBefore() int {
+	return a.shredBefore
+}
+
+// Cut records that the secret for epoch must never be wrapped under a
+// later epoch. Members who need it keep it outside the archive.
+func (a *EpochKeyArchive) Cut(epoch int) {
+	if epoch < a.shredBefore {
+		return
+	}
+	a.wrapped[epoch] = wrappedSecret{under: historyCut}
+}
+
+// ChainStart returns the earliest epoch whose secret can be unwrapped
+// starting from the secret of epoch alone.
+func (a *EpochKeyArchive) ChainStart(epoch int) int {
+	for {
+		w, ok := a.wrapped[epoch-1]
+		if !ok || w.under != epoch {
+			return epoch
+		}
+		epoch--
+	}
+}
+
+// Get retrieves the secret for an epoch, unwrapping it and any later
+// epochs it depends on.
 func (a *EpochKeyArchive) Get(epoch int) ([]byte, error) {
-	s, ok := a.keys[epoch]
-	if !ok {
-		return nil, fmt.Errorf("epoch %d not in archive", epoch)
+	if s, ok := a.keys[epoch]; ok {
+		return s, nil
 	}
-	return s, nil
+	// Follow the chain forward to the first epoch we hold in the clear,
+	// then unwrap back down to the one asked for.
+	var chain []int
+	for e := epoch; ; {
+		if _, ok := a.keys[e]; ok {
+			break
+		}
+		w, ok := a.wrapped[e]
+		if !ok || w.under == historyCut {
+			return nil, &MissingEpochError{Epoch: epoch}
+		}
+		if w.under <= e || len(chain) > len(a.wrapped) {
+			return nil, fmt.Errorf("epoch %d: malformed archive chain", epoch)
+		}
+		chain = append(chain, e)
+		e = w.under
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		e := chain[i]
+		w := a.wrapped[e]
+		secret, err := unwrapSecret(e, w, a.keys[w.under])
+		if err != nil {
+			return nil, err
+		}
+		a.keys[e] = secret
+	}
+	return a.keys[epoch], nil
 }
 
 // Has returns true if the epoch is in the archive.
 func (a *EpochKeyArchive) Has(epoch int) bool {
-	_, ok := a.keys[epoch]
-	return ok
+	if _, ok := a.keys[epoch]; ok {
+		return true
+	}
+	w, ok := a.wrapped[epoch]
+	return ok && w.under != historyCut
 }
 
 // Epochs returns sorted epoch numbers.
 func (a *EpochKeyArchive) Epochs() []int {
-	epochs := make([]int, 0, len(a.keys))
+	epochs := make([]int, 0, len(a.keys)+len(a.wrapped))
 	for k := range a.keys {
 		epochs = append(epochs, k)
 	}
-	// Simple insertion sort (small lists)
-	for i := 1; i < len(epochs); i++ {
-		for j := i; j > 0 && epochs[j-1] > epochs[j]; j-- {
-			epochs[j-1], epochs[j] = epochs[j], epochs[j-1]
+	for k, w := range a.wrapped {
+		if _, ok := a.keys[k]; !ok && w.under != historyCut {
+			epochs = append(epochs, k)
 		}
 	}
+	sort.Ints(epochs)
 	return epochs
 }
 
 // LatestEpoch returns the highest epoch number, or -1 if empty.
 func (a *EpochKeyArchive) LatestEpoch() int {
-	if len(a.keys) == 0 {
+	epochs := a.Epochs()
+	if len(epochs) == 0 {
 		return -1
 	}
-	max := -1
-	for k := range a.keys {
-		if k > max {
-			max = k
+	return epochs[len(epochs)-1]
+}
+
+// deriveArchiveKey derives the key that wraps the secret of epoch under
+// the secret of a later epoch. Binding the wrapped epoch into the key
+// stops entries from being swapped between lines.
+func deriveArchiveKey(epochSecret []byte, epoch int) []byte {
+	return crypto.DeriveFileKey(epochSecret, archiveKeyLabel, epoch)
+}
+
+func wrapSecret(epoch int, secret, underSecret []byte) ([]byte, error) {
+	nonce, ct, err := crypto.AESGCMEncrypt(deriveArchiveKey(underSecret, epoch), secret)
+	if err != nil {
+		return nil, fmt.Errorf("wrap epoch %d: %w", epoch, err)
+	}
+	return append(nonce, ct...), nil
+}
+
+func unwrapSecret(epoch int, w wrappedSecret, underSecret []byte) ([]byte, error) {
+	if len(w.data) < crypto.IVSize {
+		return nil, fmt.Errorf("epoch %d: archive entry too short", epoch)
+	}
+	secret, err := crypto.AESGCMDecrypt(deriveArchiveKey(underSecret, epoch), w.data[:crypto.IVSize], w.data[crypto.IVSize:])
+	if err != nil {
+		return nil, fmt.Errorf("unwrap epoch %d: %w", epoch, err)
+	}
+	return secret, nil
+}
+
+// Seal serializes the archive. Existing entries and cuts are kept byte
+// for byte and each known secret without an entry is wrapped under the
+// next known epoch, so a new epoch adds exactly one line. The newest
+// epoch's secret is never written; it comes from the group itself.
+func (a *EpochKeyArchive) Seal() ([]byte, error) {
+	known := make([]int, 0, len(a.keys))
+	for e := range a.keys {
+		known = append(known, e)
+	}
+	sort.Ints(known)
+	for i := 0; i+1 < len(known); i++ {
+		e, next := known[i], known[i+1]
+		if _, ok := a.wrapped[e]; ok {
+			continue
 		}
+		data, err := wrapSecret(e, a.keys[e], a.keys[next])
+		if err != nil {
+			return nil, err
+		}
+		a.wrapped[e] = wrappedSecret{under: next, data: data}
 	}
-	return max
+	return formatArchive(a.wrapped, a.shredBefore), nil
 }
 
-func (a *EpochKeyArchive) toJSONBytes() []byte {
-	obj := make(map[string]string)
-	for k, v := range a.keys {
-		obj[fmt.Sprintf("%d", k)] = crypto.B64Encode(v, true)
+func formatArchive(wrapped map[int]wrappedSecret, shredBefore int) []byte {
+	epochs := make([]int, 0, len(wrapped))
+	for e := range wrapped {
+		epochs = append(epochs, e)
+	}
+	sort.Ints(epochs)
+	var b bytes.Buffer
+	b.WriteString(archiveHeader + "\n")
+	if shredBefore > 0 {
+		fmt.Fprintf(&b, "%d shred\n", shredBefore)
+	}
+	for _, e := range epochs {
+		w := wrapped[e]
+		if w.under == historyCut {
+			fmt.Fprintf(&b, "%d cut\n", e)
+			continue
+		}
+		fmt.Fprintf(&b, "%d %d %s\n", e, w.under, crypto.B64Encode(w.data, false))
 	}
-	data, _ := json.Marshal(obj)
-	return data
+	return b.Bytes()
 }
 
-func epochKeyArchiveFromJSON(data []byte) (*EpochKeyArchive, error) {
-	var obj map[string]string
-	if err := json.Unmarshal(data, &obj); err != nil {
-		return nil, fmt.Errorf("unmarshal epoch archive: %w", err)
+// parseArchive reads the append-only format. A "N shred" line drops every
+// entry below epoch N.
+func parseArchive(data []byte) (map[int]wrappedSecret, int, error) {
+	wrapped := make(map[int]wrappedSecret)
+	shredBefore := 0
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for first := true; sc.Scan(); first = false {
+		line := strings.TrimSpace(sc.Text())
+		if first {
+			if line != archiveHeader {
+				return nil, 0, fmt.Errorf("not an epoch key archive")
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		var epoch, under int
+		var b64 string
+		if _, err := fmt.Sscanf(line, "%d cut", &epoch); err == nil && strings.HasSuffix(line, " cut") {
+			wrapped[epoch] = wrappedSecret{under: historyCut}
+			continue
+		}
+		if _, err := fmt.Sscanf(line, "%d shred", &epoch); err == nil && strings.HasSuffix(line, " shred") {
+			if epoch > shredBefore {
+				shredBefore = epoch
+			}
+			continue
+		}
+		if _, err := fmt.Sscanf(line, "%d %d %s", &epoch, &under, &b64); err != nil {
+			return nil, 0, fmt.Errorf("parse archive entry %q: %w", line, err)
+		}
+		raw, err := crypto.B64Decode(b64, false)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decode archive entry for epoch %d: %w", epoch, err)
+		}
+		wrapped[epoch] = wrappedSecret{under: under, data: raw}
 	}
-	a := NewEpochKeyArchive()
-	for k, v := range obj {
-		var epoch int
-		if _, err := fmt.Sscanf(k, "%d", &epoch); err != nil {
-			return nil, fmt.Errorf("parse epoch key %q: %w", k, err)
+	if err := sc.Err(); err != nil {
+		return nil, 0, err
+	}
+	for e := range wrapped {
+		if e < shredBefore {
+			delete(wrapped, e)
 		}
-		secret, err := crypto.B64Decode(v, true)
+	}
+	return wrapped, shredBefore, nil
+}
+
+func isLegacyArchive(data []byte) bool {
+	return !bytes.HasPrefix(bytes.TrimSpace(data), []byte(archiveHeader))
+}
+
+// DecryptArchive opens an archive file as seen from epoch, whose secret
+// is epochSecret. Nothing is unwrapped until Get asks for it. Archives in
+// the older single-blob format are decrypted whole; the next Seal writes
+// them out in the append-only format.
+func DecryptArchive(data []byte, epoch int, epochSecret []byte) (*EpochKeyArchive, error) {
+	if isLegacyArchive(data) {
+		a, err := decryptLegacyArchive(data, epochSecret)
 		if err != nil {
-			return nil, fmt.Errorf("decode epoch secret: %w", err)
+			return nil, err
+		}
+		a.Add(epoch, epochSecret)
+		return a, nil
+	}
+	wrapped, shredBefore, err := parseArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	a := NewEpochKeyArchive()
+	a.wrapped = wrapped
+	a.shredBefore = shredBefore
+	a.Add(epoch, epochSecret)
+	// Check the key against the entry wrapped directly under it, so a
+	// wrong secret fails here rather than on first use.
+	for e, w := range wrapped {
+		if w.under != epoch {
+			continue
+		}
+		if _, err := unwrapSecret(e, w, epochSecret); err != nil {
+			return nil, fmt.Errorf("decrypt archive: %w", err)
 		}
-		a.keys[epoch] = secret
 	}
 	return a, nil
 }
 
-func deriveArchiveKey(epochSecret []byte) []byte {
-	return crypto.DeriveFileKey(epochSecret, archiveKeyLabel, 0)
+// MergeArchives unions two append-only archive files, keeping our entry
+// where both sides wrapped the same epoch and honouring a shred on either
+// side. If either side is still in the single-blob format, ours is returned
+// unchanged.
+func MergeArchives(ours, theirs []byte) ([]byte, error) {
+	if isLegacyArchive(ours) || isLegacyArchive(theirs) {
+		return ours, nil
+	}
+	merged, ourShred, err := parseArchive(ours)
+	if err != nil {
+		return nil, fmt.Errorf("parse our archive: %w", err)
+	}
+	theirEntries, theirShred, err := parseArchive(theirs)
+	if err != nil {
+		return nil, fmt.Errorf("parse their archive: %w", err)
+	}
+	shredBefore := max(ourShred, theirShred)
+	for e, w := range theirEntries {
+		if _, ok := merged[e]; !ok {
+			merged[e] = w
+		}
+	}
+	for e := range merged {
+		if e < shredBefore {
+			delete(merged, e)
+		}
+	}
+	return formatArchive(merged, shredBefore), nil
 }
 
-// Encrypt encrypts the archive under a key derived from the epoch secret.
-// Returns ciphertext bytes (nonce || ciphertext || tag).
-func (a *EpochKeyArchive) Encrypt(currentEpochSecret []byte) ([]byte, error) {
-	plaintext := a.toJSONBytes()
-	archiveKey := deriveArchiveKey(currentEpochSecret)
-	nonce, ct, err := crypto.AESGCMEncrypt(archiveKey, plaintext)
-	if err != nil {
-		return nil, fmt.Errorf("encrypt archive: %w", err)
+// ShredFile rewrites an archive file from an older commit without the
+// secrets of the epochs a has shredded, so history no longer wraps them
+// under epochs members still hold. A file in the single-blob format is
+// opened with whichever of a's secrets it was encrypted under and rewritten
+// in the append-only format; if none opens it, only the shred is kept.
+func (a *EpochKeyArchive) ShredFile(data []byte) ([]byte, error) {
+	if !isLegacyArchive(data) {
+		wrapped, shredBefore, err := parseArchive(data)
+		if err != nil {
+			return nil, err
+		}
+		shredBefore = max(shredBefore, a.shredBefore)
+		for e := range wrapped {
+			if e < shredBefore {
+				delete(wrapped, e)
+			}
+		}
+		return formatArchive(wrapped, shredBefore), nil
 	}
-	return append(nonce, ct...), nil
+	for _, e := range a.Epochs() {
+		secret, err := a.Get(e)
+		if err != nil {
+			continue
+		}
+		legacy, err := decryptLegacyArchive(data, secret)
+		if err != nil {
+			continue
+		}
+		legacy.Shred(a.shredBefore)
+		return legacy.Seal()
+	}
+	return formatArchive(nil, a.shredBefore), nil
 }
 
-// DecryptArchive decrypts the archive using a key derived from the epoch secret.
-func DecryptArchive(data []byte, epochSecret []byte) (*EpochKeyArchive, error) {
-	archiveKey := deriveArchiveKey(epochSecret)
-	if len(data) < crypto.IVSize {
+// decryptLegacyArchive reads the single-blob format: base64 of a JSON map
+// of every epoch secret, encrypted under the newest epoch's secret.
+func decryptLegacyArchive(data []byte, epochSecret []byte) (*EpochKeyArchive, error) {
+	raw, err := crypto.B64Decode(strings.TrimSpace(string(data)), false)
+	if err != nil {
+		return nil, fmt.Errorf("decode archive: %w", err)
+	}
+	if len(raw) < crypto.IVSize {
 		return nil, fmt.Errorf("archive data too short")
 	}
-	nonce := data[:crypto.IVSize]
-	ct := data[crypto.IVSize:]
-	plaintext, err := crypto.AESGCMDecrypt(archiveKey, nonce, ct)
+	plaintext, err := crypto.AESGCMDecrypt(crypto.DeriveFileKey(epochSecret, archiveKeyLabel, 0), raw[:crypto.IVSize], raw[crypto.IVSize:])
 	if err != nil {
 		return nil, fmt.Errorf("decrypt archive: %w", err)
 	}
-	return epochKeyArchiveFromJSON(plaintext)
+	var obj map[string]string
+	if err := json.Unmarshal(plaintext, &obj); err != nil {
+		return nil, fmt.Errorf("unmarshal epoch archive: %w", err)
+	}
+	a := NewEpochKeyArchive()
+	for k, v := range obj {
+		var epoch int
+		if _, err := fmt.Sscanf(k, "%d", &epoch); err != nil {
+			return nil, fmt.Errorf("parse epoch key %q: %w", k, err)
+		}
+		secret, err := crypto.B64Decode(v, true)
+		if err != nil {
+			return nil, fmt.Errorf("decode epoch secret: %w", err)
+		}
+		a.keys[epoch] = secret
+	}
+	return a, nil
 }
@@ -2,21 +2,28 @@ package mls
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 func TestEpochKeyArchiveRoundtrip(t *testing.T) {
 	secret := bytes.Repeat([]byte{0x42}, 32)
 
+	secret1 := bytes.Repeat([]byte{0x43}, 32)
+
 	archive := NewWithSecret(0, secret)
-	archive.Add(1, bytes.Repeat([]byte{0x43}, 32))
+	archive.Add(1, secret1)
 
-	encrypted, err := archive.Encrypt(secret)
+	encrypted, err := archive.Seal()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	decrypted, err := DecryptArchive(encrypted, secret)
+	decrypted, err := DecryptArchive(encrypted, 1, secret1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,16 +82,16 @@ func TestEpochKeyArchiveDecryptWrongKey(t *testing.T) {
 	secret2 := bytes.Repeat([]byte{0x43}, 32)
 
 	archive := NewWithSecret(0, secret1)
-	encrypted, _ := archive.Encrypt(secret1)
+	archive.Add(1, secret2)
+	encrypted, _ := archive.Seal()
 
-	_, err := DecryptArchive(encrypted, secret2)
+	_, err := DecryptArchive(encrypted, 1, secret1)
 	if err == nil {
 		t.Fatal("expected error decrypting with wrong key")
 	}
 }
 
 func TestEpochKeyArchiveMultipleEpochs(t *testing.T) {
-	secret := bytes.Repeat([]byte{0x42}, 32)
 	archive := NewEpochKeyArchive()
 
 	for i := 0; i < 10; i++ {
@@ -92,12 +99,12 @@ func TestEpochKeyArchiveMultipleEpochs(t *testing.T) {
 		archive.Add(i, s)
 	}
 
-	encrypted, err := archive.Encrypt(secret)
+	encrypted, err := archive.Seal()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	decrypted, err := DecryptArchive(encrypted, secret)
+	decrypted, err := DecryptArchive(encrypted, 9, bytes.Repeat([]byte{9}, 32))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -113,3 +120,305 @@ func TestEpochKeyArchiveMultipleEpochs(t *testing.T) {
 		}
 	}
 }
+
+func epochSecret(epoch int) []byte {
+	return bytes.Repeat([]byte{byte(epoch + 1)}, 32)
+}
+
+func TestEpochKeyArchiveAppendOnly(t *testing.T) {
+	archive := NewWithSecret(0, epochSecret(0))
+	archive.Add(1, epochSecret(1))
+	before, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := DecryptArchive(before, 1, epochSecret(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Add(2, epochSecret(2))
+	after, err := reopened.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(after, before) {
+		t.Fatalf("sealing a new epoch rewrote existing entries:\n%s\n%s", before, after)
+	}
+	added := strings.Split(strings.TrimSpace(string(after[len(before):])), "\n")
+	if len(added) != 1 || !strings.HasPrefix(added[0], "1 2 ") {
+		t.Errorf("added lines = %q, want one entry for epoch 1 under 2", added)
+	}
+
+	final, err := DecryptArchive(after, 2, epochSecret(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for e := 0; e <= 2; e++ {
+		s, err := final.Get(e)
+		if err != nil {
+			t.Fatalf("epoch %d: %v", e, err)
+		}
+		if !bytes.Equal(s, epochSecret(e)) {
+			t.Errorf("epoch %d secret mismatch", e)
+		}
+	}
+}
+
+func TestEpochKeyArchiveUnwrapsLazily(t *testing.T) {
+	archive := NewEpochKeyArchive()
+	for e := 0; e < 5; e++ {
+		archive.Add(e, epochSecret(e))
+	}
+	data, _ := archive.Seal()
+
+	opened, err := DecryptArchive(data, 4, epochSecret(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := opened.Get(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := opened.keys[1]; ok {
+		t.Error("getting epoch 3 should not unwrap epoch 1")
+	}
+	if len(opened.Epochs()) != 5 {
+		t.Errorf("epochs = %v, want 0..4", opened.Epochs())
+	}
+}
+
+func TestEpochKeyArchiveRejectsSwappedEntries(t *testing.T) {
+	archive := NewEpochKeyArchive()
+	for e := 0; e < 3; e++ {
+		archive.Add(e, epochSecret(e))
+	}
+	data, _ := archive.Seal()
+	lines := strings.Split(string(data), "\n")
+	// Relabel epoch 1's entry as epoch 0, wrapped under 2.
+	lines[1] = "0 2 " + strings.Fields(lines[2])[2]
+
+	opened, err := DecryptArchive([]byte(strings.Join(lines, "\n")), 2, epochSecret(2))
+	if err == nil {
+		_, err = opened.Get(0)
+	}
+	if err == nil {
+		t.Error("expected relabelled entry to fail to unwrap")
+	}
+}
+
+func TestEpochKeyArchiveReadsLegacyFormat(t *testing.T) {
+	current := epochSecret(2)
+	plaintext, _ := json.Marshal(map[string]string{
+		"0": crypto.B64Encode(epochSecret(0), true),
+		"1": crypto.B64Encode(epochSecret(1), true),
+		"2": crypto.B64Encode(current, true),
+	})
+	nonce, ct, err := crypto.AESGCMEncrypt(crypto.DeriveFileKey(current, archiveKeyLabel, 0), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := []byte(crypto.B64Encode(append(nonce, ct...), false))
+
+	archive, err := DecryptArchive(legacy, 2, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(migrated, []byte(archiveHeader)) {
+		t.Fatalf("sealed archive not in append-only format:\n%s", migrated)
+	}
+
+	reopened, err := DecryptArchive(migrated, 2, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s0, err := reopened.Get(0)
+	if err != nil || !bytes.Equal(s0, epochSecret(0)) {
+		t.Errorf("epoch 0 after migration: %v", err)
+	}
+}
+
+func TestMergeArchivesUnion(t *testing.T) {
+	base := NewWithSecret(0, epochSecret(0))
+	base.Add(1, epochSecret(1))
+	baseData, _ := base.Seal()
+
+	ours, _ := DecryptArchive(baseData, 1, epochSecret(1))
+	ours.Add(2, epochSecret(2))
+	oursData, _ := ours.Seal()
+
+	theirs, _ := DecryptArchive(baseData, 1, epochSecret(1))
+	theirs.Add(2, bytes.Repeat([]byte{0xee}, 32))
+	theirs.Add(3, bytes.Repeat([]byte{0xff}, 32))
+	theirsData, _ := theirs.Seal()
+
+	merged, err := MergeArchives(oursData, theirsData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oursLines := strings.Split(strings.TrimSpace(string(oursData)), "\n")
+	for _, line := range oursLines {
+		if !strings.Contains(string(merged), line) {
+			t.Errorf("merged archive lost our line %q", line)
+		}
+	}
+	if !strings.Contains(string(merged), "\n2 3 ") {
+		t.Errorf("merged archive missing their epoch 2 entry:\n%s", merged)
+	}
+}
+
+func TestEpochKeyArchiveRemoveKeepsEarlierHistory(t *testing.T) {
+	archive := NewEpochKeyArchive()
+	for e := 0; e < 4; e++ {
+		archive.Add(e, epochSecret(e))
+	}
+	data, _ := archive.Seal()
+
+	// Abandon epochs 2 and 3 for a rebased epoch 2, as after a fork at 1.
+	opened, _ := DecryptArchive(data, 3, epochSecret(3))
+	opened.Remove(3)
+	opened.Remove(2)
+	rebased := bytes.Repeat([]byte{0xab}, 32)
+	opened.Add(2, rebased)
+	resealed, err := opened.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	final, err := DecryptArchive(resealed, 2, rebased)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for e := 0; e < 2; e++ {
+		s, err := final.Get(e)
+		if err != nil || !bytes.Equal(s, epochSecret(e)) {
+			t.Errorf("epoch %d lost after rebase: %v", e, err)
+		}
+	}
+}
+
+func TestEpochKeyArchiveCut(t *testing.T) {
+	archive := NewWithSecret(0, epochSecret(0))
+	archive.Add(1, epochSecret(1))
+	archive.Add(2, epochSecret(2))
+	archive.Cut(1)
+	data, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n1 cut\n") {
+		t.Errorf("sealed archive has no cut line:\n%s", data)
+	}
+
+	reopened, err := DecryptArchive(data, 2, epochSecret(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []int{0, 1} {
+		var missing *MissingEpochError
+		if _, err := reopened.Get(e); !errors.As(err, &missing) {
+			t.Errorf("epoch %d behind the cut: err = %v, want MissingEpochError", e, err)
+		}
+	}
+	if reopened.Has(1) {
+		t.Error("Has(1) = true for a cut epoch")
+	}
+	if got := reopened.ChainStart(2); got != 2 {
+		t.Errorf("ChainStart(2) = %d, want 2", got)
+	}
+	if got := reopened.ChainStart(1); got != 0 {
+		t.Errorf("ChainStart(1) = %d, want 0", got)
+	}
+
+	// A secret retained outside the archive opens the history before it
+	reopened.Add(1, epochSecret(1))
+	if s, err := reopened.Get(0); err != nil || !bytes.Equal(s, epochSecret(0)) {
+		t.Errorf("epoch 0 with epoch 1 retained: %v", err)
+	}
+}
+
+func TestEpochKeyArchiveShred(t *testing.T) {
+	archive := NewWithSecret(0, epochSecret(0))
+	for e := 1; e <= 3; e++ {
+		archive.Add(e, epochSecret(e))
+	}
+	before, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped := archive.Shred(2); len(dropped) != 2 || dropped[0] != 0 || dropped[1] != 1 {
+		t.Errorf("Shred(2) dropped %v, want [0 1]", dropped)
+	}
+	archive.Add(1, epochSecret(1))
+	after, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(after), "\n0 1 ") || strings.Contains(string(after), "\n1 2 ") {
+		t.Errorf("shredded entries still in the archive:\n%s", after)
+	}
+
+	// Merging with a copy from before the shred does not bring them back
+	merged, err := MergeArchives(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := DecryptArchive(merged, 3, epochSecret(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.ShreddedBefore() != 2 {
+		t.Errorf("ShreddedBefore = %d, want 2", reopened.ShreddedBefore())
+	}
+	for e := 0; e <= 3; e++ {
+		_, err := reopened.Get(e)
+		if (e < 2) != (err != nil) {
+			t.Errorf("epoch %d after shred and merge: %v", e, err)
+		}
+	}
+}
+
+func TestEpochKeyArchiveShredFile(t *testing.T) {
+	archive := NewWithSecret(0, epochSecret(0))
+	for e := 1; e <= 3; e++ {
+		archive.Add(e, epochSecret(e))
+	}
+	old, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, _ := json.Marshal(map[string]string{
+		"0": crypto.B64Encode(epochSecret(0), true),
+		"1": crypto.B64Encode(epochSecret(1), true),
+		"2": crypto.B64Encode(epochSecret(2), true),
+	})
+	nonce, ct, err := crypto.AESGCMEncrypt(crypto.DeriveFileKey(epochSecret(2), archiveKeyLabel, 0), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := []byte(crypto.B64Encode(append(nonce, ct...), false))
+
+	archive.Shred(2)
+	for _, data := range [][]byte{old, legacy} {
+		shredded, err := archive.ShredFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(shredded, []byte("\n2 shred\n")) {
+			t.Errorf("shredded copy does not record the shred:\n%s", shredded)
+		}
+		// Even the secret of epoch 2 opens nothing before it
+		reopened, err := DecryptArchive(shredded, 2, epochSecret(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reopened.Get(1); err == nil {
+			t.Errorf("epoch 1 recovered from a shredded copy:\n%s", shredded)
+		}
+	}
+}
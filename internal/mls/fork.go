@@ -0,0 +1,290 @@
+package mls
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// maxCheckpoints bounds how many past epochs a fork can be rebased from.
+const maxCheckpoints = 16
+
+// checkpoint is our local state just before an epoch change. It holds the
+// epoch secret and private keys of that epoch, so it never leaves
+// .git/mlsgit/.
+type checkpoint struct {
+	Epoch          uint64         `json:"epoch"`
+	EpochSecret    []byte         `json:"epoch_secret"`
+	Tree           ratchetTree    `json:"tree"`
+	PathKeys       map[int][]byte `json:"path_keys,omitempty"`
+	TranscriptHash []byte         `json:"transcript_hash,omitempty"`
+	InitPriv       []byte         `json:"init_priv"`
+}
+
+// ForkError reports that committed group state and our local state made
+// different transitions from the same epoch.
+type ForkError struct {
+	Epoch int // first epoch with conflicting transitions
+}
+
+func (e *ForkError) Error() string {
+	return fmt.Sprintf("group history forked at epoch %d", e.Epoch)
+}
+
+// RebasedOp describes one of our transitions replayed during Rebase.
+type RebasedOp struct {
+	Op        string // "add", "remove" or "update"
+	InitPub   []byte // init key of the member that was added or removed, or our new key
+	PQInitPub []byte // ML-KEM-768 half of our new key, for hybrid updates
+	Welcome   []byte // encrypted Welcome for a re-added member
+	Epoch     int    // epoch the op moved the group to
+	Skipped   string // non-empty if the op was dropped, with the reason
+}
+
+// RebaseResult is the outcome of Rebase.
+type RebaseResult struct {
+	ForkEpoch int
+	Ops       []RebasedOp
+	// Secrets holds the exported epoch secret for every epoch after the fork
+	// on the rebased history. Secrets for our abandoned epochs are no longer
+	// valid.
+	Secrets map[int][]byte
+}
+
+// checkpoint records the current state so a later fork at this epoch can be
+// rebased.
+func (g *MLSGitGroup) checkpoint() {
+	pathKeys := make(map[int][]byte, len(g.state.PathKeys))
+	for k, v := range g.state.PathKeys {
+		pathKeys[k] = v
+	}
+	cps := append(g.state.Checkpoints, checkpoint{
+		Epoch:          g.state.Epoch,
+		EpochSecret:    g.state.EpochSecret,
+		Tree:           g.state.Tree.clone(),
+		PathKeys:       pathKeys,
+		TranscriptHash: g.state.TranscriptHash,
+		InitPriv:       g.initPriv,
+	})
+	if len(cps) > maxCheckpoints {
+		cps = cps[len(cps)-maxCheckpoints:]
+	}
+	g.state.Checkpoints = cps
+}
+
+// forkEpoch returns the first epoch at which two transition logs record
+// different transitions.
+func forkEpoch(local, remote []transition) (uint64, bool) {
+	ours := make(map[uint64][]byte, len(local))
+	for _, t := range local {
+		ours[t.FromEpoch] = t.hash()
+	}
+	var epoch uint64
+	forked := false
+	for _, t := range remote {
+		h, ok := ours[t.FromEpoch]
+		if !ok || bytes.Equal(h, t.hash()) {
+			continue
+		}
+		if !forked || t.FromEpoch < epoch {
+			epoch = t.FromEpoch
+			forked = true
+		}
+	}
+	return epoch, forked
+}
+
+// DivergenceEpoch compares two committed group states. It returns -1 if one
+// history is a prefix of the other, or the first epoch at which they differ.
+func DivergenceEpoch(a, b []byte) (int, error) {
+	ca, err := parseCommitted(a)
+	if err != nil {
+		return 0, err
+	}
+	cb, err := parseCommitted(b)
+	if err != nil {
+		return 0, err
+	}
+	if epoch, forked := forkEpoch(ca.Transitions, cb.Transitions); forked {
+		return int(epoch), nil
+	}
+	return -1, nil
+}
+
+// CommittedEpoch returns the epoch of committed group state.
+func CommittedEpoch(data []byte) (int, error) {
+	c, err := parseCommitted(data)
+	if err != nil {
+		return 0, err
+	}
+	return int(c.Epoch), nil
+}
+
+// Rebase resolves a fork between our history and committed state from
+// another branch. It rewinds to our checkpoint at the fork epoch, follows
+// the other branch's signed transitions, then replays our own membership
+// changes on top: members we added are added again (with a fresh Welcome),
+// members we removed are removed again, and a self-update is redone with a
+// new init key. Changes made by other members on our branch cannot be
+// re-signed and are skipped. On error the local state is unchanged.
+func (g *MLSGitGroup) Rebase(theirsBytes []byte) (*RebaseResult, error) {
+	theirs, err := parseCommitted(theirsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal committed state: %w", err)
+	}
+	if !bytes.Equal(theirs.GroupID, g.state.GroupID) {
+		return nil, fmt.Errorf("committed state belongs to a different group")
+	}
+	fork, forked := forkEpoch(g.state.Transitions, theirs.Transitions)
+	if !forked {
+		return nil, fmt.Errorf("no fork between local and committed history")
+	}
+
+	// Find our state at the fork point, on the history both sides share.
+	var prevHash []byte
+	for _, t := range theirs.Transitions {
+		if t.FromEpoch == fork {
+			prevHash = t.PrevHash
+		}
+	}
+	var base *checkpoint
+	for i := len(g.state.Checkpoints) - 1; i >= 0; i-- {
+		cp := &g.state.Checkpoints[i]
+		if cp.Epoch == fork && (len(cp.TranscriptHash) == 0 || bytes.Equal(cp.TranscriptHash, prevHash)) {
+			base = cp
+			break
+		}
+	}
+	if base == nil {
+		return nil, fmt.Errorf("no local checkpoint at fork epoch %d", fork)
+	}
+
+	// Work out what our side did after the fork, in terms of member keys
+	// rather than leaf indices, by replaying our transitions on the base tree.
+	type pendingOp struct {
+		op                         string
+		sigPub, initPub, pqInitPub []byte
+		removed                    [][]byte // init keys of removed leaves
+		ours                       bool
+		historyCut                 bool
+	}
+	var pending []pendingOp
+	tree := base.Tree.clone()
+	prevHash = base.TranscriptHash
+	if len(prevHash) == 0 {
+		prevHash = genesisTranscriptHash(g.state.GroupID)
+	}
+	for _, t := range g.state.Transitions {
+		if t.FromEpoch < fork {
+			continue
+		}
+		var enc *updateEncap
+		for i := range g.state.UpdateEncaps {
+			if g.state.UpdateEncaps[i].FromEpoch == t.FromEpoch {
+				enc = &g.state.UpdateEncaps[i]
+			}
+		}
+		p := pendingOp{op: t.Op, ours: t.Signer == g.state.OwnLeafIndex, historyCut: t.HistoryCut}
+		switch t.Op {
+		case OpAdd:
+			p.sigPub, p.initPub, p.pqInitPub = t.SigPub, t.InitPub, t.PQInitPub
+		case OpRemove:
+			for _, idx := range t.removedLeaves() {
+				if leaf := tree.leaf(idx); leaf != nil {
+					p.removed = append(p.removed, leaf.PublicKey)
+				}
+			}
+			if len(p.removed) > 0 {
+				p.initPub = p.removed[0]
+			}
+		}
+		next, err := verifyTransition(g.state.GroupID, &tree, prevHash, t, enc)
+		if err != nil {
+			return nil, fmt.Errorf("replay local transition from epoch %d: %w", t.FromEpoch, err)
+		}
+		tree, prevHash = next, t.hash()
+		pending = append(pending, p)
+	}
+
+	// Rewind to the fork point and follow the other branch.
+	ng := &MLSGitGroup{sigKey: g.sigKey, initPriv: base.InitPriv, authorize: g.authorize, postQuantum: g.postQuantum}
+	ng.state = groupState{
+		GroupID:        g.state.GroupID,
+		Epoch:          base.Epoch,
+		EpochSecret:    base.EpochSecret,
+		Tree:           base.Tree.clone(),
+		OwnLeafIndex:   g.state.OwnLeafIndex,
+		PathKeys:       make(map[int][]byte, len(base.PathKeys)),
+		TranscriptHash: base.TranscriptHash,
+	}
+	for k, v := range base.PathKeys {
+		ng.state.PathKeys[k] = v
+	}
+	for e, s := range g.state.Retained {
+		if e <= fork {
+			ng.retain(e, s)
+		}
+	}
+	if !ng.stillMember(&theirs.Tree) {
+		return nil, fmt.Errorf("we were removed from the group on the other branch")
+	}
+	for _, cp := range g.state.Checkpoints {
+		if cp.Epoch < fork {
+			ng.state.Checkpoints = append(ng.state.Checkpoints, cp)
+		}
+	}
+	res := &RebaseResult{ForkEpoch: int(fork), Secrets: make(map[int][]byte)}
+	record := func() { res.Secrets[ng.Epoch()] = ng.ExportEpochSecret() }
+	if err := ng.applyTransitions(theirs, record); err != nil {
+		return nil, fmt.Errorf("follow other branch: %w", err)
+	}
+	ng.adoptCommitted(theirs)
+
+	// Replay our side's membership changes.
+	for _, p := range pending {
+		op := RebasedOp{Op: p.op, InitPub: p.initPub}
+		switch p.op {
+		case OpAdd:
+			if ng.state.Tree.findLeaf(p.initPub) >= 0 {
+				op.Skipped = "already a member"
+				break
+			}
+			_, welcome, err := ng.addMember(KeyPackageData{SigPub: p.sigPub, InitPub: p.initPub, PQInitPub: p.pqInitPub}, p.historyCut)
+			if err != nil {
+				return nil, fmt.Errorf("re-add member: %w", err)
+			}
+			op.Welcome = welcome
+		case OpRemove:
+			var leaves []int
+			for _, pub := range p.removed {
+				if idx := ng.state.Tree.findLeaf(pub); idx >= 0 && idx != ng.state.OwnLeafIndex {
+					leaves = append(leaves, idx)
+				}
+			}
+			if len(leaves) == 0 {
+				op.Skipped = "already removed"
+				break
+			}
+			if _, err := ng.RemoveMembers(leaves); err != nil {
+				return nil, fmt.Errorf("re-remove member: %w", err)
+			}
+		case OpUpdate:
+			if !p.ours {
+				op.Skipped = "key update by another member"
+				break
+			}
+			if _, err := ng.SelfUpdate(); err != nil {
+				return nil, fmt.Errorf("redo self update: %w", err)
+			}
+			op.InitPub, op.PQInitPub = ng.InitPub(), ng.PQInitPub()
+		}
+		if op.Skipped == "" {
+			op.Epoch = ng.Epoch()
+			record()
+		}
+		res.Ops = append(res.Ops, op)
+	}
+
+	g.state = ng.state
+	g.initPriv = ng.initPriv
+	return res, nil
+}
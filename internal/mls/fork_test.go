@@ -0,0 +1,155 @@
+package mls
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSyncDetectsFork(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	xKeys, _ := GenerateMLSKeys()
+	alice.AddMember(BuildKeyPackage([]byte("x"), xKeys))
+	if _, err := bob.SelfUpdate(); err != nil {
+		t.Fatal(err)
+	}
+
+	committed, _ := alice.ToCommittedBytes()
+	_, err := bob.SyncFromCommitted(committed)
+	var fe *ForkError
+	if !errors.As(err, &fe) {
+		t.Fatalf("err = %v, want ForkError", err)
+	}
+	if fe.Epoch != 1 {
+		t.Errorf("fork epoch = %d, want 1", fe.Epoch)
+	}
+
+	ours, _ := bob.ToCommittedBytes()
+	if epoch, err := DivergenceEpoch(ours, committed); err != nil || epoch != 1 {
+		t.Errorf("DivergenceEpoch = %d, %v; want 1", epoch, err)
+	}
+}
+
+func TestDivergenceEpochLinear(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice := members[0]
+	before, _ := alice.ToCommittedBytes()
+	alice.SelfUpdate()
+	after, _ := alice.ToCommittedBytes()
+	if epoch, err := DivergenceEpoch(before, after); err != nil || epoch != -1 {
+		t.Errorf("DivergenceEpoch = %d, %v; want -1", epoch, err)
+	}
+}
+
+func TestRebaseConverges(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice, bob, carol := members[0], members[1], members[2]
+
+	// Concurrently: alice adds x, bob removes carol and refreshes his keys.
+	xKeys, _ := GenerateMLSKeys()
+	_, xWelcome, err := alice.AddMember(BuildKeyPackage([]byte("x"), xKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.RemoveMember(carol.OwnLeafIndex()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.SelfUpdate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice's branch lands first; bob rebases onto it.
+	theirs, _ := alice.ToCommittedBytes()
+	res, err := bob.Rebase(theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ForkEpoch != 2 {
+		t.Errorf("fork epoch = %d, want 2", res.ForkEpoch)
+	}
+	if len(res.Ops) != 2 || res.Ops[0].Op != OpRemove || res.Ops[1].Op != OpUpdate {
+		t.Fatalf("ops = %+v", res.Ops)
+	}
+	if bob.Epoch() != alice.Epoch()+2 {
+		t.Errorf("bob epoch = %d, want %d", bob.Epoch(), alice.Epoch()+2)
+	}
+	if !bytes.Equal(res.Secrets[bob.Epoch()], bob.ExportEpochSecret()) {
+		t.Error("rebase should report the secret of the final epoch")
+	}
+	if bob.FindLeafIndex(carol.InitPub()) >= 0 {
+		t.Error("carol should still be removed after rebase")
+	}
+
+	// Alice and x follow the rebased history linearly.
+	rebased, _ := bob.ToCommittedBytes()
+	if ok, err := alice.SyncFromCommitted(rebased); !ok {
+		t.Fatalf("alice sync: %v", err)
+	}
+	x, err := JoinFromWelcome(xWelcome, xKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := x.SyncFromCommitted(rebased); !ok {
+		t.Fatalf("x sync: %v", err)
+	}
+	for name, m := range map[string]*MLSGitGroup{"alice": alice, "x": x} {
+		if !bytes.Equal(m.ExportEpochSecret(), bob.ExportEpochSecret()) {
+			t.Errorf("%s epoch secret differs from bob's", name)
+		}
+	}
+	if ok, _ := carol.SyncFromCommitted(rebased); ok {
+		t.Error("removed member should not follow the rebased history")
+	}
+}
+
+func TestRebaseReaddsMember(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	xKeys, _ := GenerateMLSKeys()
+	alice.AddMember(BuildKeyPackage([]byte("x"), xKeys))
+	yKeys, _ := GenerateMLSKeys()
+	bob.AddMember(BuildKeyPackage([]byte("y"), yKeys))
+
+	theirs, _ := alice.ToCommittedBytes()
+	res, err := bob.Rebase(theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Ops) != 1 || res.Ops[0].Welcome == nil {
+		t.Fatalf("ops = %+v, want one re-add with a welcome", res.Ops)
+	}
+	y, err := JoinFromWelcome(res.Ops[0].Welcome, yKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := alice.SyncFromCommitted(mustCommitted(t, bob)); !ok {
+		t.Fatalf("alice sync: %v", err)
+	}
+	if !bytes.Equal(y.ExportEpochSecret(), alice.ExportEpochSecret()) {
+		t.Error("re-added member should share the rebased epoch secret")
+	}
+	if alice.MemberCount() != 4 {
+		t.Errorf("member count = %d, want 4", alice.MemberCount())
+	}
+}
+
+func TestRebaseWithoutForkFails(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+	alice.SelfUpdate()
+	if _, err := bob.Rebase(mustCommitted(t, alice)); err == nil {
+		t.Error("rebase of a linear history should fail")
+	}
+}
+
+func mustCommitted(t *testing.T, g *MLSGitGroup) []byte {
+	t.Helper()
+	data, err := g.ToCommittedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -2,17 +2,23 @@
 //
 // This is a self-contained implementation providing MLS-like semantics
 // (epoch advancement, epoch secret derivation, member add/remove)
-// using Ed25519 for signing, X25519 for DH-based rekeying, and HKDF
-// for key derivation. Member removal uses DH encapsulation to ensure
-// forward secrecy: removed members cannot derive future epoch secrets.
+// using Ed25519 for signing, X25519 (optionally combined with ML-KEM-768)
+// for DH-based rekeying, and HKDF for key derivation. Members are the leaves of a TreeKEM ratchet tree:
+// member removal and self-update re-key the committer's direct path and
+// encrypt each new path secret only to the resolution of the matching
+// copath node, so a commit costs O(log n) encapsulations while still
+// ensuring removed members (or holders of stale keys) cannot derive future
+// epoch secrets.
 package mls
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 
@@ -23,10 +29,11 @@ import (
 
 // MLSKeys bundles keys generated for an MLS member.
 type MLSKeys struct {
-	SigPriv  ed25519.PrivateKey // Ed25519 signing private key
-	SigPub   ed25519.PublicKey  // Ed25519 signing public key
-	InitPriv []byte             // X25519 private key (32 bytes)
-	InitPub  []byte             // X25519 public key (32 bytes)
+	SigPriv   ed25519.PrivateKey // Ed25519 signing private key
+	SigPub    ed25519.PublicKey  // Ed25519 signing public key
+	InitPriv  []byte             // X25519 private key, or hybrid seed (32 bytes)
+	InitPub   []byte             // X25519 public key (32 bytes)
+	PQInitPub []byte             // ML-KEM-768 public key, hybrid keys only
 }
 
 // GenerateMLSKeys generates all keys needed for MLS membership.
@@ -53,78 +60,199 @@ func GenerateMLSKeys() (MLSKeys, error) {
 	}, nil
 }
 
-// KeyPackageData holds the serializable key package for a member.
+// GenerateHybridMLSKeys is GenerateMLSKeys with an X25519 + ML-KEM-768
+// hybrid init key. InitPriv holds the seed both halves are derived from.
+func GenerateHybridMLSKeys() (MLSKeys, error) {
+	keys, err := GenerateMLSKeys()
+	if err != nil {
+		return MLSKeys{}, err
+	}
+	_, keys.InitPub, keys.PQInitPub, err = mlscrypto.HybridKeys(keys.InitPriv)
+	if err != nil {
+		return MLSKeys{}, err
+	}
+	return keys, nil
+}
+
+// KeyPackageData holds the serializable key package for a member. The
+// signature, made with the key package's own signing key, proves that the
+// requester holds SigPriv.
 type KeyPackageData struct {
-	Identity []byte `json:"identity"`
-	SigPub   []byte `json:"sig_pub"`
-	InitPub  []byte `json:"init_pub"`
+	Identity  []byte `json:"identity"`
+	SigPub    []byte `json:"sig_pub"`
+	InitPub   []byte `json:"init_pub"`
+	PQInitPub []byte `json:"pq_init_pub,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
 }
 
-// BuildKeyPackage builds a serializable key package.
+// BuildKeyPackage builds a serializable key package signed with keys.SigPriv.
 func BuildKeyPackage(identity []byte, keys MLSKeys) KeyPackageData {
-	return KeyPackageData{
-		Identity: identity,
-		SigPub:   keys.SigPub,
-		InitPub:  keys.InitPub,
+	kp := KeyPackageData{
+		Identity:  identity,
+		SigPub:    keys.SigPub,
+		InitPub:   keys.InitPub,
+		PQInitPub: keys.PQInitPub,
+	}
+	kp.Sign(keys.SigPriv)
+	return kp
+}
+
+// signedBytes returns the canonical bytes covered by the key package
+// signature. The ML-KEM key is only appended when present, so X25519-only
+// key packages keep their existing signatures.
+func (kp KeyPackageData) signedBytes() []byte {
+	var b bytes.Buffer
+	b.WriteString("mlsgit-keypackage")
+	fields := [][]byte{kp.Identity, kp.SigPub, kp.InitPub}
+	if len(kp.PQInitPub) > 0 {
+		fields = append(fields, kp.PQInitPub)
 	}
+	for _, f := range fields {
+		binary.Write(&b, binary.BigEndian, uint32(len(f)))
+		b.Write(f)
+	}
+	return b.Bytes()
 }
 
-// updateEncap holds DH-based key encapsulation data for a single epoch
-// transition. Produced during RemoveMember, consumed during sync.
+// Sign (re-)signs the key package, e.g. after its init key changes.
+func (kp *KeyPackageData) Sign(sigPriv ed25519.PrivateKey) {
+	kp.Signature = ed25519.Sign(sigPriv, kp.signedBytes())
+}
+
+// Verify checks that the key package is signed by its own SigPub.
+func (kp KeyPackageData) Verify() error {
+	if len(kp.SigPub) != ed25519.PublicKeySize {
+		return fmt.Errorf("key package has an invalid signature key")
+	}
+	if !ed25519.Verify(kp.SigPub, kp.signedBytes(), kp.Signature) {
+		return fmt.Errorf("key package signature does not verify")
+	}
+	return nil
+}
+
+// SignKeyPackage signs kp with this member's leaf signing key.
+func (g *MLSGitGroup) SignKeyPackage(kp *KeyPackageData) {
+	kp.Sign(g.sigKey)
+}
+
+// updateEncap records a single TreeKEM commit that carried an update path.
+// Produced during RemoveMember and SelfUpdate, consumed during sync.
 type updateEncap struct {
-	FromEpoch uint64       `json:"from_epoch"` // epoch BEFORE the transition
-	EphPub    []byte       `json:"eph_pub"`    // ephemeral X25519 public key
-	Entries   []encapEntry `json:"entries"`     // per-member encrypted update secrets
+	FromEpoch uint64     `json:"from_epoch"` // epoch BEFORE the transition
+	Committer int        `json:"committer"`  // leaf index of the committing member
+	Path      []pathNode `json:"path"`       // committer's direct path, bottom up
+}
+
+// pathNode is one re-keyed node on the committer's direct path.
+type pathNode struct {
+	Node        int          `json:"node"`
+	PublicKey   []byte       `json:"public_key"`
+	PQPublicKey []byte       `json:"pq_public_key,omitempty"`
+	Entries     []encapEntry `json:"entries"` // path secret for each copath resolution node
 }
 
-// encapEntry holds the encrypted update secret for a single member.
+// encapEntry holds a path secret encrypted to a single tree node. Entries
+// for hybrid nodes also carry an ML-KEM-768 ciphertext.
 type encapEntry struct {
-	LeafIndex  int    `json:"leaf_index"`
-	Ciphertext []byte `json:"ciphertext"` // nonce || AES-GCM(update_secret)
+	Node          int    `json:"node"`                     // recipient node index
+	EphPub        []byte `json:"eph_pub"`                  // ephemeral X25519 public key
+	KEMCiphertext []byte `json:"kem_ciphertext,omitempty"` // ML-KEM-768 ciphertext, hybrid nodes only
+	Ciphertext    []byte `json:"ciphertext"`               // nonce || AES-GCM(path_secret)
 }
 
 // groupState is the serializable internal state.
 type groupState struct {
-	GroupID      []byte        `json:"group_id"`
-	Epoch        uint64        `json:"epoch"`
-	EpochSecret  []byte        `json:"epoch_secret"`
-	Members      []memberEntry `json:"members"`
-	OwnLeafIndex int           `json:"own_leaf_index"`
-	UpdateEncaps []updateEncap `json:"update_encaps,omitempty"`
-}
-
-type memberEntry struct {
+	GroupID      []byte         `json:"group_id"`
+	Epoch        uint64         `json:"epoch"`
+	EpochSecret  []byte         `json:"epoch_secret"`
+	Tree         ratchetTree    `json:"tree"`
+	OwnLeafIndex int            `json:"own_leaf_index"`
+	PathKeys     map[int][]byte `json:"path_keys,omitempty"` // parent node -> X25519 private key
+	UpdateEncaps []updateEncap  `json:"update_encaps,omitempty"`
+
+	// Transitions is the signed, hash-chained log of epoch changes and
+	// TranscriptHash the hash of the last one (or the genesis anchor).
+	Transitions    []transition `json:"transitions,omitempty"`
+	TranscriptHash []byte       `json:"transcript_hash,omitempty"`
+
+	// Checkpoints hold our state just before recent epoch changes so a
+	// forked history can be rebased from the fork point.
+	Checkpoints []checkpoint `json:"checkpoints,omitempty"`
+
+	// Retained holds exported secrets of past epochs that the epoch key
+	// archive does not lead back to: epochs just before a history cut, and
+	// epochs granted to us with GrantHistory.
+	Retained map[uint64][]byte `json:"retained,omitempty"`
+
+	// LegacyMembers is the flat member list written before the ratchet
+	// tree was introduced. It is only read, and converted on load.
+	LegacyMembers []legacyMember `json:"members,omitempty"`
+}
+
+// legacyMember is a member entry from the pre-tree flat member list.
+type legacyMember struct {
 	SigPub  []byte `json:"sig_pub"`
 	InitPub []byte `json:"init_pub"`
 	Active  bool   `json:"active"`
 }
 
 // committedGroupState is the subset of group state that is safe to commit
-// to git. It deliberately excludes EpochSecret and OwnLeafIndex.
-// UpdateEncaps are included so other members can perform DH-based sync
-// after removals.
+// to git. It deliberately excludes EpochSecret, OwnLeafIndex and PathKeys.
+// UpdateEncaps are included so other members can perform TreeKEM sync
+// after removals and self-updates, and Transitions so they can verify who
+// made each epoch change.
 type committedGroupState struct {
-	GroupID      []byte        `json:"group_id"`
-	Epoch        uint64        `json:"epoch"`
-	Members      []memberEntry `json:"members"`
-	UpdateEncaps []updateEncap `json:"update_encaps,omitempty"`
+	GroupID       []byte         `json:"group_id"`
+	Epoch         uint64         `json:"epoch"`
+	Tree          ratchetTree    `json:"tree"`
+	UpdateEncaps  []updateEncap  `json:"update_encaps,omitempty"`
+	Transitions   []transition   `json:"transitions,omitempty"`
+	LegacyMembers []legacyMember `json:"members,omitempty"`
 }
 
 // WelcomeData holds the data sent to a new member joining the group.
 type WelcomeData struct {
-	GroupID      []byte        `json:"group_id"`
-	Epoch        uint64        `json:"epoch"`
-	EpochSecret  []byte        `json:"epoch_secret"`
-	Members      []memberEntry `json:"members"`
-	LeafIndex    int           `json:"leaf_index"`
-	UpdateEncaps []updateEncap `json:"update_encaps,omitempty"`
+	GroupID        []byte        `json:"group_id"`
+	Epoch          uint64        `json:"epoch"`
+	EpochSecret    []byte        `json:"epoch_secret"`
+	Tree           ratchetTree   `json:"tree"`
+	LeafIndex      int           `json:"leaf_index"`
+	UpdateEncaps   []updateEncap `json:"update_encaps,omitempty"`
+	Transitions    []transition  `json:"transitions,omitempty"`
+	TranscriptHash []byte        `json:"transcript_hash,omitempty"`
+}
+
+// treeFromLegacy rebuilds a ratchet tree with blank parents from a flat
+// member list, keeping every member at its old leaf index.
+func treeFromLegacy(members []legacyMember) ratchetTree {
+	t := ratchetTree{}
+	for t.leafCount() < len(members) {
+		t.extend()
+	}
+	for i, m := range members {
+		if m.Active {
+			t.Nodes[2*i] = &treeNode{PublicKey: m.InitPub, SigPub: m.SigPub}
+		}
+	}
+	return t
 }
 
 // MLSGitGroup wraps MLS group state for mlsgit's needs.
 type MLSGitGroup struct {
 	state    groupState
 	sigKey   ed25519.PrivateKey
-	initPriv []byte // X25519 private key for DH operations during sync
+	initPriv []byte // X25519 private key (or hybrid seed) for our leaf, used during sync
+
+	authorize   AuthorizeFunc // optional policy check on synced transitions
+	postQuantum bool          // re-key with the hybrid KEM
+}
+
+// SetPostQuantum selects the X25519 + ML-KEM-768 hybrid KEM for the init
+// and path keys this member generates from now on. Keys already in the
+// tree keep their type until their owner re-keys them, so an X25519-only
+// group migrates as each member runs a self-update.
+func (g *MLSGitGroup) SetPostQuantum(enabled bool) {
+	g.postQuantum = enabled
 }
 
 // Create creates a new MLS group with the creator as the sole member.
@@ -137,15 +265,12 @@ func Create(groupID, identity []byte, keys MLSKeys) (*MLSGitGroup, error) {
 
 	g := &MLSGitGroup{
 		state: groupState{
-			GroupID:     groupID,
-			Epoch:       0,
-			EpochSecret: epochSecret,
-			Members: []memberEntry{{
-				SigPub:  keys.SigPub,
-				InitPub: keys.InitPub,
-				Active:  true,
-			}},
-			OwnLeafIndex: 0,
+			GroupID:        groupID,
+			Epoch:          0,
+			EpochSecret:    epochSecret,
+			Tree:           newRatchetTree(keys.SigPub, keys.InitPub, keys.PQInitPub),
+			OwnLeafIndex:   0,
+			TranscriptHash: genesisTranscriptHash(groupID),
 		},
 		sigKey:   keys.SigPriv,
 		initPriv: keys.InitPriv,
@@ -165,19 +290,27 @@ func JoinFromWelcome(encryptedWelcome []byte, keys MLSKeys) (*MLSGitGroup, error
 	if err := json.Unmarshal(welcomeBytes, &w); err != nil {
 		return nil, fmt.Errorf("unmarshal welcome: %w", err)
 	}
+	if err := w.Tree.checkLeaf(w.LeafIndex); err != nil {
+		return nil, fmt.Errorf("welcome leaf: %w", err)
+	}
 
 	g := &MLSGitGroup{
 		state: groupState{
-			GroupID:      w.GroupID,
-			Epoch:        w.Epoch,
-			EpochSecret:  w.EpochSecret,
-			Members:      w.Members,
-			OwnLeafIndex: w.LeafIndex,
-			UpdateEncaps: w.UpdateEncaps,
+			GroupID:        w.GroupID,
+			Epoch:          w.Epoch,
+			EpochSecret:    w.EpochSecret,
+			Tree:           w.Tree,
+			OwnLeafIndex:   w.LeafIndex,
+			UpdateEncaps:   w.UpdateEncaps,
+			Transitions:    w.Transitions,
+			TranscriptHash: w.TranscriptHash,
 		},
 		sigKey:   keys.SigPriv,
 		initPriv: keys.InitPriv,
 	}
+	if len(g.state.TranscriptHash) == 0 {
+		g.state.TranscriptHash = genesisTranscriptHash(w.GroupID)
+	}
 	return g, nil
 }
 
@@ -187,6 +320,13 @@ func FromBytes(data []byte, sigPriv ed25519.PrivateKey, initPriv []byte) (*MLSGi
 	if err := json.Unmarshal(data, &s); err != nil {
 		return nil, fmt.Errorf("unmarshal group state: %w", err)
 	}
+	if len(s.Tree.Nodes) == 0 && len(s.LegacyMembers) > 0 {
+		s.Tree = treeFromLegacy(s.LegacyMembers)
+	}
+	s.LegacyMembers = nil
+	if len(s.TranscriptHash) == 0 {
+		s.TranscriptHash = genesisTranscriptHash(s.GroupID)
+	}
 	return &MLSGitGroup{state: s, sigKey: sigPriv, initPriv: initPriv}, nil
 }
 
@@ -196,36 +336,24 @@ func (g *MLSGitGroup) ToBytes() ([]byte, error) {
 }
 
 // ToCommittedBytes serializes the group state for committing to git.
-// The output deliberately excludes EpochSecret and OwnLeafIndex so that
-// anyone with repo read access cannot derive file encryption keys.
-// UpdateEncaps are included so other members can perform DH-based sync.
+// The output deliberately excludes EpochSecret, OwnLeafIndex and the
+// private path keys so that anyone with repo read access cannot derive
+// file encryption keys. UpdateEncaps are included so other members can
+// perform TreeKEM sync, and Transitions so they can authenticate it.
 func (g *MLSGitGroup) ToCommittedBytes() ([]byte, error) {
 	return json.Marshal(committedGroupState{
 		GroupID:      g.state.GroupID,
 		Epoch:        g.state.Epoch,
-		Members:      g.state.Members,
+		Tree:         g.state.Tree,
 		UpdateEncaps: g.state.UpdateEncaps,
+		Transitions:  g.state.Transitions,
 	})
 }
 
 // FindLeafIndex returns the leaf index for a member identified by their InitPub.
 // Returns -1 if not found.
 func (g *MLSGitGroup) FindLeafIndex(initPub []byte) int {
-	for i, m := range g.state.Members {
-		if m.Active && len(m.InitPub) == len(initPub) {
-			match := true
-			for j := range m.InitPub {
-				if m.InitPub[j] != initPub[j] {
-					match = false
-					break
-				}
-			}
-			if match {
-				return i
-			}
-		}
-	}
-	return -1
+	return g.state.Tree.findLeaf(initPub)
 }
 
 // Epoch returns the current epoch number.
@@ -235,13 +363,7 @@ func (g *MLSGitGroup) Epoch() int {
 
 // MemberCount returns the number of active members.
 func (g *MLSGitGroup) MemberCount() int {
-	count := 0
-	for _, m := range g.state.Members {
-		if m.Active {
-			count++
-		}
-	}
-	return count
+	return g.state.Tree.memberCount()
 }
 
 // OwnLeafIndex returns this member's leaf index.
@@ -254,6 +376,39 @@ func (g *MLSGitGroup) SigPriv() []byte {
 	return g.sigKey.Seed()
 }
 
+// InitPriv returns the X25519 private key, or hybrid seed, for our leaf.
+func (g *MLSGitGroup) InitPriv() []byte {
+	return g.initPriv
+}
+
+// InitPub returns the X25519 public key currently published at our leaf.
+func (g *MLSGitGroup) InitPub() []byte {
+	if leaf := g.state.Tree.leaf(g.state.OwnLeafIndex); leaf != nil {
+		return leaf.PublicKey
+	}
+	return nil
+}
+
+// HoldsInitKey reports whether priv is the private key of the init key
+// published at our leaf.
+func (g *MLSGitGroup) HoldsInitKey(priv []byte) bool {
+	leaf := g.state.Tree.leaf(g.state.OwnLeafIndex)
+	if leaf == nil {
+		return false
+	}
+	pub, _, err := nodePublicKeys(priv, leaf.hybrid())
+	return err == nil && bytes.Equal(pub, leaf.PublicKey)
+}
+
+// PQInitPub returns the ML-KEM-768 public key at our leaf, or nil if our
+// leaf has an X25519-only init key.
+func (g *MLSGitGroup) PQInitPub() []byte {
+	if leaf := g.state.Tree.leaf(g.state.OwnLeafIndex); leaf != nil {
+		return leaf.PQPublicKey
+	}
+	return nil
+}
+
 // ExportEpochSecret derives the epoch application secret for file encryption.
 // label="mlsgit-epoch-secret", context="", length=32
 func (g *MLSGitGroup) ExportEpochSecret() []byte {
@@ -270,6 +425,51 @@ func exportSecret(epochSecret, label, context []byte, length int) []byte {
 	return out
 }
 
+// deriveSecret expands a TreeKEM secret under a label (path or node).
+func deriveSecret(secret []byte, label string) []byte {
+	r := hkdf.New(sha256.New, secret, nil, []byte("mlsgit-tree-"+label))
+	out := make([]byte, 32)
+	if _, err := io.ReadFull(r, out); err != nil {
+		panic(fmt.Sprintf("hkdf tree: %v", err))
+	}
+	return out
+}
+
+// deriveNodeKeypair derives the keypair for a parent node from its path
+// secret. The private key is an X25519 key, or a hybrid seed.
+func deriveNodeKeypair(pathSecret []byte, hybrid bool) (priv, pub, pqPub []byte, err error) {
+	priv = deriveSecret(pathSecret, "node")
+	pub, pqPub, err = nodePublicKeys(priv, hybrid)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("derive node public key: %w", err)
+	}
+	return priv, pub, pqPub, nil
+}
+
+// nodePublicKeys returns the public keys for a node private key: the
+// X25519 key itself, or a hybrid seed.
+func nodePublicKeys(priv []byte, hybrid bool) (pub, pqPub []byte, err error) {
+	if hybrid {
+		_, pub, pqPub, err = mlscrypto.HybridKeys(priv)
+		return pub, pqPub, err
+	}
+	pub, err = curve25519.X25519(priv, curve25519.Basepoint)
+	return pub, nil, err
+}
+
+// newInitKey generates a leaf init key of the configured type.
+func (g *MLSGitGroup) newInitKey() (priv, pub, pqPub []byte, err error) {
+	priv = make([]byte, 32)
+	if _, err := rand.Read(priv); err != nil {
+		return nil, nil, nil, fmt.Errorf("generate init key: %w", err)
+	}
+	pub, pqPub, err = nodePublicKeys(priv, g.postQuantum)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("derive init public key: %w", err)
+	}
+	return priv, pub, pqPub, nil
+}
+
 // advanceEpoch performs a deterministic epoch advance.
 // Used for add operations where no member is being excluded.
 func (g *MLSGitGroup) advanceEpoch() {
@@ -284,70 +484,132 @@ func (g *MLSGitGroup) advanceEpoch() {
 	g.state.Epoch++
 }
 
-// advanceEpochDH performs a DH-based epoch advance for member removal.
-// It generates a fresh update secret, encrypts it to each remaining active
-// member using X25519 DH, and mixes it into the epoch derivation. This
-// ensures removed members cannot derive the new epoch secret.
+// advanceEpochWithSecret mixes a commit secret into the epoch derivation:
+// HKDF(oldSecret || commitSecret, epoch, info). The commit secret provides
+// entropy that a removed member cannot obtain.
+func (g *MLSGitGroup) advanceEpochWithSecret(commitSecret []byte) {
+	epochBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(epochBytes, g.state.Epoch)
+	ikm := make([]byte, 0, len(g.state.EpochSecret)+len(commitSecret))
+	ikm = append(ikm, g.state.EpochSecret...)
+	ikm = append(ikm, commitSecret...)
+	r := hkdf.New(sha256.New, ikm, epochBytes, []byte("mlsgit-epoch-advance"))
+	newSecret := make([]byte, 32)
+	if _, err := io.ReadFull(r, newSecret); err != nil {
+		panic(fmt.Sprintf("hkdf advance: %v", err))
+	}
+	g.state.EpochSecret = newSecret
+	g.state.Epoch++
+}
+
+// advanceEpochDH performs a TreeKEM epoch advance from our own leaf.
+// It generates a fresh path secret, re-keys every node on our direct path,
+// encrypts each node's path secret to the resolution of its copath child,
+// and mixes the resulting commit secret into the epoch derivation. The tree
+// must already reflect any blanked leaves, so removed members are absent
+// from every resolution and cannot derive the new epoch secret.
 func (g *MLSGitGroup) advanceEpochDH() error {
-	// Generate fresh update secret (the entropy a removed member can't obtain)
-	updateSecret := make([]byte, 32)
-	if _, err := rand.Read(updateSecret); err != nil {
-		return fmt.Errorf("generate update secret: %w", err)
+	tree := &g.state.Tree
+	leafNode := 2 * g.state.OwnLeafIndex
+	path := directPath(leafNode, tree.leafCount())
+	cp := copath(leafNode, tree.leafCount())
+
+	pathSecret := make([]byte, 32)
+	if _, err := rand.Read(pathSecret); err != nil {
+		return fmt.Errorf("generate path secret: %w", err)
 	}
 
-	// Generate ephemeral X25519 keypair
-	ephPriv := make([]byte, 32)
-	if _, err := rand.Read(ephPriv); err != nil {
-		return fmt.Errorf("generate ephemeral key: %w", err)
+	if g.state.PathKeys == nil {
+		g.state.PathKeys = make(map[int][]byte)
 	}
-	ephPub, err := curve25519.X25519(ephPriv, curve25519.Basepoint)
-	if err != nil {
-		return fmt.Errorf("derive ephemeral public key: %w", err)
+	enc := updateEncap{FromEpoch: g.state.Epoch, Committer: g.state.OwnLeafIndex}
+	for i, node := range path {
+		priv, pub, pqPub, err := deriveNodeKeypair(pathSecret, g.postQuantum)
+		if err != nil {
+			return err
+		}
+		pn := pathNode{Node: node, PublicKey: pub, PQPublicKey: pqPub}
+		for _, r := range tree.resolution(cp[i]) {
+			entry, err := encryptPathSecret(tree.Nodes[r], r, pathSecret, g.state.Epoch)
+			if err != nil {
+				return fmt.Errorf("encrypt path secret for node %d: %w", r, err)
+			}
+			pn.Entries = append(pn.Entries, entry)
+		}
+		tree.Nodes[node] = &treeNode{PublicKey: pub, PQPublicKey: pqPub}
+		g.state.PathKeys[node] = priv
+		enc.Path = append(enc.Path, pn)
+		pathSecret = deriveSecret(pathSecret, "path")
 	}
 
-	// Encrypt updateSecret for each remaining active member
-	var entries []encapEntry
-	for i, m := range g.state.Members {
-		if !m.Active {
-			continue
-		}
-		shared, err := curve25519.X25519(ephPriv, m.InitPub)
+	// Record the encapsulation
+	g.state.UpdateEncaps = append(g.state.UpdateEncaps, enc)
+
+	// The secret one step above the root is the commit secret.
+	g.advanceEpochWithSecret(pathSecret)
+	g.prunePathKeys()
+	return nil
+}
+
+// lastEncap returns the most recently recorded update path.
+func (g *MLSGitGroup) lastEncap() *updateEncap {
+	return &g.state.UpdateEncaps[len(g.state.UpdateEncaps)-1]
+}
+
+// encryptPathSecret encrypts a path secret to a node's public key using a
+// fresh ephemeral key (ECIES with HKDF and AES-GCM). Hybrid nodes get the
+// X25519 + ML-KEM-768 hybrid KEM.
+func encryptPathSecret(recipient *treeNode, node int, pathSecret []byte, epoch uint64) (encapEntry, error) {
+	if recipient.hybrid() {
+		shared, ephPub, kemCT, err := mlscrypto.HybridEncapsulate(recipient.PublicKey, recipient.PQPublicKey)
 		if err != nil {
-			return fmt.Errorf("dh with member %d: %w", i, err)
+			return encapEntry{}, err
 		}
-		encKey := deriveEncapKey(shared, g.state.Epoch)
-		nonce, ct, err := mlscrypto.AESGCMEncrypt(encKey, updateSecret)
+		nonce, ct, err := mlscrypto.AESGCMEncrypt(deriveEncapKey(shared, epoch), pathSecret)
 		if err != nil {
-			return fmt.Errorf("encrypt update secret for member %d: %w", i, err)
+			return encapEntry{}, err
 		}
-		entries = append(entries, encapEntry{
-			LeafIndex:  i,
-			Ciphertext: append(nonce, ct...),
-		})
+		return encapEntry{Node: node, EphPub: ephPub, KEMCiphertext: kemCT, Ciphertext: append(nonce, ct...)}, nil
 	}
+	recipientPub := recipient.PublicKey
+	ephPriv := make([]byte, 32)
+	if _, err := rand.Read(ephPriv); err != nil {
+		return encapEntry{}, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	ephPub, err := curve25519.X25519(ephPriv, curve25519.Basepoint)
+	if err != nil {
+		return encapEntry{}, fmt.Errorf("derive ephemeral public key: %w", err)
+	}
+	shared, err := curve25519.X25519(ephPriv, recipientPub)
+	if err != nil {
+		return encapEntry{}, fmt.Errorf("dh: %w", err)
+	}
+	nonce, ct, err := mlscrypto.AESGCMEncrypt(deriveEncapKey(shared, epoch), pathSecret)
+	if err != nil {
+		return encapEntry{}, err
+	}
+	return encapEntry{Node: node, EphPub: ephPub, Ciphertext: append(nonce, ct...)}, nil
+}
 
-	// Record the encapsulation
-	g.state.UpdateEncaps = append(g.state.UpdateEncaps, updateEncap{
-		FromEpoch: g.state.Epoch,
-		EphPub:    ephPub,
-		Entries:   entries,
-	})
-
-	// Derive new epoch secret: HKDF(oldSecret || updateSecret, epoch, info)
-	// The updateSecret provides entropy that the removed member cannot obtain.
-	epochBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(epochBytes, g.state.Epoch)
-	ikm := make([]byte, 64)
-	copy(ikm[:32], g.state.EpochSecret)
-	copy(ikm[32:], updateSecret)
-	r := hkdf.New(sha256.New, ikm, epochBytes, []byte("mlsgit-epoch-advance"))
-	newSecret := make([]byte, 32)
-	if _, err := io.ReadFull(r, newSecret); err != nil {
-		panic(fmt.Sprintf("hkdf advance: %v", err))
+// decryptPathSecret reverses encryptPathSecret with the recipient's private
+// key (a hybrid seed if the entry carries an ML-KEM ciphertext).
+func decryptPathSecret(priv []byte, e encapEntry, epoch uint64) ([]byte, error) {
+	var shared []byte
+	var err error
+	if len(e.KEMCiphertext) > 0 {
+		shared, err = mlscrypto.HybridDecapsulate(priv, e.EphPub, e.KEMCiphertext)
+	} else {
+		shared, err = curve25519.X25519(priv, e.EphPub)
 	}
-	g.state.EpochSecret = newSecret
-	g.state.Epoch++
-	return nil
+	if err != nil {
+		return nil, fmt.Errorf("dh: %w", err)
+	}
+	if len(e.Ciphertext) < mlscrypto.IVSize {
+		return nil, fmt.Errorf("encap ciphertext too short")
+	}
+	nonce := e.Ciphertext[:mlscrypto.IVSize]
+	ct := e.Ciphertext[mlscrypto.IVSize:]
+	return mlscrypto.AESGCMDecrypt(deriveEncapKey(shared, epoch), nonce, ct)
 }
 
 // deriveEncapKey derives an AES-256 encryption key from a DH shared secret.
@@ -362,87 +624,130 @@ func deriveEncapKey(dhShared []byte, epoch uint64) []byte {
 	return key
 }
 
-// advanceOneEpoch advances the epoch by one step, using DH decapsulation
-// if an encap exists for the current epoch, or deterministic HKDF otherwise.
-func (g *MLSGitGroup) advanceOneEpoch(encaps []updateEncap) error {
-	for _, enc := range encaps {
-		if enc.FromEpoch == g.state.Epoch {
-			return g.applyDHAdvance(enc)
+// nodePrivateKey returns the X25519 private key (or hybrid seed) we hold for
+// a tree node, or nil if we hold none.
+func (g *MLSGitGroup) nodePrivateKey(node int) []byte {
+	if node == 2*g.state.OwnLeafIndex {
+		return g.initPriv
+	}
+	return g.state.PathKeys[node]
+}
+
+// prunePathKeys drops path keys for nodes that are now blank, outside the
+// tree, or carry a public key we did not derive.
+func (g *MLSGitGroup) prunePathKeys() {
+	for node, priv := range g.state.PathKeys {
+		if node >= len(g.state.Tree.Nodes) || g.state.Tree.Nodes[node] == nil {
+			delete(g.state.PathKeys, node)
+			continue
+		}
+		if !keyMatches(priv, g.state.Tree.Nodes[node]) {
+			delete(g.state.PathKeys, node)
 		}
 	}
-	// No DH encap — deterministic advance (add operation)
-	g.advanceEpoch()
-	return nil
 }
 
-// applyDHAdvance decrypts a DH encapsulation and advances the epoch.
-func (g *MLSGitGroup) applyDHAdvance(enc updateEncap) error {
-	for _, e := range enc.Entries {
-		if e.LeafIndex == g.state.OwnLeafIndex {
-			// DH: shared = X25519(ourInitPriv, ephPub)
-			shared, err := curve25519.X25519(g.initPriv, enc.EphPub)
-			if err != nil {
-				return fmt.Errorf("dh: %w", err)
-			}
-			decKey := deriveEncapKey(shared, g.state.Epoch)
+// keyMatches reports whether priv is the private key for node's public keys.
+func keyMatches(priv []byte, node *treeNode) bool {
+	pub, pqPub, err := nodePublicKeys(priv, node.hybrid())
+	return err == nil && bytes.Equal(pub, node.PublicKey) && bytes.Equal(pqPub, node.PQPublicKey)
+}
 
-			if len(e.Ciphertext) < mlscrypto.IVSize {
-				return fmt.Errorf("encap ciphertext too short")
+// applyDHAdvance finds the lowest path node whose secret was encrypted to a
+// node we hold a key for, decrypts it, derives the rest of the path up to
+// the commit secret, and advances the epoch.
+func (g *MLSGitGroup) applyDHAdvance(enc updateEncap) error {
+	if enc.Committer == g.state.OwnLeafIndex {
+		return fmt.Errorf("commit at epoch %d was made from our own leaf", g.state.Epoch)
+	}
+	for i, pn := range enc.Path {
+		for _, e := range pn.Entries {
+			priv := g.nodePrivateKey(e.Node)
+			if priv == nil {
+				continue
 			}
-			nonce := e.Ciphertext[:mlscrypto.IVSize]
-			ct := e.Ciphertext[mlscrypto.IVSize:]
-			updateSecret, err := mlscrypto.AESGCMDecrypt(decKey, nonce, ct)
+			pathSecret, err := decryptPathSecret(priv, e, g.state.Epoch)
 			if err != nil {
-				return fmt.Errorf("decrypt update secret: %w", err)
-			}
-
-			// Derive new epoch secret: HKDF(oldSecret || updateSecret, epoch, info)
-			epochBytes := make([]byte, 8)
-			binary.BigEndian.PutUint64(epochBytes, g.state.Epoch)
-			ikm := make([]byte, 64)
-			copy(ikm[:32], g.state.EpochSecret)
-			copy(ikm[32:], updateSecret)
-			r := hkdf.New(sha256.New, ikm, epochBytes, []byte("mlsgit-epoch-advance"))
-			newSecret := make([]byte, 32)
-			if _, err := io.ReadFull(r, newSecret); err != nil {
-				panic(fmt.Sprintf("hkdf advance: %v", err))
+				// Stale key for a node that has since been re-keyed
+				continue
 			}
-			g.state.EpochSecret = newSecret
-			g.state.Epoch++
-			return nil
+			return g.applyPathFrom(enc.Path[i:], pathSecret)
 		}
 	}
 	return fmt.Errorf("no encap entry for leaf %d at epoch %d", g.state.OwnLeafIndex, g.state.Epoch)
 }
 
+// applyPathFrom derives node keys for the given suffix of an update path,
+// checking each against the committed public key, then advances the epoch
+// with the resulting commit secret.
+func (g *MLSGitGroup) applyPathFrom(path []pathNode, pathSecret []byte) error {
+	if g.state.PathKeys == nil {
+		g.state.PathKeys = make(map[int][]byte)
+	}
+	for _, pn := range path {
+		priv, pub, pqPub, err := deriveNodeKeypair(pathSecret, len(pn.PQPublicKey) > 0)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(pub, pn.PublicKey) || !bytes.Equal(pqPub, pn.PQPublicKey) {
+			return fmt.Errorf("path secret does not match public key of node %d", pn.Node)
+		}
+		g.state.PathKeys[pn.Node] = priv
+		pathSecret = deriveSecret(pathSecret, "path")
+	}
+	g.advanceEpochWithSecret(pathSecret)
+	return nil
+}
+
 // AddMember adds a member to the group. Returns (commitBytes, welcomeBytes).
-// The epoch advances deterministically after this operation.
+// The new leaf is placed in the leftmost blank slot and marked unmerged at
+// its ancestors; the epoch advances deterministically after this operation.
 func (g *MLSGitGroup) AddMember(kp KeyPackageData) ([]byte, []byte, error) {
-	newLeafIndex := len(g.state.Members)
-	g.state.Members = append(g.state.Members, memberEntry{
-		SigPub:  kp.SigPub,
-		InitPub: kp.InitPub,
-		Active:  true,
-	})
+	return g.addMember(kp, false)
+}
+
+// AddMemberForwardOnly adds a member like AddMember but cuts the epoch key
+// archive before the new epoch: the signed transition tells every member
+// to retain the current epoch secret locally, and the archive must not
+// wrap it under the new epoch (see HistoryCuts).
+func (g *MLSGitGroup) AddMemberForwardOnly(kp KeyPackageData) ([]byte, []byte, error) {
+	return g.addMember(kp, true)
+}
+
+func (g *MLSGitGroup) addMember(kp KeyPackageData, historyCut bool) ([]byte, []byte, error) {
+	g.checkpoint()
+	fromEpoch := g.state.Epoch
+	newLeafIndex := g.state.Tree.addLeaf(kp.SigPub, kp.InitPub, kp.PQInitPub)
 
+	if historyCut {
+		g.retainEpochSecret()
+	}
 	g.advanceEpoch()
+	g.recordTransition(transition{FromEpoch: fromEpoch, Op: OpAdd, Leaf: newLeafIndex, SigPub: kp.SigPub, InitPub: kp.InitPub, PQInitPub: kp.PQInitPub, HistoryCut: historyCut}, nil)
 
 	// Create Welcome for the new member
 	welcome := WelcomeData{
-		GroupID:      g.state.GroupID,
-		Epoch:        g.state.Epoch,
-		EpochSecret:  g.state.EpochSecret,
-		Members:      g.state.Members,
-		LeafIndex:    newLeafIndex,
-		UpdateEncaps: g.state.UpdateEncaps,
+		GroupID:        g.state.GroupID,
+		Epoch:          g.state.Epoch,
+		EpochSecret:    g.state.EpochSecret,
+		Tree:           g.state.Tree,
+		LeafIndex:      newLeafIndex,
+		UpdateEncaps:   g.state.UpdateEncaps,
+		Transitions:    g.state.Transitions,
+		TranscriptHash: g.state.TranscriptHash,
 	}
 	welcomeBytes, err := json.Marshal(welcome)
 	if err != nil {
 		return nil, nil, fmt.Errorf("marshal welcome: %w", err)
 	}
 
-	// Encrypt the Welcome under the new member's InitPub
-	encryptedWelcome, err := mlscrypto.EncryptWelcome(kp.InitPub, welcomeBytes)
+	// Encrypt the Welcome under the new member's init key
+	var encryptedWelcome []byte
+	if len(kp.PQInitPub) > 0 {
+		encryptedWelcome, err = mlscrypto.EncryptWelcomeHybrid(kp.InitPub, kp.PQInitPub, welcomeBytes)
+	} else {
+		encryptedWelcome, err = mlscrypto.EncryptWelcome(kp.InitPub, welcomeBytes)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("encrypt welcome: %w", err)
 	}
@@ -457,21 +762,48 @@ func (g *MLSGitGroup) AddMember(kp KeyPackageData) ([]byte, []byte, error) {
 }
 
 // RemoveMember removes a member by leaf index. Returns commitBytes.
-// The epoch advances using DH-based rekeying to ensure the removed member
-// cannot derive the new epoch secret.
+// The removed leaf and its direct path are blanked, then the epoch advances
+// through a TreeKEM update path so the removed member cannot derive the new
+// epoch secret.
 func (g *MLSGitGroup) RemoveMember(leafIndex int) ([]byte, error) {
-	if leafIndex < 0 || leafIndex >= len(g.state.Members) {
-		return nil, fmt.Errorf("leaf index %d out of range [0, %d)", leafIndex, len(g.state.Members))
+	return g.RemoveMembers([]int{leafIndex})
+}
+
+// RemoveMembers removes several leaves (for example, all devices of one
+// member) in a single epoch change. Returns commitBytes.
+func (g *MLSGitGroup) RemoveMembers(leafIndices []int) ([]byte, error) {
+	if len(leafIndices) == 0 {
+		return nil, fmt.Errorf("no leaves to remove")
 	}
-	if leafIndex == g.state.OwnLeafIndex {
-		return nil, fmt.Errorf("cannot remove self")
+	seen := make(map[int]bool, len(leafIndices))
+	for _, leaf := range leafIndices {
+		if err := g.state.Tree.checkLeaf(leaf); err != nil {
+			return nil, err
+		}
+		if leaf == g.state.OwnLeafIndex {
+			return nil, fmt.Errorf("cannot remove self")
+		}
+		if seen[leaf] {
+			return nil, fmt.Errorf("leaf %d listed twice", leaf)
+		}
+		seen[leaf] = true
 	}
 
-	g.state.Members[leafIndex].Active = false
+	g.checkpoint()
+	fromEpoch := g.state.Epoch
+	for _, leaf := range leafIndices {
+		g.state.Tree.blankLeaf(leaf)
+	}
+	g.state.Tree.truncate()
 
 	if err := g.advanceEpochDH(); err != nil {
 		return nil, fmt.Errorf("advance epoch: %w", err)
 	}
+	t := transition{FromEpoch: fromEpoch, Op: OpRemove, Leaf: leafIndices[0]}
+	if len(leafIndices) > 1 {
+		t.Leaves = leafIndices
+	}
+	g.recordTransition(t, g.lastEncap())
 
 	commitBytes, err := g.ToCommittedBytes()
 	if err != nil {
@@ -480,64 +812,162 @@ func (g *MLSGitGroup) RemoveMember(leafIndex int) ([]byte, error) {
 	return commitBytes, nil
 }
 
-// ApplyCommit applies a commit received from another member.
-// Uses DH decapsulation for removal-based transitions and deterministic
-// HKDF for add-based transitions.
-func (g *MLSGitGroup) ApplyCommit(commitBytes []byte) error {
+// SelfUpdate rotates our own leaf to a freshly generated init key (hybrid
+// if SetPostQuantum is on) and advances the epoch through a TreeKEM update
+// path. Anyone who captured our
+// previous init key or path keys cannot derive the new epoch secret, which
+// restores confidentiality after a suspected compromise. Returns commitBytes.
+// The caller must persist the new init private key (see InitPriv).
+func (g *MLSGitGroup) SelfUpdate() ([]byte, error) {
+	leaf := g.state.Tree.leaf(g.state.OwnLeafIndex)
+	if leaf == nil {
+		return nil, fmt.Errorf("own leaf %d is blank", g.state.OwnLeafIndex)
+	}
+
+	initPriv, initPub, pqInitPub, err := g.newInitKey()
+	if err != nil {
+		return nil, err
+	}
+
+	g.checkpoint()
+	fromEpoch := g.state.Epoch
+	leaf.PublicKey = initPub
+	leaf.PQPublicKey = pqInitPub
+	g.initPriv = initPriv
+
+	if err := g.advanceEpochDH(); err != nil {
+		return nil, fmt.Errorf("advance epoch: %w", err)
+	}
+	g.recordTransition(transition{FromEpoch: fromEpoch, Op: OpUpdate, Leaf: g.state.OwnLeafIndex, InitPub: initPub, PQInitPub: pqInitPub}, g.lastEncap())
+
+	commitBytes, err := g.ToCommittedBytes()
+	if err != nil {
+		return nil, fmt.Errorf("marshal commit: %w", err)
+	}
+	return commitBytes, nil
+}
+
+// parseCommitted unmarshals committed state, converting the legacy flat
+// member list if no tree is present.
+func parseCommitted(data []byte) (committedGroupState, error) {
 	var committed committedGroupState
-	if err := json.Unmarshal(commitBytes, &committed); err != nil {
+	if err := json.Unmarshal(data, &committed); err != nil {
+		return committedGroupState{}, err
+	}
+	if len(committed.Tree.Nodes) == 0 && len(committed.LegacyMembers) > 0 {
+		committed.Tree = treeFromLegacy(committed.LegacyMembers)
+	}
+	return committed, nil
+}
+
+// stillMember reports whether our leaf in tree still holds our init key.
+func (g *MLSGitGroup) stillMember(tree *ratchetTree) bool {
+	leaf := tree.leaf(g.state.OwnLeafIndex)
+	if leaf == nil {
+		return false
+	}
+	if g.initPriv == nil {
+		return true
+	}
+	return keyMatches(g.initPriv, leaf)
+}
+
+// ApplyCommit applies a commit received from another member. Every epoch
+// change must be covered by a signed transition from a member of the
+// previous epoch; the epoch secret follows via TreeKEM decryption for
+// removals and self-updates, or deterministic HKDF for adds.
+func (g *MLSGitGroup) ApplyCommit(commitBytes []byte) error {
+	committed, err := parseCommitted(commitBytes)
+	if err != nil {
 		return fmt.Errorf("unmarshal commit: %w", err)
 	}
 	if committed.Epoch <= g.state.Epoch {
 		return nil // already up to date
 	}
-	// Ratchet forward from current epoch to committed epoch
-	for g.state.Epoch < committed.Epoch {
-		if err := g.advanceOneEpoch(committed.UpdateEncaps); err != nil {
-			return fmt.Errorf("advance to epoch %d: %w", g.state.Epoch+1, err)
-		}
+	saved := g.snapshot()
+	if err := g.applyTransitions(committed, nil); err != nil {
+		g.state = saved
+		return err
 	}
-	g.state.GroupID = committed.GroupID
-	g.state.Members = committed.Members
-	g.state.UpdateEncaps = committed.UpdateEncaps
+	g.adoptCommitted(committed)
 	return nil
 }
 
+// ErrRemoved is returned by SyncFromCommitted when verified committed state
+// no longer holds our leaf and init key: we were removed from the group,
+// or our keys were replaced from another copy of this state.
+var ErrRemoved = errors.New("no longer a member of the group")
+
 // SyncFromCommitted updates the group state from the committed state bytes
 // (e.g., after pulling changes from remote). The committed state does not
-// contain the epoch secret, so we derive it using DH decapsulation for
-// removal transitions or deterministic HKDF for add transitions.
-// Preserves OwnLeafIndex and signing key. Returns true if the state was updated.
-func (g *MLSGitGroup) SyncFromCommitted(committedBytes []byte) bool {
-	var committed committedGroupState
-	if err := json.Unmarshal(committedBytes, &committed); err != nil {
-		return false
+// contain the epoch secret, so we replay its signed transitions from our
+// epoch, deriving the secret as in ApplyCommit. Preserves OwnLeafIndex and
+// signing key. Returns true if the state was updated. A committed state we
+// were removed from is verified and then reported as ErrRemoved; one whose
+// transitions fail verification is rejected with an error. Either way the
+// local state is left untouched. If the committed history diverges from
+// ours the error is a *ForkError.
+func (g *MLSGitGroup) SyncFromCommitted(committedBytes []byte) (bool, error) {
+	committed, err := parseCommitted(committedBytes)
+	if err != nil {
+		return false, fmt.Errorf("unmarshal committed state: %w", err)
+	}
+	if epoch, forked := forkEpoch(g.state.Transitions, committed.Transitions); forked {
+		return false, &ForkError{Epoch: int(epoch)}
 	}
 	if committed.Epoch < g.state.Epoch {
-		return false
+		return false, nil
 	}
-	// Pick up encaps even if epoch matches (ensures propagation to all members)
+	// Pick up logs even if epoch matches (ensures propagation to all
+	// members), as long as they extend the history we already hold.
 	if committed.Epoch == g.state.Epoch {
-		if len(committed.UpdateEncaps) > len(g.state.UpdateEncaps) {
-			g.state.UpdateEncaps = committed.UpdateEncaps
-			return true
+		if len(committed.Transitions) <= len(g.state.Transitions) &&
+			len(committed.UpdateEncaps) <= len(g.state.UpdateEncaps) {
+			return false, nil
 		}
-		return false
+		n := len(committed.Transitions)
+		if n == 0 || !bytes.Equal(committed.Transitions[n-1].hash(), g.state.TranscriptHash) {
+			return false, nil
+		}
+		g.state.Transitions = committed.Transitions
+		g.state.UpdateEncaps = committed.UpdateEncaps
+		return true, nil
+	}
+	// Report a removal only once the transitions leading to it verify
+	if !g.stillMember(&committed.Tree) {
+		if err := g.verifyTransitions(committed); err != nil {
+			return false, err
+		}
+		return false, ErrRemoved
 	}
-	ownLeaf := g.state.OwnLeafIndex
-	// Don't sync if we were removed
-	if ownLeaf >= len(committed.Members) || !committed.Members[ownLeaf].Active {
-		return false
+	// Ratchet on a copy so a failed sync leaves the local state untouched
+	saved := g.snapshot()
+	if err := g.applyTransitions(committed, nil); err != nil {
+		g.state = saved
+		return false, err
 	}
-	// Ratchet local epoch secret forward to reach the committed epoch
-	for g.state.Epoch < committed.Epoch {
-		if err := g.advanceOneEpoch(committed.UpdateEncaps); err != nil {
-			return false
-		}
+	g.adoptCommitted(committed)
+	return true, nil
+}
+
+// snapshot returns a copy of the state that survives a failed ratchet.
+func (g *MLSGitGroup) snapshot() groupState {
+	saved := g.state
+	saved.PathKeys = make(map[int][]byte, len(g.state.PathKeys))
+	for k, v := range g.state.PathKeys {
+		saved.PathKeys[k] = v
 	}
-	g.state.GroupID = committed.GroupID
-	g.state.Members = committed.Members
-	g.state.OwnLeafIndex = ownLeaf
+	saved.Retained = make(map[uint64][]byte, len(g.state.Retained))
+	for k, v := range g.state.Retained {
+		saved.Retained[k] = v
+	}
+	return saved
+}
+
+// adoptCommitted takes the logs from verified committed state and drops
+// path keys that no longer match the tree.
+func (g *MLSGitGroup) adoptCommitted(committed committedGroupState) {
 	g.state.UpdateEncaps = committed.UpdateEncaps
-	return true
+	g.state.Transitions = committed.Transitions
+	g.prunePathKeys()
 }
@@ -2,6 +2,7 @@ package mls
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
@@ -110,6 +111,31 @@ func TestAddMember(t *testing.T) {
 	}
 }
 
+func TestKeyPackageSignature(t *testing.T) {
+	keys, _ := GenerateMLSKeys()
+	kp := BuildKeyPackage([]byte("bob"), keys)
+	if err := kp.Verify(); err != nil {
+		t.Fatalf("fresh key package: %v", err)
+	}
+
+	// Swapping in another init key breaks the signature until re-signed.
+	other, _ := GenerateMLSKeys()
+	kp.InitPub = other.InitPub
+	if err := kp.Verify(); err == nil {
+		t.Error("modified key package should not verify")
+	}
+	kp.Sign(keys.SigPriv)
+	if err := kp.Verify(); err != nil {
+		t.Errorf("re-signed key package: %v", err)
+	}
+
+	// Someone else's signature key cannot be claimed.
+	kp.SigPub = other.SigPub
+	if err := kp.Verify(); err == nil {
+		t.Error("key package signed by a different key should not verify")
+	}
+}
+
 func TestRemoveMember(t *testing.T) {
 	aliceKeys, _ := GenerateMLSKeys()
 	g, _ := Create([]byte("test-group"), []byte("alice"), aliceKeys)
@@ -165,15 +191,18 @@ func TestToCommittedBytesExcludesSecret(t *testing.T) {
 	if _, found := obj["own_leaf_index"]; found {
 		t.Error("ToCommittedBytes should not include own_leaf_index")
 	}
-	// But should include group_id, epoch, members
+	// But should include group_id, epoch, tree
 	if _, found := obj["group_id"]; !found {
 		t.Error("ToCommittedBytes should include group_id")
 	}
 	if _, found := obj["epoch"]; !found {
 		t.Error("ToCommittedBytes should include epoch")
 	}
-	if _, found := obj["members"]; !found {
-		t.Error("ToCommittedBytes should include members")
+	if _, found := obj["tree"]; !found {
+		t.Error("ToCommittedBytes should include tree")
+	}
+	if _, found := obj["path_keys"]; found {
+		t.Error("ToCommittedBytes should not include path_keys")
 	}
 }
 
@@ -214,9 +243,9 @@ func TestSyncFromCommittedRatchet(t *testing.T) {
 	committedBytes, _ := g1.ToCommittedBytes()
 
 	// g2 is still at epoch 0. Sync from committed state.
-	updated := g2.SyncFromCommitted(committedBytes)
+	updated, err := g2.SyncFromCommitted(committedBytes)
 	if !updated {
-		t.Fatal("SyncFromCommitted should return true")
+		t.Fatalf("SyncFromCommitted should return true: %v", err)
 	}
 	if g2.Epoch() != 1 {
 		t.Errorf("after sync, epoch = %d, want 1", g2.Epoch())
@@ -254,9 +283,9 @@ func TestSyncFromCommittedBackwardCompat(t *testing.T) {
 	oldFormatBytes, _ := g1.ToBytes()
 
 	// g2 syncs from old format - should still work via ratchet
-	updated := g2.SyncFromCommitted(oldFormatBytes)
+	updated, err := g2.SyncFromCommitted(oldFormatBytes)
 	if !updated {
-		t.Fatal("SyncFromCommitted should accept old format")
+		t.Fatalf("SyncFromCommitted should accept old format: %v", err)
 	}
 	if g2.Epoch() != g1.Epoch() {
 		t.Errorf("epoch mismatch: g2=%d, g1=%d", g2.Epoch(), g1.Epoch())
@@ -387,9 +416,9 @@ func TestSyncAfterRemoval(t *testing.T) {
 
 	// Charlie syncs from committed state
 	committedBytes, _ := alice.ToCommittedBytes()
-	updated := charlie.SyncFromCommitted(committedBytes)
+	updated, err := charlie.SyncFromCommitted(committedBytes)
 	if !updated {
-		t.Fatal("SyncFromCommitted should return true")
+		t.Fatalf("SyncFromCommitted should return true: %v", err)
 	}
 	if charlie.Epoch() != 3 {
 		t.Errorf("charlie epoch = %d, want 3", charlie.Epoch())
@@ -426,9 +455,9 @@ func TestSyncMultipleRemovalsOffline(t *testing.T) {
 
 	// Dave comes back and syncs (skipping 2 DH-based transitions)
 	committedBytes, _ := alice.ToCommittedBytes()
-	updated := dave.SyncFromCommitted(committedBytes)
+	updated, err := dave.SyncFromCommitted(committedBytes)
 	if !updated {
-		t.Fatal("SyncFromCommitted should return true")
+		t.Fatalf("SyncFromCommitted should return true: %v", err)
 	}
 	if dave.Epoch() != 5 {
 		t.Errorf("dave epoch = %d, want 5", dave.Epoch())
@@ -536,3 +565,384 @@ func TestInitPubIsX25519(t *testing.T) {
 		t.Errorf("InitPub length = %d, want 32", len(keys.InitPub))
 	}
 }
+
+// --- TreeKEM tests ---
+
+// buildGroup creates a group of n members where every member joined via
+// Welcome. Returns all members, with the creator at index 0.
+func buildGroup(t *testing.T, n int) []*MLSGitGroup {
+	t.Helper()
+	keys, _ := GenerateMLSKeys()
+	creator, _ := Create([]byte("test-group"), []byte("m0"), keys)
+	members := []*MLSGitGroup{creator}
+	for i := 1; i < n; i++ {
+		k, _ := GenerateMLSKeys()
+		_, welcome, err := creator.AddMember(BuildKeyPackage([]byte("m"), k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := JoinFromWelcome(welcome, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, m)
+	}
+	committed, _ := creator.ToCommittedBytes()
+	for _, m := range members[1:] {
+		m.SyncFromCommitted(committed)
+	}
+	return members
+}
+
+func TestRemovalEncapsLogarithmic(t *testing.T) {
+	members := buildGroup(t, 16)
+
+	// Warm the tree: each removal is made by a member in the same subtree,
+	// leaving the copath of leaf 0 (nodes 2, 5, 11, 23) populated.
+	for _, r := range []struct{ by, leaf int }{{8, 15}, {4, 7}, {2, 3}} {
+		if _, err := members[r.by].RemoveMember(r.leaf); err != nil {
+			t.Fatal(err)
+		}
+		committed, _ := members[r.by].ToCommittedBytes()
+		for _, m := range members {
+			m.SyncFromCommitted(committed)
+		}
+	}
+
+	alice := members[0]
+	if _, err := alice.RemoveMember(1); err != nil {
+		t.Fatal(err)
+	}
+	enc := alice.state.UpdateEncaps[len(alice.state.UpdateEncaps)-1]
+	entries := 0
+	for _, pn := range enc.Path {
+		entries += len(pn.Entries)
+	}
+	if len(enc.Path) != 4 {
+		t.Errorf("path length = %d, want 4 for 16 leaves", len(enc.Path))
+	}
+	if entries > len(enc.Path) {
+		t.Errorf("removal produced %d encapsulations, want at most %d", entries, len(enc.Path))
+	}
+
+	// Every remaining member still converges.
+	committed, _ := alice.ToCommittedBytes()
+	for i, m := range members {
+		if i == 0 || i == 1 || i == 3 || i == 7 || i == 15 {
+			continue
+		}
+		if ok, _ := m.SyncFromCommitted(committed); !ok || !bytes.Equal(m.ExportEpochSecret(), alice.ExportEpochSecret()) {
+			t.Errorf("member %d did not converge", i)
+		}
+	}
+}
+
+func TestTreeKEMSequentialRemovalsFromDifferentMembers(t *testing.T) {
+	members := buildGroup(t, 8)
+
+	// Each removal is made by a different member; everyone else syncs.
+	removals := []struct{ by, leaf int }{{0, 7}, {3, 1}, {5, 6}, {2, 4}}
+	removed := map[int]bool{}
+	for _, r := range removals {
+		if _, err := members[r.by].RemoveMember(r.leaf); err != nil {
+			t.Fatalf("member %d removing %d: %v", r.by, r.leaf, err)
+		}
+		removed[r.leaf] = true
+		committed, _ := members[r.by].ToCommittedBytes()
+		for i, m := range members {
+			if i == r.by || removed[i] {
+				continue
+			}
+			if ok, err := m.SyncFromCommitted(committed); !ok {
+				t.Fatalf("member %d failed to sync removal of %d: %v", i, r.leaf, err)
+			}
+			if !bytes.Equal(m.ExportEpochSecret(), members[r.by].ExportEpochSecret()) {
+				t.Fatalf("member %d secret mismatch after removal of %d", i, r.leaf)
+			}
+		}
+	}
+
+	// The removed member cannot follow.
+	committed, _ := members[2].ToCommittedBytes()
+	if ok, _ := members[7].SyncFromCommitted(committed); ok {
+		t.Error("removed member should not be able to sync")
+	}
+}
+
+func TestTreeKEMNewMemberReceivesPathViaUnmergedLeaf(t *testing.T) {
+	members := buildGroup(t, 4)
+	alice := members[0]
+
+	// Warm alice's path so parents are non-blank.
+	alice.RemoveMember(3)
+
+	// Dave joins as an unmerged leaf below alice's parents.
+	daveKeys, _ := GenerateMLSKeys()
+	_, welcome, _ := alice.AddMember(BuildKeyPackage([]byte("dave"), daveKeys))
+	dave, err := JoinFromWelcome(welcome, daveKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob removes carol; dave must still receive the new path secret.
+	bob := members[1]
+	committed, _ := alice.ToCommittedBytes()
+	bob.SyncFromCommitted(committed)
+	if _, err := bob.RemoveMember(2); err != nil {
+		t.Fatal(err)
+	}
+	committed, _ = bob.ToCommittedBytes()
+	if ok, err := dave.SyncFromCommitted(committed); !ok {
+		t.Fatalf("dave should sync via unmerged leaf encapsulation: %v", err)
+	}
+	if !bytes.Equal(dave.ExportEpochSecret(), bob.ExportEpochSecret()) {
+		t.Error("dave's epoch secret should match bob's")
+	}
+}
+
+func TestLegacyMemberListIsConverted(t *testing.T) {
+	keys, _ := GenerateMLSKeys()
+	bobKeys, _ := GenerateMLSKeys()
+	legacy, _ := json.Marshal(map[string]interface{}{
+		"group_id":       []byte("g"),
+		"epoch":          3,
+		"epoch_secret":   bytes.Repeat([]byte{1}, 32),
+		"own_leaf_index": 0,
+		"members": []legacyMember{
+			{SigPub: keys.SigPub, InitPub: keys.InitPub, Active: true},
+			{SigPub: bobKeys.SigPub, InitPub: bobKeys.InitPub, Active: false},
+			{SigPub: bobKeys.SigPub, InitPub: bobKeys.InitPub, Active: true},
+		},
+	})
+	g, err := FromBytes(legacy, keys.SigPriv, keys.InitPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.MemberCount() != 2 {
+		t.Errorf("MemberCount = %d, want 2", g.MemberCount())
+	}
+	if g.FindLeafIndex(bobKeys.InitPub) != 2 {
+		t.Errorf("bob should keep leaf index 2, got %d", g.FindLeafIndex(bobKeys.InitPub))
+	}
+	if _, err := g.RemoveMember(2); err != nil {
+		t.Fatalf("remove after conversion: %v", err)
+	}
+}
+
+func TestSelfUpdateRotatesLeafAndSyncs(t *testing.T) {
+	members := buildGroup(t, 4)
+	carol := members[2]
+	oldPub := append([]byte(nil), carol.InitPub()...)
+	oldPriv := carol.InitPriv()
+	oldEpoch := carol.Epoch()
+
+	if _, err := carol.SelfUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if !carol.HoldsInitKey(carol.InitPriv()) || carol.HoldsInitKey(oldPriv) {
+		t.Error("only the new init key should match carol's leaf")
+	}
+	if carol.Epoch() != oldEpoch+1 {
+		t.Errorf("epoch = %d, want %d", carol.Epoch(), oldEpoch+1)
+	}
+	if bytes.Equal(carol.InitPub(), oldPub) {
+		t.Error("SelfUpdate should publish a new init key")
+	}
+	if carol.FindLeafIndex(carol.InitPub()) != 2 {
+		t.Error("new init key should be at carol's leaf")
+	}
+
+	committed, _ := carol.ToCommittedBytes()
+	for i, m := range members {
+		if i == 2 {
+			continue
+		}
+		if ok, err := m.SyncFromCommitted(committed); !ok {
+			t.Fatalf("member %d failed to sync self-update: %v", i, err)
+		}
+		if !bytes.Equal(m.ExportEpochSecret(), carol.ExportEpochSecret()) {
+			t.Fatalf("member %d secret mismatch after self-update", i)
+		}
+	}
+
+	// Carol's new key must work for later commits by others.
+	if _, err := members[0].RemoveMember(3); err != nil {
+		t.Fatal(err)
+	}
+	committed, _ = members[0].ToCommittedBytes()
+	if ok, err := carol.SyncFromCommitted(committed); !ok {
+		t.Fatalf("carol should sync with rotated key: %v", err)
+	}
+	if !bytes.Equal(carol.ExportEpochSecret(), members[0].ExportEpochSecret()) {
+		t.Error("carol's secret should match after later removal")
+	}
+}
+
+func TestSelfUpdateLocksOutStaleKeys(t *testing.T) {
+	members := buildGroup(t, 4)
+	carol := members[2]
+
+	// An attacker copies carol's state and init key before the update.
+	stolenState, _ := carol.ToBytes()
+	stolen, err := FromBytes(stolenState, ed25519.NewKeyFromSeed(carol.SigPriv()), carol.InitPriv())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := carol.SelfUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	committed, _ := carol.ToCommittedBytes()
+	members[0].SyncFromCommitted(committed)
+	if _, err := members[0].SelfUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	committed, _ = members[0].ToCommittedBytes()
+
+	if ok, _ := stolen.SyncFromCommitted(committed); ok {
+		t.Error("stale keys should not follow the group after a self-update")
+	}
+	if bytes.Equal(stolen.ExportEpochSecret(), members[0].ExportEpochSecret()) {
+		t.Error("stale keys must not yield the current epoch secret")
+	}
+}
+
+func TestRemoveMembersSingleEpoch(t *testing.T) {
+	members := buildGroup(t, 4)
+	alice, bob, carol, dave := members[0], members[1], members[2], members[3]
+
+	epoch := alice.Epoch()
+	if _, err := alice.RemoveMembers([]int{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if alice.Epoch() != epoch+1 {
+		t.Errorf("epoch = %d, want %d", alice.Epoch(), epoch+1)
+	}
+	if alice.MemberCount() != 2 {
+		t.Errorf("member count = %d, want 2", alice.MemberCount())
+	}
+
+	committed, _ := alice.ToCommittedBytes()
+	if ok, err := bob.SyncFromCommitted(committed); !ok {
+		t.Fatalf("bob sync: %v", err)
+	}
+	if !bytes.Equal(bob.ExportEpochSecret(), alice.ExportEpochSecret()) {
+		t.Error("bob should share the new epoch secret")
+	}
+	for name, m := range map[string]*MLSGitGroup{"carol": carol, "dave": dave} {
+		if ok, _ := m.SyncFromCommitted(committed); ok {
+			t.Errorf("%s should not follow after removal", name)
+		}
+	}
+
+	if _, err := alice.RemoveMembers([]int{1, 1}); err == nil {
+		t.Error("duplicate leaves should be rejected")
+	}
+	if _, err := alice.RemoveMembers([]int{1, 0}); err == nil {
+		t.Error("removing self should be rejected")
+	}
+}
+
+// --- Post-quantum hybrid KEM tests ---
+
+func TestHybridGroupAddRemoveSync(t *testing.T) {
+	aliceKeys, _ := GenerateHybridMLSKeys()
+	alice, _ := Create([]byte("test-group"), []byte("alice"), aliceKeys)
+	alice.SetPostQuantum(true)
+
+	var members []*MLSGitGroup
+	for _, name := range []string{"bob", "carol"} {
+		k, err := GenerateHybridMLSKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		kp := BuildKeyPackage([]byte(name), k)
+		if err := kp.Verify(); err != nil {
+			t.Fatalf("hybrid key package should verify: %v", err)
+		}
+		_, welcome, err := alice.AddMember(kp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := JoinFromWelcome(welcome, k)
+		if err != nil {
+			t.Fatalf("join from hybrid welcome: %v", err)
+		}
+		members = append(members, m)
+	}
+	bob, carol := members[0], members[1]
+	committed, _ := alice.ToCommittedBytes()
+	bob.SyncFromCommitted(committed)
+
+	if _, err := alice.RemoveMember(carol.OwnLeafIndex()); err != nil {
+		t.Fatal(err)
+	}
+	enc := alice.state.UpdateEncaps[len(alice.state.UpdateEncaps)-1]
+	for _, pn := range enc.Path {
+		if len(pn.PQPublicKey) == 0 {
+			t.Errorf("path node %d should be hybrid", pn.Node)
+		}
+		for _, e := range pn.Entries {
+			if len(e.KEMCiphertext) == 0 {
+				t.Errorf("entry for node %d should carry an ML-KEM ciphertext", e.Node)
+			}
+		}
+	}
+
+	committed, _ = alice.ToCommittedBytes()
+	if ok, err := bob.SyncFromCommitted(committed); !ok {
+		t.Fatalf("bob should sync the hybrid removal: %v", err)
+	}
+	if !bytes.Equal(bob.ExportEpochSecret(), alice.ExportEpochSecret()) {
+		t.Error("bob's secret should match after hybrid removal")
+	}
+	if ok, _ := carol.SyncFromCommitted(committed); ok {
+		t.Error("removed member should not sync")
+	}
+}
+
+func TestSelfUpdateMigratesToHybrid(t *testing.T) {
+	members := buildGroup(t, 3)
+	for _, m := range members {
+		m.SetPostQuantum(true)
+	}
+	bob := members[1]
+	if bob.PQInitPub() != nil {
+		t.Fatal("buildGroup members should start with X25519-only keys")
+	}
+
+	// Bob migrates; the others still follow with X25519 keys.
+	if _, err := bob.SelfUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if bob.PQInitPub() == nil {
+		t.Fatal("SelfUpdate should publish a hybrid init key")
+	}
+	committed, _ := bob.ToCommittedBytes()
+	for i, m := range members {
+		if i == 1 {
+			continue
+		}
+		if ok, err := m.SyncFromCommitted(committed); !ok {
+			t.Fatalf("member %d failed to sync migration: %v", i, err)
+		}
+	}
+
+	// A later removal encrypts to bob's leaf with the hybrid KEM, and bob
+	// still derives the secret from his saved state.
+	saved, _ := bob.ToBytes()
+	bob, err := FromBytes(saved, ed25519.NewKeyFromSeed(bob.SigPriv()), bob.InitPriv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := members[0].RemoveMember(2); err != nil {
+		t.Fatal(err)
+	}
+	committed, _ = members[0].ToCommittedBytes()
+	if ok, err := bob.SyncFromCommitted(committed); !ok {
+		t.Fatalf("bob should sync with hybrid leaf: %v", err)
+	}
+	if !bytes.Equal(bob.ExportEpochSecret(), members[0].ExportEpochSecret()) {
+		t.Error("bob's secret should match after removal")
+	}
+}
@@ -0,0 +1,153 @@
+package mls
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	mlscrypto "github.com/germtb/mlsgit/internal/crypto"
+)
+
+// historyGrant is the plaintext of a history grant: exported secrets of
+// past epochs, encrypted to one leaf's init key.
+type historyGrant struct {
+	GroupID []byte            `json:"group_id"`
+	Secrets map[uint64][]byte `json:"secrets"`
+}
+
+// retain records the exported secret of a past epoch.
+func (g *MLSGitGroup) retain(epoch uint64, secret []byte) {
+	if g.state.Retained == nil {
+		g.state.Retained = make(map[uint64][]byte)
+	}
+	g.state.Retained[epoch] = secret
+}
+
+// retainEpochSecret retains the exported secret of the current epoch,
+// before an add that cuts the archive.
+func (g *MLSGitGroup) retainEpochSecret() {
+	g.retain(g.state.Epoch, g.ExportEpochSecret())
+}
+
+// RetainedSecrets returns the exported secrets this member holds for past
+// epochs outside the epoch key archive.
+func (g *MLSGitGroup) RetainedSecrets() map[int][]byte {
+	out := make(map[int][]byte, len(g.state.Retained))
+	for e, s := range g.state.Retained {
+		out[int(e)] = s
+	}
+	return out
+}
+
+// ForgetBefore drops retained secrets and fork checkpoints of every epoch
+// before epoch, after those epochs were shredded from the archive.
+func (g *MLSGitGroup) ForgetBefore(epoch int) {
+	for e := range g.state.Retained {
+		if int(e) < epoch {
+			delete(g.state.Retained, e)
+		}
+	}
+	kept := g.state.Checkpoints[:0]
+	for _, cp := range g.state.Checkpoints {
+		if int(cp.Epoch) >= epoch {
+			kept = append(kept, cp)
+		}
+	}
+	g.state.Checkpoints = kept
+}
+
+// HistoryCuts returns, in order, the epochs the archive must not wrap under
+// the epoch after them because a member was added from there without
+// access to history.
+func (g *MLSGitGroup) HistoryCuts() []int {
+	var cuts []int
+	for _, t := range g.state.Transitions {
+		if t.Op == OpAdd && t.HistoryCut {
+			cuts = append(cuts, int(t.FromEpoch))
+		}
+	}
+	sort.Ints(cuts)
+	return cuts
+}
+
+// OpenEpochArchive opens the committed epoch key archive (nil if there is
+// none yet) as seen from the current epoch, with our retained secrets
+// added. Secrets the archive records as shredded are forgotten.
+func (g *MLSGitGroup) OpenEpochArchive(data []byte) (*EpochKeyArchive, error) {
+	var archive *EpochKeyArchive
+	if data == nil {
+		archive = NewWithSecret(g.Epoch(), g.ExportEpochSecret())
+	} else {
+		var err error
+		if archive, err = DecryptArchive(data, g.Epoch(), g.ExportEpochSecret()); err != nil {
+			return nil, err
+		}
+	}
+	g.ForgetBefore(archive.ShreddedBefore())
+	for e, s := range g.state.Retained {
+		archive.Add(int(e), s)
+	}
+	return archive, nil
+}
+
+// GrantHistory encrypts the given exported epoch secrets to the init key
+// currently published at leaf, for that member to pick up with
+// AcceptHistoryGrant.
+func (g *MLSGitGroup) GrantHistory(leaf int, secrets map[int][]byte) ([]byte, error) {
+	grant := historyGrant{GroupID: g.state.GroupID, Secrets: make(map[uint64][]byte, len(secrets))}
+	for e, s := range secrets {
+		grant.Secrets[uint64(e)] = s
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return nil, fmt.Errorf("marshal history grant: %w", err)
+	}
+	return g.SealToLeaf(leaf, data)
+}
+
+// SealToLeaf encrypts data to the init key currently published at leaf,
+// for that member to open with OpenSealed.
+func (g *MLSGitGroup) SealToLeaf(leaf int, data []byte) ([]byte, error) {
+	node := g.state.Tree.leaf(leaf)
+	if node == nil {
+		return nil, fmt.Errorf("leaf %d is blank", leaf)
+	}
+	if node.hybrid() {
+		return mlscrypto.EncryptWelcomeHybrid(node.PublicKey, node.PQPublicKey, data)
+	}
+	return mlscrypto.EncryptWelcome(node.PublicKey, data)
+}
+
+// OpenSealed decrypts data sealed to our leaf's init key.
+func (g *MLSGitGroup) OpenSealed(encrypted []byte) ([]byte, error) {
+	return mlscrypto.DecryptWelcome(g.initPriv, encrypted)
+}
+
+// AcceptHistoryGrant decrypts a grant made to our leaf and retains its
+// secrets. It returns how many epochs were new to us. A grant made to an
+// init key we have since replaced fails to decrypt.
+func (g *MLSGitGroup) AcceptHistoryGrant(encrypted []byte) (int, error) {
+	data, err := g.OpenSealed(encrypted)
+	if err != nil {
+		return 0, fmt.Errorf("decrypt history grant: %w", err)
+	}
+	var grant historyGrant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return 0, fmt.Errorf("unmarshal history grant: %w", err)
+	}
+	if !bytes.Equal(grant.GroupID, g.state.GroupID) {
+		return 0, fmt.Errorf("history grant belongs to a different group")
+	}
+	added := 0
+	for e, s := range grant.Secrets {
+		if e >= g.state.Epoch {
+			continue
+		}
+		if _, ok := g.state.Retained[e]; !ok {
+			added++
+		}
+		g.retain(e, s)
+	}
+	return added, nil
+}
@@ -0,0 +1,143 @@
+package mls
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAddMemberForwardOnlyCutsHistory(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+	secret1 := alice.ExportEpochSecret()
+	archive, err := alice.OpenEpochArchive(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carolKeys, _ := GenerateMLSKeys()
+	_, welcome, err := alice.AddMemberForwardOnly(BuildKeyPackage([]byte("carol"), carolKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.Add(alice.Epoch(), alice.ExportEpochSecret())
+	for _, c := range alice.HistoryCuts() {
+		archive.Cut(c)
+	}
+	sealed, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob retains the pre-cut secret when he syncs past the add
+	committed, _ := alice.ToCommittedBytes()
+	if _, err := bob.SyncFromCommitted(committed); err != nil {
+		t.Fatal(err)
+	}
+	if cuts := bob.HistoryCuts(); len(cuts) != 1 || cuts[0] != 1 {
+		t.Errorf("HistoryCuts = %v, want [1]", cuts)
+	}
+	bobArchive, err := bob.OpenEpochArchive(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := bobArchive.Get(1); err != nil || !bytes.Equal(s, secret1) {
+		t.Errorf("bob's epoch 1 secret after the cut: %v", err)
+	}
+
+	// Carol cannot reach it through the archive
+	carol, err := JoinFromWelcome(welcome, carolKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	carolArchive, err := carol.OpenEpochArchive(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing *MissingEpochError
+	if _, err := carolArchive.Get(1); !errors.As(err, &missing) {
+		t.Errorf("carol's epoch 1: err = %v, want MissingEpochError", err)
+	}
+}
+
+func TestHistoryGrantRoundtrip(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice := members[0]
+	archive, _ := alice.OpenEpochArchive(nil)
+
+	carolKeys, _ := GenerateMLSKeys()
+	_, welcome, err := alice.AddMemberForwardOnly(BuildKeyPackage([]byte("carol"), carolKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol, err := JoinFromWelcome(welcome, carolKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1, err := archive.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, err := alice.GrantHistory(carol.OwnLeafIndex(), map[int][]byte{1: s1, 2: alice.ExportEpochSecret()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := carol.AcceptHistoryGrant(grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("accepted %d new epochs, want 1 (the current epoch is not retained)", n)
+	}
+	if n, _ := carol.AcceptHistoryGrant(grant); n != 0 {
+		t.Errorf("accepting the same grant again added %d epochs", n)
+	}
+	if got := carol.RetainedSecrets()[1]; !bytes.Equal(got, s1) {
+		t.Error("carol did not retain the granted epoch 1 secret")
+	}
+
+	// A grant from another group is rejected
+	otherKeys, _ := GenerateMLSKeys()
+	other, _ := Create([]byte("other-group"), []byte("mallory"), otherKeys)
+	other.AddMember(BuildKeyPackage([]byte("carol"), carolKeys))
+	foreign, err := other.GrantHistory(1, map[int][]byte{0: s1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := carol.AcceptHistoryGrant(foreign); err == nil {
+		t.Error("expected a grant from another group to be rejected")
+	}
+}
+
+func TestOpenEpochArchiveForgetsShreddedSecrets(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice := members[0]
+	carolKeys, _ := GenerateMLSKeys()
+	if _, _, err := alice.AddMemberForwardOnly(BuildKeyPackage([]byte("carol"), carolKeys)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := alice.RetainedSecrets()[1]; !ok {
+		t.Fatal("alice should retain the pre-cut secret")
+	}
+
+	archive, err := alice.OpenEpochArchive(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.Shred(2)
+	sealed, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := alice.OpenEpochArchive(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alice.RetainedSecrets()) != 0 {
+		t.Error("retained secrets of shredded epochs should be forgotten")
+	}
+	if _, err := reopened.Get(1); err == nil {
+		t.Error("epoch 1 should be gone after the shred")
+	}
+}
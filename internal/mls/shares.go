@@ -0,0 +1,73 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	mlscrypto "github.com/germtb/mlsgit/internal/crypto"
+)
+
+// sharedSecretsSize is the size of the secrets split by SplitSecrets:
+// epoch(8) || epoch secret(32) || archive key(32).
+const sharedSecretsSize = 8 + 32 + 32
+
+// RecoveredSecrets are the secrets of one epoch rebuilt from Shamir shares.
+type RecoveredSecrets struct {
+	Epoch       int
+	EpochSecret []byte
+	ArchiveKey  []byte // exported epoch secret, which opens the epoch key archive
+}
+
+// SplitSecrets splits the current epoch secret and archive key into n
+// Shamir shares, any k of which rebuild them with CombineSecrets.
+func (g *MLSGitGroup) SplitSecrets(n, k int) ([][]byte, error) {
+	secrets := make([]byte, 8, sharedSecretsSize)
+	binary.BigEndian.PutUint64(secrets, g.state.Epoch)
+	secrets = append(secrets, g.state.EpochSecret...)
+	secrets = append(secrets, g.ExportEpochSecret()...)
+	return mlscrypto.SplitSecret(secrets, n, k)
+}
+
+// CombineSecrets rebuilds the secrets split by SplitSecrets. The archive
+// key doubles as a checksum, so too few shares, or shares from different
+// epochs, fail here rather than yielding wrong keys.
+func CombineSecrets(shares [][]byte) (RecoveredSecrets, error) {
+	secrets, err := mlscrypto.CombineShares(shares)
+	if err != nil {
+		return RecoveredSecrets{}, err
+	}
+	if len(secrets) != sharedSecretsSize {
+		return RecoveredSecrets{}, fmt.Errorf("shares hold %d bytes, want %d", len(secrets), sharedSecretsSize)
+	}
+	rs := RecoveredSecrets{
+		Epoch:       int(binary.BigEndian.Uint64(secrets[:8])),
+		EpochSecret: secrets[8:40],
+		ArchiveKey:  secrets[40:],
+	}
+	if !bytes.Equal(exportSecret(rs.EpochSecret, []byte("mlsgit-epoch-secret"), nil, 32), rs.ArchiveKey) {
+		return RecoveredSecrets{}, fmt.Errorf("shares do not combine to a valid epoch secret (too few, or from different epochs)")
+	}
+	return rs, nil
+}
+
+// Refound starts a new group with the caller as the sole member, one
+// epoch after the recovered one. The new epoch secret mixes fresh
+// randomness into the recovered one, so the epoch key archive keeps
+// chaining back through the old group while the share holders cannot
+// derive it.
+func Refound(groupID, identity []byte, keys MLSKeys, from RecoveredSecrets) (*MLSGitGroup, error) {
+	g, err := Create(groupID, identity, keys)
+	if err != nil {
+		return nil, err
+	}
+	commitSecret := make([]byte, 32)
+	if _, err := rand.Read(commitSecret); err != nil {
+		return nil, fmt.Errorf("generate commit secret: %w", err)
+	}
+	g.state.Epoch = uint64(from.Epoch)
+	g.state.EpochSecret = from.EpochSecret
+	g.advanceEpochWithSecret(commitSecret)
+	return g, nil
+}
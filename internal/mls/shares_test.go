@@ -0,0 +1,63 @@
+package mls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitSecretsRefound(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice := members[0]
+	archive, err := alice.OpenEpochArchive(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := archive.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shares, err := alice.SplitSecrets(3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineSecrets(shares[:1]); err == nil {
+		t.Error("one share should not combine")
+	}
+	rs, err := CombineSecrets([][]byte{shares[2], shares[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Epoch != alice.Epoch() || !bytes.Equal(rs.ArchiveKey, alice.ExportEpochSecret()) {
+		t.Fatalf("recovered epoch %d, want %d with alice's archive key", rs.Epoch, alice.Epoch())
+	}
+
+	// A new identity re-founds the group and still reaches the old archive
+	keys, _ := GenerateMLSKeys()
+	g, err := Refound([]byte("refounded"), []byte("dave"), keys, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Epoch() != rs.Epoch+1 || g.MemberCount() != 1 {
+		t.Errorf("refounded group at epoch %d with %d members", g.Epoch(), g.MemberCount())
+	}
+	if bytes.Equal(g.ExportEpochSecret(), rs.ArchiveKey) {
+		t.Error("refounded epoch reuses the recovered secret")
+	}
+	old, err := DecryptArchive(sealed, rs.Epoch, rs.ArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Add(g.Epoch(), g.ExportEpochSecret())
+	resealed, err := old.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := g.OpenEpochArchive(resealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := reopened.Get(rs.Epoch); err != nil || !bytes.Equal(s, rs.ArchiveKey) {
+		t.Errorf("old epoch through the refounded archive: %v", err)
+	}
+}
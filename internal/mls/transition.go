@@ -0,0 +1,313 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// Transition operations, as passed to an AuthorizeFunc.
+const (
+	OpAdd    = "add"
+	OpRemove = "remove"
+	OpUpdate = "update"
+)
+
+// transition is a signed record of a single epoch change. Each transition
+// names the member that made it, describes the tree operation so it can be
+// replayed, binds the update path (if any) by hash, and chains to the
+// previous transition by hash. Members syncing from committed state replay
+// the chain from their own epoch and reject any step that is unsigned, not
+// signed by a member of the previous epoch, or does not reproduce the
+// committed tree.
+type transition struct {
+	FromEpoch  uint64 `json:"from_epoch"`
+	Signer     int    `json:"signer"` // leaf index of the committer at FromEpoch
+	Op         string `json:"op"`
+	Leaf       int    `json:"leaf"`                  // leaf that was added, removed or updated
+	Leaves     []int  `json:"leaves,omitempty"`      // remove only: every removed leaf, if more than one
+	SigPub     []byte `json:"sig_pub,omitempty"`     // add only
+	InitPub    []byte `json:"init_pub,omitempty"`    // add and update
+	PQInitPub  []byte `json:"pq_init_pub,omitempty"` // add and update, hybrid leaves only
+	HistoryCut bool   `json:"history_cut,omitempty"` // add only: the archive does not lead the new member back past FromEpoch
+	PrevHash   []byte `json:"prev_hash"`
+	EncapHash  []byte `json:"encap_hash,omitempty"` // remove and update
+	TreeHash   []byte `json:"tree_hash"`            // tree after the transition
+	Signature  []byte `json:"signature,omitempty"`
+}
+
+// signedBytes returns the bytes covered by the transition signature.
+func (t transition) signedBytes(groupID []byte) []byte {
+	t.Signature = nil
+	data, err := json.Marshal(struct {
+		Label      string     `json:"label"`
+		GroupID    []byte     `json:"group_id"`
+		Transition transition `json:"transition"`
+	}{"mlsgit-transition", groupID, t})
+	if err != nil {
+		panic(fmt.Sprintf("marshal transition: %v", err))
+	}
+	return data
+}
+
+// hash returns the transcript hash that the next transition chains to.
+func (t transition) hash() []byte {
+	data, err := json.Marshal(t)
+	if err != nil {
+		panic(fmt.Sprintf("marshal transition: %v", err))
+	}
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// removedLeaves returns the leaves a remove transition blanks.
+func (t transition) removedLeaves() []int {
+	if len(t.Leaves) > 0 {
+		return t.Leaves
+	}
+	return []int{t.Leaf}
+}
+
+// encapHash binds an update path to the transition that carried it.
+func encapHash(enc updateEncap) []byte {
+	data, err := json.Marshal(enc)
+	if err != nil {
+		panic(fmt.Sprintf("marshal encap: %v", err))
+	}
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// genesisTranscriptHash is the chain anchor for a newly created group, and
+// for state written before transitions existed.
+func genesisTranscriptHash(groupID []byte) []byte {
+	h := sha256.Sum256(append([]byte("mlsgit-genesis"), groupID...))
+	return h[:]
+}
+
+// recordTransition signs the transition that just moved the group from
+// t.FromEpoch to the current epoch and appends it to the transcript. The
+// caller fills in the operation; enc is the update path that carried the
+// transition, or nil for adds.
+func (g *MLSGitGroup) recordTransition(t transition, enc *updateEncap) {
+	t.Signer = g.state.OwnLeafIndex
+	t.PrevHash = g.state.TranscriptHash
+	t.TreeHash = g.state.Tree.hash()
+	if enc != nil {
+		t.EncapHash = encapHash(*enc)
+	}
+	t.Signature = ed25519.Sign(g.sigKey, t.signedBytes(g.state.GroupID))
+	g.state.Transitions = append(g.state.Transitions, t)
+	g.state.TranscriptHash = t.hash()
+}
+
+// verifyTransition checks a transition against the tree at its FromEpoch and
+// returns the tree it produces. prevHash is the transcript hash we hold for
+// FromEpoch, which the transition must chain to.
+func verifyTransition(groupID []byte, tree *ratchetTree, prevHash []byte, t transition, enc *updateEncap) (ratchetTree, error) {
+	signer := tree.leaf(t.Signer)
+	if signer == nil {
+		return ratchetTree{}, fmt.Errorf("signer leaf %d is not an active member", t.Signer)
+	}
+	if !ed25519.Verify(signer.SigPub, t.signedBytes(groupID), t.Signature) {
+		return ratchetTree{}, fmt.Errorf("invalid signature from leaf %d", t.Signer)
+	}
+	if !bytes.Equal(t.PrevHash, prevHash) {
+		return ratchetTree{}, fmt.Errorf("transition does not chain to the previous state")
+	}
+
+	next := tree.clone()
+	switch t.Op {
+	case OpAdd:
+		if enc != nil {
+			return ratchetTree{}, fmt.Errorf("add transition carries an update path")
+		}
+		if idx := next.addLeaf(t.SigPub, t.InitPub, t.PQInitPub); idx != t.Leaf {
+			return ratchetTree{}, fmt.Errorf("added leaf %d, transition claims %d", idx, t.Leaf)
+		}
+	case OpRemove:
+		for _, leaf := range t.removedLeaves() {
+			if err := next.checkLeaf(leaf); err != nil {
+				return ratchetTree{}, err
+			}
+			if leaf == t.Signer {
+				return ratchetTree{}, fmt.Errorf("member %d removed itself", leaf)
+			}
+			next.blankLeaf(leaf)
+		}
+		next.truncate()
+		if err := applyUpdatePath(&next, t, enc); err != nil {
+			return ratchetTree{}, err
+		}
+	case OpUpdate:
+		if t.Leaf != t.Signer {
+			return ratchetTree{}, fmt.Errorf("leaf %d updated by leaf %d", t.Leaf, t.Signer)
+		}
+		next.Nodes[2*t.Leaf].PublicKey = t.InitPub
+		next.Nodes[2*t.Leaf].PQPublicKey = t.PQInitPub
+		if err := applyUpdatePath(&next, t, enc); err != nil {
+			return ratchetTree{}, err
+		}
+	default:
+		return ratchetTree{}, fmt.Errorf("unknown transition op %q", t.Op)
+	}
+
+	if !bytes.Equal(next.hash(), t.TreeHash) {
+		return ratchetTree{}, fmt.Errorf("tree hash mismatch")
+	}
+	return next, nil
+}
+
+// applyUpdatePath installs the public keys from the signer's update path.
+func applyUpdatePath(tree *ratchetTree, t transition, enc *updateEncap) error {
+	if enc == nil {
+		return fmt.Errorf("%s transition has no update path", t.Op)
+	}
+	if !bytes.Equal(encapHash(*enc), t.EncapHash) {
+		return fmt.Errorf("update path does not match transition")
+	}
+	if enc.Committer != t.Signer {
+		return fmt.Errorf("update path from leaf %d, signer is leaf %d", enc.Committer, t.Signer)
+	}
+	path := directPath(2*t.Signer, tree.leafCount())
+	if len(enc.Path) != len(path) {
+		return fmt.Errorf("update path has %d nodes, want %d", len(enc.Path), len(path))
+	}
+	for i, pn := range enc.Path {
+		if pn.Node != path[i] {
+			return fmt.Errorf("update path node %d, want %d", pn.Node, path[i])
+		}
+		tree.Nodes[pn.Node] = &treeNode{PublicKey: pn.PublicKey, PQPublicKey: pn.PQPublicKey}
+	}
+	return nil
+}
+
+// AuthorizeFunc decides whether the member whose leaf signs with signer may
+// make a transition from epoch. targets holds the signature keys of the
+// leaves an add or remove affects. The decision should rest on the group as
+// it was at epoch, since a client catching up replays transitions whose
+// signers and targets may have left since. A non-nil error rejects the
+// committed state.
+type AuthorizeFunc func(epoch uint64, op string, signer []byte, targets [][]byte) error
+
+// SetAuthorizer installs a policy check run on every transition replayed
+// from committed state.
+func (g *MLSGitGroup) SetAuthorizer(f AuthorizeFunc) {
+	g.authorize = f
+}
+
+// authorizeTransition runs the authorizer, if any, against the tree at the
+// transition's FromEpoch.
+func (g *MLSGitGroup) authorizeTransition(tree *ratchetTree, t transition) error {
+	if g.authorize == nil {
+		return nil
+	}
+	var targets [][]byte
+	switch t.Op {
+	case OpAdd:
+		targets = [][]byte{t.SigPub}
+	case OpRemove:
+		for _, idx := range t.removedLeaves() {
+			if leaf := tree.leaf(idx); leaf != nil {
+				targets = append(targets, leaf.SigPub)
+			}
+		}
+	}
+	return g.authorize(t.FromEpoch, t.Op, tree.leaf(t.Signer).SigPub, targets)
+}
+
+// transitionFrom returns the transition from epoch and its update path, if
+// the committed state holds them.
+func (c committedGroupState) transitionFrom(epoch uint64) (*transition, *updateEncap) {
+	var t *transition
+	for i := range c.Transitions {
+		if c.Transitions[i].FromEpoch == epoch {
+			t = &c.Transitions[i]
+			break
+		}
+	}
+	var enc *updateEncap
+	for i := range c.UpdateEncaps {
+		if c.UpdateEncaps[i].FromEpoch == epoch {
+			enc = &c.UpdateEncaps[i]
+			break
+		}
+	}
+	return t, enc
+}
+
+// verifyTransitions checks the signed transitions from the current epoch
+// to committed.Epoch like applyTransitions, without deriving any secrets,
+// so it works for a member who cannot follow them. The local state is not
+// changed.
+func (g *MLSGitGroup) verifyTransitions(committed committedGroupState) error {
+	if !bytes.Equal(committed.GroupID, g.state.GroupID) {
+		return fmt.Errorf("committed state belongs to a different group")
+	}
+	tree := g.state.Tree.clone()
+	prevHash := g.state.TranscriptHash
+	for epoch := g.state.Epoch; epoch < committed.Epoch; epoch++ {
+		t, enc := committed.transitionFrom(epoch)
+		if t == nil {
+			return fmt.Errorf("no signed transition from epoch %d", epoch)
+		}
+		next, err := verifyTransition(g.state.GroupID, &tree, prevHash, *t, enc)
+		if err != nil {
+			return fmt.Errorf("transition from epoch %d: %w", epoch, err)
+		}
+		if err := g.authorizeTransition(&tree, *t); err != nil {
+			return fmt.Errorf("transition from epoch %d: %w", epoch, err)
+		}
+		tree, prevHash = next, t.hash()
+	}
+	if !bytes.Equal(tree.hash(), committed.Tree.hash()) {
+		return fmt.Errorf("committed tree does not match signed transitions")
+	}
+	return nil
+}
+
+// applyTransitions ratchets from the current epoch to committed.Epoch,
+// verifying each signed transition and replaying it on the tree. On success
+// the replayed tree must equal the committed tree. If visit is non-nil it is
+// called after each step.
+func (g *MLSGitGroup) applyTransitions(committed committedGroupState, visit func()) error {
+	if !bytes.Equal(committed.GroupID, g.state.GroupID) {
+		return fmt.Errorf("committed state belongs to a different group")
+	}
+	for g.state.Epoch < committed.Epoch {
+		t, enc := committed.transitionFrom(g.state.Epoch)
+		if t == nil {
+			return fmt.Errorf("no signed transition from epoch %d", g.state.Epoch)
+		}
+
+		next, err := verifyTransition(g.state.GroupID, &g.state.Tree, g.state.TranscriptHash, *t, enc)
+		if err != nil {
+			return fmt.Errorf("transition from epoch %d: %w", g.state.Epoch, err)
+		}
+		if err := g.authorizeTransition(&g.state.Tree, *t); err != nil {
+			return fmt.Errorf("transition from epoch %d: %w", g.state.Epoch, err)
+		}
+		g.checkpoint()
+		if t.Op == OpAdd && t.HistoryCut {
+			g.retainEpochSecret()
+		}
+		if enc != nil {
+			if err := g.applyDHAdvance(*enc); err != nil {
+				return fmt.Errorf("advance to epoch %d: %w", g.state.Epoch+1, err)
+			}
+		} else {
+			g.advanceEpoch()
+		}
+		g.state.Tree = next
+		g.state.TranscriptHash = t.hash()
+		if visit != nil {
+			visit()
+		}
+	}
+	if !bytes.Equal(g.state.Tree.hash(), committed.Tree.hash()) {
+		return fmt.Errorf("committed tree does not match signed transitions")
+	}
+	return nil
+}
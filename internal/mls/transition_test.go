@@ -0,0 +1,235 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mutateCommitted(t *testing.T, g *MLSGitGroup, mutate func(c *committedGroupState)) []byte {
+	t.Helper()
+	data, _ := g.ToCommittedBytes()
+	c, err := parseCommitted(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(&c)
+	out, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func expectSyncRejected(t *testing.T, g *MLSGitGroup, committed []byte, want string) {
+	t.Helper()
+	before, _ := g.ToBytes()
+	updated, err := g.SyncFromCommitted(committed)
+	if updated || err == nil {
+		t.Fatalf("sync should be rejected (updated=%v, err=%v)", updated, err)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want it to mention %q", err, want)
+	}
+	if after, _ := g.ToBytes(); !bytes.Equal(before, after) {
+		t.Error("rejected sync should leave local state untouched")
+	}
+}
+
+func TestTransitionsSignedAndChained(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice := members[0]
+	if _, err := alice.RemoveMember(2); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := alice.state.Transitions
+	if len(ts) != alice.Epoch() {
+		t.Fatalf("transitions = %d, want one per epoch (%d)", len(ts), alice.Epoch())
+	}
+	prev := genesisTranscriptHash(alice.state.GroupID)
+	for i, tr := range ts {
+		if tr.FromEpoch != uint64(i) {
+			t.Errorf("transition %d from epoch %d", i, tr.FromEpoch)
+		}
+		if !bytes.Equal(tr.PrevHash, prev) {
+			t.Errorf("transition %d does not chain to its predecessor", i)
+		}
+		if !ed25519.Verify(alice.sigKey.Public().(ed25519.PublicKey), tr.signedBytes(alice.state.GroupID), tr.Signature) {
+			t.Errorf("transition %d has an invalid signature", i)
+		}
+		prev = tr.hash()
+	}
+	if ts[len(ts)-1].Op != OpRemove {
+		t.Errorf("last op = %q, want %q", ts[len(ts)-1].Op, OpRemove)
+	}
+}
+
+func TestSyncRejectsUnsignedEpochBump(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	// The host injects a member and bumps the epoch without a transition.
+	mallory, _ := GenerateMLSKeys()
+	forged := mutateCommitted(t, alice, func(c *committedGroupState) {
+		c.Tree.addLeaf(mallory.SigPub, mallory.InitPub, nil)
+		c.Epoch++
+	})
+	expectSyncRejected(t, bob, forged, "no signed transition")
+}
+
+func TestSyncRejectsTransitionFromNonMember(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	// Mallory, who is not in the group, signs her own addition.
+	mallory, _ := GenerateMLSKeys()
+	outsider := &MLSGitGroup{state: alice.snapshot(), sigKey: mallory.SigPriv}
+	outsider.state.Tree = alice.state.Tree.clone()
+	outsider.state.Tree.Nodes[0].SigPub = mallory.SigPub
+	outsider.AddMember(BuildKeyPackage([]byte("mallory"), mallory))
+	forged := mutateCommitted(t, outsider, func(c *committedGroupState) {
+		c.Tree.Nodes[0].SigPub = alice.state.Tree.Nodes[0].SigPub
+	})
+	expectSyncRejected(t, bob, forged, "invalid signature")
+}
+
+func TestSyncRejectsTamperedTree(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice, bob := members[0], members[1]
+	if _, err := alice.RemoveMember(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A valid transition log, but the host swaps in an extra leaf.
+	mallory, _ := GenerateMLSKeys()
+	forged := mutateCommitted(t, alice, func(c *committedGroupState) {
+		c.Tree.addLeaf(mallory.SigPub, mallory.InitPub, nil)
+	})
+	expectSyncRejected(t, bob, forged, "does not match signed transitions")
+}
+
+func TestSyncRejectsTransitionFromRemovedMember(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice, bob, carol := members[0], members[1], members[2]
+
+	// Alice removes carol; bob follows.
+	alice.RemoveMember(2)
+	committed, _ := alice.ToCommittedBytes()
+	if ok, err := bob.SyncFromCommitted(committed); !ok {
+		t.Fatal(err)
+	}
+
+	// Carol, now removed, signs a transition on top of alice's state.
+	carol.state = alice.snapshot()
+	carol.state.Tree = alice.state.Tree.clone()
+	carol.state.OwnLeafIndex = 2
+	mallory, _ := GenerateMLSKeys()
+	carol.AddMember(BuildKeyPackage([]byte("mallory"), mallory))
+	committed, _ = carol.ToCommittedBytes()
+	expectSyncRejected(t, bob, committed, "not an active member")
+}
+
+func TestSyncReportsVerifiedRemoval(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice, carol := members[0], members[2]
+
+	// The host blanks carol's leaf without a signed removal: an error, not
+	// a removal.
+	forged := mutateCommitted(t, alice, func(c *committedGroupState) {
+		c.Tree.blankLeaf(2)
+		c.Tree.truncate()
+		c.Epoch++
+	})
+	expectSyncRejected(t, carol, forged, "no signed transition")
+
+	// A genuine removal verifies and is reported as one.
+	alice.RemoveMember(2)
+	committed, _ := alice.ToCommittedBytes()
+	before, _ := carol.ToBytes()
+	if ok, err := carol.SyncFromCommitted(committed); ok || !errors.Is(err, ErrRemoved) {
+		t.Errorf("sync after removal = %v, %v; want ErrRemoved", ok, err)
+	}
+	if after, _ := carol.ToBytes(); !bytes.Equal(before, after) {
+		t.Error("a removal should leave local state untouched")
+	}
+}
+
+func TestSyncRequiresGenesisAnchor(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	// An empty transcript hash no longer accepts whatever a transition
+	// chains to.
+	xKeys, _ := GenerateMLSKeys()
+	tree := alice.state.Tree.clone()
+	alice.AddMember(BuildKeyPackage([]byte("x"), xKeys))
+	tr := alice.state.Transitions[len(alice.state.Transitions)-1]
+	if _, err := verifyTransition(alice.state.GroupID, &tree, nil, tr, nil); err == nil || !strings.Contains(err.Error(), "does not chain") {
+		t.Errorf("empty prevHash: %v", err)
+	}
+	if _, err := verifyTransition(alice.state.GroupID, &tree, tr.PrevHash, tr, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// State written before transitions existed is anchored at genesis.
+	state, _ := bob.ToBytes()
+	var raw map[string]json.RawMessage
+	json.Unmarshal(state, &raw)
+	delete(raw, "transcript_hash")
+	state, _ = json.Marshal(raw)
+	restored, err := FromBytes(state, bob.sigKey, bob.InitPriv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored.state.TranscriptHash, genesisTranscriptHash(bob.state.GroupID)) {
+		t.Error("legacy state should be anchored at the genesis hash")
+	}
+}
+
+func TestSyncRejectsForkedHistory(t *testing.T) {
+	members := buildGroup(t, 2)
+	alice, bob := members[0], members[1]
+
+	// Alice and bob each add someone at the same epoch.
+	xKeys, _ := GenerateMLSKeys()
+	alice.AddMember(BuildKeyPackage([]byte("x"), xKeys))
+	yKeys, _ := GenerateMLSKeys()
+	bob.AddMember(BuildKeyPackage([]byte("y"), yKeys))
+
+	// Bob continues his branch; alice must not accept it.
+	zKeys, _ := GenerateMLSKeys()
+	bob.AddMember(BuildKeyPackage([]byte("z"), zKeys))
+	committed, _ := bob.ToCommittedBytes()
+	expectSyncRejected(t, alice, committed, "forked at epoch 1")
+}
+
+func TestApplyCommitRejectsUnsignedTransition(t *testing.T) {
+	members := buildGroup(t, 3)
+	alice, bob := members[0], members[1]
+	alice.RemoveMember(2)
+	newKeys, _ := GenerateMLSKeys()
+	alice.AddMember(BuildKeyPackage([]byte("new"), newKeys))
+
+	forged := mutateCommitted(t, alice, func(c *committedGroupState) {
+		c.Transitions = c.Transitions[:len(c.Transitions)-1]
+	})
+	epoch := bob.Epoch()
+	if err := bob.ApplyCommit(forged); err == nil {
+		t.Error("ApplyCommit should reject a missing transition")
+	}
+	if bob.Epoch() != epoch {
+		t.Error("rejected commit should leave the epoch unchanged")
+	}
+
+	committed, _ := alice.ToCommittedBytes()
+	if err := bob.ApplyCommit(committed); err != nil {
+		t.Fatalf("ApplyCommit with full log: %v", err)
+	}
+	if !bytes.Equal(bob.ExportEpochSecret(), alice.ExportEpochSecret()) {
+		t.Error("epoch secrets should match after verified ApplyCommit")
+	}
+}
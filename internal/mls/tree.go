@@ -0,0 +1,270 @@
+package mls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// The ratchet tree is a left-balanced binary tree stored in the array
+// representation from RFC 9420 Appendix C: leaves live at even node indices
+// (leaf i is node 2i) and parents at odd indices. The leaf count is always a
+// power of two, so node indices stay stable as the tree is extended or
+// truncated and only the root moves.
+
+// level returns the level of node x in the tree (leaves are level 0).
+func level(x int) int {
+	if x&1 == 0 {
+		return 0
+	}
+	k := 0
+	for (x>>k)&1 == 1 {
+		k++
+	}
+	return k
+}
+
+// nodeWidth returns the number of nodes needed for n leaves.
+func nodeWidth(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return 2*(n-1) + 1
+}
+
+// rootNode returns the root node index of a tree with n leaves (n a power of two).
+func rootNode(n int) int {
+	return n - 1
+}
+
+// leftChild returns the left child of parent node x.
+func leftChild(x int) int {
+	k := level(x)
+	return x ^ (1 << (k - 1))
+}
+
+// rightChild returns the right child of parent node x.
+func rightChild(x int) int {
+	k := level(x)
+	return x ^ (3 << (k - 1))
+}
+
+// parentNode returns the parent of node x in a full tree.
+func parentNode(x int) int {
+	k := level(x)
+	b := (x >> (k + 1)) & 1
+	return (x | (1 << k)) ^ (b << (k + 1))
+}
+
+// siblingNode returns the other child of x's parent.
+func siblingNode(x int) int {
+	p := parentNode(x)
+	if x < p {
+		return rightChild(p)
+	}
+	return leftChild(p)
+}
+
+// directPath returns the ancestors of x up to and including the root,
+// ordered from the bottom up. The root has an empty direct path.
+func directPath(x, n int) []int {
+	r := rootNode(n)
+	var path []int
+	for x != r {
+		x = parentNode(x)
+		path = append(path, x)
+	}
+	return path
+}
+
+// copath returns the siblings of x and of each node on its direct path
+// except the root, ordered from the bottom up.
+func copath(x, n int) []int {
+	r := rootNode(n)
+	var cp []int
+	for x != r {
+		cp = append(cp, siblingNode(x))
+		x = parentNode(x)
+	}
+	return cp
+}
+
+// treeNode is a single node of the ratchet tree. A nil *treeNode is blank.
+// Leaves carry the member's signature key and X25519 init key; parents carry
+// an X25519 public key derived from a path secret plus the leaves that were
+// added below the node since its key was last set. Hybrid nodes also carry
+// an ML-KEM-768 public key, and their private key is the seed of both.
+type treeNode struct {
+	PublicKey      []byte `json:"public_key"`
+	PQPublicKey    []byte `json:"pq_public_key,omitempty"`
+	SigPub         []byte `json:"sig_pub,omitempty"`
+	UnmergedLeaves []int  `json:"unmerged_leaves,omitempty"`
+}
+
+// hybrid reports whether the node uses the X25519 + ML-KEM-768 KEM.
+func (n *treeNode) hybrid() bool {
+	return len(n.PQPublicKey) > 0
+}
+
+// ratchetTree is the public part of the group's TreeKEM state.
+type ratchetTree struct {
+	Nodes []*treeNode `json:"nodes"`
+}
+
+// newRatchetTree creates a tree holding a single leaf.
+func newRatchetTree(sigPub, initPub, pqInitPub []byte) ratchetTree {
+	return ratchetTree{Nodes: []*treeNode{{PublicKey: initPub, PQPublicKey: pqInitPub, SigPub: sigPub}}}
+}
+
+// clone returns a deep copy of the tree.
+func (t *ratchetTree) clone() ratchetTree {
+	c := ratchetTree{Nodes: make([]*treeNode, len(t.Nodes))}
+	for i, n := range t.Nodes {
+		if n == nil {
+			continue
+		}
+		c.Nodes[i] = &treeNode{
+			PublicKey:      append([]byte(nil), n.PublicKey...),
+			PQPublicKey:    append([]byte(nil), n.PQPublicKey...),
+			SigPub:         append([]byte(nil), n.SigPub...),
+			UnmergedLeaves: append([]int(nil), n.UnmergedLeaves...),
+		}
+	}
+	return c
+}
+
+// hash returns the SHA-256 of the tree's JSON encoding.
+func (t *ratchetTree) hash() []byte {
+	data, err := json.Marshal(t)
+	if err != nil {
+		panic(fmt.Sprintf("marshal tree: %v", err))
+	}
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// leafCount returns the number of leaf slots (blank or not) in the tree.
+func (t *ratchetTree) leafCount() int {
+	return (len(t.Nodes) + 1) / 2
+}
+
+// leaf returns the node for leaf index i, or nil if out of range or blank.
+func (t *ratchetTree) leaf(i int) *treeNode {
+	if i < 0 || 2*i >= len(t.Nodes) {
+		return nil
+	}
+	return t.Nodes[2*i]
+}
+
+// addLeaf places a new leaf in the leftmost blank slot, doubling the tree if
+// it is full, and records it as unmerged at every non-blank ancestor.
+// Returns the new leaf index.
+func (t *ratchetTree) addLeaf(sigPub, initPub, pqInitPub []byte) int {
+	idx := -1
+	for i := 0; i < t.leafCount(); i++ {
+		if t.Nodes[2*i] == nil {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		idx = t.leafCount()
+		t.extend()
+	}
+	t.Nodes[2*idx] = &treeNode{PublicKey: initPub, PQPublicKey: pqInitPub, SigPub: sigPub}
+	for _, p := range directPath(2*idx, t.leafCount()) {
+		if n := t.Nodes[p]; n != nil {
+			n.UnmergedLeaves = append(n.UnmergedLeaves, idx)
+		}
+	}
+	return idx
+}
+
+// extend doubles the number of leaf slots. Existing node indices are
+// unchanged; the old root becomes the left child of the new root.
+func (t *ratchetTree) extend() {
+	n := t.leafCount()
+	if n == 0 {
+		t.Nodes = make([]*treeNode, 1)
+		return
+	}
+	grown := make([]*treeNode, nodeWidth(2*n))
+	copy(grown, t.Nodes)
+	t.Nodes = grown
+}
+
+// truncate halves the tree while its right half is entirely blank.
+func (t *ratchetTree) truncate() {
+	for t.leafCount() > 1 {
+		n := t.leafCount()
+		empty := true
+		for i := n / 2; i < n; i++ {
+			if t.Nodes[2*i] != nil {
+				empty = false
+				break
+			}
+		}
+		if !empty {
+			return
+		}
+		t.Nodes = t.Nodes[:nodeWidth(n/2)]
+	}
+}
+
+// blankLeaf removes leaf i and blanks its direct path.
+func (t *ratchetTree) blankLeaf(i int) {
+	t.Nodes[2*i] = nil
+	for _, p := range directPath(2*i, t.leafCount()) {
+		t.Nodes[p] = nil
+	}
+}
+
+// resolution returns the set of non-blank nodes that collectively cover the
+// subtree rooted at x: x itself plus its unmerged leaves if x is non-blank,
+// otherwise the resolutions of its children.
+func (t *ratchetTree) resolution(x int) []int {
+	if n := t.Nodes[x]; n != nil {
+		res := []int{x}
+		for _, l := range n.UnmergedLeaves {
+			res = append(res, 2*l)
+		}
+		return res
+	}
+	if level(x) == 0 {
+		return nil
+	}
+	return append(t.resolution(leftChild(x)), t.resolution(rightChild(x))...)
+}
+
+// findLeaf returns the leaf index whose init key equals initPub, or -1.
+func (t *ratchetTree) findLeaf(initPub []byte) int {
+	for i := 0; i < t.leafCount(); i++ {
+		if n := t.Nodes[2*i]; n != nil && bytes.Equal(n.PublicKey, initPub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// memberCount returns the number of non-blank leaves.
+func (t *ratchetTree) memberCount() int {
+	count := 0
+	for i := 0; i < t.leafCount(); i++ {
+		if t.Nodes[2*i] != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// checkLeaf returns an error if leaf i is out of range or blank.
+func (t *ratchetTree) checkLeaf(i int) error {
+	if i < 0 || i >= t.leafCount() {
+		return fmt.Errorf("leaf index %d out of range [0, %d)", i, t.leafCount())
+	}
+	if t.Nodes[2*i] == nil {
+		return fmt.Errorf("leaf %d is blank", i)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package mls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeMath(t *testing.T) {
+	// 4 leaves:        3
+	//              1       5
+	//            0   2   4   6
+	if rootNode(4) != 3 {
+		t.Errorf("root(4) = %d, want 3", rootNode(4))
+	}
+	if got := directPath(0, 4); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("directPath(0) = %v, want [1 3]", got)
+	}
+	if got := copath(0, 4); !reflect.DeepEqual(got, []int{2, 5}) {
+		t.Errorf("copath(0) = %v, want [2 5]", got)
+	}
+	if got := directPath(6, 4); !reflect.DeepEqual(got, []int{5, 3}) {
+		t.Errorf("directPath(6) = %v, want [5 3]", got)
+	}
+	if got := directPath(3, 4); len(got) != 0 {
+		t.Errorf("directPath(root) = %v, want empty", got)
+	}
+	if leftChild(5) != 4 || rightChild(5) != 6 {
+		t.Errorf("children(5) = %d,%d want 4,6", leftChild(5), rightChild(5))
+	}
+	if got := directPath(4, 8); !reflect.DeepEqual(got, []int{5, 3, 7}) {
+		t.Errorf("directPath(4, 8 leaves) = %v, want [5 3 7]", got)
+	}
+}
+
+func TestTreeAddLeafExtendsAndReuses(t *testing.T) {
+	tree := newRatchetTree([]byte("s0"), []byte("p0"), nil)
+	if tree.addLeaf([]byte("s1"), []byte("p1"), nil) != 1 {
+		t.Fatal("second leaf should be at index 1")
+	}
+	if tree.addLeaf([]byte("s2"), []byte("p2"), nil) != 2 {
+		t.Fatal("third leaf should be at index 2")
+	}
+	if tree.leafCount() != 4 {
+		t.Errorf("leafCount = %d, want 4", tree.leafCount())
+	}
+
+	tree.blankLeaf(1)
+	if tree.memberCount() != 2 {
+		t.Errorf("memberCount = %d, want 2", tree.memberCount())
+	}
+	if got := tree.addLeaf([]byte("s3"), []byte("p3"), nil); got != 1 {
+		t.Errorf("blank leaf should be reused, got index %d", got)
+	}
+	if tree.findLeaf([]byte("p3")) != 1 {
+		t.Error("findLeaf should locate reused leaf")
+	}
+}
+
+func TestTreeResolutionIncludesUnmergedLeaves(t *testing.T) {
+	tree := newRatchetTree([]byte("s0"), []byte("p0"), nil)
+	tree.addLeaf([]byte("s1"), []byte("p1"), nil)
+	tree.Nodes[1] = &treeNode{PublicKey: []byte("parent")}
+	tree.addLeaf([]byte("s2"), []byte("p2"), nil)
+	tree.addLeaf([]byte("s3"), []byte("p3"), nil)
+
+	// Node 1 was set before leaves 2 and 3 existed, but they are not below it.
+	if got := tree.resolution(1); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("resolution(1) = %v, want [1]", got)
+	}
+	// Node 5 is blank, so its resolution is its two leaves.
+	if got := tree.resolution(5); !reflect.DeepEqual(got, []int{4, 6}) {
+		t.Errorf("resolution(5) = %v, want [4 6]", got)
+	}
+
+	tree.blankLeaf(3)
+	tree.Nodes[3] = &treeNode{PublicKey: []byte("root")}
+	tree.addLeaf([]byte("s4"), []byte("p4"), nil)
+	if got := tree.resolution(3); !reflect.DeepEqual(got, []int{3, 6}) {
+		t.Errorf("resolution(3) = %v, want [3 6]", got)
+	}
+}
+
+func TestTreeTruncate(t *testing.T) {
+	tree := newRatchetTree([]byte("s0"), []byte("p0"), nil)
+	for i := 1; i < 5; i++ {
+		tree.addLeaf([]byte{byte(i)}, []byte{byte(i)}, nil)
+	}
+	if tree.leafCount() != 8 {
+		t.Fatalf("leafCount = %d, want 8", tree.leafCount())
+	}
+	tree.blankLeaf(4)
+	tree.truncate()
+	if tree.leafCount() != 4 {
+		t.Errorf("leafCount after truncate = %d, want 4", tree.leafCount())
+	}
+	if tree.memberCount() != 4 {
+		t.Errorf("memberCount after truncate = %d, want 4", tree.memberCount())
+	}
+}
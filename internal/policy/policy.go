@@ -0,0 +1,505 @@
+// Package policy implements the signed role policy in .mlsgit/policy.toml.
+//
+// The policy assigns each member ID a role. Admins may add and remove
+// members and change the policy, writers may encrypt files, and readers may
+// only decrypt. Recovery leaves may change membership and the policy like
+// admins, without a quorum, but never encrypt files, so a policy with a
+// recovery leaf keeps add_quorum at 1. Every client checks the policy when
+// it syncs: the policy must be signed by an admin, and a new version must
+// be signed by an admin of the version the client accepted last (kept in
+// .git/mlsgit/), so a non-admin cannot promote themselves. Older versions
+// are ignored, so rolling the file back does not restore revoked roles.
+// Each version keeps the history of role changes, so records and
+// transitions are checked against the role their signer held at their
+// epoch.
+package policy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/storage"
+)
+
+// Role is a member's permission level.
+type Role string
+
+const (
+	Admin  Role = "admin"
+	Writer Role = "writer"
+	Reader Role = "reader"
+
+	// Recovery is the role of a recovery leaf. It is only assigned by
+	// 'mlsgit init --recovery-key' and 'mlsgit add-recovery'.
+	Recovery Role = "recovery"
+)
+
+// ParseRole validates a role name.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case Admin, Writer, Reader:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown role %q (want admin, writer or reader)", s)
+}
+
+// parseStoredRole parses a role read from the policy file, where the
+// recovery role and, in the role history, no role ("") may also appear.
+func parseStoredRole(s string) (Role, error) {
+	if r := Role(s); r == Recovery || r == "" {
+		return r, nil
+	}
+	return ParseRole(s)
+}
+
+// CanWrite reports whether the role may encrypt files.
+func (r Role) CanWrite() bool { return r == Admin || r == Writer }
+
+// CanManage reports whether the role may change membership and the policy.
+func (r Role) CanManage() bool { return r == Admin || r == Recovery }
+
+// Policy is a signed assignment of roles to member IDs, with the number of
+// admin approvals needed to add a member.
+type Policy struct {
+	Version   int
+	UpdatedBy string // author (member ID, plus device ID) that signed this version
+	Roles     map[string]Role
+	History   []RoleChange   // role history, oldest first
+	Quorum    []QuorumChange // add_quorum history, oldest first
+	Signature []byte
+}
+
+// New returns an unsigned policy with adminID as its only admin.
+func New(adminID string) *Policy {
+	return &Policy{Roles: map[string]Role{adminID: Admin}}
+}
+
+// Role returns the role of memberID, or "" if it has none.
+func (p *Policy) Role(memberID string) Role {
+	if p == nil {
+		return ""
+	}
+	return p.Roles[memberID]
+}
+
+// RecoveryIDs returns the sorted member IDs of recovery leaves.
+func (p *Policy) RecoveryIDs() []string {
+	var ids []string
+	if p == nil {
+		return ids
+	}
+	for id, r := range p.Roles {
+		if r == Recovery {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// signedBytes returns the canonical bytes covered by the signature.
+func (p *Policy) signedBytes() []byte {
+	ids := make([]string, 0, len(p.Roles))
+	for id := range p.Roles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	fmt.Fprintf(&b, "mlsgit-policy\nversion=%d\nupdated_by=%s\n", p.Version, p.UpdatedBy)
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%s=%s\n", id, p.Roles[id])
+	}
+	for _, c := range p.History {
+		fmt.Fprintf(&b, "role_change=%s since=%d from=%s to=%s\n", c.MemberID, c.Epoch, c.From, c.Role)
+	}
+	for _, q := range p.Quorum {
+		fmt.Fprintf(&b, "add_quorum=%d since=%d approvals=%d\n", q.Approvals, q.Epoch, len(q.Signatures))
+		for _, a := range q.Signatures {
+			fmt.Fprintf(&b, "%s:%s\n", a.Approver, crypto.B64Encode(a.Signature, false))
+		}
+	}
+	return []byte(b.String())
+}
+
+// ToTOML serializes the policy.
+func (p *Policy) ToTOML() string {
+	ids := make([]string, 0, len(p.Roles))
+	for id := range p.Roles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var b strings.Builder
+	fmt.Fprintf(&b, "[policy]\nversion = %d\nupdated_by = %q\nsignature = %q\n\n[roles]\n",
+		p.Version, p.UpdatedBy, crypto.B64Encode(p.Signature, false))
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%q = %q\n", id, p.Roles[id])
+	}
+	for _, c := range p.History {
+		fmt.Fprintf(&b, "\n[[role_change]]\nepoch = %d\nmember = %q\nfrom = %q\nrole = %q\n", c.Epoch, c.MemberID, c.From, c.Role)
+	}
+	for _, q := range p.Quorum {
+		fmt.Fprintf(&b, "\n[[quorum]]\nepoch = %d\napprovals = %d\n", q.Epoch, q.Approvals)
+		for _, a := range q.Signatures {
+			fmt.Fprintf(&b, "\n[[quorum.signature]]\napprover = %q\nsignature = %q\n", a.Approver, crypto.B64Encode(a.Signature, false))
+		}
+	}
+	return b.String()
+}
+
+// FromTOML parses a policy.
+func FromTOML(data []byte) (*Policy, error) {
+	var w struct {
+		Policy struct {
+			Version   int    `toml:"version"`
+			UpdatedBy string `toml:"updated_by"`
+			Signature string `toml:"signature"`
+		} `toml:"policy"`
+		Roles      map[string]string `toml:"roles"`
+		RoleChange []struct {
+			Epoch  int    `toml:"epoch"`
+			Member string `toml:"member"`
+			From   string `toml:"from"`
+			Role   string `toml:"role"`
+		} `toml:"role_change"`
+		Quorum []struct {
+			Epoch     int `toml:"epoch"`
+			Approvals int `toml:"approvals"`
+			Signature []struct {
+				Approver  string `toml:"approver"`
+				Signature string `toml:"signature"`
+			} `toml:"signature"`
+		} `toml:"quorum"`
+	}
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return nil, fmt.Errorf("parse policy TOML: %w", err)
+	}
+	sig, err := crypto.B64Decode(w.Policy.Signature, false)
+	if err != nil {
+		return nil, fmt.Errorf("decode policy signature: %w", err)
+	}
+	p := &Policy{
+		Version:   w.Policy.Version,
+		UpdatedBy: w.Policy.UpdatedBy,
+		Roles:     make(map[string]Role, len(w.Roles)),
+		Signature: sig,
+	}
+	for id, r := range w.Roles {
+		if r == "" {
+			return nil, fmt.Errorf("role of %s is empty", id)
+		}
+		role, err := parseStoredRole(r)
+		if err != nil {
+			return nil, fmt.Errorf("role of %s: %w", id, err)
+		}
+		p.Roles[id] = role
+	}
+	for _, c := range w.RoleChange {
+		from, err := parseStoredRole(c.From)
+		if err != nil {
+			return nil, fmt.Errorf("role change of %s: %w", c.Member, err)
+		}
+		role, err := parseStoredRole(c.Role)
+		if err != nil {
+			return nil, fmt.Errorf("role change of %s: %w", c.Member, err)
+		}
+		p.History = append(p.History, RoleChange{Epoch: c.Epoch, MemberID: c.Member, From: from, Role: role})
+	}
+	for _, q := range w.Quorum {
+		if q.Approvals < 1 {
+			return nil, fmt.Errorf("add_quorum must be at least 1")
+		}
+		change := QuorumChange{Epoch: q.Epoch, Approvals: q.Approvals}
+		for _, a := range q.Signature {
+			sig, err := crypto.B64Decode(a.Signature, false)
+			if err != nil {
+				return nil, fmt.Errorf("decode add_quorum approval: %w", err)
+			}
+			change.Signatures = append(change.Signatures, storage.Approval{Approver: a.Approver, Signature: sig})
+		}
+		p.Quorum = append(p.Quorum, change)
+	}
+	return p, nil
+}
+
+// verify checks the policy signature against the signer's committed key,
+// that the signer is an admin of signers, that any role and add_quorum
+// changes since signers were made the way checkRoleHistory and
+// checkQuorumHistory require, and that no recovery leaf sits next to an
+// add_quorum it could bypass.
+func (p *Policy) verify(paths storage.MLSGitPaths, signers *Policy) error {
+	memberID, deviceID := storage.SplitAuthor(p.UpdatedBy)
+	if !signers.Role(memberID).CanManage() {
+		return fmt.Errorf("policy version %d signed by %s, who is not an admin", p.Version, memberID)
+	}
+	if ids := p.RecoveryIDs(); len(ids) > 0 && p.AddQuorum() > 1 {
+		return fmt.Errorf("policy version %d sets add_quorum to %d while recovery leaf %s can add members without it", p.Version, p.AddQuorum(), ids[0])
+	}
+	pub, err := storage.DevicePublicKey(paths, memberID, deviceID)
+	if err != nil {
+		return fmt.Errorf("policy signer %s: %w", p.UpdatedBy, err)
+	}
+	if !crypto.Verify(pub, p.signedBytes(), p.Signature) {
+		return fmt.Errorf("policy version %d has an invalid signature", p.Version)
+	}
+	if err := p.checkRoleHistory(signers); err != nil {
+		return err
+	}
+	return p.checkQuorumHistory(paths, signers)
+}
+
+// Load reads and verifies the committed policy. It returns nil if the
+// repository has no policy and never had one, in which case every member
+// may do everything; once a version has been accepted, a missing file
+// yields that version.
+// The policy never moves backwards: if the committed version is older than
+// the one accepted last, the accepted version is returned instead.
+func Load(paths storage.MLSGitPaths) (*Policy, error) {
+	data, err := os.ReadFile(paths.PolicyTOML())
+	if os.IsNotExist(err) {
+		// Deleting the committed file does not switch the policy off once
+		// we have accepted a version.
+		if _, err := os.Stat(paths.LocalPolicy()); err == nil {
+			return loadAccepted(paths)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	p, err := FromTOML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signer must be an admin of the last version we accepted, or of
+	// this version if we have never seen one.
+	signers := p
+	var accepted *Policy
+	if localData, err := os.ReadFile(paths.LocalPolicy()); err == nil {
+		if accepted, err = FromTOML(localData); err != nil {
+			return nil, fmt.Errorf("local policy: %w", err)
+		}
+		switch {
+		case p.Version < accepted.Version:
+			// An older committed policy (e.g. after checking out an old
+			// commit) never replaces the one we accepted.
+			return accepted, nil
+		case p.Version == accepted.Version:
+			if !bytes.Equal(p.signedBytes(), accepted.signedBytes()) {
+				return nil, fmt.Errorf("policy version %d differs from the one accepted earlier", p.Version)
+			}
+			return p, nil
+		}
+		signers = accepted
+	}
+	if err := p.verify(paths, signers); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(paths.LocalPolicy(), data, 0o600); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// loadAccepted returns the policy version accepted last.
+func loadAccepted(paths storage.MLSGitPaths) (*Policy, error) {
+	data, err := os.ReadFile(paths.LocalPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("read local policy: %w", err)
+	}
+	p, err := FromTOML(data)
+	if err != nil {
+		return nil, fmt.Errorf("local policy: %w", err)
+	}
+	return p, nil
+}
+
+// Save signs a new version of the policy as author and writes it to
+// .mlsgit/ and the local accepted copy.
+func Save(paths storage.MLSGitPaths, p *Policy, author string, key ed25519.PrivateKey) error {
+	p.Version++
+	p.UpdatedBy = author
+	p.Signature = crypto.Sign(key, p.signedBytes())
+	data := []byte(p.ToTOML())
+	if err := os.WriteFile(paths.PolicyTOML(), data, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(paths.LocalPolicy(), data, 0o600)
+}
+
+// Require returns an error unless memberID may act as role. A nil policy
+// allows everything.
+func (p *Policy) Require(memberID string, role Role) error {
+	if p == nil {
+		return nil
+	}
+	got := p.Role(memberID)
+	switch role {
+	case Admin:
+		if got == Admin {
+			return nil
+		}
+	case Writer:
+		if got.CanWrite() {
+			return nil
+		}
+	case Reader:
+		if got != "" {
+			return nil
+		}
+	}
+	if got == "" {
+		got = "no role"
+	}
+	return fmt.Errorf("member %s has %s; this requires %s", memberID, got, role)
+}
+
+// Authorizer returns the check mlsgit runs on every synced transition: only
+// members who were admins at the transition's epoch may add or remove
+// members, except that a writer may add their own devices, whose leaves
+// they signed, and a new member needs as many valid approvals as add_quorum
+// required at the epoch they were added, when that is more than one.
+// Recovery leaves act as admins and need no quorum. Leaves are mapped to
+// member IDs through the committed KeyPackages and, for members removed
+// since, the keys kept under former/ with the role they held, so a client
+// that is behind can still check transitions from before a removal. A nil
+// policy lets any member add and remove members.
+func Authorizer(paths storage.MLSGitPaths, p *Policy) mls.AuthorizeFunc {
+	if p == nil {
+		return nil
+	}
+	return func(epoch uint64, op string, signer []byte, targets [][]byte) error {
+		if op == mls.OpUpdate {
+			return nil
+		}
+		owners := leafOwners(paths)
+		owner, ok := owners[string(signer)]
+		if !ok {
+			return fmt.Errorf("%s signed by a leaf with no member record", op)
+		}
+		if !owner.activeAt(int(epoch)) {
+			return fmt.Errorf("%s at epoch %d signed by %s outside their membership", op, epoch, owner.memberID)
+		}
+		role := owner.role(p, int(epoch))
+		if op == mls.OpAdd {
+			for _, t := range targets {
+				target := owners[string(t)]
+				if target.memberID == owner.memberID && target.deviceID != "" && role.CanWrite() {
+					continue // linking one's own device
+				}
+				if !role.CanManage() {
+					return fmt.Errorf("%s by %s, who is not an admin", op, owner.memberID)
+				}
+				if quorum := p.QuorumAt(int(epoch)); quorum > 1 && role != Recovery {
+					if target.memberID == "" {
+						return fmt.Errorf("added leaf has no member record")
+					}
+					if err := checkQuorum(paths, p, target, int(epoch), quorum); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+		if !role.CanManage() {
+			return fmt.Errorf("%s by %s, who is not an admin", op, owner.memberID)
+		}
+		return nil
+	}
+}
+
+// leafOwner identifies the member device a leaf belongs to.
+type leafOwner struct {
+	memberID string
+	deviceID string                // "" for the primary device
+	former   *storage.FormerMember // set if the member has been removed
+}
+
+// activeAt reports whether the device was in the group at epoch. Current
+// members are taken to have been, as the transition chain already proves
+// their leaf existed.
+func (o leafOwner) activeAt(epoch int) bool {
+	if o.former == nil {
+		return true
+	}
+	from := o.former.ActiveFrom(o.deviceID)
+	return from >= 0 && epoch >= from && epoch < o.former.RemovedEpoch
+}
+
+// role returns the role the owner held at epoch according to p's role
+// history. Former members removed before p kept a history fall back to the
+// role they held at removal.
+func (o leafOwner) role(p *Policy, epoch int) Role {
+	role := p.RoleAt(o.memberID, epoch)
+	if role == "" && o.former != nil {
+		return Role(o.former.Role)
+	}
+	return role
+}
+
+// leafOwners maps MLS signature keys to their owners using the committed
+// KeyPackages of every member and device, and the keys of former members.
+// A device's KeyPackage only counts if the member signed its leaf; see
+// storage.VerifyDeviceLeaf.
+func leafOwners(paths storage.MLSGitPaths) map[string]leafOwner {
+	owners := make(map[string]leafOwner)
+	add := func(path string, owner leafOwner) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		sigPub, err := keyPackageSigPub(string(data))
+		if err != nil {
+			return
+		}
+		if owner.deviceID != "" && storage.VerifyDeviceLeaf(paths, owner.memberID, owner.deviceID, sigPub) != nil {
+			return
+		}
+		owners[string(sigPub)] = owner
+	}
+	formerIDs, _ := storage.ListFormerMemberIDs(paths)
+	for _, mid := range formerIDs {
+		f, err := storage.ReadFormerMember(paths, mid)
+		if err != nil {
+			continue
+		}
+		if len(f.SigPub) > 0 {
+			owners[string(f.SigPub)] = leafOwner{memberID: mid, former: &f}
+		}
+		for _, d := range f.Devices {
+			if len(d.SigPub) > 0 {
+				owners[string(d.SigPub)] = leafOwner{memberID: mid, deviceID: d.ID, former: &f}
+			}
+		}
+	}
+	ids, _ := storage.ListMemberIDs(paths)
+	for _, mid := range ids {
+		add(paths.MemberKeypackage(mid), leafOwner{memberID: mid})
+		deviceIDs, _ := storage.ListDeviceIDs(paths, mid)
+		for _, did := range deviceIDs {
+			add(paths.DeviceKeypackage(mid, did), leafOwner{memberID: mid, deviceID: did})
+		}
+	}
+	return owners
+}
+
+// keyPackageSigPub returns the MLS signature key of a base64 KeyPackage.
+func keyPackageSigPub(kpB64 string) ([]byte, error) {
+	raw, err := crypto.B64Decode(strings.TrimSpace(kpB64), false)
+	if err != nil {
+		return nil, err
+	}
+	var kp mls.KeyPackageData
+	if err := json.Unmarshal(raw, &kp); err != nil {
+		return nil, err
+	}
+	return kp.SigPub, nil
+}
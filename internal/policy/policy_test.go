@@ -0,0 +1,442 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/mls"
+	"github.com/germtb/mlsgit/internal/storage"
+)
+
+type testMember struct {
+	id   string
+	key  ed25519.PrivateKey
+	keys mls.MLSKeys
+}
+
+func setupPolicyTest(t *testing.T, ids ...string) (storage.MLSGitPaths, map[string]testMember) {
+	t.Helper()
+	tmp := t.TempDir()
+	os.MkdirAll(filepath.Join(tmp, ".git"), 0o755)
+	paths := storage.MLSGitPaths{Root: tmp}
+	paths.EnsureDirs()
+
+	members := make(map[string]testMember)
+	for _, id := range ids {
+		priv, pub, _ := crypto.GenerateKeypair()
+		pubPEM, _ := crypto.PublicKeyToPEM(pub)
+		storage.WriteMemberTOML(paths, id, id, pubPEM, 0, "self")
+		keys, _ := mls.GenerateMLSKeys()
+		kpBytes, _ := json.Marshal(mls.BuildKeyPackage([]byte(id), keys))
+		os.WriteFile(paths.MemberKeypackage(id), []byte(crypto.B64Encode(kpBytes, false)), 0o644)
+		members[id] = testMember{id: id, key: priv, keys: keys}
+	}
+	return paths, members
+}
+
+func TestSaveLoadRoundtrip(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob")
+	pol := New("alice")
+	pol.Roles["bob"] = Reader
+	if err := Save(paths, pol, "alice", m["alice"].key); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(paths.LocalPolicy())
+
+	got, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 1 || got.Role("alice") != Admin || got.Role("bob") != Reader {
+		t.Errorf("loaded policy = %+v", got)
+	}
+	if _, err := os.Stat(paths.LocalPolicy()); err != nil {
+		t.Error("Load should record the accepted policy locally")
+	}
+}
+
+func TestLoadWithoutPolicy(t *testing.T) {
+	paths, _ := setupPolicyTest(t, "alice")
+	pol, err := Load(paths)
+	if err != nil || pol != nil {
+		t.Fatalf("Load = %v, %v; want nil, nil", pol, err)
+	}
+	if err := pol.Require("alice", Admin); err != nil {
+		t.Errorf("nil policy should allow everything: %v", err)
+	}
+}
+
+func TestLoadKeepsAcceptedPolicyWhenFileDeleted(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob")
+	pol := New("alice")
+	pol.Roles["bob"] = Reader
+	Save(paths, pol, "alice", m["alice"].key)
+
+	os.Remove(paths.PolicyTOML())
+	got, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Role("bob") != Reader {
+		t.Fatalf("Load without policy.toml = %+v, want the accepted version", got)
+	}
+	if err := got.Require("bob", Admin); err == nil {
+		t.Error("deleting policy.toml should not lift role checks")
+	}
+}
+
+func TestLoadRejectsNonAdminSigner(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob")
+	pol := New("alice")
+	pol.Roles["bob"] = Writer
+	Save(paths, pol, "alice", m["alice"].key)
+
+	// Bob promotes himself and signs with his own key.
+	forged := New("alice")
+	forged.Version = pol.Version
+	forged.Roles["bob"] = Admin
+	Save(paths, forged, "bob", m["bob"].key)
+	os.WriteFile(paths.LocalPolicy(), []byte(pol.ToTOML()), 0o600)
+
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "not an admin") {
+		t.Errorf("Load = %v, want non-admin rejection", err)
+	}
+}
+
+func TestLoadIgnoresRollbackAndRejectsTampering(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob")
+	pol := New("alice")
+	pol.Roles["bob"] = Reader
+	Save(paths, pol, "alice", m["alice"].key)
+	old := pol.ToTOML()
+	pol.Roles["bob"] = Writer
+	Save(paths, pol, "alice", m["alice"].key)
+
+	os.WriteFile(paths.PolicyTOML(), []byte(old), 0o644)
+	got, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 || got.Role("bob") != Writer {
+		t.Errorf("rolled-back Load = %+v, want the accepted version 2", got)
+	}
+
+	tampered := strings.Replace(pol.ToTOML(), `"writer"`, `"admin"`, 1)
+	os.WriteFile(paths.PolicyTOML(), []byte(tampered), 0o644)
+	if _, err := Load(paths); err == nil {
+		t.Error("Load should reject a modified policy with the same version")
+	}
+}
+
+func TestLoadQuorumChanges(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob", "carol")
+	pol := New("alice")
+	pol.Roles["bob"] = Admin
+	pol.Roles["carol"] = Writer
+	pol.SetQuorum(2, 2, nil)
+	Save(paths, pol, "alice", m["alice"].key)
+	accepted := pol.ToTOML()
+	reset := func() { os.WriteFile(paths.LocalPolicy(), []byte(accepted), 0o600) }
+
+	// Alice alone cannot lower the quorum
+	lowered, _ := FromTOML([]byte(accepted))
+	lowered.SetQuorum(5, 1, nil)
+	Save(paths, lowered, "alice", m["alice"].key)
+	reset()
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "0 of 2") {
+		t.Errorf("Load = %v, want rejection of an unapproved decrease", err)
+	}
+
+	// nor rewrite the history behind it
+	rewritten, _ := FromTOML([]byte(accepted))
+	rewritten.Quorum[0].Approvals = 1
+	Save(paths, rewritten, "alice", m["alice"].key)
+	reset()
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "rewrites") {
+		t.Errorf("Load = %v, want rejection of a rewritten history", err)
+	}
+
+	// A writer's approval does not count; two admins' do
+	msg := QuorumBytes(1, 2, 1, 5)
+	var votes []storage.Approval
+	for _, id := range []string{"alice", "carol"} {
+		votes = append(votes, storage.Approval{Approver: id, Signature: crypto.Sign(m[id].key, msg)})
+	}
+	lowered, _ = FromTOML([]byte(accepted))
+	lowered.SetQuorum(5, 1, votes)
+	Save(paths, lowered, "alice", m["alice"].key)
+	reset()
+	if _, err := Load(paths); err == nil {
+		t.Error("a writer's approval should not count towards lowering the quorum")
+	}
+	votes = append(votes, storage.Approval{Approver: "bob", Signature: crypto.Sign(m["bob"].key, msg)})
+	lowered, _ = FromTOML([]byte(accepted))
+	lowered.SetQuorum(5, 1, votes)
+	Save(paths, lowered, "alice", m["alice"].key)
+	reset()
+	got, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AddQuorum() != 1 || got.QuorumAt(3) != 2 || got.QuorumAt(1) != 1 {
+		t.Errorf("quorum history = %+v", got.Quorum)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	pol := New("alice")
+	pol.Roles["bob"] = Writer
+	pol.Roles["carol"] = Reader
+	pol.Roles["rec"] = Recovery
+	cases := []struct {
+		id   string
+		role Role
+		ok   bool
+	}{
+		{"alice", Admin, true},
+		{"bob", Admin, false},
+		{"bob", Writer, true},
+		{"carol", Writer, false},
+		{"carol", Reader, true},
+		{"dave", Reader, false},
+		{"rec", Writer, false},
+		{"rec", Admin, false},
+	}
+	for _, c := range cases {
+		if err := pol.Require(c.id, c.role); (err == nil) != c.ok {
+			t.Errorf("Require(%s, %s) = %v, want ok=%v", c.id, c.role, err, c.ok)
+		}
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob")
+	pol := New("alice")
+	pol.Roles["bob"] = Writer
+	auth := Authorizer(paths, pol)
+
+	alice, bob := m["alice"].keys.SigPub, m["bob"].keys.SigPub
+	outsider, _ := mls.GenerateMLSKeys()
+	if err := auth(0, mls.OpAdd, alice, [][]byte{outsider.SigPub}); err != nil {
+		t.Errorf("admin add: %v", err)
+	}
+	if err := auth(0, mls.OpAdd, bob, [][]byte{outsider.SigPub}); err == nil {
+		t.Error("writer should not add members")
+	}
+	if err := auth(0, mls.OpRemove, bob, [][]byte{alice}); err == nil {
+		t.Error("writer should not remove members")
+	}
+	if err := auth(0, mls.OpUpdate, bob, nil); err != nil {
+		t.Errorf("self update: %v", err)
+	}
+
+	// Bob may add a device whose leaf he signed.
+	device, _ := mls.GenerateMLSKeys()
+	kpBytes, _ := json.Marshal(mls.BuildKeyPackage([]byte("laptop"), device))
+	_, devicePub, _ := crypto.GenerateKeypair()
+	devicePEM, _ := crypto.PublicKeyToPEM(devicePub)
+	info := storage.DeviceInfo{
+		Name:      "laptop",
+		PublicKey: devicePEM,
+		Signature: crypto.Sign(m["bob"].key, storage.DeviceApprovalBytes("bob", "laptop1", "laptop", devicePEM)),
+	}
+	storage.WriteDeviceInfo(paths, "bob", "laptop1", info)
+	os.WriteFile(paths.DeviceKeypackage("bob", "laptop1"), []byte(crypto.B64Encode(kpBytes, false)), 0o644)
+	if err := auth(0, mls.OpAdd, bob, [][]byte{device.SigPub}); err == nil {
+		t.Error("a device KeyPackage Bob did not sign should not count as his")
+	}
+	info.LeafSig = crypto.Sign(m["bob"].key, storage.DeviceLeafBytes("bob", "laptop1", device.SigPub))
+	storage.WriteDeviceInfo(paths, "bob", "laptop1", info)
+	if err := auth(0, mls.OpAdd, bob, [][]byte{device.SigPub}); err != nil {
+		t.Errorf("adding own device: %v", err)
+	}
+
+	// A reader may not, even with a signed leaf.
+	pol.Roles["bob"] = Reader
+	if err := auth(0, mls.OpAdd, bob, [][]byte{device.SigPub}); err == nil {
+		t.Error("a reader should not add devices")
+	}
+}
+
+func TestAuthorizerQuorum(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob", "carol", "dave")
+	pol := New("alice")
+	pol.Roles["bob"] = Admin
+	pol.Roles["carol"] = Writer
+	pol.SetQuorum(0, 2, nil)
+	auth := Authorizer(paths, pol)
+
+	// Dave is already recorded as added; his approvals decide the quorum.
+	info, _ := storage.ReadMemberTOML(paths.MemberTOML("dave"))
+	msg := storage.ApprovalBytes("dave", info.PublicKey, m["dave"].keys.SigPub)
+	dir := paths.MemberApprovalsDir("dave")
+	vote := func(id string) {
+		storage.WriteApproval(dir, storage.Approval{Approver: id, Signature: crypto.Sign(m[id].key, msg)})
+	}
+
+	alice, dave := m["alice"].keys.SigPub, m["dave"].keys.SigPub
+	vote("alice")
+	vote("carol") // a writer's vote does not count
+	if err := auth(0, mls.OpAdd, alice, [][]byte{dave}); err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("add with one admin approval = %v, want quorum rejection", err)
+	}
+
+	// A vote over a different request does not count either.
+	storage.WriteApproval(dir, storage.Approval{
+		Approver:  "bob",
+		Signature: crypto.Sign(m["bob"].key, storage.ApprovalBytes("dave", info.PublicKey, m["carol"].keys.SigPub)),
+	})
+	if err := auth(0, mls.OpAdd, alice, [][]byte{dave}); err == nil {
+		t.Error("vote for a different KeyPackage should not count")
+	}
+
+	vote("bob")
+	if err := auth(0, mls.OpAdd, alice, [][]byte{dave}); err != nil {
+		t.Errorf("add with two admin approvals: %v", err)
+	}
+	if n, _ := CountApprovals(paths, pol, dir, "dave", info.PublicKey, m["dave"].keys.SigPub); n != 2 {
+		t.Errorf("CountApprovals = %d, want 2", n)
+	}
+}
+
+func TestAuthorizerAtEpoch(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob", "dave")
+	pol := New("alice")
+	pol.Roles["bob"] = Admin
+	pol.Roles["dave"] = Writer
+	pol.SetQuorum(0, 2, nil)
+	pol.SetQuorum(4, 3, nil)
+	auth := Authorizer(paths, pol)
+
+	// Bob added Dave at epoch 3 with votes from Alice and Bob.
+	info, _ := storage.ReadMemberTOML(paths.MemberTOML("dave"))
+	msg := storage.ApprovalBytes("dave", info.PublicKey, m["dave"].keys.SigPub)
+	dir := paths.MemberApprovalsDir("dave")
+	for _, id := range []string{"alice", "bob"} {
+		storage.WriteApproval(dir, storage.Approval{Approver: id, Signature: crypto.Sign(m[id].key, msg)})
+	}
+
+	// Dave then rotates his init key, and Bob is removed at epoch 5 with his
+	// keys and role kept under former/.
+	rotated, _ := mls.GenerateMLSKeys()
+	rotated.SigPub, rotated.SigPriv = m["dave"].keys.SigPub, m["dave"].keys.SigPriv
+	kpBytes, _ := json.Marshal(mls.BuildKeyPackage([]byte("dave"), rotated))
+	os.WriteFile(paths.MemberKeypackage("dave"), []byte(crypto.B64Encode(kpBytes, false)), 0o644)
+	bobInfo, _ := storage.ReadMemberTOML(paths.MemberTOML("bob"))
+	storage.WriteFormerMember(paths, "bob", storage.FormerMember{
+		Name:         "bob",
+		PublicKey:    bobInfo.PublicKey,
+		SigPub:       m["bob"].keys.SigPub,
+		Role:         string(Admin),
+		JoinedEpoch:  0,
+		RemovedEpoch: 5,
+	})
+	os.Remove(paths.MemberTOML("bob"))
+	os.Remove(paths.MemberKeypackage("bob"))
+	delete(pol.Roles, "bob")
+
+	// A client still at epoch 3 accepts Bob's add, which met the quorum of
+	// the time.
+	bob, dave := m["bob"].keys.SigPub, m["dave"].keys.SigPub
+	if err := auth(3, mls.OpAdd, bob, [][]byte{dave}); err != nil {
+		t.Errorf("add by a since-removed admin: %v", err)
+	}
+	if err := auth(4, mls.OpAdd, m["alice"].keys.SigPub, [][]byte{dave}); err == nil {
+		t.Error("an add from epoch 4 needs the raised quorum")
+	}
+	if err := auth(5, mls.OpRemove, bob, [][]byte{m["alice"].keys.SigPub}); err == nil {
+		t.Error("a former member's leaf should not sign after their removal")
+	}
+}
+
+func TestRecoveryRole(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "rec", "dave")
+	pol := New("alice")
+	pol.Roles["rec"] = Recovery
+	Save(paths, pol, "alice", m["alice"].key)
+	accepted := pol.ToTOML()
+
+	// The recovery leaf adds without approvals, even under a quorum
+	pol.SetQuorum(0, 2, nil)
+	auth := Authorizer(paths, pol)
+	if err := auth(0, mls.OpAdd, m["rec"].keys.SigPub, [][]byte{m["dave"].keys.SigPub}); err != nil {
+		t.Errorf("recovery add: %v", err)
+	}
+	if err := auth(0, mls.OpRemove, m["rec"].keys.SigPub, [][]byte{m["alice"].keys.SigPub}); err != nil {
+		t.Errorf("recovery remove: %v", err)
+	}
+
+	// so no signed policy may raise the quorum next to it
+	Save(paths, pol, "alice", m["alice"].key)
+	os.WriteFile(paths.LocalPolicy(), []byte(accepted), 0o600)
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "recovery leaf rec") {
+		t.Errorf("Load with add_quorum 2 and a recovery leaf: %v", err)
+	}
+	pol.Quorum = nil
+
+	// It may sign the next policy version
+	pol.SetRole("dave", Admin, 1)
+	Save(paths, pol, "rec", m["rec"].key)
+	os.WriteFile(paths.LocalPolicy(), []byte(accepted), 0o600)
+	got, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Role("rec") != Recovery || got.Role("dave") != Admin {
+		t.Errorf("loaded policy = %+v", got)
+	}
+}
+
+func TestRoleHistory(t *testing.T) {
+	paths, m := setupPolicyTest(t, "alice", "bob", "dave")
+	pol := New("alice")
+	pol.Roles["bob"] = Admin
+	Save(paths, pol, "alice", m["alice"].key)
+	accepted := pol.ToTOML()
+	reset := func() { os.WriteFile(paths.LocalPolicy(), []byte(accepted), 0o600) }
+
+	// A role changed without a record of its epoch is rejected
+	unrecorded, _ := FromTOML([]byte(accepted))
+	unrecorded.Roles["bob"] = Writer
+	Save(paths, unrecorded, "alice", m["alice"].key)
+	reset()
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "without recording it") {
+		t.Errorf("Load = %v, want rejection of an unrecorded role change", err)
+	}
+
+	// Alice demotes Bob from epoch 4; a clone that accepted the old version
+	// syncs the new one and still accepts the add Bob made at epoch 3
+	demoted, _ := FromTOML([]byte(accepted))
+	demoted.SetRole("bob", Writer, 4)
+	Save(paths, demoted, "alice", m["alice"].key)
+	reset()
+	got, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Role("bob") != Writer || got.RoleAt("bob", 3) != Admin || got.RoleAt("bob", 4) != Writer {
+		t.Errorf("role history = %+v", got.History)
+	}
+	auth := Authorizer(paths, got)
+	bob, dave := m["bob"].keys.SigPub, m["dave"].keys.SigPub
+	if err := auth(3, mls.OpAdd, bob, [][]byte{dave}); err != nil {
+		t.Errorf("add by Bob before his demotion: %v", err)
+	}
+	if err := auth(4, mls.OpAdd, bob, [][]byte{dave}); err == nil {
+		t.Error("add by Bob after his demotion should be rejected")
+	}
+
+	// Nor may a later version rewrite when the demotion happened
+	rewritten, _ := FromTOML([]byte(got.ToTOML()))
+	rewritten.History[0].Epoch = 9
+	Save(paths, rewritten, "alice", m["alice"].key)
+	os.WriteFile(paths.LocalPolicy(), []byte(got.ToTOML()), 0o600)
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "rewrites role history") {
+		t.Errorf("Load = %v, want rejection of a rewritten role history", err)
+	}
+}
@@ -0,0 +1,263 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+	"github.com/germtb/mlsgit/internal/storage"
+)
+
+// QuorumChange is one entry in the policy's add_quorum history: from Epoch
+// on, adding a member needs Approvals admin approvals. An entry that lowers
+// the quorum carries approvals from as many admins as the quorum it lowers.
+type QuorumChange struct {
+	Epoch      int
+	Approvals  int
+	Signatures []storage.Approval // admin votes over QuorumBytes, for a decrease
+}
+
+// AddQuorum returns the number of admin approvals needed to add a member
+// now. A nil policy, or one that never set add_quorum, needs one.
+func (p *Policy) AddQuorum() int {
+	if p == nil || len(p.Quorum) == 0 {
+		return 1
+	}
+	return p.Quorum[len(p.Quorum)-1].Approvals
+}
+
+// QuorumAt returns the add_quorum that applied to a member added by a
+// transition from epoch.
+func (p *Policy) QuorumAt(epoch int) int {
+	n := 1
+	if p == nil {
+		return n
+	}
+	for _, q := range p.Quorum {
+		if q.Epoch > epoch {
+			break
+		}
+		n = q.Approvals
+	}
+	return n
+}
+
+// SetQuorum appends a change to approvals from epoch. Lowering the quorum
+// needs the signatures of the current quorum of admins on the change; see
+// QuorumBytes.
+func (p *Policy) SetQuorum(epoch, approvals int, signatures []storage.Approval) {
+	p.Quorum = append(p.Quorum, QuorumChange{Epoch: epoch, Approvals: approvals, Signatures: signatures})
+}
+
+// QuorumBytes returns the bytes admins sign to approve changing add_quorum
+// from the quorum to approvals, as entry index of the history, from epoch.
+func QuorumBytes(index, from, approvals, epoch int) []byte {
+	return []byte(fmt.Sprintf("mlsgit-quorum\n%d\n%d\n%d\n%d", index, from, approvals, epoch))
+}
+
+// CountQuorumApprovals returns the number of distinct admins of p whose
+// signature over QuorumBytes is among signatures. Voters removed since
+// count if they were in the group at epoch.
+func CountQuorumApprovals(paths storage.MLSGitPaths, p *Policy, index, from, approvals, epoch int, signatures []storage.Approval) int {
+	msg := QuorumBytes(index, from, approvals, epoch)
+	voters := make(map[string]bool)
+	for _, a := range signatures {
+		voterID, deviceID := storage.SplitAuthor(a.Approver)
+		pub, role, err := voterKey(paths, p, voterID, deviceID, epoch)
+		if err != nil || role != Admin {
+			continue
+		}
+		if crypto.Verify(pub, msg, a.Signature) {
+			voters[voterID] = true
+		}
+	}
+	return len(voters)
+}
+
+// checkQuorumHistory checks the add_quorum history of a new version against
+// signers, the version accepted last: it must extend signers' history, the
+// epochs of new entries must not go backwards, and each new entry that
+// lowers the quorum needs approvals from as many admins of signers as the
+// quorum it lowers. Raising the quorum only needs the version's signature.
+func (p *Policy) checkQuorumHistory(paths storage.MLSGitPaths, signers *Policy) error {
+	start := 0
+	if signers != p {
+		if len(p.Quorum) < len(signers.Quorum) {
+			return fmt.Errorf("policy version %d drops add_quorum history", p.Version)
+		}
+		for i, q := range signers.Quorum {
+			if p.Quorum[i].Epoch != q.Epoch || p.Quorum[i].Approvals != q.Approvals {
+				return fmt.Errorf("policy version %d rewrites add_quorum history", p.Version)
+			}
+		}
+		start = len(signers.Quorum)
+	}
+	for i := start; i < len(p.Quorum); i++ {
+		q, from, since := p.Quorum[i], 1, 0
+		if i > 0 {
+			from, since = p.Quorum[i-1].Approvals, p.Quorum[i-1].Epoch
+		}
+		if q.Epoch < since {
+			return fmt.Errorf("policy version %d: add_quorum change at epoch %d precedes epoch %d", p.Version, q.Epoch, since)
+		}
+		if q.Approvals >= from {
+			continue
+		}
+		if n := CountQuorumApprovals(paths, signers, i, from, q.Approvals, q.Epoch, q.Signatures); n < from {
+			return fmt.Errorf("policy version %d lowers add_quorum from %d to %d with %d of %d admin approvals", p.Version, from, q.Approvals, n, from)
+		}
+	}
+	return nil
+}
+
+// CountApprovals returns the number of distinct members with a valid vote in
+// dir for memberID's join request. A vote counts if its signature verifies
+// against the voter's committed device key and the voter is an admin.
+func CountApprovals(paths storage.MLSGitPaths, p *Policy, dir, memberID, publicKeyPEM string, mlsSigPub []byte) (int, error) {
+	return countApprovals(paths, p, dir, memberID, publicKeyPEM, mlsSigPub, -1)
+}
+
+// countApprovals is CountApprovals for a join at epoch. With epoch >= 0,
+// votes by members removed since also count if the voter was an admin in
+// the group at epoch.
+func countApprovals(paths storage.MLSGitPaths, p *Policy, dir, memberID, publicKeyPEM string, mlsSigPub []byte, epoch int) (int, error) {
+	approvals, err := storage.ReadApprovals(dir)
+	if err != nil {
+		return 0, err
+	}
+	msg := storage.ApprovalBytes(memberID, publicKeyPEM, mlsSigPub)
+	voters := make(map[string]bool)
+	for _, a := range approvals {
+		voterID, deviceID := storage.SplitAuthor(a.Approver)
+		if voterID == memberID {
+			continue
+		}
+		pub, role, err := voterKey(paths, p, voterID, deviceID, epoch)
+		if err != nil || role != Admin {
+			continue
+		}
+		if crypto.Verify(pub, msg, a.Signature) {
+			voters[voterID] = true
+		}
+	}
+	return len(voters), nil
+}
+
+// voterKey returns the signing key and role of a voter: a current member's
+// committed device key and policy role or, for a join at epoch >= 0, the key
+// and role a former member held at epoch.
+func voterKey(paths storage.MLSGitPaths, p *Policy, voterID, deviceID string, epoch int) (ed25519.PublicKey, Role, error) {
+	pub, err := storage.DevicePublicKey(paths, voterID, deviceID)
+	if err == nil {
+		return pub, p.Role(voterID), nil
+	}
+	if epoch < 0 {
+		return nil, "", err
+	}
+	f, ferr := storage.ReadFormerMember(paths, voterID)
+	if ferr != nil {
+		return nil, "", err
+	}
+	pub, err = f.PublicKeyAt(deviceID, epoch)
+	if err != nil {
+		return nil, "", err
+	}
+	return pub, Role(f.Role), nil
+}
+
+// checkQuorum verifies that a member added at epoch had quorum approvals.
+// The request and votes are read from members/, or from former/ if the
+// member has been removed since.
+func checkQuorum(paths storage.MLSGitPaths, p *Policy, added leafOwner, epoch, quorum int) error {
+	memberID := added.memberID
+	var publicKey string
+	var sigPub []byte
+	dir := paths.MemberApprovalsDir(memberID)
+	if added.former != nil {
+		publicKey, sigPub = added.former.PublicKey, added.former.SigPub
+		dir = paths.FormerApprovalsDir(memberID)
+	} else {
+		info, err := storage.ReadMemberTOML(paths.MemberTOML(memberID))
+		if err != nil {
+			return fmt.Errorf("added member %s: %w", memberID, err)
+		}
+		kp, err := os.ReadFile(paths.MemberKeypackage(memberID))
+		if err != nil {
+			return fmt.Errorf("added member %s: %w", memberID, err)
+		}
+		if sigPub, err = keyPackageSigPub(string(kp)); err != nil {
+			return fmt.Errorf("added member %s: KeyPackage: %w", memberID, err)
+		}
+		publicKey = info.PublicKey
+	}
+	n, err := countApprovals(paths, p, dir, memberID, publicKey, sigPub, epoch)
+	if err != nil {
+		return err
+	}
+	if n < quorum {
+		return fmt.Errorf("member %s was added with %d of %d required approvals", memberID, n, quorum)
+	}
+	return nil
+}
+
+// QuorumProposal is a pending change that lowers add_quorum, collecting
+// admin approvals in .mlsgit/pending/quorum.toml until it has enough to be
+// added to the policy as entry Index.
+type QuorumProposal struct {
+	Index      int
+	From       int
+	Approvals  int
+	Epoch      int
+	Signatures []storage.Approval
+}
+
+// WriteQuorumProposal writes the pending add_quorum proposal.
+func WriteQuorumProposal(paths storage.MLSGitPaths, q QuorumProposal) error {
+	if err := os.MkdirAll(paths.PendingDir(), 0o755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[proposal]\nindex = %d\nfrom = %d\napprovals = %d\nepoch = %d\n", q.Index, q.From, q.Approvals, q.Epoch)
+	for _, a := range q.Signatures {
+		fmt.Fprintf(&b, "\n[[proposal.signature]]\napprover = %q\nsignature = %q\n", a.Approver, crypto.B64Encode(a.Signature, false))
+	}
+	return os.WriteFile(paths.PendingQuorum(), []byte(b.String()), 0o644)
+}
+
+// ReadQuorumProposal reads the pending add_quorum proposal, if any.
+func ReadQuorumProposal(paths storage.MLSGitPaths) (*QuorumProposal, error) {
+	data, err := os.ReadFile(paths.PendingQuorum())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w struct {
+		Proposal struct {
+			Index     int `toml:"index"`
+			From      int `toml:"from"`
+			Approvals int `toml:"approvals"`
+			Epoch     int `toml:"epoch"`
+			Signature []struct {
+				Approver  string `toml:"approver"`
+				Signature string `toml:"signature"`
+			} `toml:"signature"`
+		} `toml:"proposal"`
+	}
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return nil, fmt.Errorf("parse add_quorum proposal: %w", err)
+	}
+	q := &QuorumProposal{Index: w.Proposal.Index, From: w.Proposal.From, Approvals: w.Proposal.Approvals, Epoch: w.Proposal.Epoch}
+	for _, a := range w.Proposal.Signature {
+		sig, err := crypto.B64Decode(a.Signature, false)
+		if err != nil {
+			return nil, fmt.Errorf("decode add_quorum approval: %w", err)
+		}
+		q.Signatures = append(q.Signatures, storage.Approval{Approver: a.Approver, Signature: sig})
+	}
+	return q, nil
+}
@@ -0,0 +1,103 @@
+package policy
+
+import "fmt"
+
+// RoleChange is one entry in the policy's role history: from Epoch on,
+// MemberID holds Role instead of From. An empty role is no role, so adding
+// and removing members are changes too.
+type RoleChange struct {
+	Epoch    int
+	MemberID string
+	From     Role
+	Role     Role
+}
+
+// SetRole gives memberID role from epoch on, recording the change in the
+// role history. An empty role removes memberID from the policy.
+func (p *Policy) SetRole(memberID string, role Role, epoch int) {
+	from := p.Roles[memberID]
+	if from == role {
+		return
+	}
+	p.History = append(p.History, RoleChange{Epoch: epoch, MemberID: memberID, From: from, Role: role})
+	if role == "" {
+		delete(p.Roles, memberID)
+	} else {
+		p.Roles[memberID] = role
+	}
+}
+
+// RoleAt returns the role memberID held at epoch: the current one, with
+// every recorded change made after epoch undone. Roles from before the
+// history was kept are taken to be the current ones.
+func (p *Policy) RoleAt(memberID string, epoch int) Role {
+	if p == nil {
+		return ""
+	}
+	role := p.Roles[memberID]
+	for i := len(p.History) - 1; i >= 0; i-- {
+		if c := p.History[i]; c.MemberID == memberID && c.Epoch > epoch {
+			role = c.From
+		}
+	}
+	return role
+}
+
+// checkRoleHistory checks the role history of a new version against
+// signers, the version accepted last: it must extend signers' history, the
+// epochs of new entries must not go backwards, and replaying them on
+// signers' roles must give the new version's roles, so no role changes
+// without a record of the epoch it changed at.
+func (p *Policy) checkRoleHistory(signers *Policy) error {
+	if signers == p {
+		return nil
+	}
+	if len(p.History) < len(signers.History) {
+		return fmt.Errorf("policy version %d drops role history", p.Version)
+	}
+	for i, c := range signers.History {
+		if p.History[i] != c {
+			return fmt.Errorf("policy version %d rewrites role history", p.Version)
+		}
+	}
+	roles := make(map[string]Role, len(signers.Roles))
+	for id, r := range signers.Roles {
+		roles[id] = r
+	}
+	since := 0
+	if n := len(signers.History); n > 0 {
+		since = signers.History[n-1].Epoch
+	}
+	for _, c := range p.History[len(signers.History):] {
+		if c.Epoch < since {
+			return fmt.Errorf("policy version %d: role change at epoch %d precedes epoch %d", p.Version, c.Epoch, since)
+		}
+		if roles[c.MemberID] != c.From {
+			return fmt.Errorf("policy version %d records %s changing from %q, but they were %q", p.Version, c.MemberID, c.From, roles[c.MemberID])
+		}
+		if c.Role == "" {
+			delete(roles, c.MemberID)
+		} else {
+			roles[c.MemberID] = c.Role
+		}
+		since = c.Epoch
+	}
+	for id := range unionKeys(roles, p.Roles) {
+		if roles[id] != p.Roles[id] {
+			return fmt.Errorf("policy version %d changes the role of %s without recording it", p.Version, id)
+		}
+	}
+	return nil
+}
+
+// unionKeys returns the member IDs with a role in a or b.
+func unionKeys(a, b map[string]Role) map[string]bool {
+	ids := make(map[string]bool, len(a)+len(b))
+	for id := range a {
+		ids[id] = true
+	}
+	for id := range b {
+		ids[id] = true
+	}
+	return ids
+}
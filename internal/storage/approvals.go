@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Approval votes ---
+//
+// When the config requires more than one approval to add a member, each
+// approving device signs the join request and stores the vote under
+// pending/<id>.approvals/. The votes move to members/<id>.approvals/ once the
+// member is added, and to former/<id>.approvals/ when they are removed, so
+// every client can check the quorum when it syncs.
+
+// Approval is one signed vote to admit a member.
+type Approval struct {
+	Approver  string // author string (member ID, plus device ID) of the voter
+	Signature []byte // voter's signature over ApprovalBytes
+	Timestamp int64
+}
+
+// ApprovalBytes returns the bytes a voter signs to approve a join request.
+// They cover the requester's signing key and the MLS signature key of their
+// KeyPackage, so a vote cannot be reused for a request with different keys.
+// The KeyPackage's init key is left out: 'mlsgit update' rotates it, and the
+// vote must still verify afterwards.
+func ApprovalBytes(memberID, publicKeyPEM string, mlsSigPub []byte) []byte {
+	return []byte(fmt.Sprintf("mlsgit-approval\n%s\n%s\n%s",
+		memberID, strings.TrimSpace(publicKeyPEM), crypto.B64Encode(mlsSigPub, false)))
+}
+
+// WriteApproval stores a vote in dir, replacing an earlier vote by the same
+// approver.
+func WriteApproval(dir string, a Approval) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	memberID, deviceID := SplitAuthor(a.Approver)
+	content := fmt.Sprintf("[approval]\napprover = %q\nsignature = %q\ntimestamp = %d\n",
+		a.Approver, crypto.B64Encode(a.Signature, false), time.Now().Unix())
+	return os.WriteFile(filepath.Join(dir, WelcomeKey(memberID, deviceID)+".toml"), []byte(content), 0o644)
+}
+
+// ReadApprovals returns the votes in dir, sorted by approver. A missing
+// directory holds no votes.
+func ReadApprovals(dir string) ([]Approval, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var approvals []Approval
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".toml") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var w struct {
+			Approval struct {
+				Approver  string `toml:"approver"`
+				Signature string `toml:"signature"`
+				Timestamp int64  `toml:"timestamp"`
+			} `toml:"approval"`
+		}
+		if _, err := toml.Decode(string(data), &w); err != nil {
+			return nil, fmt.Errorf("parse approval %s: %w", e.Name(), err)
+		}
+		sig, err := crypto.B64Decode(w.Approval.Signature, false)
+		if err != nil {
+			return nil, fmt.Errorf("decode approval signature: %w", err)
+		}
+		approvals = append(approvals, Approval{
+			Approver:  w.Approval.Approver,
+			Signature: sig,
+			Timestamp: w.Approval.Timestamp,
+		})
+	}
+	sort.Slice(approvals, func(i, j int) bool { return approvals[i].Approver < approvals[j].Approver })
+	return approvals, nil
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Backup bundles ---
+//
+// A backup bundle carries a device's identity and local key material so a
+// fresh clone can take over the same leaf. The files are sealed together
+// under a key derived from a passphrase, or under a random recovery key.
+
+// Bundle key kinds.
+const (
+	BundlePassphrase  = "argon2id"
+	BundleRecoveryKey = "recovery-key"
+)
+
+// BackupBundle is an encrypted backup of the local files in .git/mlsgit/.
+type BackupBundle struct {
+	MemberID string
+	DeviceID string
+	Epoch    int
+	Created  int64
+	KeyKind  string // BundlePassphrase or BundleRecoveryKey
+	Salt     []byte // Argon2id salt (passphrase bundles only)
+	KDF      crypto.KDFParams
+	Payload  []byte // crypto.SealLocal over the JSON-encoded files
+}
+
+// backupFiles lists the local files a bundle carries, identity first.
+func backupFiles(paths MLSGitPaths) []string {
+	return append([]string{paths.IdentityTOML()}, LocalSecrets(paths)...)
+}
+
+// ReadBackupFiles collects the local files a bundle carries, unsealed,
+// keyed by file name. Files that don't exist are skipped.
+func ReadBackupFiles(paths MLSGitPaths) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, p := range backupFiles(paths) {
+		data, err := ReadSecret(paths, p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.Base(p)] = data
+	}
+	return files, nil
+}
+
+// RestoreBackupFiles writes files from a bundle back into .git/mlsgit/.
+// Key files are sealed if a passphrase is set for this clone.
+func RestoreBackupFiles(paths MLSGitPaths, files map[string][]byte) error {
+	if _, ok := files[filepath.Base(paths.IdentityTOML())]; !ok {
+		return fmt.Errorf("bundle has no identity")
+	}
+	for _, p := range backupFiles(paths) {
+		data, ok := files[filepath.Base(p)]
+		if !ok {
+			continue
+		}
+		var err error
+		if p == paths.IdentityTOML() {
+			err = os.WriteFile(p, data, 0o644)
+		} else {
+			err = WriteSecret(paths, p, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewBackupBundle seals files under a passphrase, or under a new recovery
+// key if passphrase is nil. Returns the bundle and, for recovery key
+// bundles, the printable recovery key.
+func NewBackupBundle(memberID, deviceID string, epoch int, files map[string][]byte, passphrase []byte) (BackupBundle, string, error) {
+	b := BackupBundle{MemberID: memberID, DeviceID: deviceID, Epoch: epoch, Created: time.Now().Unix()}
+	var key []byte
+	var printable string
+	var err error
+	if passphrase != nil {
+		b.KeyKind = BundlePassphrase
+		b.Salt = make([]byte, 16)
+		if _, err := rand.Read(b.Salt); err != nil {
+			return BackupBundle{}, "", fmt.Errorf("random salt: %w", err)
+		}
+		b.KDF = crypto.DefaultKDFParams
+		key, err = crypto.PassphraseKey(passphrase, b.Salt, b.KDF)
+	} else {
+		b.KeyKind = BundleRecoveryKey
+		key, printable, err = crypto.NewRecoveryKey()
+	}
+	if err != nil {
+		return BackupBundle{}, "", err
+	}
+	payload, err := json.Marshal(files)
+	if err != nil {
+		return BackupBundle{}, "", err
+	}
+	if b.Payload, err = crypto.SealLocal(key, payload); err != nil {
+		return BackupBundle{}, "", err
+	}
+	return b, printable, nil
+}
+
+// Open decrypts the bundle with its passphrase or printable recovery key.
+func (b BackupBundle) Open(secret []byte) (map[string][]byte, error) {
+	var key []byte
+	var err error
+	switch b.KeyKind {
+	case BundlePassphrase:
+		key, err = crypto.PassphraseKey(secret, b.Salt, b.KDF)
+	case BundleRecoveryKey:
+		key, err = crypto.ParseRecoveryKey(string(secret))
+	default:
+		return nil, fmt.Errorf("unknown bundle key kind %q", b.KeyKind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload, err := crypto.OpenLocal(key, b.Payload)
+	if err != nil {
+		if b.KeyKind == BundlePassphrase {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+		return nil, fmt.Errorf("wrong recovery key")
+	}
+	var files map[string][]byte
+	if err := json.Unmarshal(payload, &files); err != nil {
+		return nil, fmt.Errorf("parse bundle payload: %w", err)
+	}
+	return files, nil
+}
+
+// ToTOML serializes the bundle.
+func (b BackupBundle) ToTOML() string {
+	s := fmt.Sprintf("[backup]\nversion = 1\nmember_id = %q\n", b.MemberID)
+	if b.DeviceID != "" {
+		s += fmt.Sprintf("device_id = %q\n", b.DeviceID)
+	}
+	s += fmt.Sprintf("epoch = %d\ncreated = %d\nkey = %q\n", b.Epoch, b.Created, b.KeyKind)
+	if b.KeyKind == BundlePassphrase {
+		s += fmt.Sprintf("salt = %q\ntime = %d\nmemory = %d\nthreads = %d\n",
+			crypto.B64Encode(b.Salt, false), b.KDF.Time, b.KDF.Memory, b.KDF.Threads)
+	}
+	s += fmt.Sprintf("payload = %q\n", crypto.B64Encode(b.Payload, false))
+	return s
+}
+
+// BackupBundleFromTOML parses a bundle written by ToTOML.
+func BackupBundleFromTOML(text string) (BackupBundle, error) {
+	var w struct {
+		Backup struct {
+			Version  int    `toml:"version"`
+			MemberID string `toml:"member_id"`
+			DeviceID string `toml:"device_id"`
+			Epoch    int    `toml:"epoch"`
+			Created  int64  `toml:"created"`
+			Key      string `toml:"key"`
+			Salt     string `toml:"salt"`
+			Time     int    `toml:"time"`
+			Memory   int    `toml:"memory"`
+			Threads  int    `toml:"threads"`
+			Payload  string `toml:"payload"`
+		} `toml:"backup"`
+	}
+	if _, err := toml.Decode(text, &w); err != nil {
+		return BackupBundle{}, fmt.Errorf("parse backup bundle: %w", err)
+	}
+	if w.Backup.Version != 1 {
+		return BackupBundle{}, fmt.Errorf("unsupported backup bundle version %d", w.Backup.Version)
+	}
+	salt, err := crypto.B64Decode(w.Backup.Salt, false)
+	if err != nil {
+		return BackupBundle{}, fmt.Errorf("bundle salt: %w", err)
+	}
+	payload, err := crypto.B64Decode(w.Backup.Payload, false)
+	if err != nil {
+		return BackupBundle{}, fmt.Errorf("bundle payload: %w", err)
+	}
+	return BackupBundle{
+		MemberID: w.Backup.MemberID,
+		DeviceID: w.Backup.DeviceID,
+		Epoch:    w.Backup.Epoch,
+		Created:  w.Backup.Created,
+		KeyKind:  w.Backup.Key,
+		Salt:     salt,
+		KDF:      crypto.KDFParams{Time: w.Backup.Time, Memory: w.Backup.Memory, Threads: w.Backup.Threads},
+		Payload:  payload,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestBackupBundleRoundtrip(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+	paths := setupTestPaths(t)
+	WriteDeviceIdentity(paths, "abc123", "alice", "dev1")
+	WriteSecret(paths, paths.PrivateKey(), []byte("signing key"))
+	WriteSecret(paths, paths.InitPriv(), []byte("init key"))
+	WriteLocalMLSState(paths, []byte("mls state"))
+
+	files, err := ReadBackupFiles(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("got %d files, want 4 (sig_priv.bin is absent)", len(files))
+	}
+
+	withPassphrase, _, err := NewBackupBundle("abc123", "dev1", 7, files, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withRecovery, recoveryKey, err := NewBackupBundle("abc123", "dev1", 7, files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recoveryKey == "" {
+		t.Fatal("recovery key bundle should return its key")
+	}
+
+	for _, tc := range []struct {
+		bundle        BackupBundle
+		secret, wrong string
+	}{
+		{withPassphrase, "pw", "not pw"},
+		{withRecovery, recoveryKey, "AAAA-AAAA"},
+	} {
+		parsed, err := BackupBundleFromTOML(tc.bundle.ToTOML())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.MemberID != "abc123" || parsed.DeviceID != "dev1" || parsed.Epoch != 7 {
+			t.Errorf("parsed header = %+v", parsed)
+		}
+		if _, err := parsed.Open([]byte(tc.wrong)); err == nil {
+			t.Errorf("%s bundle opened with the wrong secret", parsed.KeyKind)
+		}
+		opened, err := parsed.Open([]byte(tc.secret))
+		if err != nil {
+			t.Fatalf("%s bundle: %v", parsed.KeyKind, err)
+		}
+
+		fresh := setupTestPaths(t)
+		if err := RestoreBackupFiles(fresh, opened); err != nil {
+			t.Fatal(err)
+		}
+		if id, _ := ReadDeviceID(fresh); id != "dev1" {
+			t.Errorf("restored device ID = %q", id)
+		}
+		if state, _ := ReadLocalMLSState(fresh); !bytes.Equal(state, []byte("mls state")) {
+			t.Errorf("restored MLS state = %q", state)
+		}
+		if _, err := os.Stat(fresh.SigPriv()); !os.IsNotExist(err) {
+			t.Error("files missing from the bundle should not be created")
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -56,6 +58,60 @@ func (c *FilterCache) Put(filePath string, plaintext []byte, ciphertext string)
 	return os.WriteFile(ctP, []byte(ciphertext), 0o644)
 }
 
+// --- Streaming access, for files too large to read into memory ---
+
+// CreateTemp creates a temporary file beside filePath's cache entries, to
+// be moved into place with Commit.
+func (c *FilterCache) CreateTemp(filePath string) (*os.File, error) {
+	p := c.paths.CachePlain(filePath)
+	if err := ensureParent(p); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(filepath.Dir(p), filepath.Base(filePath)+".*.tmp")
+}
+
+// OpenCiphertext opens the cached ciphertext for filePath.
+func (c *FilterCache) OpenCiphertext(filePath string) (*os.File, error) {
+	return os.Open(c.paths.CacheCT(filePath))
+}
+
+// PlaintextMatches reports whether the cached plaintext for filePath has
+// the same content as the file at path, comparing a chunk at a time.
+func (c *FilterCache) PlaintextMatches(filePath, path string) bool {
+	cached, err := os.Open(c.paths.CachePlain(filePath))
+	if err != nil {
+		return false
+	}
+	defer cached.Close()
+	other, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer other.Close()
+
+	a, b := make([]byte, 64<<10), make([]byte, 64<<10)
+	for {
+		na, errA := io.ReadFull(cached, a)
+		nb, errB := io.ReadFull(other, b)
+		if na != nb || !bytes.Equal(a[:na], b[:nb]) {
+			return false
+		}
+		if errA != nil || errB != nil {
+			// Equal so far: both must have ended at the same point
+			return errA == errB
+		}
+	}
+}
+
+// Commit moves temporary files made with CreateTemp into place as the
+// plaintext and ciphertext cached for filePath.
+func (c *FilterCache) Commit(filePath, plainTemp, ctTemp string) error {
+	if err := os.Rename(plainTemp, c.paths.CachePlain(filePath)); err != nil {
+		return err
+	}
+	return os.Rename(ctTemp, c.paths.CacheCT(filePath))
+}
+
 // InvalidateAll removes all cached entries.
 func (c *FilterCache) InvalidateAll() error {
 	cacheDir := c.paths.CacheDir()
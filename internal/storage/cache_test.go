@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"os"
 	"testing"
 )
 
@@ -74,3 +75,58 @@ func TestFilterCacheNestedPaths(t *testing.T) {
 		t.Errorf("nested path = %q, want %q", got, "nested")
 	}
 }
+
+func TestFilterCacheStreaming(t *testing.T) {
+	paths := setupTestPaths(t)
+	cache := NewFilterCache(paths)
+
+	content := bytes.Repeat([]byte("0123456789"), 20000)
+	plainTemp, err := cache.CreateTemp("dir/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainTemp.Write(content)
+	plainTemp.Close()
+	ctTemp, err := cache.CreateTemp("dir/big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctTemp.WriteString("streamed-ciphertext")
+	ctTemp.Close()
+
+	if cache.PlaintextMatches("dir/big.bin", plainTemp.Name()) {
+		t.Error("nothing is cached yet")
+	}
+	if err := cache.Commit("dir/big.bin", plainTemp.Name(), ctTemp.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cache.GetPlaintext("dir/big.bin"); !bytes.Equal(got, content) {
+		t.Error("committed plaintext differs")
+	}
+	if got, ok := cache.GetCiphertext("dir/big.bin"); !ok || got != "streamed-ciphertext" {
+		t.Errorf("committed ciphertext = %q", got)
+	}
+
+	// Same content matches; a longer or changed copy does not
+	check := func(data []byte) bool {
+		f, err := cache.CreateTemp("dir/big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.Write(data)
+		f.Close()
+		return cache.PlaintextMatches("dir/big.bin", f.Name())
+	}
+	if !check(content) {
+		t.Error("identical content should match")
+	}
+	if check(append(bytes.Clone(content), 'x')) {
+		t.Error("longer content should not match")
+	}
+	changed := bytes.Clone(content)
+	changed[len(changed)-1] = 'x'
+	if check(changed) {
+		t.Error("changed content should not match")
+	}
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Device helpers ---
+//
+// A member's first device is described by members/<id>.toml. Further devices
+// live under members/<id>.devices/ and each holds a signature from the
+// device that approved it, so a device's signing key is only trusted if it
+// chains back to the member's primary key. The approver also signs the MLS
+// signature key of the device's leaf, so a KeyPackage committed under a
+// member's ID is only taken as theirs if they signed it.
+
+// DeviceInfo holds parsed device data.
+type DeviceInfo struct {
+	Name        string
+	PublicKey   string
+	AddedEpoch  int
+	ApprovedBy  string // device ID of the approving device; "" for the primary device
+	Signature   []byte // approver's signature over DeviceApprovalBytes
+	LeafSig     []byte // approver's signature over DeviceLeafBytes
+	KeysUpdated int64
+}
+
+// DeviceAuthor returns the author string a device signs file records with.
+// The primary device signs as the bare member ID.
+func DeviceAuthor(memberID, deviceID string) string {
+	if deviceID == "" {
+		return memberID
+	}
+	return memberID + ":" + deviceID
+}
+
+// SplitAuthor splits an author string into member and device IDs.
+func SplitAuthor(author string) (memberID, deviceID string) {
+	memberID, deviceID, _ = strings.Cut(author, ":")
+	return memberID, deviceID
+}
+
+// WelcomeKey returns the name of the Welcome file and welcome branch for a
+// device.
+func WelcomeKey(memberID, deviceID string) string {
+	if deviceID == "" {
+		return memberID
+	}
+	return memberID + "." + deviceID
+}
+
+// DeviceApprovalBytes returns the bytes an existing device signs to link a
+// new device to memberID.
+func DeviceApprovalBytes(memberID, deviceID, name, publicKeyPEM string) []byte {
+	return []byte(fmt.Sprintf("mlsgit-device\n%s\n%s\n%s\n%s", memberID, deviceID, name, strings.TrimSpace(publicKeyPEM)))
+}
+
+// DeviceLeafBytes returns the bytes an existing device signs to claim the
+// leaf with MLS signature key sigPub as memberID's device deviceID.
+func DeviceLeafBytes(memberID, deviceID string, sigPub []byte) []byte {
+	return []byte(fmt.Sprintf("mlsgit-device-leaf\n%s\n%s\n%s", memberID, deviceID, crypto.B64Encode(sigPub, false)))
+}
+
+// WriteDeviceInfo writes a device record under members/<id>.devices/.
+func WriteDeviceInfo(paths MLSGitPaths, memberID, deviceID string, info DeviceInfo) error {
+	if err := os.MkdirAll(paths.DevicesDir(memberID), 0o755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("[device]\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nadded_epoch = %d\napproved_by = %q\nsignature = %q\n",
+		info.Name, info.PublicKey, info.AddedEpoch, info.ApprovedBy, crypto.B64Encode(info.Signature, false))
+	if len(info.LeafSig) > 0 {
+		content += fmt.Sprintf("leaf_signature = %q\n", crypto.B64Encode(info.LeafSig, false))
+	}
+	if info.KeysUpdated != 0 {
+		content += fmt.Sprintf("keys_updated = %d\n", info.KeysUpdated)
+	}
+	return os.WriteFile(paths.DeviceTOML(memberID, deviceID), []byte(content), 0o644)
+}
+
+// ReadDeviceInfo parses a device record.
+func ReadDeviceInfo(paths MLSGitPaths, memberID, deviceID string) (DeviceInfo, error) {
+	data, err := os.ReadFile(paths.DeviceTOML(memberID, deviceID))
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	type deviceSection struct {
+		Name        string `toml:"name"`
+		PublicKey   string `toml:"public_key"`
+		AddedEpoch  int    `toml:"added_epoch"`
+		ApprovedBy  string `toml:"approved_by"`
+		Signature   string `toml:"signature"`
+		LeafSig     string `toml:"leaf_signature"`
+		KeysUpdated int64  `toml:"keys_updated"`
+	}
+	type wrapper struct {
+		Device deviceSection `toml:"device"`
+	}
+	var w wrapper
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return DeviceInfo{}, fmt.Errorf("parse device TOML: %w", err)
+	}
+	sig, err := crypto.B64Decode(w.Device.Signature, false)
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("decode device signature: %w", err)
+	}
+	leafSig, err := crypto.B64Decode(w.Device.LeafSig, false)
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("decode device leaf signature: %w", err)
+	}
+	return DeviceInfo{
+		Name:        w.Device.Name,
+		PublicKey:   strings.TrimSpace(w.Device.PublicKey),
+		AddedEpoch:  w.Device.AddedEpoch,
+		ApprovedBy:  w.Device.ApprovedBy,
+		Signature:   sig,
+		LeafSig:     leafSig,
+		KeysUpdated: w.Device.KeysUpdated,
+	}, nil
+}
+
+// ListDeviceIDs returns the sorted IDs of a member's additional devices.
+func ListDeviceIDs(paths MLSGitPaths, memberID string) ([]string, error) {
+	entries, err := os.ReadDir(paths.DevicesDir(memberID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".toml") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".toml"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// DevicePublicKey returns the signing key of one of a member's devices
+// ("" is the primary device), after checking that its approval signature
+// chains back to the primary key.
+func DevicePublicKey(paths MLSGitPaths, memberID, deviceID string) (ed25519.PublicKey, error) {
+	return devicePublicKey(paths, memberID, deviceID, make(map[string]bool))
+}
+
+func devicePublicKey(paths MLSGitPaths, memberID, deviceID string, seen map[string]bool) (ed25519.PublicKey, error) {
+	if deviceID == "" {
+		info, err := ReadMemberTOML(paths.MemberTOML(memberID))
+		if err != nil {
+			return nil, err
+		}
+		return crypto.LoadPublicKey(info.PublicKey)
+	}
+	if seen[deviceID] {
+		return nil, fmt.Errorf("device approval cycle at %s", deviceID)
+	}
+	seen[deviceID] = true
+
+	info, err := ReadDeviceInfo(paths, memberID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := crypto.LoadPublicKey(info.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	approver, err := devicePublicKey(paths, memberID, info.ApprovedBy, seen)
+	if err != nil {
+		return nil, fmt.Errorf("approver of device %s: %w", deviceID, err)
+	}
+	if !crypto.Verify(approver, DeviceApprovalBytes(memberID, deviceID, info.Name, info.PublicKey), info.Signature) {
+		return nil, fmt.Errorf("device %s has an invalid approval signature", deviceID)
+	}
+	return pub, nil
+}
+
+// VerifyDeviceLeaf checks that the leaf with MLS signature key sigPub is
+// one of memberID's devices: the device's key must chain back to the
+// primary key, and its approver must have signed the leaf. Devices linked
+// before leaves were signed fail, and must be linked again.
+func VerifyDeviceLeaf(paths MLSGitPaths, memberID, deviceID string, sigPub []byte) error {
+	if _, err := DevicePublicKey(paths, memberID, deviceID); err != nil {
+		return err
+	}
+	info, err := ReadDeviceInfo(paths, memberID, deviceID)
+	if err != nil {
+		return err
+	}
+	approver, err := DevicePublicKey(paths, memberID, info.ApprovedBy)
+	if err != nil {
+		return fmt.Errorf("approver of device %s: %w", deviceID, err)
+	}
+	if !crypto.Verify(approver, DeviceLeafBytes(memberID, deviceID, sigPub), info.LeafSig) {
+		return fmt.Errorf("device %s has no valid signature over its leaf", deviceID)
+	}
+	return nil
+}
+
+// --- Pending device requests ---
+
+// PendingDeviceInfo holds a request to link a new device.
+type PendingDeviceInfo struct {
+	MemberID   string
+	DeviceID   string
+	Name       string
+	PublicKey  string
+	Keypackage string
+	Timestamp  int64
+}
+
+// WritePendingDevice writes a pending device request.
+func WritePendingDevice(paths MLSGitPaths, req PendingDeviceInfo) error {
+	content := fmt.Sprintf(
+		"[device_request]\nmember_id = %q\ndevice_id = %q\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nkeypackage = \"\"\"\n%s\n\"\"\"\ntimestamp = %d\n",
+		req.MemberID, req.DeviceID, req.Name, req.PublicKey, req.Keypackage, time.Now().Unix())
+	return os.WriteFile(paths.PendingDevice(req.MemberID, req.DeviceID), []byte(content), 0o644)
+}
+
+// ReadPendingDevice parses a pending device request.
+func ReadPendingDevice(path string) (PendingDeviceInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PendingDeviceInfo{}, err
+	}
+	type requestSection struct {
+		MemberID   string `toml:"member_id"`
+		DeviceID   string `toml:"device_id"`
+		Name       string `toml:"name"`
+		PublicKey  string `toml:"public_key"`
+		Keypackage string `toml:"keypackage"`
+		Timestamp  int64  `toml:"timestamp"`
+	}
+	type wrapper struct {
+		Request requestSection `toml:"device_request"`
+	}
+	var w wrapper
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return PendingDeviceInfo{}, fmt.Errorf("parse device request TOML: %w", err)
+	}
+	return PendingDeviceInfo{
+		MemberID:   w.Request.MemberID,
+		DeviceID:   w.Request.DeviceID,
+		Name:       w.Request.Name,
+		PublicKey:  strings.TrimSpace(w.Request.PublicKey),
+		Keypackage: strings.TrimSpace(w.Request.Keypackage),
+		Timestamp:  w.Request.Timestamp,
+	}, nil
+}
+
+// ListPendingDevices returns paths to pending device requests.
+func ListPendingDevices(paths MLSGitPaths) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(paths.PendingDir(), "*.device.toml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+func TestDeviceApprovalChain(t *testing.T) {
+	paths := setupTestPaths(t)
+
+	primaryPriv, primaryPub, _ := crypto.GenerateKeypair()
+	primaryPEM, _ := crypto.PublicKeyToPEM(primaryPub)
+	if err := WriteMemberTOML(paths, "alice", "alice", primaryPEM, 0, "self"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary device approves a laptop, which approves a CI runner.
+	laptopPriv, laptopPub, _ := crypto.GenerateKeypair()
+	laptopPEM, _ := crypto.PublicKeyToPEM(laptopPub)
+	if err := WriteDeviceInfo(paths, "alice", "laptop1", DeviceInfo{
+		Name:       "laptop",
+		PublicKey:  laptopPEM,
+		AddedEpoch: 2,
+		Signature:  crypto.Sign(primaryPriv, DeviceApprovalBytes("alice", "laptop1", "laptop", laptopPEM)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, ciPub, _ := crypto.GenerateKeypair()
+	ciPEM, _ := crypto.PublicKeyToPEM(ciPub)
+	if err := WriteDeviceInfo(paths, "alice", "ci1", DeviceInfo{
+		Name:       "ci",
+		PublicKey:  ciPEM,
+		AddedEpoch: 3,
+		ApprovedBy: "laptop1",
+		Signature:  crypto.Sign(laptopPriv, DeviceApprovalBytes("alice", "ci1", "ci", ciPEM)),
+		LeafSig:    crypto.Sign(laptopPriv, DeviceLeafBytes("alice", "ci1", []byte("ci leaf"))),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := ListDeviceIDs(paths, "alice")
+	if err != nil || len(ids) != 2 || ids[0] != "ci1" || ids[1] != "laptop1" {
+		t.Fatalf("ListDeviceIDs = %v, %v", ids, err)
+	}
+	info, err := ReadDeviceInfo(paths, "alice", "ci1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "ci" || info.ApprovedBy != "laptop1" || info.AddedEpoch != 3 {
+		t.Errorf("device info = %+v", info)
+	}
+	pub, err := DevicePublicKey(paths, "alice", "ci1")
+	if err != nil {
+		t.Fatalf("DevicePublicKey: %v", err)
+	}
+	if !pub.Equal(ciPub) {
+		t.Error("DevicePublicKey returned the wrong key")
+	}
+
+	// The laptop signed the CI runner's leaf, and only that leaf; nobody
+	// signed the laptop's.
+	if err := VerifyDeviceLeaf(paths, "alice", "ci1", []byte("ci leaf")); err != nil {
+		t.Errorf("VerifyDeviceLeaf: %v", err)
+	}
+	if err := VerifyDeviceLeaf(paths, "alice", "ci1", []byte("planted leaf")); err == nil {
+		t.Error("a leaf the approver did not sign should be rejected")
+	}
+	if err := VerifyDeviceLeaf(paths, "alice", "laptop1", []byte("laptop leaf")); err == nil {
+		t.Error("a device without a leaf signature should be rejected")
+	}
+
+	// A device signed by someone else is not trusted.
+	otherPriv, _, _ := crypto.GenerateKeypair()
+	_, roguePub, _ := crypto.GenerateKeypair()
+	roguePEM, _ := crypto.PublicKeyToPEM(roguePub)
+	WriteDeviceInfo(paths, "alice", "rogue", DeviceInfo{
+		Name:      "rogue",
+		PublicKey: roguePEM,
+		Signature: crypto.Sign(otherPriv, DeviceApprovalBytes("alice", "rogue", "rogue", roguePEM)),
+	})
+	if _, err := DevicePublicKey(paths, "alice", "rogue"); err == nil {
+		t.Error("device with a foreign approval should be rejected")
+	}
+}
+
+func TestAuthorAndWelcomeKey(t *testing.T) {
+	if got := DeviceAuthor("alice", ""); got != "alice" {
+		t.Errorf("primary author = %q", got)
+	}
+	author := DeviceAuthor("alice", "laptop1")
+	if m, d := SplitAuthor(author); m != "alice" || d != "laptop1" {
+		t.Errorf("SplitAuthor(%q) = %q, %q", author, m, d)
+	}
+	if m, d := SplitAuthor("alice"); m != "alice" || d != "" {
+		t.Errorf("SplitAuthor(alice) = %q, %q", m, d)
+	}
+	if got := WelcomeKey("alice", "laptop1"); got != "alice.laptop1" {
+		t.Errorf("WelcomeKey = %q", got)
+	}
+}
+
+func TestApprovalsRoundtrip(t *testing.T) {
+	paths := setupTestPaths(t)
+	dir := paths.PendingApprovalsDir("carol")
+	if got, err := ReadApprovals(dir); err != nil || len(got) != 0 {
+		t.Fatalf("ReadApprovals(missing) = %v, %v", got, err)
+	}
+
+	WriteApproval(dir, Approval{Approver: "bob", Signature: []byte("sig-b")})
+	WriteApproval(dir, Approval{Approver: DeviceAuthor("alice", "laptop1"), Signature: []byte("sig-a")})
+	WriteApproval(dir, Approval{Approver: "bob", Signature: []byte("sig-b2")})
+
+	got, err := ReadApprovals(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Approver != "alice:laptop1" || got[1].Approver != "bob" {
+		t.Fatalf("approvals = %+v", got)
+	}
+	if string(got[1].Signature) != "sig-b2" || got[1].Timestamp == 0 {
+		t.Errorf("bob's vote = %+v, want the latest signature", got[1])
+	}
+}
@@ -21,6 +21,31 @@ func WriteIdentity(paths MLSGitPaths, memberID, name string) error {
 	return os.WriteFile(paths.IdentityTOML(), []byte(content), 0o644)
 }
 
+// WriteDeviceIdentity writes the local identity file for an additional
+// device of memberID.
+func WriteDeviceIdentity(paths MLSGitPaths, memberID, name, deviceID string) error {
+	content := fmt.Sprintf("[identity]\nmember_id = %q\nname = %q\ndevice_id = %q\n", memberID, name, deviceID)
+	return os.WriteFile(paths.IdentityTOML(), []byte(content), 0o644)
+}
+
+// ReadDeviceID returns the local device ID, or "" on a member's primary
+// device.
+func ReadDeviceID(paths MLSGitPaths) (string, error) {
+	data, err := os.ReadFile(paths.IdentityTOML())
+	if err != nil {
+		return "", err
+	}
+	var w struct {
+		Identity struct {
+			DeviceID string `toml:"device_id"`
+		} `toml:"identity"`
+	}
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return "", fmt.Errorf("parse identity TOML: %w", err)
+	}
+	return w.Identity.DeviceID, nil
+}
+
 // ReadIdentity reads local identity -> {"member_id": ..., "name": ...}.
 func ReadIdentity(paths MLSGitPaths) (memberID, name string, err error) {
 	data, err := os.ReadFile(paths.IdentityTOML())
@@ -43,10 +68,29 @@ func ReadIdentity(paths MLSGitPaths) (memberID, name string, err error) {
 
 // --- Member helpers ---
 
-// WriteMemberTOML writes a member info file in .mlsgit/members/.
+// WriteMemberTOML writes a member info file in .mlsgit/members/ for a member
+// whose keys were just created.
 func WriteMemberTOML(paths MLSGitPaths, memberID, name, publicKeyPEM string, joinedEpoch int, addedBy string) error {
+	return WriteMemberInfo(paths, memberID, MemberInfo{
+		Name:        name,
+		PublicKey:   publicKeyPEM,
+		JoinedEpoch: joinedEpoch,
+		AddedBy:     addedBy,
+		KeysUpdated: time.Now().Unix(),
+	})
+}
+
+// WriteMemberInfo writes a member info file in .mlsgit/members/.
+func WriteMemberInfo(paths MLSGitPaths, memberID string, info MemberInfo) error {
 	content := fmt.Sprintf("[member]\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\njoined_epoch = %d\nadded_by = %q\n",
-		name, publicKeyPEM, joinedEpoch, addedBy)
+		info.Name, info.PublicKey, info.JoinedEpoch, info.AddedBy)
+	if info.KeysUpdated != 0 {
+		content += fmt.Sprintf("keys_updated = %d\n", info.KeysUpdated)
+	}
+	for _, a := range info.Verified {
+		content += fmt.Sprintf("\n[[member.verified]]\nmember_id = %q\nsigned_by = %q\nsignature = %q\n",
+			a.MemberID, a.SignedBy, crypto.B64Encode(a.Signature, false))
+	}
 	return os.WriteFile(paths.MemberTOML(memberID), []byte(content), 0o644)
 }
 
@@ -56,6 +100,50 @@ type MemberInfo struct {
 	PublicKey   string
 	JoinedEpoch int
 	AddedBy     string
+	KeysUpdated int64         // Unix time of the last key refresh; 0 if unknown
+	Verified    []Attestation // members whose keys this member has checked
+}
+
+// Attestation records that a member compared safety numbers with another
+// member and confirmed their public key.
+type Attestation struct {
+	MemberID  string // the member whose key was checked
+	SignedBy  string // author string (member ID, plus device ID) of the checking device
+	Signature []byte // over AttestationBytes
+}
+
+// AttestationBytes returns the bytes a member signs to attest that
+// subjectID's public key is subjectPEM.
+func AttestationBytes(verifierID, subjectID, subjectPEM string) []byte {
+	return []byte(fmt.Sprintf("mlsgit-verified\n%s\n%s\n%s", verifierID, subjectID, strings.TrimSpace(subjectPEM)))
+}
+
+// IdentityVerified reports whether verifierID holds a valid attestation for
+// subjectID's current public key.
+func IdentityVerified(paths MLSGitPaths, verifierID, subjectID string) bool {
+	verifier, err := ReadMemberTOML(paths.MemberTOML(verifierID))
+	if err != nil {
+		return false
+	}
+	subject, err := ReadMemberTOML(paths.MemberTOML(subjectID))
+	if err != nil {
+		return false
+	}
+	msg := AttestationBytes(verifierID, subjectID, subject.PublicKey)
+	for _, a := range verifier.Verified {
+		if a.MemberID != subjectID {
+			continue
+		}
+		memberID, deviceID := SplitAuthor(a.SignedBy)
+		if memberID != verifierID {
+			continue
+		}
+		pub, err := DevicePublicKey(paths, memberID, deviceID)
+		if err == nil && crypto.Verify(pub, msg, a.Signature) {
+			return true
+		}
+	}
+	return false
 }
 
 // ReadMemberTOML parses a member TOML file.
@@ -69,6 +157,12 @@ func ReadMemberTOML(path string) (MemberInfo, error) {
 		PublicKey   string `toml:"public_key"`
 		JoinedEpoch int    `toml:"joined_epoch"`
 		AddedBy     string `toml:"added_by"`
+		KeysUpdated int64  `toml:"keys_updated"`
+		Verified    []struct {
+			MemberID  string `toml:"member_id"`
+			SignedBy  string `toml:"signed_by"`
+			Signature string `toml:"signature"`
+		} `toml:"verified"`
 	}
 	type wrapper struct {
 		Member memberSection `toml:"member"`
@@ -77,12 +171,21 @@ func ReadMemberTOML(path string) (MemberInfo, error) {
 	if _, err := toml.Decode(string(data), &w); err != nil {
 		return MemberInfo{}, fmt.Errorf("parse member TOML: %w", err)
 	}
-	return MemberInfo{
+	info := MemberInfo{
 		Name:        w.Member.Name,
 		PublicKey:   strings.TrimSpace(w.Member.PublicKey),
 		JoinedEpoch: w.Member.JoinedEpoch,
 		AddedBy:     w.Member.AddedBy,
-	}, nil
+		KeysUpdated: w.Member.KeysUpdated,
+	}
+	for _, v := range w.Member.Verified {
+		sig, err := crypto.B64Decode(v.Signature, false)
+		if err != nil {
+			return MemberInfo{}, fmt.Errorf("decode attestation signature: %w", err)
+		}
+		info.Verified = append(info.Verified, Attestation{MemberID: v.MemberID, SignedBy: v.SignedBy, Signature: sig})
+	}
+	return info, nil
 }
 
 // --- Epoch helpers ---
@@ -99,6 +202,11 @@ func ReadEpochTOML(paths MLSGitPaths) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	return EpochFromTOML(data)
+}
+
+// EpochFromTOML parses the current epoch number from epoch.toml contents.
+func EpochFromTOML(data []byte) (int, error) {
 	type epochSection struct {
 		Current int `toml:"current"`
 	}
@@ -114,21 +222,32 @@ func ReadEpochTOML(paths MLSGitPaths) (int, error) {
 
 // --- Pending request helpers ---
 
-// WritePendingRequest writes a pending join request file.
-func WritePendingRequest(paths MLSGitPaths, memberID, name, publicKeyPEM, keypackageB64 string) error {
-	content := fmt.Sprintf(
-		"[request]\nmember_id = %q\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nkeypackage = \"\"\"\n%s\n\"\"\"\ntimestamp = %d\n",
-		memberID, name, publicKeyPEM, keypackageB64, time.Now().Unix())
-	return os.WriteFile(paths.PendingRequest(memberID), []byte(content), 0o644)
+// PendingRequestInfo holds parsed pending request data. A request is signed
+// twice over JoinRequestBytes: by the requester's Ed25519 identity key and
+// by the signing key in their KeyPackage, proving possession of both.
+type PendingRequestInfo struct {
+	MemberID     string
+	Name         string
+	PublicKey    string
+	Keypackage   string
+	Timestamp    int64
+	Signature    []byte // by PublicKey
+	MLSSignature []byte // by the KeyPackage's SigPub
 }
 
-// PendingRequestInfo holds parsed pending request data.
-type PendingRequestInfo struct {
-	MemberID   string
-	Name       string
-	PublicKey  string
-	Keypackage string
-	Timestamp  int64
+// JoinRequestBytes returns the bytes both request signatures cover.
+func JoinRequestBytes(req PendingRequestInfo) []byte {
+	return []byte(fmt.Sprintf("mlsgit-join-request\n%s\n%s\n%s\n%s\n%d",
+		req.MemberID, req.Name, strings.TrimSpace(req.PublicKey), strings.TrimSpace(req.Keypackage), req.Timestamp))
+}
+
+// WritePendingRequest writes a pending join request file.
+func WritePendingRequest(paths MLSGitPaths, req PendingRequestInfo) error {
+	content := fmt.Sprintf(
+		"[request]\nmember_id = %q\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nkeypackage = \"\"\"\n%s\n\"\"\"\ntimestamp = %d\nsignature = %q\nmls_signature = %q\n",
+		req.MemberID, req.Name, req.PublicKey, req.Keypackage, req.Timestamp,
+		crypto.B64Encode(req.Signature, false), crypto.B64Encode(req.MLSSignature, false))
+	return os.WriteFile(paths.PendingRequest(req.MemberID), []byte(content), 0o644)
 }
 
 // ReadPendingRequest parses a pending request TOML file.
@@ -138,11 +257,13 @@ func ReadPendingRequest(path string) (PendingRequestInfo, error) {
 		return PendingRequestInfo{}, err
 	}
 	type requestSection struct {
-		MemberID   string `toml:"member_id"`
-		Name       string `toml:"name"`
-		PublicKey  string `toml:"public_key"`
-		Keypackage string `toml:"keypackage"`
-		Timestamp  int64  `toml:"timestamp"`
+		MemberID     string `toml:"member_id"`
+		Name         string `toml:"name"`
+		PublicKey    string `toml:"public_key"`
+		Keypackage   string `toml:"keypackage"`
+		Timestamp    int64  `toml:"timestamp"`
+		Signature    string `toml:"signature"`
+		MLSSignature string `toml:"mls_signature"`
 	}
 	type wrapper struct {
 		Request requestSection `toml:"request"`
@@ -151,15 +272,115 @@ func ReadPendingRequest(path string) (PendingRequestInfo, error) {
 	if _, err := toml.Decode(string(data), &w); err != nil {
 		return PendingRequestInfo{}, fmt.Errorf("parse request TOML: %w", err)
 	}
+	sig, err := crypto.B64Decode(w.Request.Signature, false)
+	if err != nil {
+		return PendingRequestInfo{}, fmt.Errorf("decode request signature: %w", err)
+	}
+	mlsSig, err := crypto.B64Decode(w.Request.MLSSignature, false)
+	if err != nil {
+		return PendingRequestInfo{}, fmt.Errorf("decode request MLS signature: %w", err)
+	}
 	return PendingRequestInfo{
-		MemberID:   w.Request.MemberID,
-		Name:       w.Request.Name,
-		PublicKey:  strings.TrimSpace(w.Request.PublicKey),
-		Keypackage: strings.TrimSpace(w.Request.Keypackage),
-		Timestamp:  w.Request.Timestamp,
+		MemberID:     w.Request.MemberID,
+		Name:         w.Request.Name,
+		PublicKey:    strings.TrimSpace(w.Request.PublicKey),
+		Keypackage:   strings.TrimSpace(w.Request.Keypackage),
+		Timestamp:    w.Request.Timestamp,
+		Signature:    sig,
+		MLSSignature: mlsSig,
 	}, nil
 }
 
+// --- Rejection helpers ---
+
+// Rejection is a signed record that a join request was turned down.
+type Rejection struct {
+	MemberID   string
+	Name       string
+	PublicKey  string // requester's identity key, so a rejection names the keys it refused
+	Reason     string
+	RejectedBy string // author string (member ID, plus device ID) of the rejecting device
+	Timestamp  int64
+	Signature  []byte // rejecter's signature over RejectionBytes
+}
+
+// RejectionBytes returns the bytes the rejecting device signs.
+func RejectionBytes(r Rejection) []byte {
+	return []byte(fmt.Sprintf("mlsgit-rejection\n%s\n%s\n%s\n%s\n%s\n%d",
+		r.MemberID, r.Name, strings.TrimSpace(r.PublicKey), r.Reason, r.RejectedBy, r.Timestamp))
+}
+
+// WriteRejection writes a rejection record to .mlsgit/rejected/.
+func WriteRejection(paths MLSGitPaths, r Rejection) error {
+	if err := os.MkdirAll(paths.RejectedDir(), 0o755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf(
+		"[rejection]\nmember_id = %q\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nreason = %q\nrejected_by = %q\ntimestamp = %d\nsignature = %q\n",
+		r.MemberID, r.Name, r.PublicKey, r.Reason, r.RejectedBy, r.Timestamp, crypto.B64Encode(r.Signature, false))
+	return os.WriteFile(paths.RejectionTOML(r.MemberID), []byte(content), 0o644)
+}
+
+// ReadRejection parses a rejection record.
+func ReadRejection(path string) (Rejection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rejection{}, err
+	}
+	var w struct {
+		Rejection struct {
+			MemberID   string `toml:"member_id"`
+			Name       string `toml:"name"`
+			PublicKey  string `toml:"public_key"`
+			Reason     string `toml:"reason"`
+			RejectedBy string `toml:"rejected_by"`
+			Timestamp  int64  `toml:"timestamp"`
+			Signature  string `toml:"signature"`
+		} `toml:"rejection"`
+	}
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return Rejection{}, fmt.Errorf("parse rejection TOML: %w", err)
+	}
+	sig, err := crypto.B64Decode(w.Rejection.Signature, false)
+	if err != nil {
+		return Rejection{}, fmt.Errorf("decode rejection signature: %w", err)
+	}
+	r := w.Rejection
+	return Rejection{
+		MemberID:   r.MemberID,
+		Name:       r.Name,
+		PublicKey:  strings.TrimSpace(r.PublicKey),
+		Reason:     r.Reason,
+		RejectedBy: r.RejectedBy,
+		Timestamp:  r.Timestamp,
+		Signature:  sig,
+	}, nil
+}
+
+// VerifyRejection checks a rejection's signature against the rejecting
+// device's committed key.
+func VerifyRejection(paths MLSGitPaths, r Rejection) error {
+	memberID, deviceID := SplitAuthor(r.RejectedBy)
+	pub, err := DevicePublicKey(paths, memberID, deviceID)
+	if err != nil {
+		return fmt.Errorf("rejecter %s: %w", r.RejectedBy, err)
+	}
+	if !crypto.Verify(pub, RejectionBytes(r), r.Signature) {
+		return fmt.Errorf("rejection of %s has an invalid signature", r.MemberID)
+	}
+	return nil
+}
+
+// ListRejections returns paths to rejection records.
+func ListRejections(paths MLSGitPaths) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(paths.RejectedDir(), "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
 // --- MLS state helpers ---
 
 // WriteGroupState writes MLS group state as base64 to .mlsgit/group/state.b64.
@@ -176,14 +397,36 @@ func ReadGroupState(paths MLSGitPaths) ([]byte, error) {
 	return crypto.B64Decode(strings.TrimSpace(string(data)), false)
 }
 
-// WriteLocalMLSState writes local MLS ratchet state to .git/mlsgit/mls_state.bin.
+// WriteLocalMLSState writes local MLS ratchet state to .git/mlsgit/mls_state.bin,
+// sealed if a passphrase is set.
 func WriteLocalMLSState(paths MLSGitPaths, stateBytes []byte) error {
-	return os.WriteFile(paths.MLSState(), stateBytes, 0o600)
+	return WriteSecret(paths, paths.MLSState(), stateBytes)
 }
 
 // ReadLocalMLSState reads local MLS ratchet state from .git/mlsgit/mls_state.bin.
 func ReadLocalMLSState(paths MLSGitPaths) ([]byte, error) {
-	return os.ReadFile(paths.MLSState())
+	return ReadSecret(paths, paths.MLSState())
+}
+
+// FinishInitKeyRotation completes an init key rotation interrupted between
+// saving the group state and moving the new key from NextInitPriv into
+// place. The new key is installed and returned if holds accepts it, as
+// the saved group state already uses it, and deleted otherwise.
+func FinishInitKeyRotation(paths MLSGitPaths, holds func(initPriv []byte) bool) ([]byte, bool, error) {
+	next, err := ReadSecret(paths, paths.NextInitPriv())
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !holds(next) {
+		return nil, false, os.Remove(paths.NextInitPriv())
+	}
+	if err := os.Rename(paths.NextInitPriv(), paths.InitPriv()); err != nil {
+		return nil, false, fmt.Errorf("install new init key: %w", err)
+	}
+	return next, true, nil
 }
 
 // WriteWelcome writes a Welcome message for a member.
@@ -201,6 +444,24 @@ func ReadWelcome(paths MLSGitPaths, memberID string) ([]byte, error) {
 	return crypto.B64Decode(strings.TrimSpace(string(data)), false)
 }
 
+// WriteHistoryGrant writes a history grant for a member or device.
+func WriteHistoryGrant(paths MLSGitPaths, memberID string, grant []byte) error {
+	if err := os.MkdirAll(paths.HistoryDir(), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(paths.HistoryGrant(memberID),
+		[]byte(crypto.B64Encode(grant, false)), 0o644)
+}
+
+// ReadHistoryGrant reads the history grant for a member or device.
+func ReadHistoryGrant(paths MLSGitPaths, memberID string) ([]byte, error) {
+	data, err := os.ReadFile(paths.HistoryGrant(memberID))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.B64Decode(strings.TrimSpace(string(data)), false)
+}
+
 // --- Merkle manifest ---
 
 // WriteMerkleManifest writes the Merkle manifest to .mlsgit/merkle.toml.
@@ -219,18 +480,15 @@ func ReadMerkleManifest(paths MLSGitPaths) (crypto.MerkleManifest, error) {
 
 // --- Epoch key archive ---
 
-// WriteEpochKeys writes the encrypted epoch key archive.
+// WriteEpochKeys writes the epoch key archive, as produced by
+// EpochKeyArchive.Seal.
 func WriteEpochKeys(paths MLSGitPaths, data []byte) error {
-	return os.WriteFile(paths.EpochKeys(), []byte(crypto.B64Encode(data, false)), 0o644)
+	return os.WriteFile(paths.EpochKeys(), data, 0o644)
 }
 
-// ReadEpochKeys reads the encrypted epoch key archive.
+// ReadEpochKeys reads the epoch key archive file as is.
 func ReadEpochKeys(paths MLSGitPaths) ([]byte, error) {
-	data, err := os.ReadFile(paths.EpochKeys())
-	if err != nil {
-		return nil, err
-	}
-	return crypto.B64Decode(strings.TrimSpace(string(data)), false)
+	return os.ReadFile(paths.EpochKeys())
 }
 
 // --- Member listing helpers ---
@@ -3,7 +3,10 @@ package storage
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
 )
 
 func setupTestPaths(t *testing.T) MLSGitPaths {
@@ -57,6 +60,21 @@ func TestMemberTOMLRoundtrip(t *testing.T) {
 	if info.JoinedEpoch != 0 {
 		t.Errorf("JoinedEpoch = %d, want 0", info.JoinedEpoch)
 	}
+	if info.KeysUpdated == 0 {
+		t.Error("KeysUpdated should be set when writing a new member")
+	}
+
+	info.KeysUpdated = 1700000000
+	if err := WriteMemberInfo(paths, "abc123", info); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := ReadMemberTOML(paths.MemberTOML("abc123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, info) {
+		t.Errorf("WriteMemberInfo roundtrip:\ngot:  %+v\nwant: %+v", updated, info)
+	}
 }
 
 func TestEpochTOMLRoundtrip(t *testing.T) {
@@ -144,7 +162,16 @@ func TestWelcomeRoundtrip(t *testing.T) {
 
 func TestPendingRequestRoundtrip(t *testing.T) {
 	paths := setupTestPaths(t)
-	if err := WritePendingRequest(paths, "abc123", "bob", "pubkey-pem", "keypackage-b64"); err != nil {
+	req := PendingRequestInfo{
+		MemberID:     "abc123",
+		Name:         "bob",
+		PublicKey:    "pubkey-pem",
+		Keypackage:   "keypackage-b64",
+		Timestamp:    1700000000,
+		Signature:    []byte("sig"),
+		MLSSignature: []byte("mls-sig"),
+	}
+	if err := WritePendingRequest(paths, req); err != nil {
 		t.Fatal(err)
 	}
 
@@ -158,6 +185,51 @@ func TestPendingRequestRoundtrip(t *testing.T) {
 	if info.Name != "bob" {
 		t.Errorf("Name = %q, want %q", info.Name, "bob")
 	}
+	if string(info.Signature) != "sig" || string(info.MLSSignature) != "mls-sig" {
+		t.Errorf("signatures = %q, %q", info.Signature, info.MLSSignature)
+	}
+	if string(JoinRequestBytes(info)) != string(JoinRequestBytes(req)) {
+		t.Error("signed bytes should survive the roundtrip")
+	}
+}
+
+func TestRejectionRoundtrip(t *testing.T) {
+	paths := setupTestPaths(t)
+	priv, pub, _ := crypto.GenerateKeypair()
+	pubPEM, _ := crypto.PublicKeyToPEM(pub)
+	WriteMemberTOML(paths, "alice", "alice", pubPEM, 0, "self")
+
+	r := Rejection{
+		MemberID:   "mallory1",
+		Name:       "mallory",
+		PublicKey:  "mallory-pem",
+		Reason:     "unknown person",
+		RejectedBy: "alice",
+		Timestamp:  1700000000,
+	}
+	r.Signature = crypto.Sign(priv, RejectionBytes(r))
+	if err := WriteRejection(paths, r); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ListRejections(paths)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("ListRejections = %v, %v", list, err)
+	}
+	got, err := ReadRejection(list[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Reason != "unknown person" || got.RejectedBy != "alice" {
+		t.Errorf("rejection = %+v", got)
+	}
+	if err := VerifyRejection(paths, got); err != nil {
+		t.Errorf("VerifyRejection: %v", err)
+	}
+	got.Reason = "changed"
+	if err := VerifyRejection(paths, got); err == nil {
+		t.Error("a modified rejection should not verify")
+	}
 }
 
 func TestListMemberIDs(t *testing.T) {
@@ -176,3 +248,40 @@ func TestListMemberIDs(t *testing.T) {
 		t.Errorf("ids = %v, want [aaa bbb]", ids)
 	}
 }
+
+func TestIdentityVerified(t *testing.T) {
+	paths := setupTestPaths(t)
+	alicePriv, alicePub, _ := crypto.GenerateKeypair()
+	alicePEM, _ := crypto.PublicKeyToPEM(alicePub)
+	_, bobPub, _ := crypto.GenerateKeypair()
+	bobPEM, _ := crypto.PublicKeyToPEM(bobPub)
+	WriteMemberTOML(paths, "alice", "alice", alicePEM, 0, "self")
+	WriteMemberTOML(paths, "bob", "bob", bobPEM, 1, "alice")
+
+	if IdentityVerified(paths, "alice", "bob") {
+		t.Fatal("bob should start unverified")
+	}
+	info, _ := ReadMemberTOML(paths.MemberTOML("alice"))
+	info.Verified = append(info.Verified, Attestation{
+		MemberID:  "bob",
+		SignedBy:  "alice",
+		Signature: crypto.Sign(alicePriv, AttestationBytes("alice", "bob", bobPEM)),
+	})
+	if err := WriteMemberInfo(paths, "alice", info); err != nil {
+		t.Fatal(err)
+	}
+	if !IdentityVerified(paths, "alice", "bob") {
+		t.Error("bob should be verified after alice's attestation")
+	}
+	if IdentityVerified(paths, "bob", "alice") {
+		t.Error("verification is not mutual")
+	}
+
+	// Replacing bob's key invalidates the attestation.
+	_, newPub, _ := crypto.GenerateKeypair()
+	newPEM, _ := crypto.PublicKeyToPEM(newPub)
+	WriteMemberTOML(paths, "bob", "bob", newPEM, 1, "alice")
+	if IdentityVerified(paths, "alice", "bob") {
+		t.Error("attestation should not cover a different key")
+	}
+}
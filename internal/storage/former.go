@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Former members ---
+//
+// Removing a member deletes members/<id>.toml, but file records they signed
+// while in the group stay in history. former/<id>.toml keeps their signing
+// keys with the epochs they were valid for, so those records still verify
+// and records claiming a later epoch do not. It also keeps their MLS
+// signature keys, so a client catching up can still tell who signed each
+// transition they were part of.
+
+// FormerMember holds the signing keys of a removed member.
+type FormerMember struct {
+	Name         string
+	PublicKey    string
+	SigPub       []byte // MLS signature key of the primary device's leaf
+	Role         string // role at removal; "" if the group had no policy
+	JoinedEpoch  int
+	RemovedEpoch int // first epoch the member was no longer in the group
+	RemovedBy    string
+	Devices      []FormerDevice
+}
+
+// FormerDevice holds the signing key of one of a removed member's
+// additional devices, checked against its approval chain at removal.
+type FormerDevice struct {
+	ID         string
+	Name       string
+	PublicKey  string
+	SigPub     []byte // MLS signature key of the device's leaf
+	AddedEpoch int
+}
+
+// WriteFormerMember writes a removed member's record under former/.
+func WriteFormerMember(paths MLSGitPaths, memberID string, f FormerMember) error {
+	if err := os.MkdirAll(paths.FormerDir(), 0o755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("[former]\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nrole = %q\njoined_epoch = %d\nremoved_epoch = %d\nremoved_by = %q\n",
+		f.Name, f.PublicKey, f.Role, f.JoinedEpoch, f.RemovedEpoch, f.RemovedBy)
+	if len(f.SigPub) > 0 {
+		content += fmt.Sprintf("sig_pub = %q\n", crypto.B64Encode(f.SigPub, false))
+	}
+	for _, d := range f.Devices {
+		content += fmt.Sprintf("\n[[former.device]]\nid = %q\nname = %q\npublic_key = \"\"\"\n%s\n\"\"\"\nadded_epoch = %d\n",
+			d.ID, d.Name, d.PublicKey, d.AddedEpoch)
+		if len(d.SigPub) > 0 {
+			content += fmt.Sprintf("sig_pub = %q\n", crypto.B64Encode(d.SigPub, false))
+		}
+	}
+	return os.WriteFile(paths.FormerMemberTOML(memberID), []byte(content), 0o644)
+}
+
+// ReadFormerMember parses a removed member's record.
+func ReadFormerMember(paths MLSGitPaths, memberID string) (FormerMember, error) {
+	data, err := os.ReadFile(paths.FormerMemberTOML(memberID))
+	if err != nil {
+		return FormerMember{}, err
+	}
+	type deviceSection struct {
+		ID         string `toml:"id"`
+		Name       string `toml:"name"`
+		PublicKey  string `toml:"public_key"`
+		SigPub     string `toml:"sig_pub"`
+		AddedEpoch int    `toml:"added_epoch"`
+	}
+	type formerSection struct {
+		Name         string          `toml:"name"`
+		PublicKey    string          `toml:"public_key"`
+		SigPub       string          `toml:"sig_pub"`
+		Role         string          `toml:"role"`
+		JoinedEpoch  int             `toml:"joined_epoch"`
+		RemovedEpoch int             `toml:"removed_epoch"`
+		RemovedBy    string          `toml:"removed_by"`
+		Device       []deviceSection `toml:"device"`
+	}
+	type wrapper struct {
+		Former formerSection `toml:"former"`
+	}
+	var w wrapper
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return FormerMember{}, fmt.Errorf("parse former member TOML: %w", err)
+	}
+	f := FormerMember{
+		Name:         w.Former.Name,
+		PublicKey:    strings.TrimSpace(w.Former.PublicKey),
+		Role:         w.Former.Role,
+		JoinedEpoch:  w.Former.JoinedEpoch,
+		RemovedEpoch: w.Former.RemovedEpoch,
+		RemovedBy:    w.Former.RemovedBy,
+	}
+	if f.SigPub, err = crypto.B64Decode(w.Former.SigPub, false); err != nil {
+		return FormerMember{}, fmt.Errorf("decode former member sig_pub: %w", err)
+	}
+	for _, d := range w.Former.Device {
+		sigPub, err := crypto.B64Decode(d.SigPub, false)
+		if err != nil {
+			return FormerMember{}, fmt.Errorf("decode sig_pub of device %s: %w", d.ID, err)
+		}
+		f.Devices = append(f.Devices, FormerDevice{
+			ID:         d.ID,
+			Name:       d.Name,
+			PublicKey:  strings.TrimSpace(d.PublicKey),
+			SigPub:     sigPub,
+			AddedEpoch: d.AddedEpoch,
+		})
+	}
+	return f, nil
+}
+
+// ListFormerMemberIDs returns the sorted IDs of removed members.
+func ListFormerMemberIDs(paths MLSGitPaths) ([]string, error) {
+	entries, err := os.ReadDir(paths.FormerDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".toml") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".toml"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ActiveFrom returns the first epoch at which the former member's device
+// ("" is the primary device) was in the group, or -1 if they had no such
+// device. The device left the group at RemovedEpoch.
+func (f FormerMember) ActiveFrom(deviceID string) int {
+	if deviceID == "" {
+		return f.JoinedEpoch
+	}
+	for _, d := range f.Devices {
+		if d.ID == deviceID {
+			return d.AddedEpoch
+		}
+	}
+	return -1
+}
+
+// PublicKeyAt returns the signing key of one of the former member's devices
+// ("" is the primary device) for a record written at epoch, which must fall
+// between the device joining and the member's removal.
+func (f FormerMember) PublicKeyAt(deviceID string, epoch int) (ed25519.PublicKey, error) {
+	pemData, from := f.PublicKey, f.ActiveFrom(deviceID)
+	if from < 0 {
+		return nil, fmt.Errorf("former member %s had no device %s", f.Name, deviceID)
+	}
+	for _, d := range f.Devices {
+		if d.ID == deviceID {
+			pemData = d.PublicKey
+		}
+	}
+	if epoch < from || epoch >= f.RemovedEpoch {
+		return nil, fmt.Errorf("record at epoch %d is outside %s's membership (epochs %d-%d)", epoch, f.Name, from, f.RemovedEpoch-1)
+	}
+	return crypto.LoadPublicKey(pemData)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+func TestFormerMemberRoundtrip(t *testing.T) {
+	paths := setupTestPaths(t)
+
+	_, primaryPub, _ := crypto.GenerateKeypair()
+	primaryPEM, _ := crypto.PublicKeyToPEM(primaryPub)
+	_, laptopPub, _ := crypto.GenerateKeypair()
+	laptopPEM, _ := crypto.PublicKeyToPEM(laptopPub)
+	want := FormerMember{
+		Name:         "bob",
+		PublicKey:    primaryPEM,
+		SigPub:       []byte("primary-mls-key"),
+		Role:         "writer",
+		JoinedEpoch:  1,
+		RemovedEpoch: 5,
+		RemovedBy:    "alice",
+		Devices:      []FormerDevice{{ID: "laptop1", Name: "laptop", PublicKey: laptopPEM, SigPub: []byte("laptop-mls-key"), AddedEpoch: 3}},
+	}
+	if err := WriteFormerMember(paths, "bob", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFormerMember(paths, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != want.Name || got.Role != want.Role || got.JoinedEpoch != 1 || got.RemovedEpoch != 5 || got.RemovedBy != "alice" {
+		t.Errorf("got %+v", got)
+	}
+	if !bytes.Equal(got.SigPub, want.SigPub) {
+		t.Errorf("SigPub = %q", got.SigPub)
+	}
+	if len(got.Devices) != 1 || got.Devices[0].ID != "laptop1" || got.Devices[0].AddedEpoch != 3 || !bytes.Equal(got.Devices[0].SigPub, want.Devices[0].SigPub) {
+		t.Errorf("devices = %+v", got.Devices)
+	}
+	if ids, _ := ListFormerMemberIDs(paths); len(ids) != 1 || ids[0] != "bob" {
+		t.Errorf("ListFormerMemberIDs = %v", ids)
+	}
+
+	// Keys are only valid for epochs inside the membership
+	cases := []struct {
+		device string
+		epoch  int
+		want   []byte
+	}{
+		{"", 1, primaryPub},
+		{"", 4, primaryPub},
+		{"", 0, nil},
+		{"", 5, nil},
+		{"laptop1", 3, laptopPub},
+		{"laptop1", 2, nil},
+		{"laptop1", 5, nil},
+		{"phone1", 3, nil},
+	}
+	for _, c := range cases {
+		pub, err := got.PublicKeyAt(c.device, c.epoch)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("PublicKeyAt(%q, %d) should fail", c.device, c.epoch)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(pub, c.want) {
+			t.Errorf("PublicKeyAt(%q, %d): %v", c.device, c.epoch, err)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// PassphraseEnv names the environment variable that, when set, unlocks
+// sealed local keys without an 'mlsgit unlock' session (for scripts and CI).
+const PassphraseEnv = "MLSGIT_PASSPHRASE"
+
+// ErrLocked is returned when local key files are sealed and neither an
+// unlocked session nor MLSGIT_PASSPHRASE provides the key.
+var ErrLocked = errors.New("local keys are locked: run 'mlsgit unlock' (or set " + PassphraseEnv + ")")
+
+// ErrNoSessionDir is returned by SaveSession when there is no per-login
+// runtime directory to keep the unsealed key in.
+var ErrNoSessionDir = errors.New("$XDG_RUNTIME_DIR is not set, and unlock sessions are only kept in a per-login runtime directory: set " + PassphraseEnv + " instead")
+
+// keystoreCheck is sealed under the passphrase key so a wrong passphrase
+// is reported as such rather than as a corrupt key file.
+var keystoreCheck = []byte("mlsgit-keystore")
+
+// Keystore records how the local key files in .git/mlsgit/ are sealed:
+// the Argon2id salt and cost, and a check value sealed under the key.
+type Keystore struct {
+	Salt  []byte
+	KDF   crypto.KDFParams
+	Check []byte
+}
+
+// unlocked caches keys derived in this process, by local directory, so a
+// filter run reading several key files derives the key once.
+var unlocked = map[string][]byte{}
+
+// NewKeystore creates a keystore for a passphrase with a fresh salt and
+// returns it with the derived key.
+func NewKeystore(passphrase []byte) (Keystore, []byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return Keystore{}, nil, fmt.Errorf("random salt: %w", err)
+	}
+	key, err := crypto.PassphraseKey(passphrase, salt, crypto.DefaultKDFParams)
+	if err != nil {
+		return Keystore{}, nil, err
+	}
+	check, err := crypto.SealLocal(key, keystoreCheck)
+	if err != nil {
+		return Keystore{}, nil, err
+	}
+	return Keystore{Salt: salt, KDF: crypto.DefaultKDFParams, Check: check}, key, nil
+}
+
+// Unlock derives the key for a passphrase and checks it against the keystore.
+func (ks Keystore) Unlock(passphrase []byte) ([]byte, error) {
+	key, err := crypto.PassphraseKey(passphrase, ks.Salt, ks.KDF)
+	if err != nil {
+		return nil, err
+	}
+	if !ks.accepts(key) {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+	return key, nil
+}
+
+func (ks Keystore) accepts(key []byte) bool {
+	check, err := crypto.OpenLocal(key, ks.Check)
+	return err == nil && string(check) == string(keystoreCheck)
+}
+
+// WriteKeystore writes .git/mlsgit/keystore.toml.
+func WriteKeystore(paths MLSGitPaths, ks Keystore) error {
+	content := fmt.Sprintf("[keystore]\nkdf = \"argon2id\"\nsalt = %q\ntime = %d\nmemory = %d\nthreads = %d\ncheck = %q\n",
+		crypto.B64Encode(ks.Salt, false), ks.KDF.Time, ks.KDF.Memory, ks.KDF.Threads, crypto.B64Encode(ks.Check, false))
+	return os.WriteFile(paths.KeystoreTOML(), []byte(content), 0o600)
+}
+
+// ReadKeystore reads .git/mlsgit/keystore.toml. Returns nil if the local
+// keys are not protected by a passphrase.
+func ReadKeystore(paths MLSGitPaths) (*Keystore, error) {
+	data, err := os.ReadFile(paths.KeystoreTOML())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w struct {
+		Keystore struct {
+			KDF     string `toml:"kdf"`
+			Salt    string `toml:"salt"`
+			Time    int    `toml:"time"`
+			Memory  int    `toml:"memory"`
+			Threads int    `toml:"threads"`
+			Check   string `toml:"check"`
+		} `toml:"keystore"`
+	}
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return nil, fmt.Errorf("parse keystore TOML: %w", err)
+	}
+	if w.Keystore.KDF != "argon2id" {
+		return nil, fmt.Errorf("keystore: unsupported kdf %q", w.Keystore.KDF)
+	}
+	salt, err := crypto.B64Decode(w.Keystore.Salt, false)
+	if err != nil {
+		return nil, fmt.Errorf("keystore salt: %w", err)
+	}
+	check, err := crypto.B64Decode(w.Keystore.Check, false)
+	if err != nil {
+		return nil, fmt.Errorf("keystore check: %w", err)
+	}
+	return &Keystore{
+		Salt:  salt,
+		KDF:   crypto.KDFParams{Time: w.Keystore.Time, Memory: w.Keystore.Memory, Threads: w.Keystore.Threads},
+		Check: check,
+	}, nil
+}
+
+// RemoveKeystore deletes the keystore, after the local key files have
+// been rewritten unsealed.
+func RemoveKeystore(paths MLSGitPaths) error {
+	delete(unlocked, paths.LocalDir())
+	if err := os.Remove(paths.KeystoreTOML()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// LocalSecrets lists the local files that hold key material.
+func LocalSecrets(paths MLSGitPaths) []string {
+	return []string{paths.PrivateKey(), paths.SigPriv(), paths.InitPriv(), paths.NextInitPriv(), paths.MLSState()}
+}
+
+// ReadSecret reads a local key file, unsealing it if it was written under
+// a passphrase.
+func ReadSecret(paths MLSGitPaths, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !crypto.IsSealed(data) {
+		return data, err
+	}
+	key, err := localKey(paths)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := crypto.OpenLocal(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return plaintext, nil
+}
+
+// WriteSecret writes a local key file, sealed if a passphrase is set.
+func WriteSecret(paths MLSGitPaths, path string, data []byte) error {
+	ks, err := ReadKeystore(paths)
+	if err != nil {
+		return err
+	}
+	if ks != nil {
+		key, err := localKey(paths)
+		if err != nil {
+			return err
+		}
+		if data, err = crypto.SealLocal(key, data); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// UseKey makes key the key for sealed local files for the rest of this
+// process, e.g. while re-sealing them under a new passphrase.
+func UseKey(paths MLSGitPaths, key []byte) {
+	unlocked[paths.LocalDir()] = key
+}
+
+// localKey returns the key sealing local files, from this process,
+// MLSGIT_PASSPHRASE or an unlocked session, in that order.
+func localKey(paths MLSGitPaths) ([]byte, error) {
+	if key, ok := unlocked[paths.LocalDir()]; ok {
+		return key, nil
+	}
+	ks, err := ReadKeystore(paths)
+	if err != nil {
+		return nil, err
+	}
+	if ks == nil {
+		return nil, fmt.Errorf("local keys are sealed but %s is missing", filepath.Base(paths.KeystoreTOML()))
+	}
+	var key []byte
+	if pass := os.Getenv(PassphraseEnv); pass != "" {
+		if key, err = ks.Unlock([]byte(pass)); err != nil {
+			return nil, fmt.Errorf("%s: %w", PassphraseEnv, err)
+		}
+	} else if key = readSession(paths); key == nil || !ks.accepts(key) {
+		return nil, ErrLocked
+	}
+	unlocked[paths.LocalDir()] = key
+	return key, nil
+}
+
+// --- Unlock sessions ---
+
+// sessionDir is where 'mlsgit unlock' caches keys: under $XDG_RUNTIME_DIR,
+// a per-login tmpfs that is never backed up. There is no fallback to a
+// persistent directory.
+func sessionDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return "", ErrNoSessionDir
+	}
+	return filepath.Join(dir, "mlsgit"), nil
+}
+
+// sessionName returns the file name of the session for paths.
+func sessionName(paths MLSGitPaths) (string, error) {
+	abs, err := filepath.Abs(paths.LocalDir())
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(abs))
+	return fmt.Sprintf("%x.session", h[:8]), nil
+}
+
+// sessionFile returns the session file for paths.
+func sessionFile(paths MLSGitPaths) (string, error) {
+	dir, err := sessionDir()
+	if err != nil {
+		return "", err
+	}
+	name, err := sessionName(paths)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// removeLegacySession deletes the session file older versions kept in the
+// user cache dir when $XDG_RUNTIME_DIR was unset.
+func removeLegacySession(paths MLSGitPaths) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return
+	}
+	if name, err := sessionName(paths); err == nil {
+		os.Remove(filepath.Join(dir, "mlsgit", name))
+	}
+}
+
+// SaveSession caches key so sealed local files can be read without a
+// passphrase until ttl has passed. Expired sessions of other repositories
+// are deleted on the way.
+func SaveSession(paths MLSGitPaths, key []byte, ttl time.Duration) error {
+	removeLegacySession(paths)
+	p, err := sessionFile(paths)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	sweepSessions(filepath.Dir(p))
+	content := fmt.Sprintf("[session]\nkey = %q\nexpires = %q\n",
+		crypto.B64Encode(key, false), time.Now().Add(ttl).UTC().Format(time.RFC3339))
+	return os.WriteFile(p, []byte(content), 0o600)
+}
+
+// readSessionFile returns the key in a session file, or nil if it cannot
+// be read or has expired, in which case the file is deleted.
+func readSessionFile(p string) []byte {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	var w struct {
+		Session struct {
+			Key     string    `toml:"key"`
+			Expires time.Time `toml:"expires"`
+		} `toml:"session"`
+	}
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		os.Remove(p)
+		return nil
+	}
+	if time.Now().After(w.Session.Expires) {
+		os.Remove(p)
+		return nil
+	}
+	key, err := crypto.B64Decode(w.Session.Key, false)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// sweepSessions deletes the expired sessions in dir.
+func sweepSessions(dir string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.session"))
+	for _, p := range matches {
+		readSessionFile(p)
+	}
+}
+
+// readSession returns the cached key, or nil if there is no unexpired
+// session. Expired sessions in the session directory are deleted.
+func readSession(paths MLSGitPaths) []byte {
+	removeLegacySession(paths)
+	p, err := sessionFile(paths)
+	if err != nil {
+		return nil
+	}
+	sweepSessions(filepath.Dir(p))
+	return readSessionFile(p)
+}
+
+// ClearSession forgets the cached key.
+func ClearSession(paths MLSGitPaths) error {
+	delete(unlocked, paths.LocalDir())
+	removeLegacySession(paths)
+	p, err := sessionFile(paths)
+	if errors.Is(err, ErrNoSessionDir) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretsSealedUnderPassphrase(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv(PassphraseEnv, "")
+	paths := setupTestPaths(t)
+	secret := []byte("init private key")
+
+	// Without a keystore, secrets are written as-is
+	if err := WriteSecret(paths, paths.InitPriv(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(paths.InitPriv()); !bytes.Equal(raw, secret) {
+		t.Error("secret should be unsealed without a keystore")
+	}
+
+	ks, key, err := NewKeystore([]byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeystore(paths, ks); err != nil {
+		t.Fatal(err)
+	}
+	UseKey(paths, key)
+	if err := WriteSecret(paths, paths.InitPriv(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(paths.InitPriv()); bytes.Contains(raw, secret) {
+		t.Error("secret should be sealed on disk")
+	}
+
+	// A fresh process has no key until a session or the env var provides one
+	ClearSession(paths)
+	if _, err := ReadSecret(paths, paths.InitPriv()); !errors.Is(err, ErrLocked) {
+		t.Fatalf("err = %v, want ErrLocked", err)
+	}
+
+	t.Setenv(PassphraseEnv, "wrong")
+	if _, err := ReadSecret(paths, paths.InitPriv()); err == nil {
+		t.Error("wrong passphrase should not unlock")
+	}
+
+	t.Setenv(PassphraseEnv, "")
+	read, err := ReadKeystore(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = read.Unlock([]byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSession(paths, key, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	delete(unlocked, paths.LocalDir())
+	got, err := ReadSecret(paths, paths.InitPriv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("ReadSecret = %q, want %q", got, secret)
+	}
+}
+
+func TestExpiredSessionIsLocked(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv(PassphraseEnv, "")
+	paths := setupTestPaths(t)
+
+	ks, key, err := NewKeystore([]byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteKeystore(paths, ks)
+	UseKey(paths, key)
+	WriteSecret(paths, paths.SigPriv(), []byte("seed"))
+
+	SaveSession(paths, key, -time.Minute)
+	delete(unlocked, paths.LocalDir())
+	if _, err := ReadSecret(paths, paths.SigPriv()); !errors.Is(err, ErrLocked) {
+		t.Errorf("err = %v, want ErrLocked", err)
+	}
+
+	// The expired session is deleted, as are those of other repositories
+	// once any session is read or saved
+	p, _ := sessionFile(paths)
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Error("expired session should be deleted")
+	}
+	other := filepath.Join(filepath.Dir(p), "other.session")
+	os.WriteFile(other, []byte("[session]\nkey = \"\"\nexpires = \"2000-01-01T00:00:00Z\"\n"), 0o600)
+	SaveSession(paths, key, time.Hour)
+	if _, err := os.Stat(other); !os.IsNotExist(err) {
+		t.Error("expired session of another repository should be deleted")
+	}
+}
+
+func TestSessionNeedsRuntimeDir(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	t.Setenv(PassphraseEnv, "")
+	paths := setupTestPaths(t)
+
+	ks, key, err := NewKeystore([]byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteKeystore(paths, ks)
+
+	// No session is written to a persistent directory instead
+	if err := SaveSession(paths, key, time.Hour); !errors.Is(err, ErrNoSessionDir) {
+		t.Fatalf("SaveSession = %v, want ErrNoSessionDir", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(cache, "mlsgit")); len(entries) != 0 {
+		t.Errorf("session written to the cache dir: %v", entries)
+	}
+
+	// and one left there by an older version is deleted, not used
+	name, _ := sessionName(paths)
+	legacy := filepath.Join(cache, "mlsgit", name)
+	os.MkdirAll(filepath.Dir(legacy), 0o700)
+	os.WriteFile(legacy, []byte("[session]\nkey = \"\"\nexpires = \"2999-01-01T00:00:00Z\"\n"), 0o600)
+	if readSession(paths) != nil {
+		t.Error("a session in the cache dir should not be used")
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("a session in the cache dir should be deleted")
+	}
+	if err := ClearSession(paths); err != nil {
+		t.Errorf("ClearSession without a runtime dir: %v", err)
+	}
+}
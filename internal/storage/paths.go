@@ -21,8 +21,15 @@ func (p MLSGitPaths) PendingDir() string          { return filepath.Join(p.MLSGi
 func (p MLSGitPaths) GroupDir() string            { return filepath.Join(p.MLSGitDir(), "group") }
 func (p MLSGitPaths) GroupState() string          { return filepath.Join(p.GroupDir(), "state.b64") }
 func (p MLSGitPaths) WelcomeDir() string          { return filepath.Join(p.GroupDir(), "welcome") }
+func (p MLSGitPaths) HistoryDir() string          { return filepath.Join(p.GroupDir(), "history") }
 func (p MLSGitPaths) EpochKeys() string           { return filepath.Join(p.MLSGitDir(), "epoch_keys.b64") }
 func (p MLSGitPaths) MerkleTOML() string          { return filepath.Join(p.MLSGitDir(), "merkle.toml") }
+func (p MLSGitPaths) PolicyTOML() string          { return filepath.Join(p.MLSGitDir(), "policy.toml") }
+func (p MLSGitPaths) RejectedDir() string         { return filepath.Join(p.MLSGitDir(), "rejected") }
+func (p MLSGitPaths) FormerDir() string           { return filepath.Join(p.MLSGitDir(), "former") }
+func (p MLSGitPaths) RecoveryDir() string         { return filepath.Join(p.MLSGitDir(), "recovery") }
+func (p MLSGitPaths) SharesDir() string           { return filepath.Join(p.MLSGitDir(), "shares") }
+func (p MLSGitPaths) SharesTOML() string          { return filepath.Join(p.SharesDir(), "shares.toml") }
 func (p MLSGitPaths) MLSGitGitattributes() string { return filepath.Join(p.MLSGitDir(), ".gitattributes") }
 
 // -- local (.git/mlsgit/) --
@@ -30,8 +37,15 @@ func (p MLSGitPaths) MLSGitGitattributes() string { return filepath.Join(p.MLSGi
 func (p MLSGitPaths) LocalDir() string     { return filepath.Join(p.Root, ".git", "mlsgit") }
 func (p MLSGitPaths) PrivateKey() string   { return filepath.Join(p.LocalDir(), "private_key.pem") }
 func (p MLSGitPaths) MLSState() string     { return filepath.Join(p.LocalDir(), "mls_state.bin") }
+func (p MLSGitPaths) InitPriv() string     { return filepath.Join(p.LocalDir(), "init_priv.bin") }
+func (p MLSGitPaths) NextInitPriv() string { return filepath.Join(p.LocalDir(), "init_priv.next.bin") }
+func (p MLSGitPaths) SigPriv() string      { return filepath.Join(p.LocalDir(), "sig_priv.bin") }
+func (p MLSGitPaths) KeystoreTOML() string { return filepath.Join(p.LocalDir(), "keystore.toml") }
 func (p MLSGitPaths) IdentityTOML() string { return filepath.Join(p.LocalDir(), "identity.toml") }
 func (p MLSGitPaths) CacheDir() string     { return filepath.Join(p.LocalDir(), "cache") }
+func (p MLSGitPaths) ForkMarker() string   { return filepath.Join(p.LocalDir(), "fork_pending") }
+func (p MLSGitPaths) LocalPolicy() string  { return filepath.Join(p.LocalDir(), "policy.toml") }
+func (p MLSGitPaths) AllowedSigners() string { return filepath.Join(p.LocalDir(), "allowed_signers") }
 
 // -- repo-level files --
 
@@ -60,10 +74,70 @@ func (p MLSGitPaths) PendingRequest(memberID string) string {
 	return filepath.Join(p.PendingDir(), memberID+".request.toml")
 }
 
+func (p MLSGitPaths) DevicesDir(memberID string) string {
+	return filepath.Join(p.MembersDir(), memberID+".devices")
+}
+
+func (p MLSGitPaths) DeviceTOML(memberID, deviceID string) string {
+	return filepath.Join(p.DevicesDir(memberID), deviceID+".toml")
+}
+
+func (p MLSGitPaths) DeviceKeypackage(memberID, deviceID string) string {
+	return filepath.Join(p.DevicesDir(memberID), deviceID+".keypackage.b64")
+}
+
+func (p MLSGitPaths) FormerMemberTOML(memberID string) string {
+	return filepath.Join(p.FormerDir(), memberID+".toml")
+}
+
+func (p MLSGitPaths) RecoveryTOML(memberID string) string {
+	return filepath.Join(p.RecoveryDir(), memberID+".toml")
+}
+
+func (p MLSGitPaths) ShareFile(memberID string) string {
+	return filepath.Join(p.SharesDir(), memberID+".share.b64")
+}
+
+func (p MLSGitPaths) ReleasedSharesDir(forID string) string {
+	return filepath.Join(p.SharesDir(), "released", forID)
+}
+
+func (p MLSGitPaths) ReleasedShare(forID, holderID string) string {
+	return filepath.Join(p.ReleasedSharesDir(forID), holderID+".share.b64")
+}
+
+func (p MLSGitPaths) RejectionTOML(memberID string) string {
+	return filepath.Join(p.RejectedDir(), memberID+".toml")
+}
+
+func (p MLSGitPaths) PendingQuorum() string {
+	return filepath.Join(p.PendingDir(), "quorum.toml")
+}
+
+func (p MLSGitPaths) PendingApprovalsDir(memberID string) string {
+	return filepath.Join(p.PendingDir(), memberID+".approvals")
+}
+
+func (p MLSGitPaths) MemberApprovalsDir(memberID string) string {
+	return filepath.Join(p.MembersDir(), memberID+".approvals")
+}
+
+func (p MLSGitPaths) FormerApprovalsDir(memberID string) string {
+	return filepath.Join(p.FormerDir(), memberID+".approvals")
+}
+
+func (p MLSGitPaths) PendingDevice(memberID, deviceID string) string {
+	return filepath.Join(p.PendingDir(), memberID+"."+deviceID+".device.toml")
+}
+
 func (p MLSGitPaths) WelcomeFile(memberID string) string {
 	return filepath.Join(p.WelcomeDir(), memberID+".welcome.b64")
 }
 
+func (p MLSGitPaths) HistoryGrant(memberID string) string {
+	return filepath.Join(p.HistoryDir(), memberID+".history.b64")
+}
+
 // EnsureDirs creates all required directories (idempotent).
 func (p MLSGitPaths) EnsureDirs() error {
 	dirs := []string{
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Recovery leaves ---
+//
+// A recovery leaf is a member whose X25519 init key is kept offline. It is
+// in members/ like anyone else, so it receives every Welcome and removal
+// encapsulation, and recovery/<id>.toml holds the Welcome that added it,
+// encrypted to the offline key, for its key holder to take part later. The
+// leaf's signing key is generated offline too; records written before that
+// carry it sealed to the offline key.
+
+// RecoveryInfo describes a recovery leaf.
+type RecoveryInfo struct {
+	InitPub    []byte // offline X25519 public key
+	AddedEpoch int
+	AddedBy    string
+	Welcome    []byte // encrypted Welcome from the add
+	SigningKey []byte // older records: Ed25519 seed of the leaf, encrypted to InitPub
+}
+
+// WriteRecovery writes a recovery leaf's record under recovery/.
+func WriteRecovery(paths MLSGitPaths, memberID string, r RecoveryInfo) error {
+	if err := os.MkdirAll(paths.RecoveryDir(), 0o755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("[recovery]\ninit_pub = %q\nadded_epoch = %d\nadded_by = %q\nwelcome = %q\n",
+		crypto.B64Encode(r.InitPub, false), r.AddedEpoch, r.AddedBy, crypto.B64Encode(r.Welcome, false))
+	if len(r.SigningKey) > 0 {
+		content += fmt.Sprintf("signing_key = %q\n", crypto.B64Encode(r.SigningKey, false))
+	}
+	return os.WriteFile(paths.RecoveryTOML(memberID), []byte(content), 0o644)
+}
+
+// ReadRecovery parses a recovery leaf's record.
+func ReadRecovery(paths MLSGitPaths, memberID string) (RecoveryInfo, error) {
+	data, err := os.ReadFile(paths.RecoveryTOML(memberID))
+	if err != nil {
+		return RecoveryInfo{}, err
+	}
+	type recoverySection struct {
+		InitPub    string `toml:"init_pub"`
+		AddedEpoch int    `toml:"added_epoch"`
+		AddedBy    string `toml:"added_by"`
+		Welcome    string `toml:"welcome"`
+		SigningKey string `toml:"signing_key"`
+	}
+	type wrapper struct {
+		Recovery recoverySection `toml:"recovery"`
+	}
+	var w wrapper
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return RecoveryInfo{}, fmt.Errorf("parse recovery TOML: %w", err)
+	}
+	r := RecoveryInfo{AddedEpoch: w.Recovery.AddedEpoch, AddedBy: w.Recovery.AddedBy}
+	for _, f := range []struct {
+		dst  *[]byte
+		src  string
+		name string
+	}{
+		{&r.InitPub, w.Recovery.InitPub, "init_pub"},
+		{&r.Welcome, w.Recovery.Welcome, "welcome"},
+		{&r.SigningKey, w.Recovery.SigningKey, "signing_key"},
+	} {
+		if *f.dst, err = crypto.B64Decode(f.src, false); err != nil {
+			return RecoveryInfo{}, fmt.Errorf("decode recovery %s: %w", f.name, err)
+		}
+	}
+	return r, nil
+}
+
+// IsRecovery reports whether memberID is a recovery leaf.
+func IsRecovery(paths MLSGitPaths, memberID string) bool {
+	_, err := os.Stat(paths.RecoveryTOML(memberID))
+	return err == nil
+}
+
+// ListRecoveryIDs returns the sorted member IDs of recovery leaves.
+func ListRecoveryIDs(paths MLSGitPaths) ([]string, error) {
+	entries, err := os.ReadDir(paths.RecoveryDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".toml") {
+			ids = append(ids, strings.TrimSuffix(e.Name(), ".toml"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
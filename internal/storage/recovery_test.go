@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecoveryRoundtrip(t *testing.T) {
+	paths := setupTestPaths(t)
+
+	want := RecoveryInfo{
+		InitPub:    bytes.Repeat([]byte{1}, 32),
+		AddedEpoch: 2,
+		AddedBy:    "alice",
+		Welcome:    []byte("sealed welcome"),
+		SigningKey: []byte("sealed key"),
+	}
+	if err := WriteRecovery(paths, "rec1", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadRecovery(paths, "rec1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.InitPub, want.InitPub) || got.AddedEpoch != 2 || got.AddedBy != "alice" ||
+		!bytes.Equal(got.Welcome, want.Welcome) || !bytes.Equal(got.SigningKey, want.SigningKey) {
+		t.Errorf("got %+v", got)
+	}
+
+	// Leaves whose signing key was generated offline carry none
+	want.SigningKey = nil
+	if err := WriteRecovery(paths, "rec1", want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadRecovery(paths, "rec1"); err != nil || len(got.SigningKey) != 0 {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	if !IsRecovery(paths, "rec1") || IsRecovery(paths, "alice") {
+		t.Error("IsRecovery should only match rec1")
+	}
+	if ids, _ := ListRecoveryIDs(paths); len(ids) != 1 || ids[0] != "rec1" {
+		t.Errorf("ListRecoveryIDs = %v", ids)
+	}
+}
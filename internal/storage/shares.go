@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/germtb/mlsgit/internal/crypto"
+)
+
+// --- Backup shares ---
+//
+// shares/shares.toml records the threshold and the epoch the shares were
+// last split at. shares/<key>.share.b64 holds one member's Shamir share,
+// encrypted to the init key of one of their devices (every device of a
+// member holds the same share). shares/released/<id>/<holder>.share.b64
+// holds a share its holder re-encrypted for a recovering identity.
+
+// ShareConfig records how the backup shares are split.
+type ShareConfig struct {
+	Threshold int
+	Holders   int
+	Epoch     int
+}
+
+// WriteShareConfig writes shares/shares.toml.
+func WriteShareConfig(paths MLSGitPaths, cfg ShareConfig) error {
+	if err := os.MkdirAll(paths.SharesDir(), 0o755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("[shares]\nthreshold = %d\nholders = %d\nepoch = %d\n",
+		cfg.Threshold, cfg.Holders, cfg.Epoch)
+	return os.WriteFile(paths.SharesTOML(), []byte(content), 0o644)
+}
+
+// ReadShareConfig parses shares/shares.toml. Returns nil if backup shares
+// are not set up.
+func ReadShareConfig(paths MLSGitPaths) (*ShareConfig, error) {
+	data, err := os.ReadFile(paths.SharesTOML())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	type wrapper struct {
+		Shares struct {
+			Threshold int `toml:"threshold"`
+			Holders   int `toml:"holders"`
+			Epoch     int `toml:"epoch"`
+		} `toml:"shares"`
+	}
+	var w wrapper
+	if _, err := toml.Decode(string(data), &w); err != nil {
+		return nil, fmt.Errorf("parse shares TOML: %w", err)
+	}
+	return &ShareConfig{Threshold: w.Shares.Threshold, Holders: w.Shares.Holders, Epoch: w.Shares.Epoch}, nil
+}
+
+// WriteShare writes the encrypted share for a member or device.
+func WriteShare(paths MLSGitPaths, key string, share []byte) error {
+	if err := os.MkdirAll(paths.SharesDir(), 0o755); err != nil {
+		return err
+	}
+	return writeB64(paths.ShareFile(key), share)
+}
+
+// ReadShare reads the encrypted share for a member or device.
+func ReadShare(paths MLSGitPaths, key string) ([]byte, error) {
+	return readB64(paths.ShareFile(key))
+}
+
+// ClearShares removes every share and released share, keeping shares.toml.
+func ClearShares(paths MLSGitPaths) error {
+	entries, err := os.ReadDir(paths.SharesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == filepath.Base(paths.SharesTOML()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(paths.SharesDir(), e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteReleasedShare writes a share holderID re-encrypted for forID.
+func WriteReleasedShare(paths MLSGitPaths, forID, holderID string, share []byte) error {
+	if err := os.MkdirAll(paths.ReleasedSharesDir(forID), 0o755); err != nil {
+		return err
+	}
+	return writeB64(paths.ReleasedShare(forID, holderID), share)
+}
+
+// ReadReleasedShares returns the shares released to forID, keyed by holder.
+func ReadReleasedShares(paths MLSGitPaths, forID string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(paths.ReleasedSharesDir(forID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	shares := make(map[string][]byte)
+	for _, e := range entries {
+		holder, ok := strings.CutSuffix(e.Name(), ".share.b64")
+		if !ok {
+			continue
+		}
+		if shares[holder], err = readB64(paths.ReleasedShare(forID, holder)); err != nil {
+			return nil, fmt.Errorf("read share released by '%s': %w", holder, err)
+		}
+	}
+	return shares, nil
+}
+
+func writeB64(path string, data []byte) error {
+	return os.WriteFile(path, []byte(crypto.B64Encode(data, false)), 0o644)
+}
+
+func readB64(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.B64Decode(strings.TrimSpace(string(data)), false)
+}
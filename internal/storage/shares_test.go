@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSharesRoundtrip(t *testing.T) {
+	paths := setupTestPaths(t)
+
+	if cfg, err := ReadShareConfig(paths); err != nil || cfg != nil {
+		t.Fatalf("ReadShareConfig before setup = %v, %v", cfg, err)
+	}
+	if err := WriteShareConfig(paths, ShareConfig{Threshold: 2, Holders: 3, Epoch: 5}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadShareConfig(paths)
+	if err != nil || cfg == nil || *cfg != (ShareConfig{Threshold: 2, Holders: 3, Epoch: 5}) {
+		t.Fatalf("ReadShareConfig = %+v, %v", cfg, err)
+	}
+
+	if err := WriteShare(paths, "alice", []byte("share a")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadShare(paths, "alice"); err != nil || !bytes.Equal(got, []byte("share a")) {
+		t.Errorf("ReadShare = %q, %v", got, err)
+	}
+	if err := WriteReleasedShare(paths, "dave", "alice", []byte("for dave")); err != nil {
+		t.Fatal(err)
+	}
+	released, err := ReadReleasedShares(paths, "dave")
+	if err != nil || len(released) != 1 || !bytes.Equal(released["alice"], []byte("for dave")) {
+		t.Errorf("ReadReleasedShares = %v, %v", released, err)
+	}
+
+	if err := ClearShares(paths); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadShare(paths, "alice"); !os.IsNotExist(err) {
+		t.Errorf("share survived ClearShares: %v", err)
+	}
+	if released, _ := ReadReleasedShares(paths, "dave"); len(released) != 0 {
+		t.Errorf("released shares survived ClearShares: %v", released)
+	}
+	if cfg, _ := ReadShareConfig(paths); cfg == nil {
+		t.Error("ClearShares removed shares.toml")
+	}
+}
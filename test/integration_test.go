@@ -1,19 +1,23 @@
 package test
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/germtb/mlsgit/internal/config"
 	"github.com/germtb/mlsgit/internal/crypto"
 	"github.com/germtb/mlsgit/internal/delta"
 	"github.com/germtb/mlsgit/internal/mls"
 	"github.com/germtb/mlsgit/internal/storage"
+	"golang.org/x/crypto/ssh"
 )
 
 var mlsgitBinary string
@@ -72,6 +76,11 @@ func makeEnv(t *testing.T) []string {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { os.RemoveAll(tmpHome) })
+	// Unlock sessions are only kept in a per-login runtime directory
+	runtimeDir := filepath.Join(tmpHome, "run")
+	if err := os.Mkdir(runtimeDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
 
 	env := []string{
 		"GIT_AUTHOR_NAME=Test",
@@ -79,6 +88,7 @@ func makeEnv(t *testing.T) []string {
 		"GIT_COMMITTER_NAME=Test",
 		"GIT_COMMITTER_EMAIL=t@t",
 		"HOME=" + tmpHome,
+		"XDG_RUNTIME_DIR=" + runtimeDir,
 		"GIT_CONFIG_GLOBAL=/dev/null",
 		"GIT_CONFIG_SYSTEM=/dev/null",
 		"GOMODCACHE=/tmp/gomod",
@@ -124,6 +134,17 @@ func mlsgitCmd(t *testing.T, repo string, args ...string) string {
 	return string(out)
 }
 
+// mlsgitCmdInput runs mlsgit with input on stdin, e.g. a passphrase.
+func mlsgitCmdInput(t *testing.T, repo, input string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command(mlsgitBinary, args...)
+	cmd.Dir = repo
+	cmd.Env = makeEnv(t)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
 func initMLSGitRepo(t *testing.T, name string) string {
 	t.Helper()
 	repo := t.TempDir()
@@ -162,13 +183,15 @@ func gitBlob(t *testing.T, repo, ref, path string) string {
 	return git(t, repo, "show", ref+":"+path)
 }
 
-func addFakeMember(t *testing.T, repo, memberName string) string {
+// writeJoinRequest writes a signed pending request for a new member and
+// returns the member ID and key fingerprint.
+func writeJoinRequest(t *testing.T, repo, memberName string) (string, string) {
 	t.Helper()
 	paths := storage.MLSGitPaths{Root: repo}
 
 	// Generate keys and create pending request
 	mlsKeys, _ := mls.GenerateMLSKeys()
-	_, sigPub, _ := crypto.GenerateKeypair()
+	sigPriv, sigPub, _ := crypto.GenerateKeypair()
 	pubPEM, _ := crypto.PublicKeyToPEM(sigPub)
 
 	memberID := memberName + "-test-id"
@@ -176,7 +199,24 @@ func addFakeMember(t *testing.T, repo, memberName string) string {
 	kpBytes, _ := json.Marshal(kp)
 	kpB64 := crypto.B64Encode(kpBytes, false)
 
-	storage.WritePendingRequest(paths, memberID, memberName, pubPEM, kpB64)
+	req := storage.PendingRequestInfo{
+		MemberID:   memberID,
+		Name:       memberName,
+		PublicKey:  pubPEM,
+		Keypackage: kpB64,
+		Timestamp:  time.Now().Unix(),
+	}
+	req.Signature = crypto.Sign(sigPriv, storage.JoinRequestBytes(req))
+	req.MLSSignature = crypto.Sign(mlsKeys.SigPriv, storage.JoinRequestBytes(req))
+	storage.WritePendingRequest(paths, req)
+
+	fp, _ := crypto.PublicKeyFingerprint(sigPub)
+	return memberID, fp
+}
+
+func addFakeMember(t *testing.T, repo, memberName string) string {
+	t.Helper()
+	memberID, _ := writeJoinRequest(t, repo, memberName)
 
 	// Approve via CLI
 	mlsgitCmd(t, repo, "add", memberID)
@@ -329,6 +369,69 @@ func TestLargeFile(t *testing.T) {
 	}
 }
 
+func TestStreamedLargeFile(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	mlsgitCmd(t, repo, "config", "set", "stream_threshold", "65536")
+
+	content := strings.Repeat("0123456789abcdef\x00", 20000)
+	writeFile(t, repo, "data.bin", content)
+	git(t, repo, "add", "data.bin")
+	git(t, repo, "commit", "-m", "streamed file")
+
+	if blob := gitBlob(t, repo, "HEAD", "data.bin"); !strings.HasPrefix(blob, "MLSGIT-STREAM 1\n") {
+		t.Fatalf("large file should be committed as a streamed record, got %.40q", blob)
+	}
+	if out := git(t, repo, "status", "--porcelain"); strings.Contains(out, "data.bin") {
+		t.Errorf("streamed file should not show as modified:\n%s", out)
+	}
+
+	os.Remove(filepath.Join(repo, "data.bin"))
+	git(t, repo, "checkout", "--", "data.bin")
+	if got := readFile(t, repo, "data.bin"); got != content {
+		t.Error("streamed file content mismatch")
+	}
+}
+
+func TestMigrateFormat(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	writeFile(t, repo, "a.txt", "first\n")
+	git(t, repo, "add", "a.txt")
+	git(t, repo, "commit", "-m", "text chain")
+	writeFile(t, repo, "a.txt", "first\nsecond\n")
+	git(t, repo, "commit", "-am", "text delta")
+
+	out := mlsgitCmd(t, repo, "migrate-format", "--to", "armored")
+	if !strings.Contains(out, "Converted 1 of 1 file(s) to the armored format") {
+		t.Fatalf("unexpected migrate output:\n%s", out)
+	}
+	if !strings.Contains(out, "mlsgit config set ciphertext_format armored") {
+		t.Errorf("migrate should suggest switching the configured format:\n%s", out)
+	}
+	git(t, repo, "commit", "-m", "armored")
+	if blob := gitBlob(t, repo, "HEAD", "a.txt"); !strings.HasPrefix(blob, "-----BEGIN MLSGIT CIPHERTEXT-----") {
+		t.Fatalf("a.txt should be armored, got %.40q", blob)
+	}
+	if status := git(t, repo, "status", "--porcelain"); strings.Contains(status, "a.txt") {
+		t.Errorf("converted file should not show as modified:\n%s", status)
+	}
+
+	// Edits append to the armored chain, and checkout still decrypts it
+	writeFile(t, repo, "a.txt", "first\nsecond\nthird\n")
+	git(t, repo, "commit", "-am", "armored delta")
+	if blob := gitBlob(t, repo, "HEAD", "a.txt"); !strings.HasPrefix(blob, "-----BEGIN MLSGIT CIPHERTEXT-----") {
+		t.Errorf("delta should keep the chain armored")
+	}
+	os.Remove(filepath.Join(repo, "a.txt"))
+	git(t, repo, "checkout", "--", "a.txt")
+	if got := readFile(t, repo, "a.txt"); got != "first\nsecond\nthird\n" {
+		t.Errorf("a.txt = %q", got)
+	}
+
+	if out := mlsgitCmd(t, repo, "migrate-format", "--to", "armored"); !strings.Contains(out, "already in the armored format") {
+		t.Errorf("second migrate should be a no-op:\n%s", out)
+	}
+}
+
 func TestMultipleSequentialEdits(t *testing.T) {
 	repo := initMLSGitRepo(t, "alice")
 
@@ -509,6 +612,36 @@ func TestFileRemoveRecreate(t *testing.T) {
 	}
 }
 
+func TestMoveFile(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+
+	writeFile(t, repo, "a.txt", "moved content\n")
+	writeFile(t, repo, "c.txt", "copied chain\n")
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "-m", "add files")
+
+	// mlsgit mv re-encrypts the chain for its new path
+	mlsgitCmd(t, repo, "mv", "a.txt", "b.txt")
+	git(t, repo, "commit", "-m", "move file")
+	if from, err := delta.ChainPath(gitBlob(t, repo, "HEAD", "b.txt")); err != nil || from != "b.txt" {
+		t.Errorf("moved chain written for %q, %v; want b.txt", from, err)
+	}
+	os.Remove(filepath.Join(repo, "b.txt"))
+	git(t, repo, "checkout", "--", "b.txt")
+	if got := readFile(t, repo, "b.txt"); got != "moved content\n" {
+		t.Errorf("after move: %q, want %q", got, "moved content\n")
+	}
+
+	// A chain moved as it is, as a server could, does not decrypt at its
+	// new path
+	git(t, repo, "mv", "c.txt", "d.txt")
+	git(t, repo, "commit", "-m", "move chain as is")
+	os.Remove(filepath.Join(repo, "d.txt"))
+	if out, err := gitNoCheck(t, repo, "checkout", "--", "d.txt"); err == nil || !strings.Contains(out, `written for "c.txt"`) {
+		t.Errorf("moved chain checked out: %v\n%s", err, out)
+	}
+}
+
 func TestCacheWipeReAdd(t *testing.T) {
 	repo := initMLSGitRepo(t, "alice")
 
@@ -566,3 +699,283 @@ func TestReviewNoPending(t *testing.T) {
 		t.Errorf("review should show no pending: %s", out)
 	}
 }
+
+func TestUpdateRefreshesKeys(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	writeFile(t, repo, "before.txt", "written before update\n")
+	git(t, repo, "add", "before.txt")
+	git(t, repo, "commit", "-m", "before update")
+
+	paths := storage.MLSGitPaths{Root: repo}
+	memberID, _, _ := storage.ReadIdentity(paths)
+	kpBefore := readFile(t, repo, ".mlsgit/members/"+memberID+".keypackage.b64")
+	oldInitPriv, _ := os.ReadFile(paths.InitPriv())
+
+	out := mlsgitCmd(t, repo, "update")
+	if !strings.Contains(out, "MLS epoch advanced: 0 -> 1") {
+		t.Errorf("update should advance the epoch: %s", out)
+	}
+	if kpAfter := readFile(t, repo, ".mlsgit/members/"+memberID+".keypackage.b64"); kpAfter == kpBefore {
+		t.Error("update should publish a new keypackage")
+	}
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "-m", "update keys")
+
+	// An update interrupted after saving the group state leaves the new
+	// init key beside the old one; the next filter run moves it into place
+	newInitPriv, _ := os.ReadFile(paths.InitPriv())
+	os.WriteFile(paths.NextInitPriv(), newInitPriv, 0o600)
+	os.WriteFile(paths.InitPriv(), oldInitPriv, 0o600)
+
+	writeFile(t, repo, "after.txt", "written after update\n")
+	git(t, repo, "add", "after.txt")
+	git(t, repo, "commit", "-m", "after update")
+	if got, _ := os.ReadFile(paths.InitPriv()); string(got) != string(newInitPriv) {
+		t.Error("the interrupted update's init key should be installed")
+	}
+	if _, err := os.Stat(paths.NextInitPriv()); !os.IsNotExist(err) {
+		t.Error("the pending init key should be gone")
+	}
+
+	for path, want := range map[string]string{
+		"before.txt": "written before update\n",
+		"after.txt":  "written after update\n",
+	} {
+		os.Remove(filepath.Join(repo, path))
+		git(t, repo, "checkout", "--", path)
+		if got := readFile(t, repo, path); got != want {
+			t.Errorf("%s after update: %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLsMarksStaleKeys(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	paths := storage.MLSGitPaths{Root: repo}
+	memberID, _, _ := storage.ReadIdentity(paths)
+
+	info, _ := storage.ReadMemberTOML(paths.MemberTOML(memberID))
+	info.KeysUpdated = time.Now().Add(-60 * 24 * time.Hour).Unix()
+	storage.WriteMemberInfo(paths, memberID, info)
+
+	if out := mlsgitCmd(t, repo, "ls"); strings.Contains(out, "stale") {
+		t.Errorf("ls should not flag keys without a rotation interval: %s", out)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.RotationInterval = 30
+	os.WriteFile(paths.ConfigTOML(), []byte(cfg.ToTOML()), 0o644)
+	if out := mlsgitCmd(t, repo, "ls"); !strings.Contains(out, "[keys stale]") {
+		t.Errorf("ls should flag stale keys: %s", out)
+	}
+
+	// Members recorded before refreshes were tracked count from when they
+	// were added, not from the epoch of Unix time
+	info.KeysUpdated = 0
+	storage.WriteMemberInfo(paths, memberID, info)
+	if out := mlsgitCmd(t, repo, "ls"); strings.Contains(out, "stale") {
+		t.Errorf("ls should not flag a member added just now: %s", out)
+	}
+
+	mlsgitCmd(t, repo, "update")
+	if out := mlsgitCmd(t, repo, "ls"); strings.Contains(out, "stale") {
+		t.Errorf("ls should not flag keys after update: %s", out)
+	}
+}
+
+func TestAddRejectsForgedRequest(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	paths := storage.MLSGitPaths{Root: repo}
+
+	// Mallory claims someone else's public key but can only sign with her own
+	_, victimPub, _ := crypto.GenerateKeypair()
+	victimPEM, _ := crypto.PublicKeyToPEM(victimPub)
+	malloryPriv, _, _ := crypto.GenerateKeypair()
+	mlsKeys, _ := mls.GenerateMLSKeys()
+	kpBytes, _ := json.Marshal(mls.BuildKeyPackage([]byte("victim"), mlsKeys))
+
+	req := storage.PendingRequestInfo{
+		MemberID:   "victim-test-id",
+		Name:       "victim",
+		PublicKey:  victimPEM,
+		Keypackage: crypto.B64Encode(kpBytes, false),
+		Timestamp:  time.Now().Unix(),
+	}
+	req.Signature = crypto.Sign(malloryPriv, storage.JoinRequestBytes(req))
+	req.MLSSignature = crypto.Sign(mlsKeys.SigPriv, storage.JoinRequestBytes(req))
+	storage.WritePendingRequest(paths, req)
+
+	out := mlsgitCmdExpectError(t, repo, "add", "victim-test-id")
+	if !strings.Contains(out, "not signed by its public key") {
+		t.Errorf("add should reject a request not signed by its key, got:\n%s", out)
+	}
+	if _, err := os.Stat(paths.MemberTOML("victim-test-id")); !os.IsNotExist(err) {
+		t.Error("forged request should not create a member")
+	}
+}
+
+func TestAddExpectFingerprint(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	memberID, fp := writeJoinRequest(t, repo, "bob")
+
+	out := mlsgitCmdExpectError(t, repo, "add", memberID, "--expect-fingerprint", "0000111122223333")
+	if !strings.Contains(out, "fingerprint mismatch") {
+		t.Errorf("add should refuse a mismatched fingerprint, got:\n%s", out)
+	}
+
+	// Fingerprints compare case- and separator-insensitively
+	spaced := strings.ToUpper(fp[:8]) + " " + fp[8:]
+	out = mlsgitCmd(t, repo, "add", memberID, "--expect-fingerprint", spaced)
+	if !strings.Contains(out, "added to the group") {
+		t.Errorf("add with the right fingerprint should succeed:\n%s", out)
+	}
+}
+
+func TestInitPostQuantum(t *testing.T) {
+	repo := t.TempDir()
+	git(t, repo, "init")
+	mlsgitCmd(t, repo, "init", "--name", "alice", "--post-quantum")
+
+	if cfg := readFile(t, repo, ".mlsgit/config.toml"); !strings.Contains(cfg, "cipher_suite = 61441") {
+		t.Errorf("config should select the hybrid suite:\n%s", cfg)
+	}
+	if out := mlsgitCmd(t, repo, "ls"); strings.Contains(out, "[no post-quantum key]") {
+		t.Errorf("creator should start with a hybrid init key:\n%s", out)
+	}
+
+	// A request made with X25519-only keys is still accepted, and flagged
+	memberID, _ := writeJoinRequest(t, repo, "bob")
+	mlsgitCmd(t, repo, "add", memberID)
+	if out := mlsgitCmd(t, repo, "ls"); !strings.Contains(out, "[no post-quantum key]") {
+		t.Errorf("X25519-only member should be flagged:\n%s", out)
+	}
+}
+
+func TestPassphraseProtectsLocalKeys(t *testing.T) {
+	repo := initMLSGitRepo(t, "alice")
+	writeFile(t, repo, "secret.txt", "v1\n")
+	git(t, repo, "add", "secret.txt")
+	git(t, repo, "commit", "-m", "add secret")
+
+	if out, err := mlsgitCmdInput(t, repo, "hunter2\n", "passwd"); err != nil {
+		t.Fatalf("passwd failed: %v\n%s", err, out)
+	}
+	for _, f := range []string{"private_key.pem", "mls_state.bin", "init_priv.bin"} {
+		if !strings.HasPrefix(readFile(t, repo, ".git/mlsgit/"+f), "mlsgit-sealed-v1") {
+			t.Errorf("%s should be sealed", f)
+		}
+	}
+
+	// The session opened by passwd lets the filters run
+	writeFile(t, repo, "secret.txt", "v2\n")
+	git(t, repo, "add", "secret.txt")
+	git(t, repo, "commit", "-m", "edit while unlocked")
+
+	mlsgitCmd(t, repo, "lock")
+	writeFile(t, repo, "secret.txt", "v3\n")
+	if out, err := gitNoCheck(t, repo, "add", "secret.txt"); err == nil || !strings.Contains(out, "locked") {
+		t.Errorf("clean filter should fail while locked: %v\n%s", err, out)
+	}
+
+	if _, err := mlsgitCmdInput(t, repo, "wrong\n", "unlock"); err == nil {
+		t.Error("unlock with the wrong passphrase should fail")
+	}
+	if out, err := mlsgitCmdInput(t, repo, "hunter2\n", "unlock"); err != nil {
+		t.Fatalf("unlock failed: %v\n%s", err, out)
+	}
+	git(t, repo, "add", "secret.txt")
+	git(t, repo, "commit", "-m", "edit after unlock")
+
+	mlsgitCmd(t, repo, "passwd", "--remove")
+	if !strings.Contains(readFile(t, repo, ".git/mlsgit/private_key.pem"), "PRIVATE KEY") {
+		t.Error("private key should be unsealed after --remove")
+	}
+	mlsgitCmd(t, repo, "lock")
+	os.Remove(filepath.Join(repo, "secret.txt"))
+	git(t, repo, "checkout", "secret.txt")
+	if got := readFile(t, repo, "secret.txt"); got != "v3\n" {
+		t.Errorf("secret.txt = %q, want v3", got)
+	}
+}
+
+func TestInitWithEncryptedSSHKeySealsLocalKeys(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "alice@laptop", []byte("ssh secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600)
+
+	repo := t.TempDir()
+	git(t, repo, "init")
+	git(t, repo, "config", "user.email", "test@test.com")
+	git(t, repo, "config", "user.name", "Test User")
+	out, err := mlsgitCmdInput(t, repo, "ssh secret\n", "init", "--name", "alice", "--ssh-key", keyPath)
+	if err != nil {
+		t.Fatalf("init: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "WARNING") {
+		t.Errorf("init should warn that local keys are sealed:\n%s", out)
+	}
+
+	// The decrypted SSH key is never written in the clear
+	if _, err := os.Stat(filepath.Join(repo, ".git", "mlsgit", "keystore.toml")); err != nil {
+		t.Fatalf("a keystore should be created: %v", err)
+	}
+	if data := readFile(t, repo, ".git/mlsgit/private_key.pem"); strings.Contains(data, "PRIVATE KEY") {
+		t.Error("the imported key should be sealed")
+	}
+
+	// The session stays unlocked, so the filters keep working
+	writeFile(t, repo, "secret.txt", "sealed keys\n")
+	git(t, repo, "add", "secret.txt")
+	if staged := git(t, repo, "show", ":secret.txt"); strings.Contains(staged, "sealed keys") {
+		t.Error("secret.txt should be encrypted")
+	}
+}
+
+func TestInitWithSSHKey(t *testing.T) {
+	// An existing OpenSSH key, as in ~/.ssh/id_ed25519
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	block, err := ssh.MarshalPrivateKey(priv, "alice@laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir := t.TempDir()
+	keyPath := filepath.Join(keyDir, "id_ed25519")
+	os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600)
+	authorized, _ := crypto.SSHAuthorizedKey(pub)
+	os.WriteFile(keyPath+".pub", []byte(authorized+" alice@laptop\n"), 0o644)
+
+	repo := t.TempDir()
+	git(t, repo, "init")
+	out := mlsgitCmd(t, repo, "init", "--name", "alice", "--ssh-key", keyPath+".pub")
+	fp, _ := crypto.SSHFingerprint(pub)
+	if !strings.Contains(out, fp) {
+		t.Errorf("init should print the SSH fingerprint %s:\n%s", fp, out)
+	}
+	memberID := getMemberID(t, repo)
+	info, _ := storage.ReadMemberTOML(filepath.Join(repo, ".mlsgit", "members", memberID+".toml"))
+	if got, _ := crypto.LoadPublicKey(info.PublicKey); !got.Equal(pub) {
+		t.Error("member key should be the imported SSH key")
+	}
+
+	mlsgitCmd(t, repo, "allowed-signers")
+	signers := readFile(t, repo, ".git/mlsgit/allowed_signers")
+	if !strings.Contains(signers, memberID+` namespaces="git" `+authorized) {
+		t.Errorf("allowed signers should list alice's key:\n%s", signers)
+	}
+
+	// git verify-commit trusts a commit signed with the same SSH key
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	git(t, repo, "config", "gpg.format", "ssh")
+	git(t, repo, "config", "user.signingkey", keyPath+".pub")
+	git(t, repo, "add", ".mlsgit/", ".gitattributes", ".gitignore")
+	git(t, repo, "commit", "-S", "-m", "init mlsgit")
+	if out := git(t, repo, "verify-commit", "HEAD"); !strings.Contains(out, "Good") {
+		t.Errorf("verify-commit:\n%s", out)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/germtb/mlsgit/internal/delta"
 	"github.com/germtb/mlsgit/internal/storage"
 )
 
@@ -299,6 +301,452 @@ func TestMemberRemoval(t *testing.T) {
 	}
 }
 
+func TestUpdateSyncsToOtherMember(t *testing.T) {
+	_, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+
+	// Bob refreshes his keys and pushes the new epoch
+	mlsgitCmd(t, bobRepo, "update")
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "update keys: bob")
+	git(t, bobRepo, "push", "origin", "master")
+
+	// Alice pulls and writes a file under the new epoch
+	git(t, aliceRepo, "pull", "--no-edit")
+	writeFile(t, aliceRepo, "post-update.txt", "after bob's update\n")
+	git(t, aliceRepo, "add", "post-update.txt")
+	git(t, aliceRepo, "commit", "-m", "add post-update file")
+	git(t, aliceRepo, "push")
+
+	// Bob can read it with his rotated keys
+	git(t, bobRepo, "pull", "--no-edit")
+	got := readFile(t, bobRepo, "post-update.txt")
+	if got != "after bob's update\n" {
+		t.Errorf("bob reads post-update file: %q, want %q", got, "after bob's update\n")
+	}
+}
+
+func TestMigrateToPostQuantum(t *testing.T) {
+	_, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+
+	// Alice switches the group to the hybrid KEM
+	cfgPath := filepath.Join(aliceRepo, ".mlsgit", "config.toml")
+	cfg := strings.Replace(readFile(t, aliceRepo, ".mlsgit/config.toml"), "cipher_suite = 1", "cipher_suite = 61441", 1)
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := mlsgitCmd(t, aliceRepo, "ls")
+	if strings.Count(out, "[no post-quantum key]") != 2 {
+		t.Errorf("both members should be flagged before migrating:\n%s", out)
+	}
+
+	// Each member migrates by refreshing their keys
+	mlsgitCmd(t, aliceRepo, "update")
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "switch to post-quantum KEM")
+	git(t, aliceRepo, "push")
+
+	git(t, bobRepo, "pull", "--no-edit")
+	mlsgitCmd(t, bobRepo, "update")
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "update keys: bob")
+	git(t, bobRepo, "push", "origin", "master")
+
+	git(t, aliceRepo, "pull", "--no-edit")
+	if out := mlsgitCmd(t, aliceRepo, "ls"); strings.Contains(out, "[no post-quantum key]") {
+		t.Errorf("no member should be flagged after migrating:\n%s", out)
+	}
+
+	// Files written after the migration still round-trip
+	writeFile(t, aliceRepo, "pq.txt", "after migration\n")
+	git(t, aliceRepo, "add", "pq.txt")
+	git(t, aliceRepo, "commit", "-m", "add pq file")
+	git(t, aliceRepo, "push")
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "pq.txt"); got != "after migration\n" {
+		t.Errorf("bob reads pq.txt: %q", got)
+	}
+}
+
+func TestSwitchCipherSuite(t *testing.T) {
+	_, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+	writeFile(t, aliceRepo, "notes.txt", "written under aes\n")
+	git(t, aliceRepo, "add", "notes.txt")
+	git(t, aliceRepo, "commit", "-m", "add notes")
+
+	out := mlsgitCmd(t, aliceRepo, "config", "set", "cipher_suite", "x25519-chacha20poly1305")
+	if !strings.Contains(out, "git add .mlsgit/config.toml") {
+		t.Errorf("config set should suggest committing the config:\n%s", out)
+	}
+	if out := mlsgitCmd(t, aliceRepo, "config"); !strings.Contains(out, "x25519-chacha20poly1305") {
+		t.Errorf("config should show the new suite:\n%s", out)
+	}
+	mlsgitCmdExpectError(t, aliceRepo, "config", "set", "cipher_suite", "rot13")
+
+	// New writes use the new suite on top of the existing AES-GCM chain
+	writeFile(t, aliceRepo, "notes.txt", "written under aes\nand under chacha\n")
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "switch to chacha20-poly1305")
+	git(t, aliceRepo, "push")
+
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "notes.txt"); got != "written under aes\nand under chacha\n" {
+		t.Errorf("bob reads notes.txt: %q", got)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	bare, aliceRepo, bobRepo, _, bobID := setupTwoUsers(t, nil)
+
+	// Bob backs up his keys under a recovery key
+	bundle := filepath.Join(t.TempDir(), "bob.bundle")
+	out := mlsgitCmd(t, bobRepo, "backup", "--out", bundle, "--recovery-key")
+	lines := strings.Split(out, "\n")
+	var recoveryKey string
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Recovery key") && i+1 < len(lines) {
+			recoveryKey = strings.TrimSpace(lines[i+1])
+		}
+	}
+	if recoveryKey == "" {
+		t.Fatalf("backup should print a recovery key:\n%s", out)
+	}
+
+	// The group moves on while Bob's disk is lost
+	mlsgitCmd(t, aliceRepo, "update")
+	writeFile(t, aliceRepo, "later.txt", "written after the backup\n")
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "update keys and add file")
+	git(t, aliceRepo, "push")
+
+	// Bob restores into a fresh clone and catches up
+	restored := filepath.Join(t.TempDir(), "bob2")
+	gitClone(t, bare, restored)
+	git(t, restored, "config", "pull.rebase", "false")
+	if out, err := mlsgitCmdInput(t, restored, "wrong\n", "restore", bundle); err == nil {
+		t.Fatalf("restore with a wrong recovery key should fail:\n%s", out)
+	}
+	if out, err := mlsgitCmdInput(t, restored, recoveryKey+"\n", "restore", bundle); err != nil {
+		t.Fatalf("restore failed: %v\n%s", err, out)
+	}
+	if got := getMemberID(t, restored); got != bobID {
+		t.Errorf("restored member ID = %q, want %q", got, bobID)
+	}
+	if got := readFile(t, restored, "later.txt"); got != "written after the backup\n" {
+		t.Errorf("restored clone reads later.txt: %q", got)
+	}
+
+	// and keeps writing as the same member
+	writeFile(t, restored, "back.txt", "bob is back\n")
+	git(t, restored, "add", "back.txt")
+	git(t, restored, "commit", "-m", "bob is back")
+	git(t, restored, "push")
+	git(t, aliceRepo, "pull", "--no-edit")
+	if got := readFile(t, aliceRepo, "back.txt"); got != "bob is back\n" {
+		t.Errorf("alice reads back.txt: %q", got)
+	}
+}
+
+func TestConcurrentUpdatesResolve(t *testing.T) {
+	_, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+
+	// Alice refreshes her keys and pushes a file under the new epoch
+	mlsgitCmd(t, aliceRepo, "update")
+	writeFile(t, aliceRepo, "alice.txt", "from alice\n")
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "update keys: alice")
+	git(t, aliceRepo, "push")
+
+	// Meanwhile bob refreshes his keys from the same epoch
+	mlsgitCmd(t, bobRepo, "update")
+	writeFile(t, bobRepo, "bob.txt", "from bob\n")
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "update keys: bob")
+
+	// Pulling reports the fork as a conflict in the group state
+	if out, err := gitNoCheck(t, bobRepo, "pull", "--no-edit"); err == nil {
+		t.Fatalf("pull of a forked history should conflict:\n%s", out)
+	}
+	out := mlsgitCmd(t, bobRepo, "resolve")
+	if !strings.Contains(out, "forked at epoch") {
+		t.Errorf("resolve output should describe the fork:\n%s", out)
+	}
+	if got := readFile(t, bobRepo, "alice.txt"); got != "from alice\n" {
+		t.Errorf("bob reads alice.txt after resolve: %q", got)
+	}
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "--no-edit")
+	git(t, bobRepo, "push", "origin", "master")
+
+	// Alice follows the rebased history and reads bob's file
+	git(t, aliceRepo, "pull", "--no-edit")
+	if got := readFile(t, aliceRepo, "bob.txt"); got != "from bob\n" {
+		t.Errorf("alice reads bob.txt: %q", got)
+	}
+	aliceEpoch := readFile(t, aliceRepo, ".mlsgit/epoch.toml")
+	bobEpoch := readFile(t, bobRepo, ".mlsgit/epoch.toml")
+	if aliceEpoch != bobEpoch {
+		t.Errorf("epochs differ after resolve: alice %q, bob %q", aliceEpoch, bobEpoch)
+	}
+
+	// Both sides keep working on the converged epoch
+	writeFile(t, aliceRepo, "after.txt", "converged\n")
+	git(t, aliceRepo, "add", "after.txt")
+	git(t, aliceRepo, "commit", "-m", "after resolve")
+	git(t, aliceRepo, "push")
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "after.txt"); got != "converged\n" {
+		t.Errorf("bob reads after.txt: %q", got)
+	}
+}
+
+// linkDevice clones the remote as a new device of the member owning
+// ownerRepo and runs the approval flow. Returns the device repo and its ID.
+func linkDevice(t *testing.T, bare, ownerRepo, memberID, deviceName string) (string, string) {
+	t.Helper()
+	deviceRepo := filepath.Join(t.TempDir(), deviceName)
+	gitClone(t, bare, deviceRepo)
+	git(t, deviceRepo, "config", "user.email", deviceName+"@test.com")
+	git(t, deviceRepo, "config", "user.name", deviceName)
+	git(t, deviceRepo, "config", "pull.rebase", "false")
+
+	mlsgitCmd(t, deviceRepo, "join", "--device-of", memberID, "--name", deviceName)
+	deviceID, err := storage.ReadDeviceID(storage.MLSGitPaths{Root: deviceRepo})
+	if err != nil || deviceID == "" {
+		t.Fatalf("read device ID: %q, %v", deviceID, err)
+	}
+	branch := "welcome/" + memberID + "." + deviceID
+	git(t, deviceRepo, "add", ".mlsgit/pending/")
+	git(t, deviceRepo, "commit", "-m", "request device: "+deviceName)
+	git(t, deviceRepo, "push", "-u", "origin", branch)
+
+	git(t, ownerRepo, "fetch", "origin")
+	git(t, ownerRepo, "checkout", "-b", branch, "origin/"+branch)
+	mlsgitCmd(t, ownerRepo, "device", "add", deviceID)
+	git(t, ownerRepo, "add", ".")
+	git(t, ownerRepo, "commit", "-m", "add device: "+deviceName)
+	git(t, ownerRepo, "push")
+	git(t, ownerRepo, "checkout", "master")
+
+	git(t, deviceRepo, "pull", "--no-edit")
+	mlsgitCmd(t, deviceRepo, "join")
+	git(t, deviceRepo, "push", "origin", "master")
+	git(t, ownerRepo, "pull", "--no-edit")
+	return deviceRepo, deviceID
+}
+
+func TestLinkedDeviceReadsAndWrites(t *testing.T) {
+	bare, aliceRepo, bobRepo, aliceID, _ := setupTwoUsers(t, nil)
+	laptop, laptopID := linkDevice(t, bare, aliceRepo, aliceID, "laptop")
+
+	writeFile(t, aliceRepo, "notes.txt", "shared notes\n")
+	git(t, aliceRepo, "add", "notes.txt")
+	git(t, aliceRepo, "commit", "-m", "notes")
+	git(t, aliceRepo, "push")
+	git(t, laptop, "pull", "--no-edit")
+	if got := readFile(t, laptop, "notes.txt"); got != "shared notes\n" {
+		t.Errorf("laptop reads notes.txt: %q", got)
+	}
+	identity := readFile(t, laptop, ".git/mlsgit/identity.toml")
+	if !strings.Contains(identity, aliceID) {
+		t.Errorf("device identity should use alice's member ID:\n%s", identity)
+	}
+
+	// A file written on the laptop verifies for the other member
+	writeFile(t, laptop, "from-laptop.txt", "written on laptop\n")
+	git(t, laptop, "add", "from-laptop.txt")
+	git(t, laptop, "commit", "-m", "laptop file")
+	git(t, laptop, "push", "origin", "master")
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "from-laptop.txt"); got != "written on laptop\n" {
+		t.Errorf("bob reads laptop file: %q", got)
+	}
+
+	out := mlsgitCmd(t, bobRepo, "ls")
+	if !strings.Contains(out, "laptop ["+laptopID+"]") {
+		t.Errorf("ls should list alice's laptop:\n%s", out)
+	}
+	if strings.Contains(out, "[unverified]") {
+		t.Errorf("laptop approval should verify:\n%s", out)
+	}
+}
+
+func TestRemoveMemberRevokesAllDevices(t *testing.T) {
+	bare, aliceRepo, bobRepo, _, bobID := setupTwoUsers(t, nil)
+	bobLaptop, _ := linkDevice(t, bare, bobRepo, bobID, "bob-laptop")
+	git(t, aliceRepo, "pull", "--no-edit")
+
+	before := readFile(t, aliceRepo, ".mlsgit/epoch.toml")
+	out := mlsgitCmd(t, aliceRepo, "remove", bobID)
+	if !strings.Contains(out, "Revoked 2 devices") {
+		t.Errorf("remove should revoke both of bob's devices:\n%s", out)
+	}
+	after := readFile(t, aliceRepo, ".mlsgit/epoch.toml")
+	if before == after {
+		t.Fatal("remove should advance the epoch")
+	}
+	if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "members", bobID+".devices")); !os.IsNotExist(err) {
+		t.Error("bob's device records should be deleted")
+	}
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "remove bob")
+	writeFile(t, aliceRepo, "secret.txt", "after bob left\n")
+	git(t, aliceRepo, "add", "secret.txt")
+	git(t, aliceRepo, "commit", "-m", "secret")
+	git(t, aliceRepo, "push")
+
+	// Neither of bob's devices can read new files
+	for _, repo := range []string{bobRepo, bobLaptop} {
+		gitNoCheck(t, repo, "pull", "--no-edit")
+		if got, err := os.ReadFile(filepath.Join(repo, "secret.txt")); err == nil && string(got) == "after bob left\n" {
+			t.Errorf("%s should not decrypt files after removal", repo)
+		}
+	}
+}
+
+func TestRemovedMemberFilesStillVerify(t *testing.T) {
+	bare, aliceRepo, bobRepo, _, bobID := setupTwoUsers(t, nil)
+	bobLaptop, _ := linkDevice(t, bare, bobRepo, bobID, "bob-laptop")
+	writeFile(t, bobLaptop, "laptop.txt", "from bob's laptop\n")
+	git(t, bobLaptop, "add", "laptop.txt")
+	git(t, bobLaptop, "commit", "-m", "laptop file")
+	git(t, bobLaptop, "push")
+	git(t, bobRepo, "pull", "--no-edit")
+	writeFile(t, bobRepo, "bob.txt", "from bob\n")
+	git(t, bobRepo, "add", "bob.txt")
+	git(t, bobRepo, "commit", "-m", "bob file")
+	git(t, bobRepo, "push")
+	git(t, aliceRepo, "pull", "--no-edit")
+
+	mlsgitCmd(t, aliceRepo, "remove", bobID)
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "remove bob")
+	if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "former", bobID+".toml")); err != nil {
+		t.Fatalf("bob's keys should move to the former members registry: %v", err)
+	}
+
+	// Files bob's devices wrote while in the group still decrypt
+	for _, f := range []string{"bob.txt", "laptop.txt"} {
+		os.Remove(filepath.Join(aliceRepo, f))
+	}
+	git(t, aliceRepo, "checkout", "--", "bob.txt", "laptop.txt")
+	if got := readFile(t, aliceRepo, "bob.txt"); got != "from bob\n" {
+		t.Errorf("bob.txt after removal: %q", got)
+	}
+	if got := readFile(t, aliceRepo, "laptop.txt"); got != "from bob's laptop\n" {
+		t.Errorf("laptop.txt after removal: %q", got)
+	}
+}
+
+func TestAddRequiresQuorum(t *testing.T) {
+	bare, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+
+	// Make bob an admin and require two approvals
+	bobID := getMemberID(t, bobRepo)
+	mlsgitCmd(t, aliceRepo, "policy", "set", bobID, "admin")
+	mlsgitCmd(t, aliceRepo, "policy", "quorum", "2")
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "require two approvals")
+	git(t, aliceRepo, "push")
+	git(t, bobRepo, "pull", "--no-edit")
+
+	charlieRepo := filepath.Join(t.TempDir(), "charlie")
+	gitClone(t, bare, charlieRepo)
+	git(t, charlieRepo, "config", "user.email", "charlie@test.com")
+	git(t, charlieRepo, "config", "user.name", "Charlie")
+	git(t, charlieRepo, "config", "pull.rebase", "false")
+	mlsgitCmd(t, charlieRepo, "join", "--name", "charlie")
+	charlieID := getMemberID(t, charlieRepo)
+	branch := "welcome/" + charlieID
+	git(t, charlieRepo, "add", ".mlsgit/pending/")
+	git(t, charlieRepo, "commit", "-m", "request to join: charlie")
+	git(t, charlieRepo, "push", "-u", "origin", branch)
+
+	// Alice's approval alone does not add charlie
+	git(t, aliceRepo, "fetch", "origin")
+	git(t, aliceRepo, "checkout", "-b", branch, "origin/"+branch)
+	out := mlsgitCmd(t, aliceRepo, "add", charlieID)
+	if !strings.Contains(out, "1 of 2") {
+		t.Errorf("first approval should be recorded without adding:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "group", "welcome", charlieID+".welcome.b64")); !os.IsNotExist(err) {
+		t.Fatal("no Welcome should be written before the quorum is reached")
+	}
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "approve charlie")
+	git(t, aliceRepo, "push")
+	git(t, aliceRepo, "checkout", "master")
+
+	// Bob's approval completes the quorum
+	git(t, bobRepo, "fetch", "origin")
+	git(t, bobRepo, "checkout", "-b", branch, "origin/"+branch)
+	out = mlsgitCmd(t, bobRepo, "add", charlieID)
+	if !strings.Contains(out, "added to the group") {
+		t.Errorf("second approval should add charlie:\n%s", out)
+	}
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "add charlie")
+	git(t, bobRepo, "push")
+	git(t, bobRepo, "checkout", "master")
+
+	git(t, charlieRepo, "pull", "--no-edit")
+	mlsgitCmd(t, charlieRepo, "join")
+	writeFile(t, charlieRepo, "charlie.txt", "hello from charlie\n")
+	git(t, charlieRepo, "add", "charlie.txt")
+	git(t, charlieRepo, "commit", "-m", "charlie file")
+	git(t, charlieRepo, "push", "origin", "master")
+
+	// Charlie rotates his keys, then bob removes him. The approvals
+	// signed over charlie's request must survive both.
+	mlsgitCmd(t, charlieRepo, "update")
+	git(t, charlieRepo, "add", ".")
+	git(t, charlieRepo, "commit", "-m", "update charlie's keys")
+	git(t, charlieRepo, "push", "origin", "master")
+	git(t, bobRepo, "pull", "--no-edit")
+	mlsgitCmd(t, bobRepo, "remove", charlieID)
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "remove charlie")
+	git(t, bobRepo, "push")
+
+	// Alice, still before the add, syncs all three transitions, checking
+	// the add against the approvals kept under former/
+	git(t, aliceRepo, "pull", "--no-edit")
+	if got := readFile(t, aliceRepo, "charlie.txt"); got != "hello from charlie\n" {
+		t.Errorf("alice reads charlie's file: %q", got)
+	}
+	writeFile(t, aliceRepo, "after.txt", "after charlie\n")
+	git(t, aliceRepo, "add", "after.txt")
+	if out := mlsgitCmd(t, aliceRepo, "ls"); strings.Contains(out, "charlie") {
+		t.Errorf("charlie should no longer be listed as a member:\n%s", out)
+	}
+
+	// Lowering the quorum needs both admins
+	out = mlsgitCmd(t, aliceRepo, "policy", "quorum", "1")
+	if !strings.Contains(out, "1 of 2") {
+		t.Errorf("alice alone should only record an approval:\n%s", out)
+	}
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "approve add_quorum 1")
+	git(t, aliceRepo, "push")
+	git(t, bobRepo, "pull", "--no-edit")
+	out = mlsgitCmd(t, bobRepo, "policy", "quorum", "1")
+	if !strings.Contains(out, "lowered from 2 to 1") {
+		t.Errorf("bob's approval should lower the quorum:\n%s", out)
+	}
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "add_quorum 1")
+	git(t, bobRepo, "push")
+	git(t, aliceRepo, "pull", "--no-edit")
+	if out := mlsgitCmd(t, aliceRepo, "policy"); strings.Contains(out, "admin approvals") {
+		t.Errorf("alice should accept the lowered quorum:\n%s", out)
+	}
+
+	// add_quorum in the unsigned config is refused
+	writeFile(t, aliceRepo, ".mlsgit/config.toml", readFile(t, aliceRepo, ".mlsgit/config.toml")+"add_quorum = 1\n")
+	if out := mlsgitCmdExpectError(t, aliceRepo, "config"); !strings.Contains(out, "policy quorum") {
+		t.Errorf("add_quorum in config.toml should be refused:\n%s", out)
+	}
+}
+
 func TestSealAndVerify(t *testing.T) {
 	repo := initMLSGitRepo(t, "alice")
 
@@ -439,6 +887,534 @@ func TestCrossEpochDecryption(t *testing.T) {
 	}
 }
 
+func TestEpochKeysGrowOneLinePerEpoch(t *testing.T) {
+	_, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+	writeFile(t, aliceRepo, "old.txt", "written before the updates\n")
+	git(t, aliceRepo, "add", "old.txt")
+	git(t, aliceRepo, "commit", "-m", "add old file")
+
+	// Each key update appends one entry and rewrites none
+	for i := 0; i < 2; i++ {
+		mlsgitCmd(t, aliceRepo, "update")
+		git(t, aliceRepo, "add", ".")
+		git(t, aliceRepo, "commit", "-m", "update keys")
+		stat := git(t, aliceRepo, "diff", "--numstat", "HEAD~1", "--", ".mlsgit/epoch_keys.b64")
+		if !strings.HasPrefix(stat, "1\t0\t") {
+			t.Errorf("update %d: epoch_keys.b64 diff = %q, want one added line", i+1, stat)
+		}
+	}
+	git(t, aliceRepo, "push")
+
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "old.txt"); got != "written before the updates\n" {
+		t.Errorf("bob reads old.txt: %q", got)
+	}
+}
+
+// backdate moves a file's mtime an hour back, so git does not re-clean it
+// (at a later epoch) when the index is refreshed in the same second.
+func backdate(t *testing.T, repo, name string) {
+	t.Helper()
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(repo, name), past, past); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// joinMember clones bare as a new member and has adminRepo add them with
+// addArgs. It returns the new clone and member ID.
+func joinMember(t *testing.T, bare, adminRepo, name string, addArgs ...string) (string, string) {
+	t.Helper()
+	repo := filepath.Join(t.TempDir(), name)
+	gitClone(t, bare, repo)
+	git(t, repo, "config", "user.email", name+"@test.com")
+	git(t, repo, "config", "user.name", name)
+	git(t, repo, "config", "pull.rebase", "false")
+
+	mlsgitCmd(t, repo, "join", "--name", name)
+	id := getMemberID(t, repo)
+	branch := "welcome/" + id
+	git(t, repo, "add", ".mlsgit/pending/")
+	git(t, repo, "commit", "-m", "request to join: "+name)
+	git(t, repo, "push", "-u", "origin", branch)
+
+	git(t, adminRepo, "fetch", "origin")
+	git(t, adminRepo, "checkout", "-b", branch, "origin/"+branch)
+	mlsgitCmd(t, adminRepo, append([]string{"add", id}, addArgs...)...)
+	git(t, adminRepo, "add", ".")
+	git(t, adminRepo, "commit", "-m", "add "+name)
+	git(t, adminRepo, "push")
+	git(t, adminRepo, "checkout", "master")
+
+	git(t, repo, "pull", "--no-edit")
+	mlsgitCmd(t, repo, "join")
+	git(t, repo, "push", "origin", "master")
+	git(t, adminRepo, "pull", "--no-edit")
+	return repo, id
+}
+
+func TestAddWithoutHistory(t *testing.T) {
+	bare, aliceRepo, bobRepo, _, _ := setupTwoUsers(t, nil)
+	writeFile(t, aliceRepo, "old.txt", "written before carol joined\n")
+	backdate(t, aliceRepo, "old.txt")
+	git(t, aliceRepo, "add", "old.txt")
+	git(t, aliceRepo, "commit", "-m", "add old file")
+	git(t, aliceRepo, "push")
+
+	carolRepo, carolID := joinMember(t, bare, aliceRepo, "carol", "--history=none")
+	if got := readFile(t, carolRepo, "old.txt"); got == "written before carol joined\n" {
+		t.Error("carol should not decrypt a file from before she joined")
+	}
+
+	// Existing members keep their history across the cut
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "old.txt"); got != "written before carol joined\n" {
+		t.Errorf("bob reads old.txt after the cut: %q", got)
+	}
+
+	// Files written after the add are readable by carol
+	writeFile(t, aliceRepo, "new.txt", "written after carol joined\n")
+	git(t, aliceRepo, "add", "new.txt")
+	git(t, aliceRepo, "commit", "-m", "add new file")
+	git(t, aliceRepo, "push")
+	git(t, carolRepo, "pull", "--no-edit")
+	if got := readFile(t, carolRepo, "new.txt"); got != "written after carol joined\n" {
+		t.Errorf("carol reads new.txt: %q", got)
+	}
+
+	// Granting history opens the old file
+	mlsgitCmdExpectError(t, aliceRepo, "grant-history", carolID, "--from", "5")
+	out := mlsgitCmd(t, aliceRepo, "grant-history", carolID, "--from", "1")
+	if !strings.Contains(out, "Granted epochs 1-1") {
+		t.Errorf("grant-history output:\n%s", out)
+	}
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "grant history to carol")
+	git(t, aliceRepo, "push")
+
+	git(t, carolRepo, "pull", "--no-edit")
+	os.Remove(filepath.Join(carolRepo, "old.txt"))
+	git(t, carolRepo, "checkout", "--", "old.txt")
+	if got := readFile(t, carolRepo, "old.txt"); got != "written before carol joined\n" {
+		t.Errorf("carol reads old.txt after the grant: %q", got)
+	}
+}
+
+func TestShredOldEpochs(t *testing.T) {
+	_, aliceRepo, _, _, _ := setupTwoUsers(t, nil)
+	writeFile(t, aliceRepo, "old.txt", "old secret\n")
+	backdate(t, aliceRepo, "old.txt")
+	git(t, aliceRepo, "add", "old.txt")
+	git(t, aliceRepo, "commit", "-m", "old file")
+	oldRev := strings.TrimSpace(git(t, aliceRepo, "rev-parse", "--short=7", "HEAD"))
+
+	mlsgitCmd(t, aliceRepo, "update")
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "update keys")
+	if epoch := readFile(t, aliceRepo, ".mlsgit/epoch.toml"); !strings.Contains(epoch, "current = 2\n") {
+		t.Fatalf("expected epoch 2 after update:\n%s", epoch)
+	}
+
+	mlsgitCmdExpectError(t, aliceRepo, "shred", "--before-epoch", "3", "--yes")
+
+	// Rewriting history needs confirmation and a clean tree, and nothing
+	// is shredded without them
+	mlsgitCmdExpectError(t, aliceRepo, "shred", "--before-epoch", "2", "--rebase")
+	writeFile(t, aliceRepo, "old.txt", "uncommitted\n")
+	mlsgitCmdExpectError(t, aliceRepo, "shred", "--before-epoch", "2", "--rebase", "--yes")
+	git(t, aliceRepo, "checkout", "--", "old.txt")
+	if archive := readFile(t, aliceRepo, ".mlsgit/epoch_keys.b64"); strings.Contains(archive, " shred\n") {
+		t.Fatalf("a refused shred should not touch the archive:\n%s", archive)
+	}
+
+	out := mlsgitCmd(t, aliceRepo, "shred", "--before-epoch", "2", "--rebase", "--yes")
+	if strings.TrimSpace(git(t, aliceRepo, "rev-parse", "--short=7", "HEAD~1")) == oldRev {
+		t.Fatal("shred should rewrite history")
+	}
+	oldRev = strings.TrimSpace(git(t, aliceRepo, "rev-parse", "--short=7", "HEAD~1"))
+	if !strings.Contains(out, "old.txt: ") || !strings.Contains(out, oldRev) {
+		t.Errorf("shred should report old.txt at %s as undecryptable:\n%s", oldRev, out)
+	}
+	archive := readFile(t, aliceRepo, ".mlsgit/epoch_keys.b64")
+	if !strings.Contains(archive, "\n2 shred\n") || strings.Contains(archive, "\n1 2 ") {
+		t.Errorf("archive should drop epochs before 2:\n%s", archive)
+	}
+
+	// No commit still wraps a shredded epoch under a later one
+	for _, rev := range strings.Fields(git(t, aliceRepo, "rev-list", "--all")) {
+		if old := git(t, aliceRepo, "show", rev+":.mlsgit/epoch_keys.b64"); strings.Contains(old, "\n1 2 ") {
+			t.Errorf("commit %s still holds epoch 1:\n%s", rev[:7], old)
+		}
+	}
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "shred epochs before 2")
+
+	// The current version was re-encrypted and still reads
+	os.Remove(filepath.Join(aliceRepo, "old.txt"))
+	git(t, aliceRepo, "checkout", "--", "old.txt")
+	if got := readFile(t, aliceRepo, "old.txt"); got != "old secret\n" {
+		t.Errorf("rebased old.txt: %q", got)
+	}
+
+	// The old revision can no longer be decrypted
+	git(t, aliceRepo, "checkout", oldRev, "--", "old.txt")
+	if got := readFile(t, aliceRepo, "old.txt"); got == "old secret\n" {
+		t.Error("old revision should stay encrypted after the shred")
+	}
+}
+
+func TestRekeyAfterRemoval(t *testing.T) {
+	_, aliceRepo, _, _, bobID := setupTwoUsers(t, nil)
+	writeFile(t, aliceRepo, "secret.txt", "before removal\n")
+	backdate(t, aliceRepo, "secret.txt")
+	git(t, aliceRepo, "add", "secret.txt")
+	git(t, aliceRepo, "commit", "-m", "add secret")
+
+	mlsgitCmd(t, aliceRepo, "remove", bobID)
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "remove bob")
+
+	blobEpochs := func(rev string) []int {
+		t.Helper()
+		epochs, err := delta.ChainEpochs(git(t, aliceRepo, "cat-file", "blob", rev+":secret.txt"))
+		if err != nil {
+			t.Fatalf("parse %s:secret.txt: %v", rev, err)
+		}
+		return epochs
+	}
+	epoch := blobEpochs("HEAD")[0] + 1
+
+	// Current files are re-encrypted under the new epoch
+	out := mlsgitCmd(t, aliceRepo, "rekey")
+	if !strings.Contains(out, "Re-encrypted 1 of 1 file(s)") {
+		t.Errorf("rekey should re-encrypt secret.txt:\n%s", out)
+	}
+	git(t, aliceRepo, "commit", "-m", "rekey")
+	if got := blobEpochs("HEAD"); len(got) != 1 || got[0] != epoch {
+		t.Errorf("rekeyed secret.txt epochs = %v, want [%d]", got, epoch)
+	}
+	if got := blobEpochs("HEAD~2"); got[0] == epoch {
+		t.Error("rekey should not touch older commits")
+	}
+
+	// Rewriting history refuses a dirty tree
+	writeFile(t, aliceRepo, "secret.txt", "uncommitted\n")
+	if out := mlsgitCmdExpectError(t, aliceRepo, "rekey", "--history"); !strings.Contains(out, "uncommitted changes") {
+		t.Errorf("expected dirty-tree refusal:\n%s", out)
+	}
+	git(t, aliceRepo, "checkout", "--", "secret.txt")
+
+	// Every reachable commit is rewritten
+	oldHead := strings.TrimSpace(git(t, aliceRepo, "rev-parse", "--short=7", "HEAD"))
+	out = mlsgitCmd(t, aliceRepo, "rekey", "--history")
+	newHead := strings.TrimSpace(git(t, aliceRepo, "rev-parse", "--short=7", "HEAD"))
+	if !strings.Contains(out, oldHead+" -> "+newHead) {
+		t.Errorf("expected mapping %s -> %s:\n%s", oldHead, newHead, out)
+	}
+	if got := blobEpochs("HEAD~2"); len(got) != 1 || got[0] != epoch {
+		t.Errorf("rewritten secret.txt epochs = %v, want [%d]", got, epoch)
+	}
+	if got := readFile(t, aliceRepo, "secret.txt"); got != "before removal\n" {
+		t.Errorf("secret.txt after rewrite: %q", got)
+	}
+	if status := git(t, aliceRepo, "status", "--porcelain", "--untracked-files=no"); status != "" {
+		t.Errorf("tree should be clean after rewrite:\n%s", status)
+	}
+}
+
+func TestRecoveryLeafRecoversAccess(t *testing.T) {
+	bare, aliceRepo, bobRepo, aliceID, _ := setupTwoUsers(t, nil)
+
+	out := mlsgitCmd(t, aliceRepo, "recover", "--keygen")
+	var pub, priv string
+	for _, line := range strings.Split(out, "\n") {
+		if v, ok := strings.CutPrefix(line, "Public key:"); ok {
+			pub = strings.TrimSpace(v)
+		}
+		if v, ok := strings.CutPrefix(line, "Private key:"); ok {
+			priv = strings.TrimSpace(v)
+		}
+	}
+	if pub == "" || priv == "" {
+		t.Fatalf("unexpected keygen output:\n%s", out)
+	}
+	mlsgitCmd(t, aliceRepo, "add-recovery", pub)
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "add recovery leaf")
+	git(t, aliceRepo, "push")
+	records, _ := filepath.Glob(filepath.Join(aliceRepo, ".mlsgit", "recovery", "*.toml"))
+	if len(records) != 1 {
+		t.Fatalf("recovery records: %v", records)
+	}
+	if record, _ := os.ReadFile(records[0]); strings.Contains(string(record), "signing_key") {
+		t.Errorf("the recovery record should not hold a signing key:\n%s", record)
+	}
+
+	// The recovery leaf adds without approvals, so the quorum stays at 1
+	if out, err := mlsgitCmdInput(t, aliceRepo, "", "policy", "quorum", "2"); err == nil || !strings.Contains(out, "recovery leaf") {
+		t.Errorf("raising add_quorum next to a recovery leaf should fail:\n%s", out)
+	}
+	if out := mlsgitCmd(t, aliceRepo, "ls"); !strings.Contains(out, "Recovery (1):") || !strings.Contains(out, "[recovery]") {
+		t.Errorf("ls should list the recovery leaf separately:\n%s", out)
+	}
+
+	// Later epoch changes reach the recovery leaf through TreeKEM
+	git(t, bobRepo, "pull", "--no-edit")
+	mlsgitCmd(t, bobRepo, "update")
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "update keys")
+	git(t, bobRepo, "push")
+	git(t, aliceRepo, "pull", "--no-edit")
+	writeFile(t, aliceRepo, "secret.txt", "only members can read this\n")
+	git(t, aliceRepo, "add", "secret.txt")
+	git(t, aliceRepo, "commit", "-m", "add secret")
+	git(t, aliceRepo, "push")
+
+	// Everyone has lost their keys; a fresh clone recovers
+	carolRepo := filepath.Join(t.TempDir(), "carol")
+	gitClone(t, bare, carolRepo)
+	git(t, carolRepo, "config", "pull.rebase", "false")
+	if _, err := mlsgitCmdInput(t, carolRepo, "AAAA\n", "recover", "--name", "carol"); err == nil {
+		t.Error("recover should reject a wrong key")
+	}
+	out, err := mlsgitCmdInput(t, carolRepo, priv+"\n", "recover", "--name", "carol")
+	if err != nil {
+		t.Fatalf("recover: %v\n%s", err, out)
+	}
+	if got := readFile(t, carolRepo, "secret.txt"); got != "only members can read this\n" {
+		t.Errorf("recovered secret.txt: %q", got)
+	}
+	if out := mlsgitCmd(t, carolRepo, "ls"); !strings.Contains(out, "carol") || !strings.Contains(out, "(you)  [admin]") {
+		t.Errorf("carol should be an admin:\n%s", out)
+	}
+	git(t, carolRepo, "add", ".")
+	git(t, carolRepo, "commit", "-m", "recover: carol")
+	git(t, carolRepo, "push")
+
+	// Bob accepts the recovery leaf's add and policy
+	git(t, bobRepo, "pull", "--no-edit")
+	if out := mlsgitCmd(t, bobRepo, "ls"); !strings.Contains(out, "carol") {
+		t.Errorf("bob should see carol:\n%s", out)
+	}
+
+	// The new admin manages the group as usual
+	mlsgitCmd(t, carolRepo, "remove", aliceID)
+	writeFile(t, carolRepo, "after.txt", "written after recovery\n")
+	git(t, carolRepo, "add", ".")
+	git(t, carolRepo, "commit", "-m", "remove alice")
+	git(t, carolRepo, "push")
+
+	git(t, bobRepo, "pull", "--no-edit")
+	if got := readFile(t, bobRepo, "after.txt"); got != "written after recovery\n" {
+		t.Errorf("bob reading carol's file: %q", got)
+	}
+}
+
+func TestInitWithRecoveryKey(t *testing.T) {
+	repo := t.TempDir()
+	git(t, repo, "init")
+	mlsgitCmdExpectError(t, repo, "init", "--name", "alice", "--recovery-key", "not-a-key")
+
+	out := mlsgitCmd(t, repo, "recover", "--keygen")
+	pub := strings.TrimSpace(strings.TrimPrefix(strings.Split(out, "\n")[0], "Public key:"))
+	mlsgitCmd(t, repo, "init", "--name", "alice", "--recovery-key", pub)
+	if epoch := readFile(t, repo, ".mlsgit/epoch.toml"); !strings.Contains(epoch, "current = 1\n") {
+		t.Errorf("adding the recovery leaf should advance to epoch 1:\n%s", epoch)
+	}
+	if policy := readFile(t, repo, ".mlsgit/policy.toml"); !strings.Contains(policy, `= "recovery"`) {
+		t.Errorf("policy should give the leaf the recovery role:\n%s", policy)
+	}
+	out = mlsgitCmd(t, repo, "ls")
+	if !strings.Contains(out, "Members (1):") || !strings.Contains(out, "Recovery (1):") {
+		t.Errorf("ls should show one member and one recovery leaf:\n%s", out)
+	}
+}
+
+func TestRecoverFromShares(t *testing.T) {
+	bare, aliceRepo, bobRepo, aliceID, bobID := setupTwoUsers(t, nil)
+
+	mlsgitCmdExpectError(t, aliceRepo, "backup-shares", "--threshold", "3")
+	mlsgitCmd(t, aliceRepo, "backup-shares", "--threshold", "2")
+	for _, id := range []string{aliceID, bobID} {
+		if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "shares", id+".share.b64")); err != nil {
+			t.Errorf("no share for %s: %v", id, err)
+		}
+	}
+	git(t, aliceRepo, "add", ".")
+	git(t, aliceRepo, "commit", "-m", "backup shares")
+	git(t, aliceRepo, "push")
+
+	// An epoch change splits the shares again
+	git(t, bobRepo, "pull", "--no-edit")
+	mlsgitCmd(t, bobRepo, "update")
+	if cfg := readFile(t, bobRepo, ".mlsgit/shares/shares.toml"); !strings.Contains(cfg, "epoch = 2\n") {
+		t.Errorf("shares should follow the epoch change:\n%s", cfg)
+	}
+	git(t, bobRepo, "add", ".")
+	git(t, bobRepo, "commit", "-m", "update keys")
+	git(t, bobRepo, "push")
+	git(t, aliceRepo, "pull", "--no-edit")
+	writeFile(t, aliceRepo, "secret.txt", "only members can read this\n")
+	git(t, aliceRepo, "add", "secret.txt")
+	git(t, aliceRepo, "commit", "-m", "add secret")
+	git(t, aliceRepo, "push")
+
+	// The admin is gone; dave asks for the members' shares
+	daveRepo := filepath.Join(t.TempDir(), "dave")
+	gitClone(t, bare, daveRepo)
+	git(t, daveRepo, "config", "pull.rebase", "false")
+	mlsgitCmd(t, daveRepo, "join", "--name", "dave")
+	daveID := getMemberID(t, daveRepo)
+	welcomeBranch := "welcome/" + daveID
+	git(t, daveRepo, "add", ".mlsgit/pending/")
+	git(t, daveRepo, "commit", "-m", "request to join: dave")
+	git(t, daveRepo, "push", "-u", "origin", welcomeBranch)
+
+	for i, repo := range []string{aliceRepo, bobRepo} {
+		git(t, repo, "fetch", "origin")
+		git(t, repo, "checkout", "-b", welcomeBranch, "origin/"+welcomeBranch)
+		if i > 0 {
+			git(t, repo, "pull", "--no-edit")
+		}
+		mlsgitCmd(t, repo, "recover-from-shares", "--release", daveID)
+		git(t, repo, "add", ".")
+		git(t, repo, "commit", "-m", "release share")
+		git(t, repo, "push")
+		if i == 0 {
+			git(t, daveRepo, "pull", "--no-edit")
+			if out := mlsgitCmd(t, daveRepo, "recover-from-shares"); !strings.Contains(out, "1 of 2 shares") {
+				t.Errorf("one share should not be enough:\n%s", out)
+			}
+		}
+	}
+
+	git(t, daveRepo, "pull", "--no-edit")
+	out := mlsgitCmd(t, daveRepo, "recover-from-shares")
+	if !strings.Contains(out, "Re-founded the group at epoch 3") {
+		t.Errorf("unexpected recover-from-shares output:\n%s", out)
+	}
+	if got := readFile(t, daveRepo, "secret.txt"); got != "only members can read this\n" {
+		t.Errorf("recovered secret.txt: %q", got)
+	}
+	out = mlsgitCmd(t, daveRepo, "ls")
+	if !strings.Contains(out, "Members (1):") || !strings.Contains(out, "(you)  [admin]") {
+		t.Errorf("dave should be the only member and an admin:\n%s", out)
+	}
+	for _, id := range []string{aliceID, bobID} {
+		if _, err := os.Stat(filepath.Join(daveRepo, ".mlsgit", "former", id+".toml")); err != nil {
+			t.Errorf("%s should be a former member: %v", id, err)
+		}
+	}
+
+	// The re-founded group works as usual
+	writeFile(t, daveRepo, "after.txt", "written after recovery\n")
+	git(t, daveRepo, "add", ".")
+	git(t, daveRepo, "commit", "-m", "recover from shares")
+	os.Remove(filepath.Join(daveRepo, "after.txt"))
+	git(t, daveRepo, "checkout", "--", "after.txt")
+	if got := readFile(t, daveRepo, "after.txt"); got != "written after recovery\n" {
+		t.Errorf("after.txt: %q", got)
+	}
+}
+
+func TestSafetyNumberVerification(t *testing.T) {
+	_, aliceRepo, bobRepo, aliceID, bobID := setupTwoUsers(t, nil)
+
+	safetyNumber := func(out string) string {
+		lines := strings.Split(out, "\n")
+		if len(lines) < 3 {
+			t.Fatalf("unexpected safety-number output:\n%s", out)
+		}
+		return strings.TrimSpace(lines[2])
+	}
+	fromAlice := safetyNumber(mlsgitCmd(t, aliceRepo, "safety-number", bobID))
+	fromBob := safetyNumber(mlsgitCmd(t, bobRepo, "safety-number", aliceID))
+	if fromAlice != fromBob || len(strings.ReplaceAll(fromAlice, " ", "")) != 60 {
+		t.Fatalf("safety numbers differ: %q vs %q", fromAlice, fromBob)
+	}
+
+	if out := mlsgitCmd(t, aliceRepo, "ls"); !strings.Contains(out, "[identity unverified]") {
+		t.Errorf("bob should start unverified:\n%s", out)
+	}
+	mlsgitCmd(t, aliceRepo, "safety-number", bobID, "--mark-verified")
+	if out := mlsgitCmd(t, aliceRepo, "ls"); !strings.Contains(out, "[identity verified]") {
+		t.Errorf("bob should be verified after comparing:\n%s", out)
+	}
+	git(t, aliceRepo, "add", ".mlsgit/members/")
+	git(t, aliceRepo, "commit", "-m", "verify bob")
+	git(t, aliceRepo, "push")
+
+	// The attestation is alice's; bob has not verified alice
+	git(t, bobRepo, "pull", "--no-edit")
+	if out := mlsgitCmd(t, bobRepo, "ls"); !strings.Contains(out, "[identity unverified]") {
+		t.Errorf("bob has not verified alice:\n%s", out)
+	}
+
+	// A replaced copy of alice's own record does not change her half
+	paths := storage.MLSGitPaths{Root: aliceRepo}
+	alice, _ := storage.ReadMemberTOML(paths.MemberTOML(aliceID))
+	bob, _ := storage.ReadMemberTOML(paths.MemberTOML(bobID))
+	alice.PublicKey = bob.PublicKey
+	storage.WriteMemberInfo(paths, aliceID, alice)
+	if out := mlsgitCmdExpectError(t, aliceRepo, "safety-number", bobID); !strings.Contains(out, "is not yours") {
+		t.Errorf("safety-number should refuse a replaced own record:\n%s", out)
+	}
+}
+
+func TestRejectJoinRequest(t *testing.T) {
+	bare, aliceRepo, _, _, _ := setupTwoUsers(t, nil)
+
+	malloryRepo := filepath.Join(t.TempDir(), "mallory")
+	gitClone(t, bare, malloryRepo)
+	git(t, malloryRepo, "config", "user.email", "mallory@test.com")
+	git(t, malloryRepo, "config", "user.name", "Mallory")
+	mlsgitCmd(t, malloryRepo, "join", "--name", "mallory")
+	malloryID := getMemberID(t, malloryRepo)
+	branch := "welcome/" + malloryID
+	git(t, malloryRepo, "add", ".mlsgit/pending/")
+	git(t, malloryRepo, "commit", "-m", "request to join: mallory")
+	writeFile(t, malloryRepo, ".mlsgit/members/"+malloryID+".toml", "[member]\nname = \"mallory\"\n")
+	git(t, malloryRepo, "add", ".mlsgit/")
+	git(t, malloryRepo, "commit", "-m", "sneak in a member record")
+	malloryHead := strings.TrimSpace(git(t, malloryRepo, "rev-parse", "HEAD"))
+	git(t, malloryRepo, "push", "-u", "origin", branch)
+
+	git(t, aliceRepo, "fetch", "origin")
+	git(t, aliceRepo, "checkout", "-b", branch, "origin/"+branch)
+	mlsgitCmd(t, aliceRepo, "reject", malloryID, "--reason", "unknown person")
+
+	// Nothing from mallory's branch reaches master
+	merged := exec.Command("git", "merge-base", "--is-ancestor", malloryHead, "master")
+	merged.Dir = aliceRepo
+	if merged.Run() == nil {
+		t.Error("the rejected welcome branch should not be merged into master")
+	}
+	if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "members", malloryID+".toml")); !os.IsNotExist(err) {
+		t.Error("files from the rejected branch should not be on master")
+	}
+	if out := git(t, aliceRepo, "log", "-1", "--format=%s", "master"); !strings.Contains(out, "reject member: mallory") {
+		t.Errorf("the rejection should be committed on master, last commit: %s", out)
+	}
+
+	if got := strings.TrimSpace(git(t, aliceRepo, "rev-parse", "--abbrev-ref", "HEAD")); got != "master" {
+		t.Errorf("reject should return to master, on %q", got)
+	}
+	if out := git(t, aliceRepo, "branch", "--list", branch); strings.TrimSpace(out) != "" {
+		t.Errorf("welcome branch should be deleted:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "pending", malloryID+".request.toml")); !os.IsNotExist(err) {
+		t.Error("pending request should be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(aliceRepo, ".mlsgit", "rejected", malloryID+".toml")); err != nil {
+		t.Errorf("rejection record missing: %v", err)
+	}
+
+	out := mlsgitCmd(t, aliceRepo, "review")
+	if !strings.Contains(out, "unknown person") || strings.Contains(out, "[unverified]") {
+		t.Errorf("review should list the verified rejection:\n%s", out)
+	}
+}
+
 // ========================================================================
 // Unhappy path tests
 // ========================================================================